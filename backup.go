@@ -0,0 +1,582 @@
+package lredis
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// 键空间的逻辑备份和恢复，不需要访问服务器的文件系统
+// 导出: SCAN遍历键空间，每个键记录类型、过期的时间点和值，写入gzip压缩的文件
+// 大集合使用HSCAN/SSCAN/ZSCAN/LRANGE分批读取，每批写成一条记录，Seq从0开始递增
+// 导入: 使用pipeline批量写入，支持前缀过滤和键名改写
+// 不覆盖时每个pipeline执行之前批量检查目标中是否已经存在，存在时跳过这个键的所有记录和过期时间
+// 导入时已经过期的键直接跳过，其他键的过期时间为导入时的剩余时间
+// 注意: 导出期间键空间仍在变化，得到的不是严格意义的时间点快照
+
+const (
+	BackupFormatJSON   = "json"   // 每行一个json记录
+	BackupFormatBinary = "binary" // 每条记录前4字节为记录长度
+)
+
+// BackupRecord 备份文件中的一条记录
+// Items的含义由Type决定
+// string: [value]
+// list、set: 元素
+// hash: field, value 交替
+// zset: member, score 交替
+type BackupRecord struct {
+	Key      string   `json:"key"`
+	Type     string   `json:"type"`
+	ExpireAt int64    `json:"expire_at"` // 过期的unix时间戳 毫秒, 0 没有过期时间
+	Seq      int      `json:"seq"`       // 同一个键的第几批数据
+	Items    [][]byte `json:"items"`
+}
+
+// remainingTTL 记录在now时剩余的过期时间 毫秒, 0 没有过期时间, -1 已经过期
+func (record *BackupRecord) remainingTTL(now time.Time) int64 {
+	if record.ExpireAt <= 0 {
+		return 0
+	}
+	ttl := record.ExpireAt - unixMilli(now)
+	if ttl <= 0 {
+		return -1
+	}
+	return ttl
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+type ExportOptions struct {
+	Format    string // json 或者 binary
+	Match     string // SCAN的MATCH, 为空导出所有的键
+	ScanCount int64  // SCAN的COUNT
+	BatchSize int64  // 大集合每批读取的元素个数
+}
+
+type ImportOptions struct {
+	Format   string                  // 需要和导出时一致
+	Prefix   string                  // 只导入此前缀的键(改写之前的键名)
+	Rewrite  func(key string) string // 键名改写，为nil不改写
+	Pipeline int                     // 每个pipeline包含的记录数
+	Replace  bool                    // 目标已经存在的键先删除，false时跳过已经存在的键
+}
+
+// ExportFile 导出到本地文件
+func ExportFile(client redis.Cmdable, filePath string, opts ExportOptions) (int64, error) {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return Export(client, file, opts)
+}
+
+// ImportFile 从本地文件导入
+func ImportFile(client redis.Cmdable, filePath string, opts ImportOptions) (int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return Import(client, file, opts)
+}
+
+// Export 导出键空间，返回导出的键数
+// 集群模式下遍历每个主节点
+func Export(client redis.Cmdable, w io.Writer, opts ExportOptions) (int64, error) {
+	if opts.Match == "" {
+		opts.Match = "*"
+	}
+	if opts.ScanCount <= 0 {
+		opts.ScanCount = 500
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	zw := gzip.NewWriter(w)
+	writer, err := newRecordWriter(zw, opts.Format)
+	if err != nil {
+		return 0, err
+	}
+
+	nodes, err := MasterNodes(client)
+	if err != nil {
+		return 0, err
+	}
+	var exported int64
+	for _, node := range nodes {
+		var cursor uint64
+		for {
+			keys, next, err := node.Scan(cursor, opts.Match, opts.ScanCount).Result()
+			if err != nil {
+				return exported, err
+			}
+			for _, key := range keys {
+				ok, err := exportKey(node, key, opts.BatchSize, writer)
+				if err != nil {
+					return exported, err
+				}
+				if ok {
+					exported++
+				}
+			}
+			cursor = next
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+	return exported, zw.Close()
+}
+
+// exportKey 导出单个键，键已经不存在或者类型不支持时返回false
+func exportKey(client redis.Cmdable, key string, batchSize int64, writer recordWriter) (bool, error) {
+	keyType, err := client.Type(key).Result()
+	if err != nil {
+		return false, err
+	}
+	pttl, err := client.PTTL(key).Result()
+	if err != nil {
+		return false, err
+	}
+	// go-redis把PTTL的-2(键不存在)转换为 -2ms
+	if keyType == "none" || pttl == -2*time.Millisecond {
+		return false, nil
+	}
+	// 记录过期的时间点，导入时按照导入的时间计算剩余时间
+	var expireAt int64
+	if pttl > 0 {
+		expireAt = unixMilli(time.Now().Add(pttl))
+	}
+
+	seq := 0
+	write := func(items []string) error {
+		record := &BackupRecord{Key: key, Type: keyType, ExpireAt: expireAt, Seq: seq, Items: toBytes(items)}
+		seq++
+		return writer.write(record)
+	}
+
+	switch keyType {
+	case "string":
+		val, err := client.Get(key).Result()
+		if err == redis.Nil {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, write([]string{val})
+	case "list":
+		for start := int64(0); ; start += batchSize {
+			items, err := client.LRange(key, start, start+batchSize-1).Result()
+			if err != nil {
+				return false, err
+			}
+			if len(items) == 0 && seq > 0 {
+				break
+			}
+			if err := write(items); err != nil {
+				return false, err
+			}
+			if int64(len(items)) < batchSize {
+				break
+			}
+		}
+		return true, nil
+	case "hash", "set", "zset":
+		scan := map[string]func(uint64) *redis.ScanCmd{
+			"hash": func(cursor uint64) *redis.ScanCmd { return client.HScan(key, cursor, "", batchSize) },
+			"set":  func(cursor uint64) *redis.ScanCmd { return client.SScan(key, cursor, "", batchSize) },
+			"zset": func(cursor uint64) *redis.ScanCmd { return client.ZScan(key, cursor, "", batchSize) },
+		}[keyType]
+		var cursor uint64
+		for {
+			items, next, err := scan(cursor).Result()
+			if err != nil {
+				return false, err
+			}
+			// SCAN可能返回空的批次，只在第一批时写入空记录，用于标记键的存在
+			if len(items) > 0 || seq == 0 {
+				if err := write(items); err != nil {
+					return false, err
+				}
+			}
+			cursor = next
+			if cursor == 0 {
+				return true, nil
+			}
+		}
+	default:
+		log.Printf("export key %s type %s not support\n", key, keyType)
+		return false, nil
+	}
+}
+
+// Import 导入备份文件，返回导入的键数
+func Import(client redis.Cmdable, r io.Reader, opts ImportOptions) (int64, error) {
+	if opts.Pipeline <= 0 {
+		opts.Pipeline = 100
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+	reader, err := newRecordReader(zr, opts.Format)
+	if err != nil {
+		return 0, err
+	}
+
+	pipe := client.Pipeline()
+	defer pipe.Close()
+	var imported int64
+	batch := make([]importItem, 0, opts.Pipeline)
+	// 是否跳过当前键，目标中已经存在或者已经过期，同一个键的记录在文件中是连续的
+	skip := false
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		var exists map[string]bool
+		if !opts.Replace {
+			var err error
+			if exists, err = existingKeys(client, batch); err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		for _, item := range batch {
+			key, record := item.key, item.record
+			if skip && record.Seq > 0 {
+				continue
+			}
+			ttl := record.remainingTTL(now)
+			if record.Seq == 0 {
+				skip = ttl < 0 || exists[key]
+				if skip {
+					continue
+				}
+				if !opts.Replace {
+					// SCAN可能重复返回同一个键，之后的重复记录按照已经存在处理
+					exists[key] = true
+				}
+				imported++
+			} else if ttl < 0 {
+				// 导入上一批记录期间过期，剩余的记录不再写入
+				continue
+			}
+			if err := importRecord(pipe, key, record, time.Duration(ttl)*time.Millisecond, opts.Replace); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		_, err := pipe.Exec()
+		return err
+	}
+
+	for {
+		record, err := reader.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imported, err
+		}
+		if opts.Prefix != "" && !strings.HasPrefix(record.Key, opts.Prefix) {
+			continue
+		}
+		key := record.Key
+		if opts.Rewrite != nil {
+			key = opts.Rewrite(key)
+		}
+		batch = append(batch, importItem{key: key, record: record})
+		if len(batch) >= opts.Pipeline {
+			if err := flush(); err != nil {
+				return imported, err
+			}
+		}
+	}
+	return imported, flush()
+}
+
+// importItem 一条待导入的记录，key为改写之后的键名
+type importItem struct {
+	key    string
+	record *BackupRecord
+}
+
+// existingKeys 使用一个pipeline检查本批记录中的键在目标中是否已经存在
+func existingKeys(client redis.Cmdable, batch []importItem) (map[string]bool, error) {
+	pipe := client.Pipeline()
+	defer pipe.Close()
+	cmds := map[string]*redis.IntCmd{}
+	for _, item := range batch {
+		if _, ok := cmds[item.key]; !ok && item.record.Seq == 0 {
+			cmds[item.key] = pipe.Exists(item.key)
+		}
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool, len(cmds))
+	for key, cmd := range cmds {
+		exists[key] = cmd.Val() > 0
+	}
+	return exists, nil
+}
+
+// importRecord 把一条记录写入pipeline，ttl为剩余的过期时间，replace为false时调用方已经确认键不存在
+func importRecord(pipe redis.Pipeliner, key string, record *BackupRecord, ttl time.Duration, replace bool) error {
+	if record.Seq == 0 && replace && record.Type != "string" {
+		pipe.Del(key)
+	}
+	items := record.Items
+	switch record.Type {
+	case "string":
+		if len(items) != 1 {
+			return fmt.Errorf("backup key %s string record items %d", record.Key, len(items))
+		}
+		// 过期时间和值一起设置，SETNX失败时不会修改已有键的过期时间
+		if replace {
+			pipe.Set(key, items[0], ttl)
+		} else {
+			pipe.SetNX(key, items[0], ttl)
+		}
+		return nil
+	case "list":
+		if len(items) > 0 {
+			pipe.RPush(key, toInterfaces(items)...)
+		}
+	case "set":
+		if len(items) > 0 {
+			pipe.SAdd(key, toInterfaces(items)...)
+		}
+	case "hash":
+		if len(items)%2 != 0 {
+			return fmt.Errorf("backup key %s hash record items %d", record.Key, len(items))
+		}
+		if len(items) > 0 {
+			fields := make(map[string]interface{}, len(items)/2)
+			for i := 0; i < len(items); i += 2 {
+				fields[string(items[i])] = items[i+1]
+			}
+			pipe.HMSet(key, fields)
+		}
+	case "zset":
+		if len(items)%2 != 0 {
+			return fmt.Errorf("backup key %s zset record items %d", record.Key, len(items))
+		}
+		members := make([]redis.Z, 0, len(items)/2)
+		for i := 0; i < len(items); i += 2 {
+			score, err := strconv.ParseFloat(string(items[i+1]), 64)
+			if err != nil {
+				return fmt.Errorf("backup key %s zset score %s", record.Key, items[i+1])
+			}
+			members = append(members, redis.Z{Score: score, Member: items[i]})
+		}
+		if len(members) > 0 {
+			pipe.ZAdd(key, members...)
+		}
+	default:
+		return fmt.Errorf("backup key %s type %s not support", record.Key, record.Type)
+	}
+	if ttl > 0 {
+		pipe.PExpire(key, ttl)
+	}
+	return nil
+}
+
+type recordWriter interface {
+	write(record *BackupRecord) error
+}
+
+type recordReader interface {
+	read() (*BackupRecord, error)
+}
+
+func newRecordWriter(w io.Writer, format string) (recordWriter, error) {
+	switch format {
+	case "", BackupFormatJSON:
+		return &jsonRecordWriter{encoder: json.NewEncoder(w)}, nil
+	case BackupFormatBinary:
+		return &binaryRecordWriter{w: w}, nil
+	}
+	return nil, errors.New("backup format not support " + format)
+}
+
+func newRecordReader(r io.Reader, format string) (recordReader, error) {
+	switch format {
+	case "", BackupFormatJSON:
+		return &jsonRecordReader{decoder: json.NewDecoder(r)}, nil
+	case BackupFormatBinary:
+		return &binaryRecordReader{r: bufio.NewReader(r)}, nil
+	}
+	return nil, errors.New("backup format not support " + format)
+}
+
+type jsonRecordWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonRecordWriter) write(record *BackupRecord) error {
+	return w.encoder.Encode(record)
+}
+
+type jsonRecordReader struct {
+	decoder *json.Decoder
+}
+
+func (r *jsonRecordReader) read() (*BackupRecord, error) {
+	record := &BackupRecord{}
+	if err := r.decoder.Decode(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// 二进制记录的格式
+// | 记录长度 uint32 | key | type | expire_at varint | seq varint | items个数 uvarint | item... |
+// key、type、item 均为 uvarint长度 + 内容
+type binaryRecordWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (w *binaryRecordWriter) write(record *BackupRecord) error {
+	buf := w.buf[:0]
+	buf = appendBytes(buf, []byte(record.Key))
+	buf = appendBytes(buf, []byte(record.Type))
+	buf = appendVarint(buf, record.ExpireAt)
+	buf = appendVarint(buf, int64(record.Seq))
+	buf = appendUvarint(buf, uint64(len(record.Items)))
+	for _, item := range record.Items {
+		buf = appendBytes(buf, item)
+	}
+	w.buf = buf
+
+	var head [4]byte
+	binary.BigEndian.PutUint32(head[:], uint32(len(buf)))
+	if _, err := w.w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(buf)
+	return err
+}
+
+type binaryRecordReader struct {
+	r *bufio.Reader
+}
+
+func (r *binaryRecordReader) read() (*BackupRecord, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r.r, head[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint32(head[:]))
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	record := &BackupRecord{}
+	var key, keyType []byte
+	var expireAt, seq int64
+	var num uint64
+	var ok bool
+	if key, buf, ok = readBytes(buf); !ok {
+		return nil, errors.New("backup record key corrupt")
+	}
+	if keyType, buf, ok = readBytes(buf); !ok {
+		return nil, errors.New("backup record type corrupt")
+	}
+	if expireAt, buf, ok = readVarint(buf); !ok {
+		return nil, errors.New("backup record expire_at corrupt")
+	}
+	if seq, buf, ok = readVarint(buf); !ok {
+		return nil, errors.New("backup record seq corrupt")
+	}
+	if num, buf, ok = readUvarint(buf); !ok || num > uint64(len(buf)) {
+		return nil, errors.New("backup record items corrupt")
+	}
+	record.Key = string(key)
+	record.Type = string(keyType)
+	record.ExpireAt = expireAt
+	record.Seq = int(seq)
+	record.Items = make([][]byte, num)
+	for i := range record.Items {
+		if record.Items[i], buf, ok = readBytes(buf); !ok {
+			return nil, errors.New("backup record item corrupt")
+		}
+	}
+	return record, nil
+}
+
+func appendUvarint(buf []byte, num uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], num)
+	return append(buf, tmp[:n]...)
+}
+
+func appendVarint(buf []byte, num int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], num)
+	return append(buf, tmp[:n]...)
+}
+
+func appendBytes(buf []byte, data []byte) []byte {
+	buf = appendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+func readUvarint(buf []byte) (uint64, []byte, bool) {
+	num, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return 0, buf, false
+	}
+	return num, buf[n:], true
+}
+
+func readVarint(buf []byte) (int64, []byte, bool) {
+	num, n := binary.Varint(buf)
+	if n <= 0 {
+		return 0, buf, false
+	}
+	return num, buf[n:], true
+}
+
+func readBytes(buf []byte) ([]byte, []byte, bool) {
+	length, buf, ok := readUvarint(buf)
+	if !ok || length > uint64(len(buf)) {
+		return nil, buf, false
+	}
+	return buf[:length], buf[length:], true
+}
+
+func toBytes(items []string) [][]byte {
+	data := make([][]byte, len(items))
+	for i, item := range items {
+		data[i] = []byte(item)
+	}
+	return data
+}
+
+func toInterfaces(items [][]byte) []interface{} {
+	values := make([]interface{}, len(items))
+	for i, item := range items {
+		values[i] = item
+	}
+	return values
+}
@@ -0,0 +1,218 @@
+package lredis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"learn/l_redis/myredis"
+
+	"github.com/go-redis/redis"
+)
+
+// startMyRedis 启动一个myredis服务，测试结束时关闭
+func startMyRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := myredis.NewServer()
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(l)
+	}()
+	client := redis.NewClient(&redis.Options{Addr: l.Addr().String()})
+	t.Cleanup(func() {
+		client.Close()
+		s.Shutdown()
+		<-served
+	})
+	return client
+}
+
+var testRecords = []*BackupRecord{
+	{Key: "s", Type: "string", ExpireAt: 1500, Items: [][]byte{[]byte("v\n\x00\xff")}},
+	{Key: "l", Type: "list", Seq: 0, Items: [][]byte{[]byte("a"), []byte("")}},
+	{Key: "l", Type: "list", Seq: 1, Items: [][]byte{[]byte("c")}},
+	{Key: "空集合", Type: "set", Items: [][]byte{}},
+	{Key: "z", Type: "zset", ExpireAt: 1 << 40, Items: [][]byte{[]byte("m"), []byte("-1.5")}},
+}
+
+func encodeRecords(t *testing.T, format string, records []*BackupRecord) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newRecordWriter(&buf, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if err := w.write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestBackupCodec(t *testing.T) {
+	for _, format := range []string{BackupFormatJSON, BackupFormatBinary} {
+		data := encodeRecords(t, format, testRecords)
+		r, _ := newRecordReader(bytes.NewReader(data), format)
+		for _, want := range testRecords {
+			got, err := r.read()
+			if err != nil {
+				t.Fatalf("%s read %v", format, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s read %+v, want %+v", format, got, want)
+			}
+		}
+		if _, err := r.read(); err != io.EOF {
+			t.Fatalf("%s read after last record %v", format, err)
+		}
+	}
+	if _, err := newRecordWriter(nil, "xml"); err == nil {
+		t.Fatal("unknown format should fail")
+	}
+	if _, err := newRecordReader(nil, "xml"); err == nil {
+		t.Fatal("unknown format should fail")
+	}
+}
+
+// TestBackupCodecTruncated 截断的文件返回错误，不会当作正常结束
+func TestBackupCodecTruncated(t *testing.T) {
+	for _, format := range []string{BackupFormatJSON, BackupFormatBinary} {
+		data := encodeRecords(t, format, testRecords[:2])
+		first := len(encodeRecords(t, format, testRecords[:1]))
+		// json记录以换行结尾，去掉换行仍然是完整的记录
+		for n := first + 1; n < len(data)-1; n++ {
+			r, _ := newRecordReader(bytes.NewReader(data[:n]), format)
+			if _, err := r.read(); err != nil {
+				t.Fatalf("%s first record %v", format, err)
+			}
+			if _, err := r.read(); err == nil || err == io.EOF {
+				t.Fatalf("%s truncated at %d/%d err %v", format, n, len(data), err)
+			}
+		}
+	}
+}
+
+func TestBackupCodecCorrupt(t *testing.T) {
+	record := func(body []byte) []byte {
+		head := make([]byte, 4)
+		binary.BigEndian.PutUint32(head, uint32(len(body)))
+		return append(head, body...)
+	}
+	valid := encodeRecords(t, BackupFormatBinary, testRecords[1:2])[4:]
+	for name, data := range map[string][]byte{
+		"key length":  record([]byte{10, 'k'}),
+		"type":        record([]byte{1, 'k'}),
+		"ttl":         record([]byte{1, 'k', 1, 's', 0x80}),
+		"items count": record(append(append([]byte{}, valid[:len(valid)-4]...), 0xff, 0x01)),
+		"item length": record(valid[:len(valid)-1]),
+	} {
+		r, _ := newRecordReader(bytes.NewReader(data), BackupFormatBinary)
+		if _, err := r.read(); err == nil || !strings.Contains(err.Error(), "corrupt") {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	r, _ := newRecordReader(strings.NewReader(`{"key":"k","items":"not base64"}`), BackupFormatJSON)
+	if _, err := r.read(); err == nil {
+		t.Fatal("json with invalid items should fail")
+	}
+}
+
+func gzipRecords(t *testing.T, records []*BackupRecord) io.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(encodeRecords(t, BackupFormatBinary, records))
+	zw.Close()
+	return &buf
+}
+
+// TestImportSkipExisting 不覆盖时跳过目标中已经存在的键，不修改它们的值和过期时间
+func TestImportSkipExisting(t *testing.T) {
+	client := startMyRedis(t)
+	client.Set("s", "old", 0)
+	client.Set("l", "string", 0)
+
+	expireAt := unixMilli(time.Now().Add(time.Minute))
+	records := []*BackupRecord{
+		{Key: "s", Type: "string", ExpireAt: expireAt, Items: [][]byte{[]byte("new")}},
+		// 目标中是字符串，合并时会返回WRONGTYPE
+		{Key: "l", Type: "list", Seq: 0, Items: [][]byte{[]byte("a")}},
+		{Key: "l", Type: "list", Seq: 1, ExpireAt: expireAt, Items: [][]byte{[]byte("b")}},
+		{Key: "n", Type: "list", Seq: 0, ExpireAt: expireAt, Items: [][]byte{[]byte("a")}},
+		{Key: "n", Type: "list", Seq: 1, ExpireAt: expireAt, Items: [][]byte{[]byte("b")}},
+		{Key: "t", Type: "string", ExpireAt: expireAt, Items: [][]byte{[]byte("v")}},
+		// SCAN可能重复返回同一个键
+		{Key: "n", Type: "list", Seq: 0, ExpireAt: expireAt, Items: [][]byte{[]byte("a")}},
+	}
+	imported, err := Import(client, gzipRecords(t, records), ImportOptions{Format: BackupFormatBinary, Pipeline: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 2 {
+		t.Fatalf("imported %d", imported)
+	}
+	if v := client.Get("s").Val(); v != "old" || client.TTL("s").Val() != -time.Second {
+		t.Fatalf("existing string %s ttl %s", v, client.TTL("s").Val())
+	}
+	if v := client.Get("l").Val(); v != "string" || client.TTL("l").Val() != -time.Second {
+		t.Fatalf("existing key of other type %s ttl %s", v, client.TTL("l").Val())
+	}
+	if v := client.LRange("n", 0, -1).Val(); strings.Join(v, ",") != "a,b" || client.TTL("n").Val() <= 0 {
+		t.Fatalf("new list %v ttl %s", v, client.TTL("n").Val())
+	}
+	if v := client.Get("t").Val(); v != "v" || client.TTL("t").Val() <= 0 {
+		t.Fatalf("new string %s ttl %s", v, client.TTL("t").Val())
+	}
+
+	// 覆盖时先删除已经存在的键
+	imported, err = Import(client, gzipRecords(t, records[:3]), ImportOptions{Format: BackupFormatBinary, Replace: true})
+	if err != nil || imported != 2 {
+		t.Fatalf("replace imported %d %v", imported, err)
+	}
+	if v := client.Get("s").Val(); v != "new" || client.TTL("s").Val() <= 0 {
+		t.Fatalf("replaced string %s ttl %s", v, client.TTL("s").Val())
+	}
+	if v := client.LRange("l", 0, -1).Val(); strings.Join(v, ",") != "a,b" {
+		t.Fatalf("replaced list %v", v)
+	}
+}
+
+// TestImportExpired 导入时已经过期的键被跳过，其他键使用剩余的过期时间
+func TestImportExpired(t *testing.T) {
+	client := startMyRedis(t)
+	now := time.Now()
+	records := []*BackupRecord{
+		{Key: "expired", Type: "list", Seq: 0, ExpireAt: unixMilli(now.Add(-time.Second)), Items: [][]byte{[]byte("a")}},
+		{Key: "expired", Type: "list", Seq: 1, ExpireAt: unixMilli(now.Add(-time.Second)), Items: [][]byte{[]byte("b")}},
+		{Key: "s", Type: "string", ExpireAt: unixMilli(now.Add(10 * time.Second)), Items: [][]byte{[]byte("v")}},
+		{Key: "l", Type: "list", ExpireAt: unixMilli(now.Add(10 * time.Second)), Items: [][]byte{[]byte("a")}},
+		{Key: "p", Type: "list", Items: [][]byte{[]byte("a")}},
+	}
+	imported, err := Import(client, gzipRecords(t, records), ImportOptions{Format: BackupFormatBinary})
+	if err != nil || imported != 3 {
+		t.Fatalf("imported %d %v", imported, err)
+	}
+	if n := client.Exists("expired").Val(); n != 0 {
+		t.Fatal("expired key imported")
+	}
+	for _, key := range []string{"s", "l"} {
+		if ttl := client.PTTL(key).Val(); ttl <= 0 || ttl > 10*time.Second {
+			t.Fatalf("%s ttl %s", key, ttl)
+		}
+	}
+	if ttl := client.PTTL("p").Val(); ttl != -time.Millisecond {
+		t.Fatalf("persistent key ttl %s", ttl)
+	}
+}
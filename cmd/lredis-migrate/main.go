@@ -0,0 +1,392 @@
+package main
+
+// 跨实例迁移键空间
+// 源和目标均使用lredis的配置文件描述
+// 每个源节点一个协程执行SCAN，扫描到的键交给worker执行 DUMP+PTTL -> RESTORE
+// 每批键全部处理完毕之后记录SCAN的游标到断点文件，中断之后使用同一个断点文件可以继续迁移
+// 迁移完毕之后在已迁移的键中抽样，对比源和目标的类型、值和过期时间
+
+// 使用示例
+// lredis-migrate -src src.yaml -dst dst.yaml -prefix user: -workers 16 -rate 5000 -checkpoint migrate.json
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	lredis "learn/l_redis"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+var (
+	srcConfig      = flag.String("src", "", "源redis的配置文件")
+	dstConfig      = flag.String("dst", "", "目标redis的配置文件")
+	prefix         = flag.String("prefix", "", "只迁移指定前缀的键，为空迁移所有的键")
+	workers        = flag.Int("workers", 8, "执行DUMP/RESTORE的并发数")
+	scanCount      = flag.Int64("scan-count", 500, "每次SCAN的COUNT")
+	rate           = flag.Int("rate", 0, "每秒最多迁移的键数，0 不限制")
+	replace        = flag.Bool("replace", true, "目标已经存在的键使用REPLACE覆盖，false时跳过")
+	checkpointFile = flag.String("checkpoint", "", "断点文件，为空不记录断点")
+	verifyNum      = flag.Int("verify", 100, "迁移完毕之后抽样校验的键数，0 不校验")
+)
+
+// checkpoint 记录每个源节点SCAN的游标
+type checkpoint struct {
+	mu      sync.Mutex
+	path    string
+	Cursors map[string]uint64 `json:"cursors"`
+	Done    map[string]bool   `json:"done"`
+}
+
+func loadCheckpoint(path string) (*checkpoint, error) {
+	cp := &checkpoint{
+		path:    path,
+		Cursors: map[string]uint64{},
+		Done:    map[string]bool{},
+	}
+	if path == "" {
+		return cp, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (cp *checkpoint) save(node string, cursor uint64, done bool) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.Cursors[node] = cursor
+	cp.Done[node] = done
+	if cp.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免中断时断点文件损坏
+	tmp := cp.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
+
+func (cp *checkpoint) get(node string) (uint64, bool) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.Cursors[node], cp.Done[node]
+}
+
+// limiter 简单的令牌限速
+type limiter struct {
+	tokens <-chan time.Time
+}
+
+func newLimiter(rate int) *limiter {
+	if rate <= 0 {
+		return &limiter{}
+	}
+	return &limiter{tokens: time.Tick(time.Second / time.Duration(rate))}
+}
+
+func (l *limiter) wait() {
+	if l.tokens != nil {
+		<-l.tokens
+	}
+}
+
+const (
+	ttlMissing = -2 * time.Millisecond // PTTL 键不存在，没有过期时间时为 -1ms
+
+	// 校验时源和目标剩余过期时间允许的误差，两次PTTL之间存在时间差
+	ttlTolerance = time.Second
+)
+
+// globEscape 转义前缀中的通配符，避免 * ? [ ] \ 被SCAN MATCH当作模式
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// ttlEqual 源和目标的过期时间是否一致，都没有过期时间或者剩余时间相差不超过ttlTolerance
+func ttlEqual(src, dst time.Duration) bool {
+	if src < 0 || dst < 0 {
+		return src == dst
+	}
+	diff := src - dst
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= ttlTolerance
+}
+
+type migrator struct {
+	dst     redis.Cmdable
+	limiter *limiter
+	cp      *checkpoint
+
+	migrated int64
+	skipped  int64
+	missing  int64
+	failed   int64
+
+	// 已迁移键的蓄水池抽样，用于最后的校验
+	sampleMu   sync.Mutex
+	samples    []sampleKey
+	sampleSeen int64
+}
+
+type sampleKey struct {
+	src redis.Cmdable
+	key string
+}
+
+func (m *migrator) sample(src redis.Cmdable, key string) {
+	if *verifyNum <= 0 {
+		return
+	}
+	m.sampleMu.Lock()
+	defer m.sampleMu.Unlock()
+	m.sampleSeen++
+	if len(m.samples) < *verifyNum {
+		m.samples = append(m.samples, sampleKey{src: src, key: key})
+		return
+	}
+	if index := rand.Int63n(m.sampleSeen); index < int64(*verifyNum) {
+		m.samples[index] = sampleKey{src: src, key: key}
+	}
+}
+
+// migrateKey 迁移单个键
+func (m *migrator) migrateKey(src redis.Cmdable, key string) {
+	m.limiter.wait()
+	pipe := src.Pipeline()
+	dumpCmd := pipe.Dump(key)
+	ttlCmd := pipe.PTTL(key)
+	_, err := pipe.Exec()
+	pipe.Close()
+	if err == redis.Nil {
+		// 扫描之后键已经被删除或者过期
+		atomic.AddInt64(&m.missing, 1)
+		return
+	}
+	if err != nil {
+		atomic.AddInt64(&m.failed, 1)
+		log.Printf("dump %s err: %s\n", key, err.Error())
+		return
+	}
+
+	// go-redis把PTTL的-1和-2按照毫秒转换为 -1ms 和 -2ms
+	ttl := ttlCmd.Val()
+	if ttl == ttlMissing {
+		// DUMP和PTTL之间键过期，不能当作没有过期时间的键写入目标
+		atomic.AddInt64(&m.missing, 1)
+		return
+	}
+	if ttl < 0 {
+		// 没有过期时间，RESTORE的ttl为0
+		ttl = 0
+	}
+
+	if *replace {
+		err = m.dst.RestoreReplace(key, ttl, dumpCmd.Val()).Err()
+	} else {
+		err = m.dst.Restore(key, ttl, dumpCmd.Val()).Err()
+		if err != nil && strings.HasPrefix(err.Error(), "BUSYKEY") {
+			atomic.AddInt64(&m.skipped, 1)
+			return
+		}
+	}
+	if err != nil {
+		atomic.AddInt64(&m.failed, 1)
+		log.Printf("restore %s err: %s\n", key, err.Error())
+		return
+	}
+	atomic.AddInt64(&m.migrated, 1)
+	m.sample(src, key)
+}
+
+// migrateNode 扫描单个源节点，每批键处理完毕之后保存游标
+func (m *migrator) migrateNode(src redis.Cmdable) error {
+	node := lredis.NodeAddr(src)
+	cursor, done := m.cp.get(node)
+	if done {
+		log.Printf("node %s already migrated\n", node)
+		return nil
+	}
+	log.Printf("node %s start migrate from cursor %d\n", node, cursor)
+
+	for {
+		keys, next, err := src.Scan(cursor, globEscape(*prefix)+"*", *scanCount).Result()
+		if err != nil {
+			return err
+		}
+
+		var wg sync.WaitGroup
+		keyCh := make(chan string)
+		for i := 0; i < *workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for key := range keyCh {
+					m.migrateKey(src, key)
+				}
+			}()
+		}
+		for _, key := range keys {
+			keyCh <- key
+		}
+		close(keyCh)
+		wg.Wait()
+
+		cursor = next
+		if err := m.cp.save(node, cursor, cursor == 0); err != nil {
+			log.Printf("save checkpoint err: %s\n", err.Error())
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// verify 对比抽样键在源和目标上的类型、DUMP的值以及剩余过期时间
+// 源和目标的redis版本不同时DUMP的结果可能不同，此时只能作为参考
+func (m *migrator) verify() (mismatch int) {
+	for _, sample := range m.samples {
+		src, key := sample.src, sample.key
+		srcType, _ := src.Type(key).Result()
+		dstType, _ := m.dst.Type(key).Result()
+		if srcType == "none" {
+			// 迁移之后源已经删除
+			continue
+		}
+		if srcType != dstType {
+			log.Printf("verify %s type src %s dst %s\n", key, srcType, dstType)
+			mismatch++
+			continue
+		}
+		srcDump, _ := src.Dump(key).Result()
+		dstDump, _ := m.dst.Dump(key).Result()
+		if srcDump != dstDump {
+			log.Printf("verify %s value not equal\n", key)
+			mismatch++
+			continue
+		}
+		srcTTL, _ := src.PTTL(key).Result()
+		dstTTL, _ := m.dst.PTTL(key).Result()
+		if srcTTL == ttlMissing || dstTTL == ttlMissing {
+			// 对比期间键已经过期
+			continue
+		}
+		if !ttlEqual(srcTTL, dstTTL) {
+			log.Printf("verify %s ttl src %s dst %s\n", key, srcTTL, dstTTL)
+			mismatch++
+		}
+	}
+	return mismatch
+}
+
+func main() {
+	flag.Parse()
+	if *srcConfig == "" || *dstConfig == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *workers <= 0 {
+		*workers = 1
+	}
+
+	srcConf, err := lredis.ParseConfig(*srcConfig)
+	if err != nil {
+		log.Fatalf("read src config err: %s", err.Error())
+	}
+	dstConf, err := lredis.ParseConfig(*dstConfig)
+	if err != nil {
+		log.Fatalf("read dst config err: %s", err.Error())
+	}
+	srcClients, err := lredis.OpenConfig(srcConf)
+	if err != nil {
+		log.Fatalf("open src err: %s", err.Error())
+	}
+	// 目标只能是一个单实例或者一个集群(由集群客户端路由)
+	// lredis没有在多个单实例之间分片键的规则，无法确定每个键应该写入哪个节点
+	if hosts := strings.Split(dstConf.Hosts, ","); len(hosts) > 1 {
+		log.Fatalf("dst has %d hosts, sharded destination not supported, use a single instance or one cluster seed host", len(hosts))
+	}
+	dstClients, err := lredis.OpenConfig(dstConf)
+	if err != nil {
+		log.Fatalf("open dst err: %s", err.Error())
+	}
+
+	cp, err := loadCheckpoint(*checkpointFile)
+	if err != nil {
+		log.Fatalf("load checkpoint err: %s", err.Error())
+	}
+
+	m := &migrator{
+		dst:     dstClients[0],
+		limiter: newLimiter(*rate),
+		cp:      cp,
+	}
+
+	nodes := []redis.Cmdable{}
+	for _, client := range srcClients {
+		masters, err := lredis.MasterNodes(client)
+		if err != nil {
+			log.Fatalf("get src nodes err: %s", err.Error())
+		}
+		nodes = append(nodes, masters...)
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	var failedNodes int32
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node redis.Cmdable) {
+			defer wg.Done()
+			if err := m.migrateNode(node); err != nil {
+				atomic.AddInt32(&failedNodes, 1)
+				log.Printf("node %s migrate err: %s\n", lredis.NodeAddr(node), err.Error())
+			}
+		}(node)
+	}
+	wg.Wait()
+
+	fmt.Printf("migrated:%d skipped:%d missing:%d failed:%d cost:%s\n",
+		m.migrated, m.skipped, m.missing, m.failed, time.Since(start))
+
+	mismatch := 0
+	if *verifyNum > 0 && len(m.samples) > 0 {
+		mismatch = m.verify()
+		fmt.Printf("verify samples:%d mismatch:%d\n", len(m.samples), mismatch)
+	}
+
+	if failedNodes > 0 || m.failed > 0 || mismatch > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cp.json")
+
+	cp, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor, done := cp.get("a:6379"); cursor != 0 || done {
+		t.Fatalf("new checkpoint %d %v", cursor, done)
+	}
+	if err := cp.save("a:6379", 42, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.save("b:6379", 0, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("temporary file should be renamed")
+	}
+
+	// 重新加载之后从保存的游标继续
+	cp, err = loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor, done := cp.get("a:6379"); cursor != 42 || done {
+		t.Fatalf("a %d %v", cursor, done)
+	}
+	if _, done := cp.get("b:6379"); !done {
+		t.Fatal("b should be done")
+	}
+
+	ioutil.WriteFile(path, []byte("{"), 0644)
+	if _, err := loadCheckpoint(path); err == nil {
+		t.Fatal("corrupt checkpoint should fail")
+	}
+}
+
+// TestSample 蓄水池抽样最多保留verify个键
+func TestSample(t *testing.T) {
+	old := *verifyNum
+	defer func() { *verifyNum = old }()
+	*verifyNum = 10
+
+	m := &migrator{}
+	for i := 0; i < 1000; i++ {
+		m.sample(nil, "key")
+	}
+	if len(m.samples) != 10 || m.sampleSeen != 1000 {
+		t.Fatalf("samples %d seen %d", len(m.samples), m.sampleSeen)
+	}
+
+	*verifyNum = 0
+	m = &migrator{}
+	m.sample(nil, "key")
+	if len(m.samples) != 0 {
+		t.Fatal("sample with verify disabled")
+	}
+}
+
+func TestGlobEscape(t *testing.T) {
+	for prefix, want := range map[string]string{
+		"user:":     "user:",
+		"a*b?":      `a\*b\?`,
+		"[tag]":     `\[tag\]`,
+		`back\path`: `back\\path`,
+	} {
+		if got := globEscape(prefix); got != want {
+			t.Fatalf("globEscape(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+}
+
+func TestTTLEqual(t *testing.T) {
+	noTTL := -time.Millisecond
+	cases := []struct {
+		src, dst time.Duration
+		want     bool
+	}{
+		{noTTL, noTTL, true},
+		{noTTL, time.Minute, false},
+		{time.Minute, noTTL, false},
+		{time.Minute, time.Minute - 200*time.Millisecond, true},
+		{time.Hour, time.Minute, false},
+	}
+	for _, c := range cases {
+		if got := ttlEqual(c.src, c.dst); got != c.want {
+			t.Fatalf("ttlEqual(%s, %s) = %v", c.src, c.dst, got)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+// 启动myredis服务，可以使用redis-cli或者go-redis连接
+// 使用示例
+// myredis-server -addr :6380 -databases 16 -maxmemory 104857600 -maxmemory-policy allkeys-lru
+
+import (
+	"flag"
+	"learn/l_redis/myredis"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var (
+	addr      = flag.String("addr", ":6380", "监听的地址")
+	databases = flag.Int("databases", 16, "数据库的数量")
+	hz        = flag.Int("hz", 10, "后台任务每秒执行的次数 [1, 500]")
+
+	maxmemory        = flag.Int("maxmemory", 0, "最大内存 byte，0表示不限制")
+	maxmemoryPolicy  = flag.String("maxmemory-policy", "noeviction", "内存淘汰策略")
+	maxmemorySamples = flag.Int("maxmemory-samples", myredis.MAXMEMORY_SAMPLES, "淘汰时每次抽样的键数")
+	lfuLogFactor     = flag.Int("lfu-log-factor", myredis.LFU_LOG_FACTOR, "lfu计数器增长的对数因子")
+	lfuDecayTime     = flag.Int("lfu-decay-time", myredis.LFU_DECAY_TIME, "lfu计数器衰减的周期 分钟")
+)
+
+func main() {
+	flag.Parse()
+	server := myredis.NewServer()
+	server.DBNum = *databases
+	server.Hz = *hz
+	server.MaxMemory = *maxmemory
+	server.MaxMemorySamples = *maxmemorySamples
+	server.LfuLogFactor = *lfuLogFactor
+	server.LfuDecayTime = *lfuDecayTime
+	policy, ok := myredis.ParseMemoryPolicy(*maxmemoryPolicy)
+	if !ok {
+		log.Fatalf("invalid maxmemory-policy %s\n", *maxmemoryPolicy)
+	}
+	server.MaxMemoryPolicy = policy
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		server.Shutdown()
+	}()
+
+	if err := server.ListenAndServe(*addr); err != nil && err != myredis.ErrServerClosed {
+		log.Fatalf("myredis server err: %s\n", err.Error())
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis"
@@ -20,18 +21,30 @@ const (
 )
 
 func ReadConfig(filePath string) error {
+	config, err := ParseConfig(filePath)
+	if err != nil {
+		return err
+	}
+	redisConfig = *config
+	return nil
+}
+
+// ParseConfig 读取配置文件并填充默认值，不修改全局配置
+// 需要同时连接多个redis时使用，如迁移工具的源和目标
+func ParseConfig(filePath string) (*RedisConfig, error) {
 	log.Printf("read config info %s\n", filePath)
 	configByte, err := ioutil.ReadFile(filePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	var redisConfig RedisConfig
 	if err := yaml.Unmarshal(configByte, &redisConfig); err != nil {
 		log.Fatalf("read  config err: %s\n", err.Error())
-		return err
+		return nil, err
 	}
 
 	if redisConfig.Hosts == "" {
-		return errors.New("must have db hosts")
+		return nil, errors.New("must have db hosts")
 	}
 
 	if redisConfig.PoolSize == 0 {
@@ -42,6 +55,11 @@ func ReadConfig(filePath string) error {
 		redisConfig.MaxRetries = 3
 	}
 
+	// -1 关闭go-redis自身的重试，由Resilient按照命令类别重试
+	if redisConfig.MaxRetries < 0 {
+		redisConfig.MaxRetries = 0
+	}
+
 	if redisConfig.IdleConns == 0 {
 		redisConfig.IdleConns = 10
 	}
@@ -70,7 +88,7 @@ func ReadConfig(filePath string) error {
 		redisConfig.DB = 0
 	}
 
-	return nil
+	return &redisConfig, nil
 }
 
 type RedisConfig struct {
@@ -84,11 +102,20 @@ type RedisConfig struct {
 	IdleTime   time.Duration `yaml:"idle_time"`
 	LifeTime   time.Duration `yaml:"life_time"`
 	DBMod      int           `yaml:"db_mod"` // redis模式 1 单例 2 主从 3 哨兵
+	// 允许部分节点不可用时启动，不可用的节点由HealthChecker继续探测
+	AllowDegraded bool `yaml:"allow_degraded"`
 }
 
 func Open() ([]redis.Cmdable, error) {
+	return OpenConfig(&redisConfig)
+}
+
+// OpenConfig 使用给定的配置连接redis
+func OpenConfig(redisConfig *RedisConfig) ([]redis.Cmdable, error) {
 	hosts := strings.Split(redisConfig.Hosts, ",")
 	clients := make([]redis.Cmdable, len(hosts))
+	failed := 0
+	var lastErr error
 	for index, host := range hosts {
 		log.Printf("open db host %s \n", host)
 		var client redis.Cmdable
@@ -117,10 +144,67 @@ func Open() ([]redis.Cmdable, error) {
 			})
 		}
 		if _, err := client.Ping().Result(); err != nil {
-			return nil, err
+			if !redisConfig.AllowDegraded {
+				return nil, err
+			}
+			log.Printf("open db host %s degraded err: %s\n", host, err.Error())
+			lastErr = err
+			failed++
 		}
 		clients[index] = client
 	}
 
+	if failed == len(hosts) {
+		// 降级模式下至少需要一个节点可用
+		return nil, lastErr
+	}
 	return clients, nil
 }
+
+// processor 可以执行任意命令的客户端, redis.Client和redis.ClusterClient均已实现
+type processor interface {
+	Process(cmd redis.Cmder) error
+}
+
+// doCmd 执行go-redis没有封装的命令, 如XAUTOCLAIM、XTRIM MINID
+func doCmd(client redis.Cmdable, args ...interface{}) (interface{}, error) {
+	p, ok := client.(processor)
+	if !ok {
+		return nil, errors.New("client not support process command")
+	}
+	cmd := redis.NewCmd(args...)
+	if err := p.Process(cmd); err != nil {
+		return nil, err
+	}
+	return cmd.Result()
+}
+
+// MasterNodes 返回客户端对应的所有主节点
+// 集群模式下返回每个主节点的客户端，SCAN、KEYS等命令需要在每个节点上执行
+// 单实例返回自身
+func MasterNodes(client redis.Cmdable) ([]redis.Cmdable, error) {
+	cluster, ok := client.(*redis.ClusterClient)
+	if !ok {
+		return []redis.Cmdable{client}, nil
+	}
+	var mu sync.Mutex
+	nodes := []redis.Cmdable{}
+	err := cluster.ForEachMaster(func(c *redis.Client) error {
+		mu.Lock()
+		nodes = append(nodes, c)
+		mu.Unlock()
+		return nil
+	})
+	return nodes, err
+}
+
+// NodeAddr 返回节点的地址，用于日志和断点记录
+func NodeAddr(client redis.Cmdable) string {
+	if c, ok := client.(*redis.Client); ok {
+		return c.Options().Addr
+	}
+	if c, ok := client.(*redis.ClusterClient); ok {
+		return strings.Join(c.Options().Addrs, ",")
+	}
+	return ""
+}
@@ -0,0 +1,474 @@
+package lredis
+
+import (
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/spaolacci/murmur3"
+)
+
+// 数据对比，用于迁移和故障转移之后确认两边的数据是否一致
+// 对比的两边可以是两个redis实例，也可以是redis实例和ExportFile导出的备份文件
+// 每个键计算类型、过期时间以及值的摘要，只对比摘要，大集合不需要完整传输到一处
+// list按照顺序计算sha1
+// hash、set、zset与顺序无关，每个元素计算murmur3之后求和，两边遍历顺序不同也能得到相同的摘要
+
+const (
+	DiffMissingInA = "missing_in_a" // 键只存在于B
+	DiffMissingInB = "missing_in_b" // 键只存在于A
+	DiffType       = "type"         // 类型不同
+	DiffTTL        = "ttl"          // 过期时间超出容差
+	DiffValue      = "value"        // 值不同
+)
+
+// DiffEntry 一个键的对比信息
+type DiffEntry struct {
+	Type   string `json:"type"`
+	TTL    int64  `json:"ttl"`    // 剩余过期时间 毫秒, 0 没有过期时间
+	Length int64  `json:"length"` // 元素个数，string为字节数
+	Digest string `json:"digest"`
+}
+
+// DiffResult 一个不一致的键
+type DiffResult struct {
+	Key  string     `json:"key"`
+	Kind string     `json:"kind"`
+	A    *DiffEntry `json:"a,omitempty"`
+	B    *DiffEntry `json:"b,omitempty"`
+}
+
+// DiffSummary 对比的汇总结果
+type DiffSummary struct {
+	Compared   int64   `json:"compared"`
+	MissingInA int64   `json:"missing_in_a"`
+	MissingInB int64   `json:"missing_in_b"`
+	TypeDiff   int64   `json:"type_diff"`
+	TTLDiff    int64   `json:"ttl_diff"`
+	ValueDiff  int64   `json:"value_diff"`
+	SampleRate float64 `json:"sample_rate"`
+}
+
+type DiffOptions struct {
+	TTLTolerance time.Duration // 两边过期时间的差值在此范围内认为一致
+	SampleRate   float64       // 抽样比例 (0, 1], 0 或者 1 对比所有的键
+}
+
+// DiffSource 参与对比的一方
+type DiffSource interface {
+	// Keys 遍历所有的键
+	Keys(fn func(key string) error) error
+	// Entry 返回键的对比信息，键不存在时返回nil
+	Entry(key string) (*DiffEntry, error)
+	// Exists 键是否存在，查找只存在于B的键时使用，避免重复计算摘要
+	Exists(key string) (bool, error)
+}
+
+// DiffReporter 处理每个不一致的键
+type DiffReporter func(result *DiffResult) error
+
+// NewJSONDiffReporter 每个不一致的键输出一行json
+func NewJSONDiffReporter(w io.Writer) DiffReporter {
+	encoder := json.NewEncoder(w)
+	return func(result *DiffResult) error {
+		return encoder.Encode(result)
+	}
+}
+
+// Diff 对比A和B，先遍历A对比每个键，再遍历B找出只存在于B的键
+func Diff(a, b DiffSource, opts DiffOptions, report DiffReporter) (*DiffSummary, error) {
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+	summary := &DiffSummary{SampleRate: opts.SampleRate}
+
+	err := a.Keys(func(key string) error {
+		if !diffSampled(key, opts.SampleRate) {
+			return nil
+		}
+		entryA, err := a.Entry(key)
+		if err != nil {
+			return err
+		}
+		if entryA == nil {
+			// 遍历之后被删除
+			return nil
+		}
+		entryB, err := b.Entry(key)
+		if err != nil {
+			return err
+		}
+		summary.Compared++
+
+		kind := ""
+		switch {
+		case entryB == nil:
+			kind = DiffMissingInB
+			summary.MissingInB++
+		case entryA.Type != entryB.Type:
+			kind = DiffType
+			summary.TypeDiff++
+		case !ttlEqual(entryA.TTL, entryB.TTL, opts.TTLTolerance):
+			kind = DiffTTL
+			summary.TTLDiff++
+		case entryA.Digest != entryB.Digest:
+			kind = DiffValue
+			summary.ValueDiff++
+		}
+		if kind == "" || report == nil {
+			return nil
+		}
+		return report(&DiffResult{Key: key, Kind: kind, A: entryA, B: entryB})
+	})
+	if err != nil {
+		return summary, err
+	}
+
+	err = b.Keys(func(key string) error {
+		if !diffSampled(key, opts.SampleRate) {
+			return nil
+		}
+		exists, err := a.Exists(key)
+		if err != nil || exists {
+			return err
+		}
+		entryB, err := b.Entry(key)
+		if err != nil || entryB == nil {
+			return err
+		}
+		summary.MissingInA++
+		if report == nil {
+			return nil
+		}
+		return report(&DiffResult{Key: key, Kind: DiffMissingInA, B: entryB})
+	})
+	return summary, err
+}
+
+// diffSampled 根据键的哈希值抽样，两边对同一个键的抽样结果相同
+func diffSampled(key string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return float64(h.Sum32()%10000) < rate*10000
+}
+
+func ttlEqual(a, b int64, tolerance time.Duration) bool {
+	if (a == 0) != (b == 0) {
+		return false
+	}
+	diff := a - b
+	if diff < 0 {
+		diff = -diff
+	}
+	return time.Duration(diff)*time.Millisecond <= tolerance
+}
+
+// digester 计算值的摘要
+type digester struct {
+	ordered hash.Hash // list 和 string 按照顺序计算
+	// 与顺序无关的摘要，每个元素murmur3 128位求和
+	sumHigh, sumLow uint64
+	// SCAN可能重复返回同一个元素，这里去重
+	seen   map[[2]uint64]struct{}
+	length int64
+}
+
+func newDigester() *digester {
+	return &digester{ordered: sha1.New(), seen: map[[2]uint64]struct{}{}}
+}
+
+func (d *digester) addOrdered(item []byte) {
+	var head [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(head[:], uint64(len(item)))
+	d.ordered.Write(head[:n])
+	d.ordered.Write(item)
+	d.length++
+}
+
+func (d *digester) addUnordered(parts ...[]byte) {
+	h := murmur3.New128()
+	for _, part := range parts {
+		var head [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(head[:], uint64(len(part)))
+		h.Write(head[:n])
+		h.Write(part)
+	}
+	high, low := h.Sum128()
+	if _, ok := d.seen[[2]uint64{high, low}]; ok {
+		return
+	}
+	d.seen[[2]uint64{high, low}] = struct{}{}
+	d.sumHigh += high
+	d.sumLow += low
+	d.length++
+}
+
+// add 根据类型添加一批元素，元素的格式和BackupRecord.Items相同
+func (d *digester) add(keyType string, items [][]byte) error {
+	switch keyType {
+	case "string":
+		for _, item := range items {
+			d.ordered.Write(item)
+			d.length += int64(len(item))
+		}
+	case "list":
+		for _, item := range items {
+			d.addOrdered(item)
+		}
+	case "set":
+		for _, item := range items {
+			d.addUnordered(item)
+		}
+	case "hash":
+		for i := 0; i+1 < len(items); i += 2 {
+			d.addUnordered(items[i], items[i+1])
+		}
+	case "zset":
+		for i := 0; i+1 < len(items); i += 2 {
+			// 统一分值的格式
+			score, err := strconv.ParseFloat(string(items[i+1]), 64)
+			if err != nil {
+				return fmt.Errorf("zset score %s", items[i+1])
+			}
+			d.addUnordered(items[i], []byte(strconv.FormatFloat(score, 'g', -1, 64)))
+		}
+	default:
+		return fmt.Errorf("digest type %s not support", keyType)
+	}
+	return nil
+}
+
+func (d *digester) digest(keyType string) string {
+	if keyType == "string" || keyType == "list" {
+		return hex.EncodeToString(d.ordered.Sum(nil))
+	}
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], d.sumHigh)
+	binary.BigEndian.PutUint64(buf[8:], d.sumLow)
+	return hex.EncodeToString(buf[:])
+}
+
+// RedisDiffSource 使用redis实例参与对比
+type RedisDiffSource struct {
+	Client    redis.Cmdable
+	Match     string // 为空对比所有的键
+	ScanCount int64
+	BatchSize int64 // 大集合每批读取的元素个数
+}
+
+func (s *RedisDiffSource) Keys(fn func(key string) error) error {
+	match := s.Match
+	if match == "" {
+		match = "*"
+	}
+	count := s.ScanCount
+	if count <= 0 {
+		count = 500
+	}
+	nodes, err := MasterNodes(s.Client)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		var cursor uint64
+		for {
+			keys, next, err := node.Scan(cursor, match, count).Result()
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if err := fn(key); err != nil {
+					return err
+				}
+			}
+			cursor = next
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (s *RedisDiffSource) Exists(key string) (bool, error) {
+	num, err := s.Client.Exists(key).Result()
+	return num > 0, err
+}
+
+func (s *RedisDiffSource) Entry(key string) (*DiffEntry, error) {
+	batch := s.BatchSize
+	if batch <= 0 {
+		batch = 1000
+	}
+	keyType, err := s.Client.Type(key).Result()
+	if err != nil || keyType == "none" {
+		return nil, err
+	}
+	pttl, err := s.Client.PTTL(key).Result()
+	// go-redis把PTTL的-2(键不存在)转换为 -2ms
+	if err != nil || pttl == -2*time.Millisecond {
+		return nil, err
+	}
+	entry := &DiffEntry{Type: keyType}
+	if pttl > 0 {
+		entry.TTL = int64(pttl / time.Millisecond)
+	}
+
+	d := newDigester()
+	switch keyType {
+	case "string":
+		val, err := s.Client.Get(key).Result()
+		if err == redis.Nil {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		d.add(keyType, [][]byte{[]byte(val)})
+	case "list":
+		for start := int64(0); ; start += batch {
+			items, err := s.Client.LRange(key, start, start+batch-1).Result()
+			if err != nil {
+				return nil, err
+			}
+			d.add(keyType, toBytes(items))
+			if int64(len(items)) < batch {
+				break
+			}
+		}
+	case "hash", "set", "zset":
+		var cursor uint64
+		for {
+			var cmd *redis.ScanCmd
+			switch keyType {
+			case "hash":
+				cmd = s.Client.HScan(key, cursor, "", batch)
+			case "set":
+				cmd = s.Client.SScan(key, cursor, "", batch)
+			default:
+				cmd = s.Client.ZScan(key, cursor, "", batch)
+			}
+			items, next, err := cmd.Result()
+			if err != nil {
+				return nil, err
+			}
+			if err := d.add(keyType, toBytes(items)); err != nil {
+				return nil, err
+			}
+			cursor = next
+			if cursor == 0 {
+				break
+			}
+		}
+	default:
+		// 其他类型只对比类型和过期时间
+	}
+	entry.Length = d.length
+	entry.Digest = d.digest(keyType)
+	return entry, nil
+}
+
+// BackupDiffSource 使用备份文件参与对比
+// 加载时只在内存中保留每个键的摘要
+// 备份文件中记录的是过期的时间点，加载时换算为剩余时间，已经过期的键不参与对比
+type BackupDiffSource struct {
+	keys    []string
+	entries map[string]*DiffEntry
+}
+
+// NewBackupDiffSource 加载备份文件，format需要和导出时一致
+func NewBackupDiffSource(filePath, format string) (*BackupDiffSource, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	reader, err := newRecordReader(zr, format)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &BackupDiffSource{entries: map[string]*DiffEntry{}}
+	// 同一个键的记录在备份文件中是连续的，键变化时计算上一个键的摘要
+	var entry *DiffEntry
+	var d *digester
+	now := time.Now()
+	// 当前键已经过期，跳过它的所有记录
+	skip := false
+	finish := func() {
+		if entry != nil {
+			entry.Length = d.length
+			entry.Digest = d.digest(entry.Type)
+		}
+	}
+	for {
+		record, err := reader.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if record.Seq == 0 {
+			finish()
+			entry = nil
+			ttl := record.remainingTTL(now)
+			if skip = ttl < 0; skip {
+				continue
+			}
+			d = newDigester()
+			entry = &DiffEntry{Type: record.Type, TTL: ttl}
+			if _, ok := s.entries[record.Key]; !ok {
+				s.keys = append(s.keys, record.Key)
+			}
+			s.entries[record.Key] = entry
+		}
+		if skip {
+			continue
+		}
+		if entry == nil {
+			return nil, fmt.Errorf("backup key %s first record seq %d", record.Key, record.Seq)
+		}
+		if err := d.add(record.Type, record.Items); err != nil {
+			return nil, err
+		}
+	}
+	finish()
+	return s, nil
+}
+
+func (s *BackupDiffSource) Keys(fn func(key string) error) error {
+	for _, key := range s.keys {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BackupDiffSource) Entry(key string) (*DiffEntry, error) {
+	return s.entries[key], nil
+}
+
+func (s *BackupDiffSource) Exists(key string) (bool, error) {
+	_, ok := s.entries[key]
+	return ok, nil
+}
@@ -0,0 +1,251 @@
+package lredis
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func digestOf(t *testing.T, keyType string, batches ...[]string) string {
+	t.Helper()
+	d := newDigester()
+	for _, batch := range batches {
+		if err := d.add(keyType, toBytes(batch)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return d.digest(keyType)
+}
+
+func TestDigest(t *testing.T) {
+	// list与顺序相关，与分批的方式无关
+	list := digestOf(t, "list", []string{"a", "b", "c"})
+	if digestOf(t, "list", []string{"a"}, []string{"b", "c"}) != list {
+		t.Fatal("list digest depends on batches")
+	}
+	if digestOf(t, "list", []string{"c", "b", "a"}) == list {
+		t.Fatal("list digest should depend on order")
+	}
+	// 元素带长度前缀，拼接相同的元素不会冲突
+	if digestOf(t, "list", []string{"ab", "c"}) == digestOf(t, "list", []string{"a", "bc"}) {
+		t.Fatal("list digest ambiguous")
+	}
+
+	// set、hash、zset与顺序无关，SCAN重复返回的元素只计算一次
+	set := digestOf(t, "set", []string{"a", "b", "c"})
+	if digestOf(t, "set", []string{"c", "a"}, []string{"b", "a"}) != set {
+		t.Fatal("set digest depends on order or duplicates")
+	}
+	if digestOf(t, "set", []string{"a", "b"}) == set {
+		t.Fatal("set digest ignores missing member")
+	}
+	hash := digestOf(t, "hash", []string{"f1", "v1", "f2", "v2"})
+	if digestOf(t, "hash", []string{"f2", "v2"}, []string{"f1", "v1"}) != hash {
+		t.Fatal("hash digest depends on order")
+	}
+	if digestOf(t, "hash", []string{"f1", "v2", "f2", "v1"}) == hash {
+		t.Fatal("hash digest ignores field value pairs")
+	}
+	// 分值的格式统一之后计算
+	if digestOf(t, "zset", []string{"m", "1.50", "n", "2"}) != digestOf(t, "zset", []string{"n", "2.0", "m", "1.5"}) {
+		t.Fatal("zset digest depends on score format")
+	}
+
+	d := newDigester()
+	if err := d.add("zset", toBytes([]string{"m", "x"})); err == nil {
+		t.Fatal("invalid zset score should fail")
+	}
+	if err := d.add("stream", nil); err == nil {
+		t.Fatal("unsupported type should fail")
+	}
+	d = newDigester()
+	d.add("string", toBytes([]string{"hello"}))
+	if d.length != 5 {
+		t.Fatalf("string length %d", d.length)
+	}
+}
+
+func TestTTLEqual(t *testing.T) {
+	for _, c := range []struct {
+		a, b      int64
+		tolerance time.Duration
+		want      bool
+	}{
+		{0, 0, 0, true},
+		{0, 1000, time.Hour, false},
+		{1000, 0, time.Hour, false},
+		{1000, 1500, time.Second, true},
+		{1500, 1000, time.Second, true},
+		{1000, 2500, time.Second, false},
+	} {
+		if got := ttlEqual(c.a, c.b, c.tolerance); got != c.want {
+			t.Fatalf("ttlEqual(%d, %d, %s) = %v", c.a, c.b, c.tolerance, got)
+		}
+	}
+}
+
+func TestDiffSampled(t *testing.T) {
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		key := "key:" + strconv.Itoa(i)
+		if diffSampled(key, 0.1) {
+			sampled++
+		}
+		if diffSampled(key, 0.1) != diffSampled(key, 0.1) || !diffSampled(key, 1) {
+			t.Fatal("sampling should depend only on the key")
+		}
+	}
+	if sampled < 800 || sampled > 1200 {
+		t.Fatalf("sampled %d of 10000 at rate 0.1", sampled)
+	}
+}
+
+// mapDiffSource 内存中的对比数据
+type mapDiffSource map[string]*DiffEntry
+
+func (s mapDiffSource) Keys(fn func(key string) error) error {
+	for key := range s {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s mapDiffSource) Entry(key string) (*DiffEntry, error) {
+	return s[key], nil
+}
+
+func (s mapDiffSource) Exists(key string) (bool, error) {
+	_, ok := s[key]
+	return ok, nil
+}
+
+func TestDiff(t *testing.T) {
+	a := mapDiffSource{
+		"same":   {Type: "string", Digest: "x"},
+		"onlyA":  {Type: "string", Digest: "x"},
+		"type":   {Type: "string", Digest: "x"},
+		"ttl":    {Type: "string", TTL: 1000, Digest: "x"},
+		"ttlok":  {Type: "string", TTL: 1000, Digest: "x"},
+		"value":  {Type: "list", Digest: "x"},
+		"noTTLb": {Type: "string", TTL: 1000, Digest: "x"},
+	}
+	b := mapDiffSource{
+		"same":   {Type: "string", Digest: "x"},
+		"onlyB":  {Type: "string", Digest: "x"},
+		"type":   {Type: "list", Digest: "x"},
+		"ttl":    {Type: "string", TTL: 5000, Digest: "x"},
+		"ttlok":  {Type: "string", TTL: 1200, Digest: "x"},
+		"value":  {Type: "list", Digest: "y"},
+		"noTTLb": {Type: "string", Digest: "x"},
+	}
+	kinds := map[string]string{}
+	summary, err := Diff(a, b, DiffOptions{TTLTolerance: time.Second}, func(result *DiffResult) error {
+		kinds[result.Key] = result.Kind
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"onlyA":  DiffMissingInB,
+		"onlyB":  DiffMissingInA,
+		"type":   DiffType,
+		"ttl":    DiffTTL,
+		"noTTLb": DiffTTL,
+		"value":  DiffValue,
+	}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Fatalf("diff %v, want %v", kinds, want)
+	}
+	wantSummary := DiffSummary{Compared: 7, MissingInA: 1, MissingInB: 1, TypeDiff: 1, TTLDiff: 2, ValueDiff: 1, SampleRate: 1}
+	if *summary != wantSummary {
+		t.Fatalf("summary %+v", summary)
+	}
+
+	stop := io.ErrShortWrite
+	if _, err := Diff(a, b, DiffOptions{}, func(*DiffResult) error { return stop }); err != stop {
+		t.Fatalf("reporter error %v", err)
+	}
+}
+
+// TestBackupDiffSource 备份文件中的摘要与分批方式无关
+func TestBackupDiffSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backup.gz")
+	expireAt := unixMilli(time.Now().Add(time.Minute))
+	records := []*BackupRecord{
+		{Key: "l", Type: "list", ExpireAt: expireAt, Seq: 0, Items: toBytes([]string{"a"})},
+		{Key: "l", Type: "list", ExpireAt: expireAt, Seq: 1, Items: toBytes([]string{"b", "c"})},
+		{Key: "s", Type: "set", Items: toBytes([]string{"x", "y"})},
+		// 已经过期的键不参与对比
+		{Key: "e", Type: "list", ExpireAt: 1, Seq: 0, Items: toBytes([]string{"a"})},
+		{Key: "e", Type: "list", ExpireAt: 1, Seq: 1, Items: toBytes([]string{"b"})},
+	}
+	data, _ := ioutil.ReadAll(gzipRecords(t, records))
+	ioutil.WriteFile(path, data, 0644)
+
+	s, err := NewBackupDiffSource(path, BackupFormatBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	s.Keys(func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if !reflect.DeepEqual(keys, []string{"l", "s"}) {
+		t.Fatalf("keys %v", keys)
+	}
+	l, _ := s.Entry("l")
+	// 过期时间换算为加载时的剩余时间
+	if l == nil || l.TTL <= 0 || l.TTL > int64(time.Minute/time.Millisecond) {
+		t.Fatalf("entry %+v", l)
+	}
+	want := &DiffEntry{Type: "list", TTL: l.TTL, Length: 3, Digest: digestOf(t, "list", []string{"a", "b", "c"})}
+	if !reflect.DeepEqual(l, want) {
+		t.Fatalf("entry %+v, want %+v", l, want)
+	}
+	if exists, _ := s.Exists("missing"); exists {
+		t.Fatal("missing key exists")
+	}
+
+	// 第一条记录的seq不为0
+	data, _ = ioutil.ReadAll(gzipRecords(t, records[1:]))
+	ioutil.WriteFile(path, data, 0644)
+	if _, err := NewBackupDiffSource(path, BackupFormatBinary); err == nil {
+		t.Fatal("backup starting with seq 1 should fail")
+	}
+}
+
+// expiringClient TYPE之后键过期，PTTL返回键不存在
+type expiringClient struct {
+	redis.Cmdable
+}
+
+func (c *expiringClient) Type(key string) *redis.StatusCmd {
+	return redis.NewStatusResult("string", nil)
+}
+
+func (c *expiringClient) PTTL(key string) *redis.DurationCmd {
+	return redis.NewDurationResult(-2*time.Millisecond, nil)
+}
+
+func TestRedisDiffSourceExpired(t *testing.T) {
+	s := &RedisDiffSource{Client: &expiringClient{}}
+	if entry, err := s.Entry("k"); entry != nil || err != nil {
+		t.Fatalf("expired key entry %+v %v", entry, err)
+	}
+}
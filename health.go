@@ -0,0 +1,227 @@
+package lredis
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// 后台健康检查
+// 按照Interval对每个节点执行PING并记录往返时间
+// 状态切换带有滞后: 连续FallCount次失败才标记为不可用，连续RiseCount次成功才恢复为可用，避免网络抖动时状态来回切换
+// 调用方可以通过HealthyClients跳过不可用的节点，通过Events接收状态变化
+
+type HealthConfig struct {
+	Interval  time.Duration // 检查间隔
+	RiseCount int           // 连续成功多少次标记为可用
+	FallCount int           // 连续失败多少次标记为不可用
+	EventSize int           // 状态变化事件的缓冲区大小，缓冲区满时丢弃事件
+}
+
+// HostStatus 单个节点的健康状态
+type HostStatus struct {
+	Addr      string
+	Up        bool
+	RTT       time.Duration // 最近一次成功PING的往返时间
+	LastError string        // 最近一次失败的原因
+	LastCheck time.Time
+	Successes int // 连续成功的次数
+	Failures  int // 连续失败的次数
+}
+
+// HealthEvent 节点状态变化事件
+type HealthEvent struct {
+	Addr string
+	Up   bool
+	At   time.Time
+	Err  string
+}
+
+type hostHealth struct {
+	client  redis.Cmdable
+	status  HostStatus
+	checked bool // 第一次检查的结果直接作为初始状态
+}
+
+type HealthChecker struct {
+	conf   HealthConfig
+	hosts  []*hostHealth
+	mu     sync.RWMutex
+	events chan HealthEvent
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+
+	// stopMu 保证Start中的wg.Add发生在Stop关闭stopCh之前，或者Start看到stopCh已经关闭
+	stopMu    sync.Mutex
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+// NewHealthChecker 创建健康检查，clients为Open返回的客户端
+func NewHealthChecker(clients []redis.Cmdable, conf HealthConfig) *HealthChecker {
+	if conf.Interval <= 0 {
+		conf.Interval = time.Second
+	}
+	if conf.RiseCount <= 0 {
+		conf.RiseCount = 2
+	}
+	if conf.FallCount <= 0 {
+		conf.FallCount = 3
+	}
+	if conf.EventSize <= 0 {
+		conf.EventSize = 64
+	}
+	hc := &HealthChecker{
+		conf:   conf,
+		hosts:  make([]*hostHealth, len(clients)),
+		events: make(chan HealthEvent, conf.EventSize),
+		stopCh: make(chan struct{}),
+	}
+	for i, client := range clients {
+		addr := NodeAddr(client)
+		if addr == "" {
+			addr = fmt.Sprintf("host-%d", i)
+		}
+		hc.hosts[i] = &hostHealth{client: client, status: HostStatus{Addr: addr}}
+	}
+	return hc
+}
+
+// Start 立即检查一次，然后在后台定时检查，多次调用只启动一次，Stop之后不再启动
+func (hc *HealthChecker) Start() {
+	hc.startOnce.Do(func() {
+		hc.stopMu.Lock()
+		select {
+		case <-hc.stopCh:
+			hc.stopMu.Unlock()
+			return
+		default:
+		}
+		// 第一次检查也计入wg，并发的Stop等待检查结束之后才关闭事件通道
+		hc.wg.Add(1)
+		hc.stopMu.Unlock()
+
+		hc.checkAll()
+		go func() {
+			defer hc.wg.Done()
+			ticker := time.NewTicker(hc.conf.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-hc.stopCh:
+					return
+				case <-ticker.C:
+					hc.checkAll()
+				}
+			}
+		}()
+	})
+}
+
+// Stop 停止检查并关闭事件通道
+func (hc *HealthChecker) Stop() {
+	hc.stopOnce.Do(func() {
+		hc.stopMu.Lock()
+		close(hc.stopCh)
+		hc.stopMu.Unlock()
+		hc.wg.Wait()
+		close(hc.events)
+	})
+}
+
+// Events 节点状态变化的事件
+func (hc *HealthChecker) Events() <-chan HealthEvent {
+	return hc.events
+}
+
+// Status 返回所有节点的状态快照
+func (hc *HealthChecker) Status() []HostStatus {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	status := make([]HostStatus, len(hc.hosts))
+	for i, host := range hc.hosts {
+		status[i] = host.status
+	}
+	return status
+}
+
+// Healthy 客户端对应的节点是否可用，不在检查列表中的客户端认为可用
+func (hc *HealthChecker) Healthy(client redis.Cmdable) bool {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	for _, host := range hc.hosts {
+		if host.client == client {
+			return host.status.Up
+		}
+	}
+	return true
+}
+
+// HealthyClients 返回所有可用的客户端，保持原有的顺序
+func (hc *HealthChecker) HealthyClients() []redis.Cmdable {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	clients := make([]redis.Cmdable, 0, len(hc.hosts))
+	for _, host := range hc.hosts {
+		if host.status.Up {
+			clients = append(clients, host.client)
+		}
+	}
+	return clients
+}
+
+// checkAll 并发检查所有的节点，单个节点超时不影响其他节点
+func (hc *HealthChecker) checkAll() {
+	var wg sync.WaitGroup
+	for _, host := range hc.hosts {
+		wg.Add(1)
+		go func(host *hostHealth) {
+			defer wg.Done()
+			start := time.Now()
+			err := host.client.Ping().Err()
+			hc.update(host, time.Since(start), err)
+		}(host)
+	}
+	wg.Wait()
+}
+
+func (hc *HealthChecker) update(host *hostHealth, rtt time.Duration, err error) {
+	hc.mu.Lock()
+	status := &host.status
+	wasUp := status.Up
+	status.LastCheck = time.Now()
+	if err == nil {
+		status.RTT = rtt
+		status.Successes++
+		status.Failures = 0
+		if !host.checked || status.Successes >= hc.conf.RiseCount {
+			status.Up = true
+		}
+	} else {
+		status.LastError = err.Error()
+		status.Failures++
+		status.Successes = 0
+		if !host.checked || status.Failures >= hc.conf.FallCount {
+			status.Up = false
+		}
+	}
+	changed := !host.checked || wasUp != status.Up
+	host.checked = true
+	event := HealthEvent{Addr: status.Addr, Up: status.Up, At: status.LastCheck}
+	if !status.Up {
+		event.Err = status.LastError
+	}
+	hc.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	log.Printf("redis host %s up: %v\n", event.Addr, event.Up)
+	select {
+	case hc.events <- event:
+	default:
+	}
+}
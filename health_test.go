@@ -0,0 +1,153 @@
+package lredis
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// fakePingClient down不为0时PING返回错误
+type fakePingClient struct {
+	redis.Cmdable
+	pings int32
+	down  int32
+}
+
+func (f *fakePingClient) Ping() *redis.StatusCmd {
+	atomic.AddInt32(&f.pings, 1)
+	if atomic.LoadInt32(&f.down) != 0 {
+		return redis.NewStatusResult("", errors.New("connection refused"))
+	}
+	return redis.NewStatusResult("PONG", nil)
+}
+
+func TestHealthHysteresis(t *testing.T) {
+	a, b := &fakePingClient{}, &fakePingClient{}
+	hc := NewHealthChecker([]redis.Cmdable{a, b}, HealthConfig{RiseCount: 2, FallCount: 3, EventSize: 16})
+	host := hc.hosts[0]
+	errDown := errors.New("connection refused")
+
+	var events []HealthEvent
+	drain := func() {
+		for {
+			select {
+			case event := <-hc.Events():
+				events = append(events, event)
+			default:
+				return
+			}
+		}
+	}
+
+	// 第一次检查的结果直接作为初始状态
+	hc.update(host, time.Millisecond, nil)
+	hc.update(hc.hosts[1], 0, errDown)
+	if !hc.Healthy(a) || hc.Healthy(b) {
+		t.Fatal("initial state")
+	}
+	if clients := hc.HealthyClients(); len(clients) != 1 || clients[0] != a {
+		t.Fatalf("healthy clients %v", clients)
+	}
+	drain()
+	if len(events) != 2 || !events[0].Up || events[1].Up || events[1].Err != errDown.Error() {
+		t.Fatalf("initial events %+v", events)
+	}
+
+	// 连续失败FallCount次才标记为不可用，中间成功一次重新计数
+	events = nil
+	for _, err := range []error{errDown, errDown, nil, errDown, errDown} {
+		hc.update(host, 0, err)
+		if !host.status.Up {
+			t.Fatalf("down after failures %d", host.status.Failures)
+		}
+	}
+	hc.update(host, 0, errDown)
+	if host.status.Up || host.status.Failures != 3 {
+		t.Fatalf("status %+v", host.status)
+	}
+	// 连续成功RiseCount次才恢复
+	hc.update(host, 0, nil)
+	if host.status.Up {
+		t.Fatal("up after one success")
+	}
+	hc.update(host, 2*time.Millisecond, nil)
+	if !host.status.Up || host.status.RTT != 2*time.Millisecond {
+		t.Fatalf("status %+v", host.status)
+	}
+	drain()
+	if len(events) != 2 || events[0].Up || !events[1].Up {
+		t.Fatalf("events %+v", events)
+	}
+
+	// 不在检查列表中的客户端认为可用
+	if !hc.Healthy(&fakePingClient{}) {
+		t.Fatal("unknown client should be healthy")
+	}
+}
+
+// TestHealthStartOnce 多次调用Start只启动一个检查协程
+func TestHealthStartOnce(t *testing.T) {
+	client := &fakePingClient{}
+	hc := NewHealthChecker([]redis.Cmdable{client}, HealthConfig{Interval: time.Hour})
+	hc.Start()
+	hc.Start()
+	if n := atomic.LoadInt32(&client.pings); n != 1 {
+		t.Fatalf("pings %d after starting twice", n)
+	}
+	hc.Stop()
+	hc.Stop()
+	hc.Start()
+	if n := atomic.LoadInt32(&client.pings); n != 1 {
+		t.Fatalf("pings %d after stop", n)
+	}
+	if _, ok := <-hc.Events(); !ok {
+		t.Fatal("initial event should be buffered")
+	}
+	if _, ok := <-hc.Events(); ok {
+		t.Fatal("events should be closed after stop")
+	}
+
+	// Stop之后Start不会再检查
+	hc = NewHealthChecker([]redis.Cmdable{client}, HealthConfig{})
+	hc.Stop()
+	hc.Start()
+	if n := atomic.LoadInt32(&client.pings); n != 1 {
+		t.Fatalf("pings %d when started after stop", n)
+	}
+}
+
+// TestHealthStartStopRace Stop与第一次检查并发时不能向已经关闭的事件通道发送
+func TestHealthStartStopRace(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		hc := NewHealthChecker([]redis.Cmdable{&fakePingClient{down: 1}}, HealthConfig{Interval: time.Hour, FallCount: 1})
+		done := make(chan struct{})
+		go func() {
+			hc.Stop()
+			close(done)
+		}()
+		hc.Start()
+		<-done
+	}
+}
+
+func TestHealthTicker(t *testing.T) {
+	client := &fakePingClient{down: 1}
+	hc := NewHealthChecker([]redis.Cmdable{client}, HealthConfig{Interval: 5 * time.Millisecond, RiseCount: 1})
+	hc.Start()
+	defer hc.Stop()
+	if event := <-hc.Events(); event.Up {
+		t.Fatalf("initial event %+v", event)
+	}
+	atomic.StoreInt32(&client.down, 0)
+	select {
+	case event := <-hc.Events():
+		if !event.Up {
+			t.Fatalf("event %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("host never recovered")
+	}
+}
@@ -0,0 +1,416 @@
+package lredis
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/spaolacci/murmur3"
+)
+
+// 热点键探测
+// 在客户端按照采样率对命令进行采样，采样到的键写入count-min sketch估算访问次数
+// 滑动窗口由多个桶组成，每个桶一个sketch，窗口内的次数为所有桶的估算值之和
+// 读和写分别维护一个Top-K候选集合，估算次数超过候选集合中最小值的键替换最小值
+// 估算QPS超过阈值时回调一次，低于阈值之后再次超过会再次回调
+
+const (
+	HotKeyRead  = "read"
+	HotKeyWrite = "write"
+)
+
+type HotKeyConfig struct {
+	SampleRate float64       // 采样率 (0, 1]
+	Window     time.Duration // 滑动窗口的长度
+	Buckets    int           // 滑动窗口的桶数，桶越多窗口滑动越平滑
+	TopK       int           // 读和写各保留的热点键个数
+	Width      int           // sketch每行的计数器个数
+	Depth      int           // sketch的行数
+	Threshold  float64       // 估算QPS超过此值时回调，0 不回调
+	OnHot      func(key HotKey)
+}
+
+// HotKey 热点键以及估算的QPS
+type HotKey struct {
+	Key  string
+	Kind string // read 或者 write
+	QPS  float64
+}
+
+// writeCommands 写命令，其他命令按照读命令统计
+var writeCommands = map[string]bool{
+	"set": true, "setnx": true, "setex": true, "psetex": true, "mset": true, "msetnx": true,
+	"append": true, "incr": true, "incrby": true, "incrbyfloat": true, "decr": true, "decrby": true,
+	"getset": true, "setrange": true, "setbit": true, "del": true, "unlink": true,
+	"expire": true, "pexpire": true, "expireat": true, "pexpireat": true, "persist": true,
+	"rename": true, "renamenx": true, "restore": true,
+	"lpush": true, "rpush": true, "lpushx": true, "rpushx": true, "lpop": true, "rpop": true,
+	"lset": true, "linsert": true, "lrem": true, "ltrim": true, "rpoplpush": true, "lmove": true,
+	"sadd": true, "srem": true, "spop": true, "smove": true,
+	"zadd": true, "zincrby": true, "zrem": true, "zremrangebyscore": true, "zremrangebyrank": true,
+	"zremrangebylex": true, "zpopmin": true, "zpopmax": true,
+	"hset": true, "hsetnx": true, "hmset": true, "hdel": true, "hincrby": true, "hincrbyfloat": true,
+	"pfadd": true, "pfmerge": true, "xadd": true, "xdel": true, "xtrim": true, "geoadd": true,
+}
+
+// commandKeys 返回命令访问的键
+func commandKeys(args []interface{}) []string {
+	if len(args) < 2 {
+		return nil
+	}
+	name := strings.ToLower(toString(args[0]))
+	switch name {
+	case "mget", "del", "unlink", "exists", "touch", "watch", "sinter", "sunion", "sdiff", "pfcount":
+		keys := make([]string, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			keys = append(keys, toString(arg))
+		}
+		return keys
+	case "mset", "msetnx":
+		keys := make([]string, 0, len(args)/2)
+		for i := 1; i < len(args); i += 2 {
+			keys = append(keys, toString(args[i]))
+		}
+		return keys
+	case "bitop":
+		// BITOP operation destkey key [key ...]
+		keys := make([]string, 0, len(args)-2)
+		for _, arg := range args[2:] {
+			keys = append(keys, toString(arg))
+		}
+		return keys
+	case "xread", "xreadgroup":
+		// XREADGROUP GROUP group consumer [COUNT count] STREAMS key [key ...] id [id ...]
+		for i := 1; i < len(args); i++ {
+			if strings.ToLower(toString(args[i])) != "streams" {
+				continue
+			}
+			streams := args[i+1:]
+			keys := make([]string, 0, len(streams)/2)
+			for _, arg := range streams[:len(streams)/2] {
+				keys = append(keys, toString(arg))
+			}
+			return keys
+		}
+		return nil
+	case "georadius", "georadiusbymember":
+		// STORE和STOREDIST的目标也是键
+		keys := []string{toString(args[1])}
+		for i := 2; i+1 < len(args); i++ {
+			switch strings.ToLower(toString(args[i])) {
+			case "store", "storedist":
+				keys = append(keys, toString(args[i+1]))
+			}
+		}
+		return keys
+	case "object", "memory", "xinfo", "xgroup":
+		// 子命令之后的参数为键，如 OBJECT ENCODING key、MEMORY USAGE key、XINFO STREAM key
+		if len(args) < 3 {
+			return nil
+		}
+		switch strings.ToLower(toString(args[1])) {
+		case "help", "stats", "doctor", "malloc-stats", "purge":
+			return nil
+		}
+		return []string{toString(args[2])}
+	case "ping", "echo", "info", "select", "auth", "scan", "keys", "dbsize", "flushdb", "flushall",
+		"eval", "evalsha", "script", "config", "client", "cluster", "command", "slowlog", "latency",
+		"debug", "wait", "publish", "subscribe", "psubscribe", "unsubscribe", "punsubscribe", "pubsub":
+		return nil
+	}
+	return []string{toString(args[1])}
+}
+
+func toString(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// countMinSketch 使用depth个哈希函数，每行width个计数器
+// 估算值为所有行中最小的计数器，只会高估不会低估
+type countMinSketch struct {
+	width  uint64
+	counts [][]uint32
+}
+
+func newCountMinSketch(width, depth int) *countMinSketch {
+	counts := make([][]uint32, depth)
+	for i := range counts {
+		counts[i] = make([]uint32, width)
+	}
+	return &countMinSketch{width: uint64(width), counts: counts}
+}
+
+// add 使用两个哈希值组合出depth个哈希函数 h1 + i*h2
+func (s *countMinSketch) add(h1, h2 uint64) {
+	for i := range s.counts {
+		s.counts[i][(h1+uint64(i)*h2)%s.width]++
+	}
+}
+
+func (s *countMinSketch) estimate(h1, h2 uint64) uint32 {
+	var minCount uint32
+	for i := range s.counts {
+		count := s.counts[i][(h1+uint64(i)*h2)%s.width]
+		if i == 0 || count < minCount {
+			minCount = count
+		}
+	}
+	return minCount
+}
+
+func (s *countMinSketch) reset() {
+	for i := range s.counts {
+		for j := range s.counts[i] {
+			s.counts[i][j] = 0
+		}
+	}
+}
+
+// hotKeyWindow 一种访问类型(读或者写)的滑动窗口和Top-K
+type hotKeyWindow struct {
+	kind    string
+	buckets []*countMinSketch
+	current int
+	topK    map[string]uint32 // 候选热点键以及窗口内的估算次数
+	hot     map[string]bool   // 已经回调过的键
+}
+
+func (w *hotKeyWindow) estimate(h1, h2 uint64) uint32 {
+	var sum uint32
+	for _, bucket := range w.buckets {
+		sum += bucket.estimate(h1, h2)
+	}
+	return sum
+}
+
+type HotKeyDetector struct {
+	conf       HotKeyConfig
+	bucketSpan time.Duration // 每个桶的时间跨度
+
+	mu        sync.Mutex
+	windows   map[string]*hotKeyWindow
+	rotatedAt time.Time
+	startAt   time.Time
+}
+
+// NewHotKeyDetector 创建热点键探测器
+func NewHotKeyDetector(conf HotKeyConfig) *HotKeyDetector {
+	if conf.SampleRate <= 0 || conf.SampleRate > 1 {
+		conf.SampleRate = 0.01
+	}
+	if conf.Window <= 0 {
+		conf.Window = time.Minute
+	}
+	if conf.Buckets <= 0 {
+		conf.Buckets = 6
+	}
+	if conf.TopK <= 0 {
+		conf.TopK = 20
+	}
+	if conf.Width <= 0 {
+		conf.Width = 2048
+	}
+	if conf.Depth <= 0 {
+		conf.Depth = 4
+	}
+
+	// Window小于Buckets纳秒时桶的跨度为0，至少按照1ns
+	bucketSpan := conf.Window / time.Duration(conf.Buckets)
+	if bucketSpan <= 0 {
+		bucketSpan = time.Nanosecond
+	}
+
+	now := time.Now()
+	d := &HotKeyDetector{
+		conf:       conf,
+		bucketSpan: bucketSpan,
+		windows:    map[string]*hotKeyWindow{},
+		rotatedAt:  now,
+		startAt:    now,
+	}
+	for _, kind := range []string{HotKeyRead, HotKeyWrite} {
+		w := &hotKeyWindow{
+			kind:    kind,
+			buckets: make([]*countMinSketch, conf.Buckets),
+			topK:    map[string]uint32{},
+			hot:     map[string]bool{},
+		}
+		for i := range w.buckets {
+			w.buckets[i] = newCountMinSketch(conf.Width, conf.Depth)
+		}
+		d.windows[kind] = w
+	}
+	return d
+}
+
+// processWrapper 支持WrapProcess的客户端, redis.Client和redis.ClusterClient均已实现
+type processWrapper interface {
+	WrapProcess(fn func(oldProcess func(cmd redis.Cmder) error) func(cmd redis.Cmder) error)
+	WrapProcessPipeline(fn func(oldProcess func([]redis.Cmder) error) func([]redis.Cmder) error)
+}
+
+// Wrap 在客户端上安装采样，普通命令和pipeline中的命令都会被采样
+func (d *HotKeyDetector) Wrap(client redis.Cmdable) error {
+	wrapper, ok := client.(processWrapper)
+	if !ok {
+		return errors.New("client not support wrap process")
+	}
+	wrapper.WrapProcess(func(old func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			d.Sample(cmd.Args())
+			return old(cmd)
+		}
+	})
+	wrapper.WrapProcessPipeline(func(old func([]redis.Cmder) error) func([]redis.Cmder) error {
+		return func(cmds []redis.Cmder) error {
+			for _, cmd := range cmds {
+				d.Sample(cmd.Args())
+			}
+			return old(cmds)
+		}
+	})
+	return nil
+}
+
+// Sample 按照采样率记录一条命令
+func (d *HotKeyDetector) Sample(args []interface{}) {
+	if rand.Float64() >= d.conf.SampleRate {
+		return
+	}
+	keys := commandKeys(args)
+	if len(keys) == 0 {
+		return
+	}
+	kind := HotKeyRead
+	if writeCommands[strings.ToLower(toString(args[0]))] {
+		kind = HotKeyWrite
+	}
+
+	var fired []HotKey
+	now := time.Now()
+	d.mu.Lock()
+	d.rotate(now)
+	w := d.windows[kind]
+	for _, key := range keys {
+		if hot, ok := d.record(w, key, now); ok {
+			fired = append(fired, hot)
+		}
+	}
+	d.mu.Unlock()
+
+	// 回调不能阻塞命令的执行
+	if d.conf.OnHot != nil {
+		for _, hot := range fired {
+			go d.conf.OnHot(hot)
+		}
+	}
+}
+
+// record 记录一次访问并更新Top-K，键第一次超过阈值时返回true
+func (d *HotKeyDetector) record(w *hotKeyWindow, key string, now time.Time) (HotKey, bool) {
+	h1, h2 := murmur3.Sum128([]byte(key))
+	w.buckets[w.current].add(h1, h2)
+	count := w.estimate(h1, h2)
+
+	if _, ok := w.topK[key]; ok || len(w.topK) < d.conf.TopK {
+		w.topK[key] = count
+	} else {
+		minKey, minCount := "", uint32(0)
+		for candidate, candidateCount := range w.topK {
+			if minKey == "" || candidateCount < minCount {
+				minKey, minCount = candidate, candidateCount
+			}
+		}
+		if count > minCount {
+			delete(w.topK, minKey)
+			delete(w.hot, minKey)
+			w.topK[key] = count
+		}
+	}
+
+	if d.conf.Threshold <= 0 || w.hot[key] {
+		return HotKey{}, false
+	}
+	qps := d.qps(count, now)
+	if qps < d.conf.Threshold {
+		return HotKey{}, false
+	}
+	w.hot[key] = true
+	return HotKey{Key: key, Kind: w.kind, QPS: qps}, true
+}
+
+// rotate 滑动窗口，清空过期的桶并重新估算候选键的次数
+func (d *HotKeyDetector) rotate(now time.Time) {
+	bucketSpan := d.bucketSpan
+	steps := int(now.Sub(d.rotatedAt) / bucketSpan)
+	if steps <= 0 {
+		return
+	}
+	if steps > d.conf.Buckets {
+		steps = d.conf.Buckets
+	}
+	d.rotatedAt = d.rotatedAt.Add(now.Sub(d.rotatedAt) / bucketSpan * bucketSpan)
+
+	for _, w := range d.windows {
+		for i := 0; i < steps; i++ {
+			w.current = (w.current + 1) % len(w.buckets)
+			w.buckets[w.current].reset()
+		}
+		for key := range w.topK {
+			h1, h2 := murmur3.Sum128([]byte(key))
+			count := w.estimate(h1, h2)
+			if count == 0 {
+				delete(w.topK, key)
+				delete(w.hot, key)
+				continue
+			}
+			w.topK[key] = count
+			if w.hot[key] && d.qps(count, now) < d.conf.Threshold {
+				delete(w.hot, key)
+			}
+		}
+	}
+}
+
+// qps 由窗口内的采样次数估算QPS，启动不足一个窗口时按照已运行的时间计算
+// 最新的桶只统计了rotatedAt之后的访问，窗口的实际长度为 (Buckets-1)个桶 + 最新的桶已经经过的时间
+func (d *HotKeyDetector) qps(count uint32, now time.Time) float64 {
+	span := time.Duration(d.conf.Buckets-1)*d.bucketSpan + now.Sub(d.rotatedAt)
+	if elapsed := now.Sub(d.startAt); elapsed < span {
+		span = elapsed
+	}
+	if span < time.Second {
+		span = time.Second
+	}
+	return float64(count) / d.conf.SampleRate / span.Seconds()
+}
+
+// HotKeys 返回读或者写的热点键，按照QPS从大到小排序
+func (d *HotKeyDetector) HotKeys(kind string) []HotKey {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, ok := d.windows[kind]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	d.rotate(now)
+	keys := make([]HotKey, 0, len(w.topK))
+	for key, count := range w.topK {
+		keys = append(keys, HotKey{Key: key, Kind: kind, QPS: d.qps(count, now)})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].QPS > keys[j].QPS
+	})
+	return keys
+}
@@ -0,0 +1,182 @@
+package lredis
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/spaolacci/murmur3"
+)
+
+func TestCommandKeys(t *testing.T) {
+	for _, c := range []struct {
+		args []interface{}
+		want []string
+	}{
+		{[]interface{}{"get", "k"}, []string{"k"}},
+		{[]interface{}{"GET", []byte("k")}, []string{"k"}},
+		{[]interface{}{"hset", "h", "f", "v"}, []string{"h"}},
+		{[]interface{}{"mget", "a", "b"}, []string{"a", "b"}},
+		{[]interface{}{"mset", "a", "1", "b", "2"}, []string{"a", "b"}},
+		{[]interface{}{"bitop", "and", "dest", "a", "b"}, []string{"dest", "a", "b"}},
+		{[]interface{}{"xread", "count", 10, "block", 0, "streams", "s1", "s2", "0", "$"}, []string{"s1", "s2"}},
+		{[]interface{}{"xreadgroup", "group", "g", "c", "STREAMS", "s", ">"}, []string{"s"}},
+		{[]interface{}{"xreadgroup", "group", "g", "c"}, nil},
+		{[]interface{}{"georadius", "geo", 1, 2, 3, "km", "store", "dst"}, []string{"geo", "dst"}},
+		{[]interface{}{"georadiusbymember", "geo", "m", 3, "km"}, []string{"geo"}},
+		{[]interface{}{"object", "encoding", "k"}, []string{"k"}},
+		{[]interface{}{"object", "help"}, nil},
+		{[]interface{}{"memory", "usage", "k", "samples", 5}, []string{"k"}},
+		{[]interface{}{"memory", "stats"}, nil},
+		{[]interface{}{"xinfo", "stream", "s"}, []string{"s"}},
+		{[]interface{}{"xgroup", "create", "s", "g", "$"}, []string{"s"}},
+		{[]interface{}{"slowlog", "get", 10}, nil},
+		{[]interface{}{"config", "get", "maxmemory"}, nil},
+		{[]interface{}{"ping"}, nil},
+	} {
+		if got := commandKeys(c.args); !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("commandKeys(%v) = %v, want %v", c.args, got, c.want)
+		}
+	}
+}
+
+// TestCountMinSketch 估算值不会低于真实的次数，重置之后清零
+func TestCountMinSketch(t *testing.T) {
+	s := newCountMinSketch(64, 4)
+	counts := map[string]uint32{}
+	for i := 0; i < 2000; i++ {
+		key := strconv.Itoa(i % 200)
+		if i%7 == 0 {
+			key = "hot"
+		}
+		counts[key]++
+		h1, h2 := murmur3.Sum128([]byte(key))
+		s.add(h1, h2)
+	}
+	for key, count := range counts {
+		h1, h2 := murmur3.Sum128([]byte(key))
+		if got := s.estimate(h1, h2); got < count {
+			t.Fatalf("estimate %s = %d, less than %d", key, got, count)
+		}
+	}
+	h1, h2 := murmur3.Sum128([]byte("hot"))
+	if got := s.estimate(h1, h2); got > counts["hot"]*3/2 {
+		t.Fatalf("estimate of hot key %d, real %d", got, counts["hot"])
+	}
+	s.reset()
+	if got := s.estimate(h1, h2); got != 0 {
+		t.Fatalf("estimate after reset %d", got)
+	}
+}
+
+// newTestDetector 已经运行了startAgo的探测器，最新的桶开始于rotatedAgo之前
+func newTestDetector(conf HotKeyConfig, now time.Time, startAgo, rotatedAgo time.Duration) *HotKeyDetector {
+	d := NewHotKeyDetector(conf)
+	d.startAt = now.Add(-startAgo)
+	d.rotatedAt = now.Add(-rotatedAgo)
+	return d
+}
+
+func TestHotKeyQPS(t *testing.T) {
+	now := time.Now()
+	conf := HotKeyConfig{SampleRate: 0.5, Window: 10 * time.Second, Buckets: 10}
+
+	// 最新的桶只经过了0.5秒，窗口的实际长度为9.5秒
+	d := newTestDetector(conf, now, time.Hour, 500*time.Millisecond)
+	if qps := d.qps(95, now); math.Abs(qps-20) > 1e-9 {
+		t.Fatalf("qps %f, want 20", qps)
+	}
+	// 启动不足一个窗口时按照已运行的时间计算
+	d = newTestDetector(conf, now, 4*time.Second, 500*time.Millisecond)
+	if qps := d.qps(40, now); math.Abs(qps-20) > 1e-9 {
+		t.Fatalf("qps after start %f, want 20", qps)
+	}
+	// 最短按照1秒计算
+	d = newTestDetector(conf, now, time.Millisecond, time.Millisecond)
+	if qps := d.qps(10, now); qps != 20 {
+		t.Fatalf("qps just after start %f, want 20", qps)
+	}
+}
+
+// TestHotKeyTinyWindow 窗口小于桶数纳秒时不能除零
+func TestHotKeyTinyWindow(t *testing.T) {
+	d := NewHotKeyDetector(HotKeyConfig{SampleRate: 1, Window: 3 * time.Nanosecond, Buckets: 6})
+	if d.bucketSpan != time.Nanosecond {
+		t.Fatalf("bucket span %s", d.bucketSpan)
+	}
+	d.Sample([]interface{}{"get", "k"})
+	d.HotKeys(HotKeyRead)
+}
+
+func TestHotKeyTopK(t *testing.T) {
+	now := time.Now()
+	d := newTestDetector(HotKeyConfig{SampleRate: 1, Window: 10 * time.Second, Buckets: 10, TopK: 2, Threshold: 5},
+		now, time.Hour, 0)
+	w := d.windows[HotKeyRead]
+	record := func(key string, n int) (fired []string) {
+		for i := 0; i < n; i++ {
+			if hot, ok := d.record(w, key, now); ok {
+				fired = append(fired, hot.Key)
+			}
+		}
+		return fired
+	}
+
+	record("a", 30)
+	record("b", 20)
+	record("c", 10)
+	if !reflect.DeepEqual(w.topK, map[string]uint32{"a": 30, "b": 20}) {
+		t.Fatalf("topK %v", w.topK)
+	}
+	// c的次数超过候选集合中的最小值时替换
+	record("c", 11)
+	if !reflect.DeepEqual(w.topK, map[string]uint32{"a": 30, "c": 21}) {
+		t.Fatalf("topK after c %v", w.topK)
+	}
+
+	// 9秒的窗口内超过45次时回调，只回调一次
+	if fired := record("d", 46); !reflect.DeepEqual(fired, []string{"d"}) {
+		t.Fatalf("fired %v", fired)
+	}
+	if fired := record("d", 10); len(fired) != 0 {
+		t.Fatalf("fired again %v", fired)
+	}
+
+	// 窗口完全滑过之后清空
+	later := now.Add(11 * time.Second)
+	d.rotate(later)
+	if len(w.topK) != 0 || len(w.hot) != 0 {
+		t.Fatalf("topK after window %v hot %v", w.topK, w.hot)
+	}
+	if d.rotatedAt.After(later) || later.Sub(d.rotatedAt) >= time.Second {
+		t.Fatalf("rotatedAt %s", later.Sub(d.rotatedAt))
+	}
+}
+
+func TestHotKeySample(t *testing.T) {
+	fired := make(chan HotKey, 1)
+	d := NewHotKeyDetector(HotKeyConfig{SampleRate: 1, Threshold: 1, OnHot: func(key HotKey) { fired <- key }})
+	d.Sample([]interface{}{"set", "k", "v"})
+	d.Sample([]interface{}{"get", "r"})
+	d.Sample([]interface{}{"ping"})
+
+	select {
+	case key := <-fired:
+		if key.Kind != HotKeyWrite && key.Kind != HotKeyRead {
+			t.Fatalf("fired %+v", key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnHot not called")
+	}
+	if keys := d.HotKeys(HotKeyWrite); len(keys) != 1 || keys[0].Key != "k" {
+		t.Fatalf("write hot keys %v", keys)
+	}
+	if keys := d.HotKeys(HotKeyRead); len(keys) != 1 || keys[0].Key != "r" {
+		t.Fatalf("read hot keys %v", keys)
+	}
+	if d.HotKeys("other") != nil {
+		t.Fatal("unknown kind")
+	}
+}
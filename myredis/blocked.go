@@ -0,0 +1,237 @@
+package myredis
+
+import (
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// 阻塞操作 BLPOP BRPOP BLMOVE BRPOPLPUSH
+// 1 列表都为空时客户端阻塞，executor不发送命令完成的信号，客户端协程等待解除阻塞
+// 2 每个数据库的blockingKeys记录阻塞在每个键上的客户端，按照阻塞的顺序排列
+// 3 列表加入键空间时如果有客户端阻塞在这个键上，把键加入server.readyKeys
+// 4 每个命令(包括整个EXEC)执行完毕之后处理readyKeys，按照阻塞的顺序为客户端弹出元素
+// 5 serverCron检查阻塞的超时时间，超时返回nil
+// 6 阻塞期间客户端协程检测连接是否断开，断开时通知executor解除阻塞
+
+// blockingState 客户端阻塞的状态
+type blockingState struct {
+	timeout   time.Time // 超时的时间，零值表示一直阻塞
+	keys      []string  // 阻塞的键
+	target    string    // BLMOVE的目标列表
+	hasTarget bool      // 是否为BLMOVE
+	wherefrom int       // 弹出的位置
+	whereto   int       // BLMOVE加入目标列表的位置
+}
+
+// readyKey 有客户端阻塞并且已经加入键空间的键
+type readyKey struct {
+	db  *RedisDb
+	key string
+}
+
+// getTimeoutFromObjectOrReply 解析阻塞的超时时间(秒)，可以是小数，0表示一直阻塞
+func (c *Client) getTimeoutFromObjectOrReply(arg []byte) (time.Time, bool) {
+	timeout, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+		c.addReplyError("timeout is not a float or out of range")
+		return time.Time{}, false
+	}
+	if timeout < 0 {
+		c.addReplyError("timeout is negative")
+		return time.Time{}, false
+	}
+	// 转换为time.Duration时不能溢出
+	if timeout > float64(math.MaxInt64/int64(time.Second)) {
+		c.addReplyError("timeout is out of range")
+		return time.Time{}, false
+	}
+	if timeout == 0 {
+		return time.Time{}, true
+	}
+	return time.Now().Add(time.Duration(timeout * float64(time.Second))), true
+}
+
+// blockForKeys 客户端阻塞在keys上，同一个键只阻塞一次
+func blockForKeys(c *Client, keys []string, timeout time.Time, wherefrom int, target string, hasTarget bool, whereto int) {
+	c.bpop = blockingState{
+		timeout:   timeout,
+		target:    target,
+		hasTarget: hasTarget,
+		wherefrom: wherefrom,
+		whereto:   whereto,
+	}
+	for _, key := range keys {
+		blocked := false
+		for _, k := range c.bpop.keys {
+			if k == key {
+				blocked = true
+				break
+			}
+		}
+		if blocked {
+			continue
+		}
+		c.bpop.keys = append(c.bpop.keys, key)
+		c.db.blockingKeys[key] = append(c.db.blockingKeys[key], c)
+	}
+	c.Flags |= CLIENT_BLOCKED
+	c.server.blockedClients++
+}
+
+// unblockClient 解除客户端的阻塞，通知客户端协程继续处理请求
+func (s *Server) unblockClient(c *Client) {
+	for _, key := range c.bpop.keys {
+		clients := c.db.blockingKeys[key]
+		for i, bc := range clients {
+			if bc == c {
+				clients = append(clients[:i], clients[i+1:]...)
+				break
+			}
+		}
+		if len(clients) == 0 {
+			delete(c.db.blockingKeys, key)
+		} else {
+			c.db.blockingKeys[key] = clients
+		}
+	}
+	c.bpop = blockingState{}
+	c.Flags &^= CLIENT_BLOCKED
+	s.blockedClients--
+	c.done <- false
+}
+
+// signalKeyAsReady 列表加入键空间时调用，有客户端阻塞在这个键上时加入readyKeys
+func signalKeyAsReady(db *RedisDb, key string) {
+	if db.server == nil || len(db.blockingKeys[key]) == 0 {
+		return
+	}
+	if _, ok := db.readyKeys[key]; ok {
+		return
+	}
+	db.readyKeys[key] = struct{}{}
+	db.server.readyKeys = append(db.server.readyKeys, readyKey{db: db, key: key})
+}
+
+// scanDatabaseForReadyLists SWAPDB之后检查阻塞的键是否已经存在
+func scanDatabaseForReadyLists(db *RedisDb) {
+	for key := range db.blockingKeys {
+		if o := lookupKeyWrite(db, key); o != nil && o.Type == OBJ_LIST {
+			signalKeyAsReady(db, key)
+		}
+	}
+}
+
+// handleClientsBlockedOnKeys 为阻塞在readyKeys上的客户端弹出元素
+// BLMOVE加入目标列表时可能产生新的readyKeys，循环直到没有新的键
+func (s *Server) handleClientsBlockedOnKeys() {
+	for len(s.readyKeys) > 0 {
+		readyKeys := s.readyKeys
+		s.readyKeys = nil
+		for _, rk := range readyKeys {
+			delete(rk.db.readyKeys, rk.key)
+			s.serveClientsBlockedOnListKey(rk.db, rk.key)
+		}
+	}
+}
+
+// serveClientsBlockedOnListKey 按照阻塞的顺序为客户端弹出元素，直到列表为空
+func (s *Server) serveClientsBlockedOnListKey(db *RedisDb, key string) {
+	o := lookupKeyWrite(db, key)
+	if o == nil || o.Type != OBJ_LIST {
+		return
+	}
+	for len(db.blockingKeys[key]) > 0 && listTypeLength(o) > 0 {
+		receiver := db.blockingKeys[key][0]
+		wherefrom := receiver.bpop.wherefrom
+		value := listTypePop(o, wherefrom)
+		if !serveClientBlockedOnList(receiver, key, value) {
+			// 目标列表类型错误，撤销弹出
+			listTypePush(o, s.ListMaxZiplistSize, value, wherefrom)
+		}
+		s.unblockClient(receiver)
+	}
+	deleteListIfEmpty(db, key, o)
+}
+
+// serveClientBlockedOnList 把弹出的元素回复给阻塞的客户端，BLMOVE同时加入目标列表
+// 目标列表类型错误时回复错误并返回false
+func serveClientBlockedOnList(receiver *Client, key string, value []byte) bool {
+	bpop := &receiver.bpop
+	if !bpop.hasTarget {
+		receiver.reply.WriteArray(2)
+		receiver.reply.WriteBulkString(key)
+		receiver.reply.WriteBulk(value)
+		return true
+	}
+	dobj, ok := lookupListWrite(receiver, bpop.target)
+	if !ok {
+		return false
+	}
+	if dobj == nil {
+		dobj = createListKey(receiver.db, bpop.target)
+	}
+	listTypePush(dobj, receiver.server.ListMaxZiplistSize, value, bpop.whereto)
+	receiver.reply.WriteBulk(value)
+	return true
+}
+
+// clientsCron 解除超时的客户端的阻塞，回复nil
+func (s *Server) clientsCron() {
+	now := time.Now()
+	var timedout []*Client
+	s.mu.Lock()
+	for _, c := range s.clients {
+		if c.Flags&CLIENT_BLOCKED != 0 && !c.bpop.timeout.IsZero() && now.After(c.bpop.timeout) {
+			timedout = append(timedout, c)
+		}
+	}
+	s.mu.Unlock()
+	for _, c := range timedout {
+		c.reply.WriteNilArray()
+		s.unblockClient(c)
+	}
+}
+
+// waitUnblocked 客户端协程等待解除阻塞，连接断开时返回false
+// 阻塞期间查询缓冲区没有被读取，使用Peek检测连接是否断开
+func (s *Server) waitUnblocked(c *Client) bool {
+	peeked := make(chan error, 1)
+	go func() {
+		for {
+			// 客户端在阻塞期间发送的命令留在缓冲区中，只等待更多的数据或者连接断开
+			n := c.querybuf.Buffered() + 1
+			if n > c.querybuf.Size() {
+				peeked <- nil
+				return
+			}
+			if _, err := c.querybuf.Peek(n); err != nil {
+				peeked <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-c.done:
+		// 唤醒Peek，协程退出之后才能继续读取查询缓冲区
+		c.conn.SetReadDeadline(time.Now())
+		<-peeked
+		s.mu.Lock()
+		if atomic.LoadInt32(&s.closing) == 0 {
+			c.conn.SetReadDeadline(time.Time{})
+		}
+		s.mu.Unlock()
+		return true
+	case err := <-peeked:
+		if err == nil {
+			<-c.done
+			return true
+		}
+		// 连接断开或者服务关闭，executor可能已经解除了阻塞
+		s.disconnected <- c
+		<-c.done
+		return false
+	}
+}
@@ -0,0 +1,246 @@
+package myredis
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// waitBlocked 等待阻塞的客户端数变为n
+func waitBlocked(t *testing.T, client *redis.Client, n int) {
+	t.Helper()
+	want := strconv.Itoa(n)
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if parseInfo(t, client.Info("clients").Val())["blocked_clients"] == want {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("blocked_clients never reached %d", n)
+}
+
+// blockingCall 在另一个客户端中执行阻塞命令，结果从通道返回
+func blockingCall(addr string, args ...interface{}) <-chan interface{} {
+	result := make(chan interface{}, 1)
+	go func() {
+		client := newTestClient(addr)
+		defer client.Close()
+		v, err := client.Do(args...).Result()
+		if err != nil {
+			result <- err
+			return
+		}
+		result <- v
+	}()
+	return result
+}
+
+func recvResult(t *testing.T, result <-chan interface{}) interface{} {
+	t.Helper()
+	select {
+	case v := <-result:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked client not served")
+	}
+	return nil
+}
+
+func TestBlockingPopNonEmpty(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	client.RPush("b", "1", "2")
+	// 按照参数的顺序弹出第一个不为空的列表
+	if v := client.BLPop(time.Second, "a", "b").Val(); strings.Join(v, ",") != "b,1" {
+		t.Fatalf("blpop %v", v)
+	}
+	if v := client.BRPop(time.Second, "a", "b").Val(); strings.Join(v, ",") != "b,2" {
+		t.Fatalf("brpop %v", v)
+	}
+	if client.Exists("b").Val() != 0 {
+		t.Fatal("empty list should be deleted")
+	}
+
+	client.Set("str", "v", 0)
+	if err := client.Do("blpop", "str", 0).Err(); err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		t.Fatalf("blpop on string %v", err)
+	}
+	if err := client.Do("blpop", "a", -1).Err(); err == nil || err.Error() != "ERR timeout is negative" {
+		t.Fatalf("negative timeout %v", err)
+	}
+	if err := client.Do("blpop", "a", "x").Err(); err == nil || err.Error() != "ERR timeout is not a float or out of range" {
+		t.Fatalf("invalid timeout %v", err)
+	}
+	for _, timeout := range []string{"9223372037", "1e300"} {
+		if err := client.Do("blpop", "a", timeout).Err(); err == nil || err.Error() != "ERR timeout is out of range" {
+			t.Fatalf("timeout %s: %v", timeout, err)
+		}
+	}
+}
+
+func TestBlockingPopWakeup(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	result := blockingCall(addr, "brpop", "a", "b", 0)
+	waitBlocked(t, client, 1)
+	client.RPush("b", "x", "y")
+	if v := fmtValue(recvResult(t, result)); v != "[b y]" {
+		t.Fatalf("brpop %s", v)
+	}
+	waitBlocked(t, client, 0)
+	// 只弹出一个元素
+	if v := client.LRange("b", 0, -1).Val(); strings.Join(v, ",") != "x" {
+		t.Fatalf("list after wakeup %v", v)
+	}
+}
+
+// TestBlockingPopFIFO 多个客户端阻塞在同一个键上时按照阻塞的顺序弹出
+func TestBlockingPopFIFO(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	var results []<-chan interface{}
+	for i := 0; i < 3; i++ {
+		results = append(results, blockingCall(addr, "blpop", "list", 0))
+		waitBlocked(t, client, i+1)
+	}
+	client.RPush("list", "a")
+	if v := fmtValue(recvResult(t, results[0])); v != "[list a]" {
+		t.Fatalf("first client %s", v)
+	}
+	waitBlocked(t, client, 2)
+	client.RPush("list", "b", "c", "d")
+	if v := fmtValue(recvResult(t, results[1])); v != "[list b]" {
+		t.Fatalf("second client %s", v)
+	}
+	if v := fmtValue(recvResult(t, results[2])); v != "[list c]" {
+		t.Fatalf("third client %s", v)
+	}
+	if v := client.LRange("list", 0, -1).Val(); strings.Join(v, ",") != "d" {
+		t.Fatalf("list after serving all clients %v", v)
+	}
+}
+
+func TestBlockingPopTimeout(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	start := time.Now()
+	err := client.Do("blpop", "missing", 0.2).Err()
+	if err != redis.Nil {
+		t.Fatalf("blpop timeout %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("timeout after %v", elapsed)
+	}
+	if err := client.Do("blmove", "missing", "dst", "left", "right", 0.1).Err(); err != redis.Nil {
+		t.Fatalf("blmove timeout %v", err)
+	}
+	waitBlocked(t, client, 0)
+	// 超时之后加入的元素不会被弹出
+	client.RPush("missing", "v")
+	if client.LLen("missing").Val() != 1 {
+		t.Fatal("timed out client should not be served")
+	}
+}
+
+// TestBlockingPopMulti 事务执行完毕之后才唤醒阻塞的客户端，事务中的阻塞命令不阻塞
+func TestBlockingPopMulti(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	result := blockingCall(addr, "blpop", "list", 0)
+	waitBlocked(t, client, 1)
+	_, err := client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.LPush("list", "a")
+		pipe.LPush("list", "b")
+		pipe.LPush("list", "c")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := fmtValue(recvResult(t, result)); v != "[list c]" {
+		t.Fatalf("blpop after exec %s", v)
+	}
+
+	cmds, err := client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.BLPop(0, "empty")
+		pipe.BRPopLPush("empty", "dst", 0)
+		return nil
+	})
+	if err != redis.Nil || len(cmds) != 2 || cmds[0].Err() != redis.Nil || cmds[1].Err() != redis.Nil {
+		t.Fatalf("blocking commands in multi %v %v", cmds, err)
+	}
+	waitBlocked(t, client, 0)
+}
+
+func TestBlockingMove(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	// 阻塞在dst上的客户端被BLMOVE加入的元素唤醒
+	moved := blockingCall(addr, "blmove", "src", "dst", "left", "right", 0)
+	waitBlocked(t, client, 1)
+	popped := blockingCall(addr, "blpop", "dst", 0)
+	waitBlocked(t, client, 2)
+	client.RPush("src", "a", "b")
+	if v := fmtValue(recvResult(t, moved)); v != "a" {
+		t.Fatalf("blmove %s", v)
+	}
+	if v := fmtValue(recvResult(t, popped)); v != "[dst a]" {
+		t.Fatalf("blpop on destination %s", v)
+	}
+	if v := client.LRange("src", 0, -1).Val(); strings.Join(v, ",") != "b" {
+		t.Fatalf("src %v", v)
+	}
+
+	// 源列表不为空时同RPOPLPUSH
+	if v := client.BRPopLPush("src", "dst", time.Second).Val(); v != "b" {
+		t.Fatalf("brpoplpush %s", v)
+	}
+
+	// 目标类型错误时回复错误，元素留在源列表中
+	client.Set("str", "v", 0)
+	wrong := blockingCall(addr, "brpoplpush", "src", "str", 0)
+	waitBlocked(t, client, 1)
+	client.RPush("src", "c")
+	if err, ok := recvResult(t, wrong).(error); !ok || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		t.Fatalf("brpoplpush to string %v", err)
+	}
+	if v := client.LRange("src", 0, -1).Val(); strings.Join(v, ",") != "c" {
+		t.Fatalf("src after failed move %v", v)
+	}
+}
+
+func TestBlockingDisconnect(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	blocked := newTestClient(addr)
+	go blocked.Do("blpop", "list", 0)
+	waitBlocked(t, client, 1)
+	blocked.Close()
+	waitBlocked(t, client, 0)
+	client.RPush("list", "v")
+	if client.LLen("list").Val() != 1 {
+		t.Fatal("disconnected client should not be served")
+	}
+
+	// 服务关闭时仍然阻塞的客户端被断开
+	go newTestClient(addr).Do("blpop", "other", 0)
+	waitBlocked(t, client, 1)
+}
@@ -0,0 +1,85 @@
+package myredis
+
+import (
+	"bufio"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	CLIENT_CLOSE_AFTER_REPLY = 1 << 0 // 发送完回复之后关闭连接 如QUIT
+	CLIENT_MULTI             = 1 << 1 // 处于MULTI状态
+	CLIENT_DIRTY_EXEC        = 1 << 2 // 事务中的命令出现错误，EXEC失败
+	CLIENT_BLOCKED           = 1 << 3 // 阻塞在BLPOP等命令
+)
+
+const (
+	PROTO_IOBUF_LEN         = 16 * 1024 // 查询缓冲区的大小
+	PROTO_REPLY_CHUNK_BYTES = 16 * 1024 // 回复缓冲区的大小
+)
+
+// Client 服务端保存的客户端状态
+// Argv、Flags、db 只在executor中修改
+type Client struct {
+	ID              int64
+	Addr            string
+	Name            string   // CLIENT SETNAME 设置的名称
+	Flags           int      // CLIENT_* 标记
+	Argv            [][]byte // 当前执行的命令和参数
+	CreateTime      time.Time
+	LastInteraction time.Time // 最后一次执行命令的时间
+
+	server   *Server
+	conn     net.Conn
+	querybuf *bufio.Reader // 查询缓冲区，保存还没有解析的请求
+	reader   *RequestReader
+	reply    *ReplyWriter  // 回复缓冲区
+	db       *RedisDb      // 当前选择的数据库
+	mstate   []multiCmd    // 事务队列
+	bpop     blockingState // 阻塞的状态
+	done     chan bool     // 命令执行完成，true表示客户端被阻塞，解除阻塞时再发送false
+}
+
+// clientConn 读写客户端的连接，统计 total_net_input_bytes 和 total_net_output_bytes
+type clientConn struct {
+	c *Client
+}
+
+func (cc clientConn) Read(p []byte) (int, error) {
+	n, err := cc.c.conn.Read(p)
+	atomic.AddInt64(&cc.c.server.stat.netInputBytes, int64(n))
+	return n, err
+}
+
+func (cc clientConn) Write(p []byte) (int, error) {
+	n, err := cc.c.conn.Write(p)
+	atomic.AddInt64(&cc.c.server.stat.netOutputBytes, int64(n))
+	return n, err
+}
+
+// memory 客户端占用的内存，包括查询缓冲区和回复缓冲区
+func (c *Client) memory() int {
+	return CLIENT_OVERHEAD + c.querybuf.Size() + c.reply.w.Size()
+}
+
+func (c *Client) addReplyError(msg string) {
+	c.reply.WriteError("ERR " + msg)
+}
+
+func (c *Client) addReplyWrongType() {
+	c.reply.WriteError("WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+func (c *Client) addReplyOK() {
+	c.reply.WriteSimpleString("OK")
+}
+
+// selectDb 切换当前的数据库
+func (c *Client) selectDb(id int) bool {
+	if id < 0 || id >= len(c.server.db) {
+		return false
+	}
+	c.db = c.server.db[id]
+	return true
+}
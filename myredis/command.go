@@ -0,0 +1,269 @@
+package myredis
+
+import (
+	"strings"
+	"time"
+)
+
+// 命令表，同redis的redisCommandTable
+// arity 为参数个数(包含命令名)，负数表示至少-arity个参数
+// FirstKey LastKey KeyStep 描述参数中哪些是键，LastKey为负数表示从末尾计算，没有键时均为0
+
+const (
+	CMD_WRITE    = 1 << 0 // 会修改数据
+	CMD_READONLY = 1 << 1 // 只读取数据
+	CMD_DENYOOM  = 1 << 2 // 可能增加内存，超过maxmemory时拒绝执行
+	CMD_FAST     = 1 << 3 // 时间复杂度为O(1)或者O(log(N))
+	CMD_ADMIN    = 1 << 4 // 管理命令
+	CMD_NOSCRIPT = 1 << 5 // 不允许在脚本中执行
+)
+
+var commandFlagNames = []struct {
+	flag int
+	name string
+}{
+	{CMD_WRITE, "write"},
+	{CMD_READONLY, "readonly"},
+	{CMD_DENYOOM, "denyoom"},
+	{CMD_ADMIN, "admin"},
+	{CMD_NOSCRIPT, "noscript"},
+	{CMD_FAST, "fast"},
+}
+
+type commandProc func(c *Client)
+
+type RedisCommand struct {
+	Name     string
+	proc     commandProc
+	Arity    int
+	Flags    int
+	FirstKey int
+	LastKey  int
+	KeyStep  int
+}
+
+// commandStat INFO commandstats 中每个命令的统计
+// 命令表由所有的Server共享，统计保存在Server中
+type commandStat struct {
+	calls        int64 // 调用次数
+	microseconds int64 // 总耗时 微秒
+}
+
+var redisCommandTable = []*RedisCommand{
+	{"get", getCommand, 2, CMD_READONLY | CMD_FAST, 1, 1, 1},
+	{"set", setCommand, -3, CMD_WRITE | CMD_DENYOOM, 1, 1, 1},
+	{"del", delCommand, -2, CMD_WRITE, 1, -1, 1},
+	{"exists", existsCommand, -2, CMD_READONLY | CMD_FAST, 1, -1, 1},
+	{"rpush", rpushCommand, -3, CMD_WRITE | CMD_DENYOOM | CMD_FAST, 1, 1, 1},
+	{"lpush", lpushCommand, -3, CMD_WRITE | CMD_DENYOOM | CMD_FAST, 1, 1, 1},
+	{"rpushx", rpushxCommand, -3, CMD_WRITE | CMD_DENYOOM | CMD_FAST, 1, 1, 1},
+	{"lpushx", lpushxCommand, -3, CMD_WRITE | CMD_DENYOOM | CMD_FAST, 1, 1, 1},
+	{"linsert", linsertCommand, 5, CMD_WRITE | CMD_DENYOOM, 1, 1, 1},
+	{"rpop", rpopCommand, -2, CMD_WRITE | CMD_FAST, 1, 1, 1},
+	{"lpop", lpopCommand, -2, CMD_WRITE | CMD_FAST, 1, 1, 1},
+	{"llen", llenCommand, 2, CMD_READONLY | CMD_FAST, 1, 1, 1},
+	{"lindex", lindexCommand, 3, CMD_READONLY, 1, 1, 1},
+	{"lset", lsetCommand, 4, CMD_WRITE | CMD_DENYOOM, 1, 1, 1},
+	{"lrange", lrangeCommand, 4, CMD_READONLY, 1, 1, 1},
+	{"ltrim", ltrimCommand, 4, CMD_WRITE, 1, 1, 1},
+	{"lpos", lposCommand, -3, CMD_READONLY, 1, 1, 1},
+	{"lrem", lremCommand, 4, CMD_WRITE, 1, 1, 1},
+	{"rpoplpush", rpoplpushCommand, 3, CMD_WRITE | CMD_DENYOOM, 1, 2, 1},
+	{"lmove", lmoveCommand, 5, CMD_WRITE | CMD_DENYOOM, 1, 2, 1},
+	{"brpop", brpopCommand, -3, CMD_WRITE | CMD_NOSCRIPT, 1, -2, 1},
+	{"blpop", blpopCommand, -3, CMD_WRITE | CMD_NOSCRIPT, 1, -2, 1},
+	{"brpoplpush", brpoplpushCommand, 4, CMD_WRITE | CMD_DENYOOM | CMD_NOSCRIPT, 1, 2, 1},
+	{"blmove", blmoveCommand, 6, CMD_WRITE | CMD_DENYOOM | CMD_NOSCRIPT, 1, 2, 1},
+	{"select", selectCommand, 2, CMD_FAST, 0, 0, 0},
+	{"swapdb", swapdbCommand, 3, CMD_WRITE | CMD_FAST, 0, 0, 0},
+	{"move", moveCommand, 3, CMD_WRITE | CMD_FAST, 1, 1, 1},
+	{"rename", renameCommand, 3, CMD_WRITE, 1, 2, 1},
+	{"randomkey", randomkeyCommand, 1, CMD_READONLY, 0, 0, 0},
+	{"expire", expireCommand, 3, CMD_WRITE | CMD_FAST, 1, 1, 1},
+	{"expireat", expireatCommand, 3, CMD_WRITE | CMD_FAST, 1, 1, 1},
+	{"pexpire", pexpireCommand, 3, CMD_WRITE | CMD_FAST, 1, 1, 1},
+	{"pexpireat", pexpireatCommand, 3, CMD_WRITE | CMD_FAST, 1, 1, 1},
+	{"ttl", ttlCommand, 2, CMD_READONLY | CMD_FAST, 1, 1, 1},
+	{"pttl", pttlCommand, 2, CMD_READONLY | CMD_FAST, 1, 1, 1},
+	{"persist", persistCommand, 2, CMD_WRITE | CMD_FAST, 1, 1, 1},
+	{"dbsize", dbsizeCommand, 1, CMD_READONLY | CMD_FAST, 0, 0, 0},
+	{"type", typeCommand, 2, CMD_READONLY | CMD_FAST, 1, 1, 1},
+	{"object", objectCommand, -2, CMD_READONLY, 2, 2, 1},
+	{"memory", memoryCommand, -2, CMD_READONLY, 0, 0, 0},
+	{"flushdb", flushdbCommand, -1, CMD_WRITE, 0, 0, 0},
+	{"flushall", flushallCommand, -1, CMD_WRITE, 0, 0, 0},
+	{"multi", multiCommand, 1, CMD_NOSCRIPT | CMD_FAST, 0, 0, 0},
+	{"exec", execCommand, 1, CMD_NOSCRIPT, 0, 0, 0},
+	{"discard", discardCommand, 1, CMD_NOSCRIPT | CMD_FAST, 0, 0, 0},
+	{"ping", pingCommand, -1, CMD_FAST, 0, 0, 0},
+	{"echo", echoCommand, 2, CMD_FAST, 0, 0, 0},
+	{"quit", quitCommand, -1, CMD_FAST, 0, 0, 0},
+	{"client", clientCommand, -2, CMD_ADMIN | CMD_NOSCRIPT, 0, 0, 0},
+	{"command", commandCommand, -1, 0, 0, 0, 0},
+	{"info", infoCommand, -1, 0, 0, 0, 0},
+}
+
+// populateCommandTable 由命令表生成按照名称查找的字典
+func (s *Server) populateCommandTable() {
+	s.commandTable = redisCommandTable
+	s.commands = make(map[string]*RedisCommand, len(s.commandTable))
+	s.stat.commands = make(map[string]*commandStat, len(s.commandTable))
+	for _, cmd := range s.commandTable {
+		s.commands[cmd.Name] = cmd
+		s.stat.commands[cmd.Name] = &commandStat{}
+	}
+}
+
+func (s *Server) lookupCommand(name []byte) *RedisCommand {
+	return s.commands[strings.ToLower(string(name))]
+}
+
+// processCommand 在executor中处理客户端当前的命令
+// 执行之前检查命令是否存在、参数个数以及内存是否超过maxmemory
+// 客户端处于MULTI状态时命令加入队列，由EXEC执行
+func (s *Server) processCommand(c *Client) {
+	c.LastInteraction = time.Now()
+	cmd := s.lookupCommand(c.Argv[0])
+	if cmd == nil {
+		args := ""
+		for _, arg := range c.Argv[1:] {
+			args += "`" + string(arg) + "`, "
+		}
+		flagTransaction(c)
+		c.addReplyError("unknown command `" + string(c.Argv[0]) + "`, with args beginning with: " + args)
+		return
+	}
+	if (cmd.Arity > 0 && len(c.Argv) != cmd.Arity) || len(c.Argv) < -cmd.Arity {
+		flagTransaction(c)
+		c.addReplyError("wrong number of arguments for '" + cmd.Name + "' command")
+		return
+	}
+	if s.MaxMemory > 0 && cmd.Flags&CMD_DENYOOM != 0 {
+		if _, err := freeMemoryIfNeed(s); err != nil {
+			flagTransaction(c)
+			c.reply.WriteError(err.Error())
+			return
+		}
+	}
+	if c.Flags&CLIENT_MULTI != 0 && !isTransactionCommand(cmd) {
+		queueMultiCommand(c, cmd)
+		c.reply.WriteSimpleString("QUEUED")
+		return
+	}
+	s.call(c, cmd)
+}
+
+// call 执行命令并更新统计
+func (s *Server) call(c *Client, cmd *RedisCommand) {
+	start := time.Now()
+	cmd.proc(c)
+	duration := time.Since(start)
+
+	stat := s.stat.commands[cmd.Name]
+	stat.calls++
+	stat.microseconds += int64(duration / time.Microsecond)
+	s.stat.numcommands++
+	if cmd.Flags&CMD_WRITE != 0 {
+		s.dirty++
+	}
+}
+
+// commandCommand COMMAND [COUNT|INFO name...]
+func commandCommand(c *Client) {
+	if len(c.Argv) == 1 {
+		c.reply.WriteArray(len(c.server.commandTable))
+		for _, cmd := range c.server.commandTable {
+			addReplyCommand(c, cmd)
+		}
+		return
+	}
+	switch strings.ToLower(string(c.Argv[1])) {
+	case "count":
+		if len(c.Argv) != 2 {
+			c.addReplyError("wrong number of arguments for 'command|count' command")
+			return
+		}
+		c.reply.WriteInt(int64(len(c.server.commandTable)))
+	case "info":
+		c.reply.WriteArray(len(c.Argv) - 2)
+		for _, name := range c.Argv[2:] {
+			cmd := c.server.lookupCommand(name)
+			if cmd == nil {
+				c.reply.WriteNilArray()
+				continue
+			}
+			addReplyCommand(c, cmd)
+		}
+	default:
+		c.addReplyError("Unknown subcommand '" + string(c.Argv[1]) + "'. Try COMMAND HELP.")
+	}
+}
+
+// addReplyCommand [name, arity, [flags], first key, last key, step]
+func addReplyCommand(c *Client, cmd *RedisCommand) {
+	c.reply.WriteArray(6)
+	c.reply.WriteBulkString(cmd.Name)
+	c.reply.WriteInt(int64(cmd.Arity))
+	flags := []string{}
+	for _, f := range commandFlagNames {
+		if cmd.Flags&f.flag != 0 {
+			flags = append(flags, f.name)
+		}
+	}
+	c.reply.WriteArray(len(flags))
+	for _, flag := range flags {
+		c.reply.WriteSimpleString(flag)
+	}
+	c.reply.WriteInt(int64(cmd.FirstKey))
+	c.reply.WriteInt(int64(cmd.LastKey))
+	c.reply.WriteInt(int64(cmd.KeyStep))
+}
+
+func pingCommand(c *Client) {
+	if len(c.Argv) > 2 {
+		c.addReplyError("wrong number of arguments for 'ping' command")
+		return
+	}
+	if len(c.Argv) == 2 {
+		c.reply.WriteBulk(c.Argv[1])
+		return
+	}
+	c.reply.WriteSimpleString("PONG")
+}
+
+func echoCommand(c *Client) {
+	c.reply.WriteBulk(c.Argv[1])
+}
+
+func quitCommand(c *Client) {
+	c.Flags |= CLIENT_CLOSE_AFTER_REPLY
+	c.addReplyOK()
+}
+
+// clientCommand CLIENT SETNAME|GETNAME|ID
+func clientCommand(c *Client) {
+	switch strings.ToLower(string(c.Argv[1])) {
+	case "setname":
+		if len(c.Argv) != 3 {
+			c.addReplyError("wrong number of arguments for 'client|setname' command")
+			return
+		}
+		name := string(c.Argv[2])
+		if strings.ContainsAny(name, " \n") {
+			c.addReplyError("Client names cannot contain spaces, newlines or special characters.")
+			return
+		}
+		c.Name = name
+		c.addReplyOK()
+	case "getname":
+		if c.Name == "" {
+			c.reply.WriteNil()
+			return
+		}
+		c.reply.WriteBulkString(c.Name)
+	case "id":
+		c.reply.WriteInt(c.ID)
+	default:
+		c.addReplyError("Unknown subcommand '" + string(c.Argv[1]) + "'. Try CLIENT HELP.")
+	}
+}
@@ -0,0 +1,450 @@
+package myredis
+
+import (
+	"strconv"
+	"strings"
+)
+
+// RedisDb 一个数据库的键空间
+// dict 保存所有的键值对，键为string，值为*RedisObject
+// expires 保存设置了过期时间的键，值为过期的unix时间戳(毫秒)
+type RedisDb struct {
+	ID      int
+	dict    *Dict
+	expires *Dict
+	avgTTL  int64 // 定期删除时估算的平均剩余时间 毫秒
+	server  *Server
+	alloc   *allocator // Server的分配器，键空间以及其中的对象使用
+
+	blockingKeys map[string][]*Client // 阻塞在每个键上的客户端，按照阻塞的顺序排列
+	readyKeys    map[string]struct{}  // 已经加入server.readyKeys的键，避免重复加入
+}
+
+const (
+	HASHTABLE_MIN_FILL    = 10  // 字典的最小使用率 百分比
+	REHASH_STEPS_PER_CRON = 100 // 每次cron每个字典rehash的桶数
+)
+
+// dbDictType 键空间，键为string，删除时释放键占用的内存，值由调用方减少引用计数
+var dbDictType = &DictType{
+	hashFuction:  dictStringHash,
+	keyCampare:   dictStringCompare,
+	keyDestrutor: dictSdsDestructor,
+}
+
+// keyptrDictType 过期字典，和键空间共享键，不释放键的内存
+var keyptrDictType = &DictType{
+	hashFuction: dictStringHash,
+	keyCampare:  dictStringCompare,
+}
+
+func newRedisDb(server *Server, id int) *RedisDb {
+	db := &RedisDb{
+		ID:     id,
+		server: server,
+
+		blockingKeys: map[string][]*Client{},
+		readyKeys:    map[string]struct{}{},
+	}
+	if server != nil {
+		db.alloc = &server.mem
+	}
+	db.dict = DictCreate(db.alloc, dbDictType)
+	db.expires = DictCreate(db.alloc, keyptrDictType)
+	return db
+}
+
+// lookupKey的标志
+const (
+	LOOKUP_NONE    = 0
+	LOOKUP_NOTOUCH = 1 << 0 // 不更新访问时间和频率
+	LOOKUP_NOSTATS = 1 << 1 // 不统计keyspace_hits和keyspace_misses
+)
+
+// lookupKey 查找键并更新对象的访问时间，使用LFU淘汰时更新访问频率
+func lookupKey(db *RedisDb, key string, flags int) *RedisObject {
+	entry := DictFetchValue(db.dict, key)
+	if entry == nil {
+		return nil
+	}
+	val := entry.val.(*RedisObject)
+	if flags&LOOKUP_NOTOUCH != 0 {
+		return val
+	}
+	if db.server != nil && db.server.MaxMemoryPolicy.isLFU() {
+		db.server.updateLFU(val)
+	} else {
+		val.lru = LRUClock()
+	}
+	return val
+}
+
+// lookupKeyRead 读命令查找键，已经过期的键会被删除
+func lookupKeyRead(db *RedisDb, key string) *RedisObject {
+	return lookupKeyReadWithFlags(db, key, LOOKUP_NONE)
+}
+
+// lookupKeyReadWithFlags 读命令查找键，flags为LOOKUP_*
+func lookupKeyReadWithFlags(db *RedisDb, key string, flags int) *RedisObject {
+	stats := db.server != nil && flags&LOOKUP_NOSTATS == 0
+	if expireIfNeeded(db, key) {
+		if stats {
+			db.server.stat.keyspaceMisses++
+		}
+		return nil
+	}
+	val := lookupKey(db, key, flags)
+	if stats {
+		if val == nil {
+			db.server.stat.keyspaceMisses++
+		} else {
+			db.server.stat.keyspaceHits++
+		}
+	}
+	return val
+}
+
+// lookupKeyWrite 写命令查找键，已经过期的键会被删除
+func lookupKeyWrite(db *RedisDb, key string) *RedisObject {
+	expireIfNeeded(db, key)
+	return lookupKey(db, key, LOOKUP_NONE)
+}
+
+// dbAdd 添加键，键已经存在时返回false
+// 添加列表时唤醒阻塞在这个键上的客户端
+func dbAdd(db *RedisDb, key string, val *RedisObject) bool {
+	if DictAdd(db.dict, key, val) != nil {
+		return false
+	}
+	db.alloc.zmalloc(sdsAllocSize(len(key)))
+	if val.Type == OBJ_LIST {
+		signalKeyAsReady(db, key)
+	}
+	return true
+}
+
+// dbOverwrite 覆盖已经存在的键，保留原有的过期时间
+func dbOverwrite(db *RedisDb, key string, val *RedisObject) bool {
+	entry := DictFetchValue(db.dict, key)
+	if entry == nil {
+		return false
+	}
+	old := entry.val.(*RedisObject)
+	entry.val = val
+	DecrRefCount(old)
+	return true
+}
+
+// initObjectFreq 新创建的对象加入键空间时初始化LFU的访问频率
+// old 不为nil时为覆盖的值，新的值继承原有的访问频率
+func initObjectFreq(db *RedisDb, val, old *RedisObject) {
+	if db.server == nil || !db.server.MaxMemoryPolicy.isLFU() || val.isShared() {
+		return
+	}
+	if old != nil {
+		val.lru = old.lru
+		return
+	}
+	val.lru = LFUGetTimeInMinutes()<<LFU_COUNTER_BITS | LFU_INIT_VAL
+}
+
+// setKey 设置键的值，键存在时覆盖，同时删除过期时间
+// val 的引用计数会增加，调用方仍然持有自己的引用
+func setKey(db *RedisDb, key string, val *RedisObject) {
+	var old *RedisObject
+	if entry := DictFetchValue(db.dict, key); entry != nil {
+		old = entry.val.(*RedisObject)
+	}
+	initObjectFreq(db, val, old)
+	IncrRefCount(val)
+	if old == nil {
+		dbAdd(db, key, val)
+	} else {
+		dbOverwrite(db, key, val)
+	}
+	removeExpire(db, key)
+}
+
+// dbExists 键是否存在，不更新访问时间
+func dbExists(db *RedisDb, key string) bool {
+	return DictFetchValue(db.dict, key) != nil
+}
+
+// dbDelete 删除键以及过期时间
+func dbDelete(db *RedisDb, key string) bool {
+	if DictSize(db.expires) > 0 {
+		DictDelete(db.expires, key)
+	}
+	entry := DictFetchValue(db.dict, key)
+	if entry == nil {
+		return false
+	}
+	val := entry.val.(*RedisObject)
+	DictDelete(db.dict, key)
+	DecrRefCount(val)
+	return true
+}
+
+// dbSize 键的数量
+func dbSize(db *RedisDb) int {
+	return DictSize(db.dict)
+}
+
+// dbRandomKey 随机返回一个没有过期的键，数据库为空时返回false
+func dbRandomKey(db *RedisDb) (string, bool) {
+	for {
+		entry := DictGetRandomKey(db.dict)
+		if entry == nil {
+			return "", false
+		}
+		key := entry.key.(string)
+		if !expireIfNeeded(db, key) {
+			return key, true
+		}
+	}
+}
+
+// emptyDb 清空数据库，返回删除的键数
+func emptyDb(db *RedisDb) int {
+	removed := dbSize(db)
+	DictForEach(db.dict, func(entry *DictEntry) bool {
+		DecrRefCount(entry.val.(*RedisObject))
+		return true
+	})
+	DictRelease(db.dict)
+	DictRelease(db.expires)
+	db.dict = DictCreate(db.alloc, dbDictType)
+	db.expires = DictCreate(db.alloc, keyptrDictType)
+	db.avgTTL = 0
+	return removed
+}
+
+// setExpire 设置键的过期时间，when为unix时间戳(毫秒)，键必须存在
+func setExpire(db *RedisDb, key string, when int64) {
+	if !dbExists(db, key) {
+		return
+	}
+	DictReplace(db.expires, key, when)
+}
+
+// removeExpire 删除键的过期时间，没有设置过期时间时返回false
+func removeExpire(db *RedisDb, key string) bool {
+	if DictSize(db.expires) == 0 {
+		return false
+	}
+	return DictDelete(db.expires, key) == nil
+}
+
+// getExpire 返回键的过期时间，没有设置过期时间返回-1
+func getExpire(db *RedisDb, key string) int64 {
+	if DictSize(db.expires) == 0 {
+		return -1
+	}
+	entry := DictFetchValue(db.expires, key)
+	if entry == nil {
+		return -1
+	}
+	return entry.val.(int64)
+}
+
+// htNeedsResize 使用率低于HASHTABLE_MIN_FILL时需要缩小
+func htNeedsResize(d *Dict) bool {
+	size := d.ht[0].size
+	used := d.ht[0].used + d.ht[1].used
+	return size > DICT_HT_SIZE && used*100/size < HASHTABLE_MIN_FILL
+}
+
+// tryResizeHashTables 大量的键删除之后缩小字典
+// 过期字典的使用率过低时定期删除无法抽样到键
+func tryResizeHashTables(db *RedisDb) {
+	if htNeedsResize(db.dict) {
+		db.dict.dictReduce()
+	}
+	if htNeedsResize(db.expires) {
+		db.expires.dictReduce()
+	}
+}
+
+// incrementallyRehash 在cron中推进rehash，避免没有访问时字典一直处于rehash状态
+func incrementallyRehash(db *RedisDb) {
+	if db.dict.dictRehashing() {
+		db.dict.dictRehash(REHASH_STEPS_PER_CRON)
+	}
+	if db.expires.dictRehashing() {
+		db.expires.dictRehash(REHASH_STEPS_PER_CRON)
+	}
+}
+
+// getDbIndex 解析数据库的编号
+func (c *Client) getDbIndex(arg []byte) (int, bool) {
+	id, err := strconv.Atoi(string(arg))
+	if err != nil {
+		c.addReplyError("value is not an integer or out of range")
+		return 0, false
+	}
+	if id < 0 || id >= len(c.server.db) {
+		c.addReplyError("DB index is out of range")
+		return 0, false
+	}
+	return id, true
+}
+
+func selectCommand(c *Client) {
+	id, ok := c.getDbIndex(c.Argv[1])
+	if !ok {
+		return
+	}
+	c.selectDb(id)
+	c.addReplyOK()
+}
+
+func dbsizeCommand(c *Client) {
+	c.reply.WriteInt(int64(dbSize(c.db)))
+}
+
+// existsCommand EXISTS key [key ...] 同一个键出现多次计算多次
+// 只检查键是否存在，不更新访问时间，也不统计命中
+func existsCommand(c *Client) {
+	var count int64
+	for _, key := range c.Argv[1:] {
+		if lookupKeyReadWithFlags(c.db, string(key), LOOKUP_NOTOUCH|LOOKUP_NOSTATS) != nil {
+			count++
+		}
+	}
+	c.reply.WriteInt(count)
+}
+
+func delCommand(c *Client) {
+	var deleted int64
+	for _, key := range c.Argv[1:] {
+		// 已经过期的键按照不存在处理，同时计入expired_keys
+		expireIfNeeded(c.db, string(key))
+		if dbDelete(c.db, string(key)) {
+			deleted++
+		}
+	}
+	c.reply.WriteInt(deleted)
+}
+
+// typeCommand TYPE key 不更新访问时间和频率
+func typeCommand(c *Client) {
+	val := lookupKeyReadWithFlags(c.db, string(c.Argv[1]), LOOKUP_NOTOUCH)
+	if val == nil {
+		c.reply.WriteSimpleString("none")
+		return
+	}
+	c.reply.WriteSimpleString(ObjectTypeName(val))
+}
+
+func randomkeyCommand(c *Client) {
+	key, ok := dbRandomKey(c.db)
+	if !ok {
+		c.reply.WriteNil()
+		return
+	}
+	c.reply.WriteBulkString(key)
+}
+
+// renameCommand RENAME key newkey 保留原有的过期时间
+func renameCommand(c *Client) {
+	src, dst := string(c.Argv[1]), string(c.Argv[2])
+	val := lookupKeyWrite(c.db, src)
+	if val == nil {
+		c.addReplyError("no such key")
+		return
+	}
+	if src == dst {
+		c.addReplyOK()
+		return
+	}
+	expire := getExpire(c.db, src)
+	IncrRefCount(val)
+	dbDelete(c.db, dst)
+	dbAdd(c.db, dst, val)
+	if expire != -1 {
+		setExpire(c.db, dst, expire)
+	}
+	dbDelete(c.db, src)
+	c.addReplyOK()
+}
+
+// moveCommand MOVE key db 目标数据库中已经存在时不移动
+func moveCommand(c *Client) {
+	id, ok := c.getDbIndex(c.Argv[2])
+	if !ok {
+		return
+	}
+	src, dst := c.db, c.server.db[id]
+	if src == dst {
+		c.addReplyError("source and destination objects are the same")
+		return
+	}
+	key := string(c.Argv[1])
+	val := lookupKeyWrite(src, key)
+	if val == nil || lookupKeyWrite(dst, key) != nil {
+		c.reply.WriteInt(0)
+		return
+	}
+	expire := getExpire(src, key)
+	IncrRefCount(val)
+	dbAdd(dst, key, val)
+	if expire != -1 {
+		setExpire(dst, key, expire)
+	}
+	dbDelete(src, key)
+	c.reply.WriteInt(1)
+}
+
+// swapdbCommand SWAPDB index1 index2
+// 交换两个数据库的内容，选择了这两个数据库的客户端会立即看到另一个数据库的数据
+func swapdbCommand(c *Client) {
+	id1, ok := c.getDbIndex(c.Argv[1])
+	if !ok {
+		return
+	}
+	id2, ok := c.getDbIndex(c.Argv[2])
+	if !ok {
+		return
+	}
+	db1, db2 := c.server.db[id1], c.server.db[id2]
+	db1.dict, db2.dict = db2.dict, db1.dict
+	db1.expires, db2.expires = db2.expires, db1.expires
+	db1.avgTTL, db2.avgTTL = db2.avgTTL, db1.avgTTL
+	// 阻塞的客户端仍然阻塞在原来的数据库上，交换之后的数据库中可能已经存在列表
+	scanDatabaseForReadyLists(db1)
+	scanDatabaseForReadyLists(db2)
+	c.addReplyOK()
+}
+
+// flushArgs 解析FLUSHDB和FLUSHALL的ASYNC和SYNC参数，这里都是同步执行
+func flushArgs(c *Client) bool {
+	if len(c.Argv) == 1 {
+		return true
+	}
+	if len(c.Argv) == 2 {
+		switch strings.ToLower(string(c.Argv[1])) {
+		case "async", "sync":
+			return true
+		}
+	}
+	c.addReplyError("syntax error")
+	return false
+}
+
+func flushdbCommand(c *Client) {
+	if !flushArgs(c) {
+		return
+	}
+	emptyDb(c.db)
+	c.addReplyOK()
+}
+
+func flushallCommand(c *Client) {
+	if !flushArgs(c) {
+		return
+	}
+	for _, db := range c.server.db {
+		emptyDb(db)
+	}
+	c.addReplyOK()
+}
@@ -0,0 +1,123 @@
+package myredis
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+func TestKeyspaceCommands(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	client.Set("a", "1", 0)
+	client.Set("b", "hello", 0)
+	if n := client.Exists("a", "b", "a", "missing").Val(); n != 3 {
+		t.Fatalf("exists %d", n)
+	}
+	if typ := client.Type("a").Val(); typ != "string" {
+		t.Fatalf("type %s", typ)
+	}
+	if typ := client.Type("missing").Val(); typ != "none" {
+		t.Fatalf("type missing %s", typ)
+	}
+	if err := client.Rename("b", "c").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Rename("missing", "d").Err(); err == nil || err.Error() != "ERR no such key" {
+		t.Fatalf("rename missing %v", err)
+	}
+	if val := client.Get("c").Val(); val != "hello" {
+		t.Fatalf("renamed %s", val)
+	}
+	if key := client.RandomKey().Val(); key != "a" && key != "c" {
+		t.Fatalf("randomkey %s", key)
+	}
+	if n := client.DBSize().Val(); n != 2 {
+		t.Fatalf("dbsize %d", n)
+	}
+	if n := client.Del("a", "missing").Val(); n != 1 {
+		t.Fatalf("del %d", n)
+	}
+
+	db1 := redis.NewClient(&redis.Options{Addr: addr, DB: 1})
+	defer db1.Close()
+	if !client.Move("c", 1).Val() {
+		t.Fatal("move")
+	}
+	if client.Exists("c").Val() != 0 || db1.Get("c").Val() != "hello" {
+		t.Fatal("moved key")
+	}
+	if err := client.Do("swapdb", 0, 1).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if client.Get("c").Val() != "hello" || db1.DBSize().Val() != 0 {
+		t.Fatal("swapdb")
+	}
+	client.FlushAll()
+	if client.DBSize().Val() != 0 {
+		t.Fatal("flushall")
+	}
+	if err := client.RandomKey().Err(); err != redis.Nil {
+		t.Fatalf("randomkey empty %v", err)
+	}
+}
+
+func TestKeyspaceManyKeys(t *testing.T) {
+	db := newRedisDb(nil, 0)
+	for i := 0; i < 10000; i++ {
+		val := CreateStringObjectFromInt64(db.alloc, int64(i))
+		setKey(db, "key:"+strconv.Itoa(i), val)
+	}
+	if dbSize(db) != 10000 {
+		t.Fatalf("dbsize %d", dbSize(db))
+	}
+	if emptyDb(db) != 10000 || dbSize(db) != 0 {
+		t.Fatal("emptydb")
+	}
+}
+
+// TestKeyspaceNoTouch EXISTS和TYPE不更新访问频率，EXISTS不统计命中
+func TestKeyspaceNoTouch(t *testing.T) {
+	_, addr := startServer(t, func(s *Server) {
+		s.MaxMemoryPolicy = AllKeysLFU
+	})
+	client := newTestClient(addr)
+	defer client.Close()
+
+	client.Set("k", "v", 0)
+	for i := 0; i < 100; i++ {
+		client.Exists("k", "missing")
+		client.Type("k")
+	}
+	if freq, _ := client.Do("object", "freq", "k").Int64(); freq != LFU_INIT_VAL {
+		t.Fatalf("freq %d after exists and type", freq)
+	}
+	stats := parseInfo(t, client.Info("stats").Val())
+	if stats["keyspace_misses"] != "0" {
+		t.Fatalf("keyspace misses %s", stats["keyspace_misses"])
+	}
+}
+
+func TestFlushOptions(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	for _, args := range [][]interface{}{{"flushdb"}, {"flushdb", "async"}, {"flushdb", "SYNC"}, {"flushall", "sync"}, {"flushall", "ASYNC"}} {
+		client.Set("k", "v", 0)
+		if err := client.Do(args...).Err(); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if n := client.DBSize().Val(); n != 0 {
+			t.Fatalf("%v: dbsize %d", args, n)
+		}
+	}
+	for _, args := range [][]interface{}{{"flushdb", "lazy"}, {"flushall", "sync", "async"}} {
+		if err := client.Do(args...).Err(); err == nil || err.Error() != "ERR syntax error" {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+}
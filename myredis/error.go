@@ -11,6 +11,7 @@ const (
 	NOT_ALLOW_TYPE    = 8  // 不允许的类型
 	VALUE_EXIST       = 9  // 元素已经存在
 	VALUE_NOT_FOUND   = 10 // 元素不存在
+	PROTOCOL_ERR      = 11 // 客户端请求不符合协议
 )
 
 type Error struct {
@@ -0,0 +1,258 @@
+package myredis
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 过期键的删除
+// 1 惰性删除: 每次访问键时检查是否过期，过期直接删除
+// 2 定期删除: serverCron按照hz的频率执行慢模式，每次最多使用 1s/hz*25% 的时间(hz=10时25ms)
+//   每个数据库随机抽取20个设置了过期时间的键，删除其中过期的键
+//   过期的比例超过25%时继续抽取，否则处理下一个数据库，超过时间限制时退出
+// 3 快模式: 慢模式因为超时退出或者过期键的估算比例超过10%时，每次执行命令之后执行快模式
+//   快模式最多运行1ms，并且2s内只运行一次
+
+const (
+	ACTIVE_EXPIRE_CYCLE_LOOKUPS_PER_LOOP = 20               // 每次循环在每个数据库中抽取的键数
+	ACTIVE_EXPIRE_CYCLE_FAST_DURATION    = time.Millisecond // 快模式的时间限制
+	ACTIVE_EXPIRE_CYCLE_FAST_INTERVAL    = 2 * time.Second  // 快模式的最小间隔
+	ACTIVE_EXPIRE_CYCLE_SLOW_TIME_PERC   = 25               // 慢模式最多使用cron周期的百分比
+	ACTIVE_EXPIRE_CYCLE_ACCEPTABLE_STALE = 10               // 可以接受的过期键比例，超过时执行快模式
+
+	ACTIVE_EXPIRE_CYCLE_SLOW = 0
+	ACTIVE_EXPIRE_CYCLE_FAST = 1
+
+	CRON_DBS_PER_CALL = 16 // 每次最多处理的数据库个数
+)
+
+// mstime 当前的unix时间戳 毫秒
+func mstime() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// keyIsExpired 键是否已经过期
+func keyIsExpired(db *RedisDb, key string) bool {
+	when := getExpire(db, key)
+	if when < 0 {
+		return false
+	}
+	return mstime() > when
+}
+
+// expireIfNeeded 惰性删除，键已经过期时删除并返回true
+func expireIfNeeded(db *RedisDb, key string) bool {
+	if !keyIsExpired(db, key) {
+		return false
+	}
+	if db.server != nil {
+		db.server.stat.expiredKeys++
+	}
+	dbDelete(db, key)
+	return true
+}
+
+// activeExpireCycleTryExpire 删除已经过期的键
+func (s *Server) activeExpireCycleTryExpire(db *RedisDb, entry *DictEntry, now int64) bool {
+	if now <= entry.val.(int64) {
+		return false
+	}
+	s.stat.expiredKeys++
+	dbDelete(db, entry.key.(string))
+	return true
+}
+
+// activeExpireCycle 定期删除过期键
+func (s *Server) activeExpireCycle(cycleType int) {
+	start := time.Now()
+	if cycleType == ACTIVE_EXPIRE_CYCLE_FAST {
+		// 上一次慢模式没有超时并且过期键的比例可以接受时不需要执行快模式
+		if !s.expire.timelimitExit && s.stat.expiredStalePerc < ACTIVE_EXPIRE_CYCLE_ACCEPTABLE_STALE {
+			return
+		}
+		if start.Sub(s.expire.lastFastCycle) < ACTIVE_EXPIRE_CYCLE_FAST_INTERVAL {
+			return
+		}
+		s.expire.lastFastCycle = start
+	}
+
+	dbsPerCall := CRON_DBS_PER_CALL
+	// 上一次因为超时退出时处理所有的数据库，避免过期键在部分数据库中堆积
+	if dbsPerCall > len(s.db) || s.expire.timelimitExit {
+		dbsPerCall = len(s.db)
+	}
+	timelimit := time.Second * ACTIVE_EXPIRE_CYCLE_SLOW_TIME_PERC / time.Duration(s.Hz) / 100
+	if cycleType == ACTIVE_EXPIRE_CYCLE_FAST {
+		timelimit = ACTIVE_EXPIRE_CYCLE_FAST_DURATION
+	}
+	s.expire.timelimitExit = false
+
+	totalSampled, totalExpired := 0, 0
+	iteration := 0
+	for j := 0; j < dbsPerCall && !s.expire.timelimitExit; j++ {
+		db := s.db[s.expire.currentDb%len(s.db)]
+		s.expire.currentDb++
+
+		for {
+			num := DictSize(db.expires)
+			if num == 0 {
+				break
+			}
+			// 过期字典的使用率低于1%时抽样的代价太高，等待字典缩小
+			slots := int(db.expires.ht[0].size + db.expires.ht[1].size)
+			if slots > DICT_HT_SIZE && num*100/slots < 1 {
+				break
+			}
+			if num > ACTIVE_EXPIRE_CYCLE_LOOKUPS_PER_LOOP {
+				num = ACTIVE_EXPIRE_CYCLE_LOOKUPS_PER_LOOP
+			}
+
+			now := mstime()
+			expired, sampled := 0, 0
+			var ttlSum int64
+			ttlSamples := 0
+			for ; num > 0; num-- {
+				entry := DictGetRandomKey(db.expires)
+				if entry == nil {
+					break
+				}
+				sampled++
+				ttl := entry.val.(int64) - now
+				if s.activeExpireCycleTryExpire(db, entry, now) {
+					expired++
+				} else if ttl > 0 {
+					ttlSum += ttl
+					ttlSamples++
+				}
+			}
+			// 平均剩余时间，以2%的权重更新，INFO keyspace 中的avg_ttl
+			if ttlSamples > 0 {
+				avgTTL := ttlSum / int64(ttlSamples)
+				if db.avgTTL == 0 {
+					db.avgTTL = avgTTL
+				} else {
+					db.avgTTL = db.avgTTL/50*49 + avgTTL/50
+				}
+			}
+			totalExpired += expired
+			totalSampled += sampled
+
+			// 每16次循环检查一次是否超时
+			iteration++
+			if iteration%16 == 0 && time.Since(start) > timelimit {
+				s.expire.timelimitExit = true
+				s.stat.expiredTimeCapReachedCount++
+				break
+			}
+			if expired <= ACTIVE_EXPIRE_CYCLE_LOOKUPS_PER_LOOP/4 {
+				break
+			}
+		}
+	}
+
+	// 使用指数移动平均估算过期键的比例
+	currentPerc := 0.0
+	if totalSampled > 0 {
+		currentPerc = float64(totalExpired) / float64(totalSampled) * 100
+	}
+	s.stat.expiredStalePerc = currentPerc*0.05 + s.stat.expiredStalePerc*0.95
+}
+
+// expireGenericCommand EXPIRE PEXPIRE EXPIREAT PEXPIREAT
+// basetime 为0时参数为绝对时间，unit为参数的时间单位(毫秒)
+func expireGenericCommand(c *Client, basetime int64, unit int64) {
+	key := string(c.Argv[1])
+	when, err := strconv.ParseInt(string(c.Argv[2]), 10, 64)
+	if err != nil {
+		c.addReplyError("value is not an integer or out of range")
+		return
+	}
+	when, ok := expireTime(when, unit, basetime)
+	if !ok {
+		c.addReplyError("invalid expire time in " + strings.ToLower(string(c.Argv[0])))
+		return
+	}
+
+	if lookupKeyWrite(c.db, key) == nil {
+		c.reply.WriteInt(0)
+		return
+	}
+	// 过期时间已经过去时直接删除
+	if when <= mstime() {
+		dbDelete(c.db, key)
+		c.reply.WriteInt(1)
+		return
+	}
+	setExpire(c.db, key, when)
+	c.reply.WriteInt(1)
+}
+
+// expireTime 把参数转换为毫秒的过期时间，乘以单位或者加上基准时间溢出时返回false
+func expireTime(when, unit, basetime int64) (int64, bool) {
+	if when > math.MaxInt64/unit || when < math.MinInt64/unit {
+		return 0, false
+	}
+	when *= unit
+	if when > math.MaxInt64-basetime {
+		return 0, false
+	}
+	return when + basetime, true
+}
+
+func expireCommand(c *Client) {
+	expireGenericCommand(c, mstime(), 1000)
+}
+
+func pexpireCommand(c *Client) {
+	expireGenericCommand(c, mstime(), 1)
+}
+
+func expireatCommand(c *Client) {
+	expireGenericCommand(c, 0, 1000)
+}
+
+func pexpireatCommand(c *Client) {
+	expireGenericCommand(c, 0, 1)
+}
+
+// ttlGenericCommand 键不存在返回-2，没有过期时间返回-1
+func ttlGenericCommand(c *Client, outputMs bool) {
+	key := string(c.Argv[1])
+	if lookupKeyRead(c.db, key) == nil {
+		c.reply.WriteInt(-2)
+		return
+	}
+	expire := getExpire(c.db, key)
+	if expire == -1 {
+		c.reply.WriteInt(-1)
+		return
+	}
+	ttl := expire - mstime()
+	if ttl < 0 {
+		ttl = 0
+	}
+	if outputMs {
+		c.reply.WriteInt(ttl)
+		return
+	}
+	c.reply.WriteInt((ttl + 500) / 1000)
+}
+
+func ttlCommand(c *Client) {
+	ttlGenericCommand(c, false)
+}
+
+func pttlCommand(c *Client) {
+	ttlGenericCommand(c, true)
+}
+
+func persistCommand(c *Client) {
+	key := string(c.Argv[1])
+	if lookupKeyWrite(c.db, key) == nil || !removeExpire(c.db, key) {
+		c.reply.WriteInt(0)
+		return
+	}
+	c.reply.WriteInt(1)
+}
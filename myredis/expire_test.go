@@ -0,0 +1,165 @@
+package myredis
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestExpireCommands(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	client.Set("k", "v", 0)
+	if ttl, _ := client.Do("ttl", "k").Int64(); ttl != -1 {
+		t.Fatalf("ttl without expire %v", ttl)
+	}
+	if ttl, _ := client.Do("ttl", "missing").Int64(); ttl != -2 {
+		t.Fatalf("ttl missing %v", ttl)
+	}
+	if !client.Expire("k", 100*time.Second).Val() {
+		t.Fatal("expire")
+	}
+	if ttl := client.TTL("k").Val(); ttl != 100*time.Second {
+		t.Fatalf("ttl %v", ttl)
+	}
+	if pttl := client.PTTL("k").Val(); pttl <= 99*time.Second || pttl > 100*time.Second {
+		t.Fatalf("pttl %v", pttl)
+	}
+	if !client.Persist("k").Val() || client.Persist("k").Val() {
+		t.Fatal("persist")
+	}
+	if !client.ExpireAt("k", time.Now().Add(-time.Second)).Val() || client.Exists("k").Val() != 0 {
+		t.Fatal("expireat in the past should delete the key")
+	}
+
+	// SET 会清除原有的过期时间
+	client.Set("k", "v", time.Minute)
+	client.Set("k", "v2", 0)
+	if ttl, _ := client.Do("ttl", "k").Int64(); ttl != -1 {
+		t.Fatalf("ttl after set %v", ttl)
+	}
+
+	// 惰性删除
+	client.Set("lazy", "v", 20*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	if err := client.Get("lazy").Err(); err != redis.Nil {
+		t.Fatalf("lazy expire %v", err)
+	}
+}
+
+// TestExpireOverflow 过期时间乘以单位或者加上当前时间溢出时返回错误
+func TestExpireOverflow(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	client.Set("k", "v", 0)
+	max := strconv.FormatInt(math.MaxInt64, 10)
+	for _, args := range [][]interface{}{
+		{"expire", "k", max},
+		{"expire", "k", strconv.FormatInt(math.MinInt64/100, 10)},
+		{"pexpire", "k", max},
+		{"expireat", "k", strconv.FormatInt(math.MaxInt64/1000+1, 10)},
+		{"set", "k", "v", "ex", strconv.FormatInt(math.MaxInt64/1000, 10)},
+		{"set", "k", "v", "px", max, "nx"},
+	} {
+		err := client.Do(args...).Err()
+		if err == nil || !strings.HasPrefix(err.Error(), "ERR invalid expire time in ") {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+	if ttl, _ := client.Do("ttl", "k").Int64(); ttl != -1 {
+		t.Fatalf("ttl after invalid expire %d", ttl)
+	}
+	// 没有溢出的最大值
+	if !client.PExpireAt("k", time.Unix(0, math.MaxInt64)).Val() || client.TTL("k").Val() <= 0 {
+		t.Fatal("pexpireat far future")
+	}
+}
+
+// TestActiveExpireCycleStats 慢模式的过期键比例和超时次数
+func TestActiveExpireCycleStats(t *testing.T) {
+	s := NewServer()
+	s.Hz = 500
+	db := newRedisDb(s, 0)
+	s.db = []*RedisDb{db}
+	expireKeys := func(n int) {
+		for i := 0; i < n; i++ {
+			key := "key:" + strconv.Itoa(i)
+			setKey(db, key, CreateStringObjectFromInt64(db.alloc, int64(i)))
+			setExpire(db, key, mstime()-1000)
+		}
+	}
+
+	expireKeys(200)
+	s.activeExpireCycle(ACTIVE_EXPIRE_CYCLE_SLOW)
+	// 抽样的键全部过期，比例以5%的权重更新
+	if dbSize(db) != 0 || s.stat.expiredKeys != 200 || s.stat.expiredStalePerc != 5 {
+		t.Fatalf("dbsize %d expired %d stale %f", dbSize(db), s.stat.expiredKeys, s.stat.expiredStalePerc)
+	}
+	if s.stat.expiredTimeCapReachedCount != 0 || s.expire.timelimitExit {
+		t.Fatal("time limit reached with 200 keys")
+	}
+	s.activeExpireCycle(ACTIVE_EXPIRE_CYCLE_SLOW)
+	if s.stat.expiredStalePerc != 5*0.95 {
+		t.Fatalf("stale without samples %f", s.stat.expiredStalePerc)
+	}
+
+	// hz=500时每次最多运行0.5ms，删除大量的键会超时
+	expireKeys(100000)
+	s.activeExpireCycle(ACTIVE_EXPIRE_CYCLE_SLOW)
+	if s.stat.expiredTimeCapReachedCount != 1 || !s.expire.timelimitExit || dbSize(db) == 0 {
+		t.Fatalf("time cap reached %d dbsize %d", s.stat.expiredTimeCapReachedCount, dbSize(db))
+	}
+}
+
+func TestActiveExpireCycle(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	pipe := client.Pipeline()
+	for i := 0; i < 1000; i++ {
+		pipe.Set("volatile:"+strconv.Itoa(i), "v", 50*time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		pipe.Set("persistent:"+strconv.Itoa(i), "v", 0)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有访问过期的键，由serverCron定期删除
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if client.DBSize().Val() == 10 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("dbsize %d after active expire", client.DBSize().Val())
+}
+
+// TestDelExpired DEL已经过期的键返回0，并且计入expired_keys
+func TestDelExpired(t *testing.T) {
+	// hz=1时第一次定期删除在启动1秒之后，测试期间过期的键只能被惰性删除
+	_, addr := startServer(t, func(s *Server) { s.Hz = 1 })
+	client := newTestClient(addr)
+	defer client.Close()
+
+	client.Set("expired", "v", 20*time.Millisecond)
+	client.Set("k", "v", 0)
+	time.Sleep(30 * time.Millisecond)
+	if n := client.Del("expired", "k").Val(); n != 1 {
+		t.Fatalf("del %d", n)
+	}
+	if expired := parseInfo(t, client.Info("stats").Val())["expired_keys"]; expired != "1" {
+		t.Fatalf("expired_keys %s", expired)
+	}
+}
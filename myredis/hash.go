@@ -65,27 +65,31 @@ func (ht *DictHt) reset() {
 }
 
 // resetTable 重新对table进行赋值
-func (ht *DictHt) resetTable(size uint) {
+func (ht *DictHt) resetTable(alloc *allocator, size uint) {
 	if size == 0 {
 		return
 	}
 	ht.table = make([]*DictEntry, size)
+	alloc.zmalloc(int(size) * PTR_SIZE)
 	ht.size = size
 	ht.sizemask = size - 1
 	ht.used = 0
 }
 
-func (ht *DictHt) release() {
+// release 释放哈希表中所有的节点以及table，freeEntry 释放每个节点
+func (ht *DictHt) release(alloc *allocator, freeEntry func(entry *DictEntry)) {
 	var i uint
 	for i < ht.size {
 		entry := ht.table[i]
-		if entry != nil {
-			entry.release()
+		for entry != nil {
+			next := entry.next
+			freeEntry(entry)
+			entry = next
 		}
-		entry = nil
 		ht.table[i] = nil
-		ht.used--
+		i++
 	}
+	alloc.zfree(int(ht.size) * PTR_SIZE)
 	ht.reset()
 }
 
@@ -101,6 +105,14 @@ func (entry *DictEntry) release() {
 	entry.next = nil
 }
 
+func (entry *DictEntry) Key() interface{} {
+	return entry.key
+}
+
+func (entry *DictEntry) Val() interface{} {
+	return entry.val
+}
+
 func newEntry(key, val interface{}, next *DictEntry) *DictEntry {
 	return &DictEntry{
 		key:  key,
@@ -112,6 +124,8 @@ func newEntry(key, val interface{}, next *DictEntry) *DictEntry {
 type Dict struct {
 	// 特定的函数类型
 	dictType *DictType
+	// 字典以及键值对使用的分配器
+	alloc *allocator
 	// 哈希表用于存储键值对
 	// ht[0] 和ht[1]
 	// 当ht[0] 元素过大就会像ht[1] 渐进式哈希
@@ -143,6 +157,7 @@ func (d *Dict) dictRehash(n int) (bool, error) {
 		// 并且重新设置ht[1]中的值
 		// 然后把rehashidx设置为-1
 		if d.ht[0].used == 0 {
+			d.alloc.zfree(int(d.ht[0].size) * PTR_SIZE)
 			tempHt := d.ht[0]
 			// 释放ht[0]中的哈希表
 			tempHt.reset()
@@ -156,7 +171,7 @@ func (d *Dict) dictRehash(n int) (bool, error) {
 			return true, nil
 		}
 
-		if d.ht[0].size > uint(d.rehashidx) {
+		if uint(d.rehashidx) >= d.ht[0].size {
 			// rehash 发生越界
 			return false, &Error{
 				Code: REHASH_OUT_RANGE,
@@ -224,6 +239,11 @@ func (d *Dict) getEntryWithKey(key interface{}, hash uint64) *DictEntry {
 		return nil
 	}
 
+	// 空字典
+	if d.ht[0].size == 0 {
+		return nil
+	}
+
 	// 在ht[0]中查找
 	index0 := hash & uint64(d.ht[0].sizemask)
 	dictEntry := findKeyFunc(d.ht[0].table[index0])
@@ -248,6 +268,7 @@ func (d *Dict) getEntryWithKey(key interface{}, hash uint64) *DictEntry {
 func (d *Dict) dictAdd(htIndex int, hash uint64, key, value interface{}) {
 	index := hash & uint64(d.ht[htIndex].sizemask)
 	entry := newEntry(key, value, d.ht[htIndex].table[index])
+	d.alloc.zmalloc(DICT_ENTRY_OVERHEAD)
 	d.ht[htIndex].table[index] = entry
 	d.ht[htIndex].used++
 }
@@ -261,7 +282,7 @@ func (d *Dict) dictExpend() {
 
 	// 如果ht[0].size ==0, 创建并返回一个给定大小的ht[0].table
 	if d.ht[0].size == 0 {
-		d.ht[0].resetTable(DICT_HT_SIZE)
+		d.ht[0].resetTable(d.alloc, DICT_HT_SIZE)
 		return
 	}
 
@@ -272,18 +293,28 @@ func (d *Dict) dictExpend() {
 	// 如果对哈希表进行扩展扩展的大小为第一个>=ht[0].used*2的2^n(2的n次幂)
 	if (d.ht[0].used >= d.ht[0].size && dict_can_resize) || (d.ht[0].used/d.ht[0].size > dict_force_resize_ratio) {
 		size := d.getTableSize(2 * d.ht[0].used)
-		d.ht[1].resetTable(size)
+		d.ht[1].resetTable(d.alloc, size)
 		d.rehashidx = 0
 	}
 }
 
-func (d *Dict) release() {
-	if d.dictRehashing() {
-		// 如果在rehash中，不能进行扩展
-		return
+// freeEntry 调用键和值的销毁函数并释放节点
+func (d *Dict) freeEntry(entry *DictEntry) {
+	if d.dictType.keyDestrutor != nil {
+		d.dictType.keyDestrutor(d, entry.key)
 	}
-	d.ht[0].release()
-	d.ht[1].release()
+	if d.dictType.valDestrutor != nil {
+		d.dictType.valDestrutor(d, entry.val)
+	}
+	entry.release()
+	d.alloc.zfree(DICT_ENTRY_OVERHEAD)
+}
+
+// release 释放两个哈希表，rehash过程中两个哈希表都有节点
+func (d *Dict) release() {
+	d.ht[0].release(d.alloc, d.freeEntry)
+	d.ht[1].release(d.alloc, d.freeEntry)
+	d.rehashidx = -1
 }
 
 // dictReduce 哈希表缩小
@@ -307,13 +338,13 @@ func (d *Dict) dictReduce() {
 	}
 	// 返回一个更小值
 	size = d.getTableSize(size)
-	d.ht[1].resetTable(size)
+	d.ht[1].resetTable(d.alloc, size)
 	d.rehashidx = 0
 }
 
 func (d *Dict) getTableSize(size uint) uint {
 	var length uint = 1
-	for length >= size {
+	for length < size {
 		length = length << 1
 	}
 	return length
@@ -330,7 +361,7 @@ func (d *Dict) dictGetRandomKey() *DictEntry {
 	// 不在rehash时,  d.ht[1].size 为0, 所以如下可以满足两种情况
 	for dictEntry == nil {
 		index := uint(rand.Intn(int(d.ht[0].size + d.ht[1].size)))
-		if index <= d.ht[0].size {
+		if index < d.ht[0].size {
 			dictEntry = d.ht[0].table[index]
 		} else {
 			dictEntry = d.ht[1].table[index-d.ht[0].size]
@@ -350,6 +381,7 @@ func (d *Dict) dictGetRandomKey() *DictEntry {
 	randomEntryNum := rand.Intn(entryNum)
 	for randomEntryNum > 0 {
 		entry = entry.next
+		randomEntryNum--
 	}
 	return entry
 }
@@ -387,7 +419,7 @@ func (d *Dict) dictDelete(key interface{}) error {
 				// 节点数目-1
 				d.ht[tableIndex].used--
 				// 释放dictEntry指向的值
-				dictEntry.release()
+				d.freeEntry(dictEntry)
 				dictEntry = nil
 				return true
 			}
@@ -403,26 +435,23 @@ func (d *Dict) dictDelete(key interface{}) error {
 		return nil
 	}
 
-	if !d.dictRehashing() {
-		// 证明没有rehash,只在ht[0]中查找即可
-		return nil
-	}
-
-	// 查找到直接返回
-	if deleteKeyFunc(1) {
+	// 没有rehash时只在ht[0]中查找
+	if d.dictRehashing() && deleteKeyFunc(1) {
 		return nil
 	}
 
 	return &Error{Code: KEY_NNOT_FOUND, MSG: "key not found in hash"}
 }
 
-// DictCreate 创建一个哈希表
-func DictCreate(dictType *DictType) *Dict {
+// DictCreate 创建一个哈希表，字典以及之后添加的键值对的内存计入alloc
+func DictCreate(alloc *allocator, dictType *DictType) *Dict {
 	// 给ht赋初值
 	dictht := DictHt{}
 	ht := [2]DictHt{dictht, dictht}
+	alloc.zmalloc(DICT_OVERHEAD)
 	return &Dict{
 		dictType:  dictType,
+		alloc:     alloc,
 		ht:        ht,
 		rehashidx: -1, // 初始值设置为-1
 	}
@@ -469,8 +498,12 @@ func DictReplace(dict *Dict, key, value interface{}) error {
 		// 这里hash不可避免计算两次
 		return DictAdd(dict, key, value)
 	}
-	// 使用新值替换旧值
+	// 使用新值替换旧值，先设置新值再释放旧值，新值和旧值可能相同
+	old := dictEntry.val
 	dictEntry.val = value
+	if dict.dictType.valDestrutor != nil {
+		dict.dictType.valDestrutor(dict, old)
+	}
 	return nil
 }
 
@@ -484,7 +517,7 @@ func DictFetchValue(dict *Dict, key interface{}) *DictEntry {
 
 // DictGetRandomKey 从字典中随机返回一个键值对
 func DictGetRandomKey(dict *Dict) *DictEntry {
-	if dict.ht[0].size == 0 {
+	if DictSize(dict) == 0 {
 		// 字典为空
 		return nil
 	}
@@ -505,6 +538,34 @@ func DictRelease(dict *Dict) {
 		return
 	}
 	dict.release()
+	dict.alloc.zfree(DICT_OVERHEAD)
+}
+
+// DictSize 字典中键值对的数量，rehash时为两个哈希表之和
+func DictSize(dict *Dict) int {
+	if dict == nil {
+		return 0
+	}
+	return int(dict.ht[0].used + dict.ht[1].used)
+}
+
+// DictForEach 遍历字典中所有的键值对，fn返回false时停止遍历
+// 遍历过程中不会执行rehash，fn中不能对字典进行添加或者删除
+func DictForEach(dict *Dict, fn func(entry *DictEntry) bool) {
+	if dict == nil {
+		return
+	}
+	for i := range dict.ht {
+		for _, entry := range dict.ht[i].table {
+			for entry != nil {
+				next := entry.next
+				if !fn(entry) {
+					return
+				}
+				entry = next
+			}
+		}
+	}
 }
 
 // 特殊的函数类型
@@ -517,8 +578,8 @@ type DictType struct {
 	valDup func(val interface{}) interface{}
 	// 对比键的函数
 	keyCampare func(key1, key2 interface{}) bool
-	// 销毁键的函数
-	keyDestrutor func(key interface{})
+	// 销毁键的函数，d为键所在的字典
+	keyDestrutor func(d *Dict, key interface{})
 	// 销毁值的函数
-	valDestrutor func(val interface{})
+	valDestrutor func(d *Dict, val interface{})
 }
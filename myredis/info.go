@@ -0,0 +1,295 @@
+package myredis
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// INFO 命令的输出，每个部分以 # Section 开头，每行为 field:value，部分之间以空行分隔
+// 字段和redis一致，没有实现的功能(持久化、复制等)输出redis在功能关闭时的值
+
+const (
+	REDIS_VERSION      = "6.2.0"
+	CONFIG_RUN_ID_SIZE = 40 // run_id 的长度
+)
+
+// 瞬时指标，serverCron中采样，取最近STATS_METRIC_SAMPLES次采样的平均值
+const (
+	STATS_METRIC_SAMPLES    = 16
+	STATS_METRIC_COMMAND    = 0 // 每秒执行的命令数
+	STATS_METRIC_NET_INPUT  = 1 // 每秒读取的字节数
+	STATS_METRIC_NET_OUTPUT = 2 // 每秒发送的字节数
+	STATS_METRIC_COUNT      = 3
+)
+
+type instantaneousMetric struct {
+	lastSampleTime  time.Time // 上一次采样的时间
+	lastSampleCount int64     // 上一次采样时的计数
+	samples         [STATS_METRIC_SAMPLES]int64
+	idx             int
+}
+
+// trackInstantaneousMetric 根据两次采样之间计数的增量计算每秒的值
+func (s *Server) trackInstantaneousMetric(metric int, current int64) {
+	m := &s.stat.instMetric[metric]
+	now := time.Now()
+	if !m.lastSampleTime.IsZero() {
+		if elapsed := now.Sub(m.lastSampleTime); elapsed > 0 {
+			m.samples[m.idx] = (current - m.lastSampleCount) * int64(time.Second) / int64(elapsed)
+			m.idx = (m.idx + 1) % STATS_METRIC_SAMPLES
+		}
+	}
+	m.lastSampleTime = now
+	m.lastSampleCount = current
+}
+
+// getInstantaneousMetric 最近的采样的平均值
+func (s *Server) getInstantaneousMetric(metric int) int64 {
+	var sum int64
+	for _, v := range s.stat.instMetric[metric].samples {
+		sum += v
+	}
+	return sum / STATS_METRIC_SAMPLES
+}
+
+// processRSS 进程向操作系统申请并且没有归还的内存，作为used_memory_rss
+func processRSS() int {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return int(ms.Sys - ms.HeapReleased)
+}
+
+// bytesToHuman 把字节数转换为便于阅读的格式 如1.50M
+func bytesToHuman(n int) string {
+	d := float64(n)
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%dB", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.2fK", d/1024)
+	case n < 1024*1024*1024:
+		return fmt.Sprintf("%.2fM", d/(1024*1024))
+	case n < 1024*1024*1024*1024:
+		return fmt.Sprintf("%.2fG", d/(1024*1024*1024))
+	}
+	return fmt.Sprintf("%.2fT", d/(1024*1024*1024*1024))
+}
+
+// genRedisInfoString 生成section部分的信息
+// all返回所有的部分，default返回除了commandstats之外的部分
+func (s *Server) genRedisInfoString(section string) string {
+	var b strings.Builder
+	all := section == "all"
+	def := section == "default"
+	sections := 0
+	newSection := func(name string) bool {
+		lower := strings.ToLower(name)
+		if section != lower && !all && !(def && lower != "commandstats") {
+			return false
+		}
+		if sections > 0 {
+			b.WriteString("\r\n")
+		}
+		sections++
+		b.WriteString("# " + name + "\r\n")
+		return true
+	}
+	info := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\r\n", args...)
+	}
+
+	if newSection("Server") {
+		uptime := time.Since(s.startTime)
+		port := 0
+		if addr, ok := s.Addr().(*net.TCPAddr); ok {
+			port = addr.Port
+		}
+		executable, _ := os.Executable()
+		info("redis_version:%s", REDIS_VERSION)
+		info("redis_git_sha1:00000000")
+		info("redis_git_dirty:0")
+		info("redis_build_id:0")
+		info("redis_mode:standalone")
+		info("os:%s %s", runtime.GOOS, runtime.GOARCH)
+		info("arch_bits:%d", 32<<(^uint(0)>>63))
+		info("multiplexing_api:goroutine")
+		info("atomicvar_api:sync-atomic")
+		info("gcc_version:0.0.0")
+		info("go_version:%s", runtime.Version())
+		info("process_id:%d", os.Getpid())
+		info("run_id:%s", s.ID)
+		info("tcp_port:%d", port)
+		info("uptime_in_seconds:%d", int64(uptime/time.Second))
+		info("uptime_in_days:%d", int64(uptime/(24*time.Hour)))
+		info("hz:%d", s.Hz)
+		info("configured_hz:%d", s.Hz)
+		info("lru_clock:%d", LRUClock())
+		info("executable:%s", executable)
+		info("config_file:")
+	}
+
+	if newSection("Clients") {
+		s.mu.Lock()
+		connected := len(s.clients)
+		maxInput, maxOutput := 0, 0
+		for _, c := range s.clients {
+			if n := c.querybuf.Size(); n > maxInput {
+				maxInput = n
+			}
+			if n := c.reply.w.Size(); n > maxOutput {
+				maxOutput = n
+			}
+		}
+		s.mu.Unlock()
+		info("connected_clients:%d", connected)
+		info("client_recent_max_input_buffer:%d", maxInput)
+		info("client_recent_max_output_buffer:%d", maxOutput)
+		info("blocked_clients:%d", s.blockedClients)
+	}
+
+	if newSection("Memory") {
+		s.updatePeakMemory()
+		used := s.usedMemory()
+		rss := processRSS()
+		mh := s.getMemoryOverheadData()
+		dataset := used - mh.total
+		if dataset < 0 {
+			dataset = 0
+		}
+		var peakPerc, datasetPerc float64
+		if s.stat.peakMemory > 0 {
+			peakPerc = float64(used) * 100 / float64(s.stat.peakMemory)
+		}
+		if net := used - mh.startup; net > 0 {
+			datasetPerc = float64(dataset) * 100 / float64(net)
+		}
+		var fragmentation float64
+		if used > 0 {
+			fragmentation = float64(rss) / float64(used)
+		}
+		info("used_memory:%d", used)
+		info("used_memory_human:%s", bytesToHuman(used))
+		info("used_memory_rss:%d", rss)
+		info("used_memory_rss_human:%s", bytesToHuman(rss))
+		info("used_memory_peak:%d", s.stat.peakMemory)
+		info("used_memory_peak_human:%s", bytesToHuman(s.stat.peakMemory))
+		info("used_memory_peak_perc:%.2f%%", peakPerc)
+		info("used_memory_overhead:%d", mh.total)
+		info("used_memory_startup:%d", mh.startup)
+		info("used_memory_dataset:%d", dataset)
+		info("used_memory_dataset_perc:%.2f%%", datasetPerc)
+		info("maxmemory:%d", s.MaxMemory)
+		info("maxmemory_human:%s", bytesToHuman(s.MaxMemory))
+		info("maxmemory_policy:%s", s.MaxMemoryPolicy)
+		info("mem_fragmentation_ratio:%.2f", fragmentation)
+		info("mem_fragmentation_bytes:%d", rss-used)
+		info("mem_allocator:go")
+	}
+
+	if newSection("Persistence") {
+		info("loading:0")
+		info("rdb_changes_since_last_save:%d", s.dirty)
+		info("rdb_bgsave_in_progress:0")
+		info("rdb_last_save_time:%d", s.startTime.Unix())
+		info("rdb_last_bgsave_status:ok")
+		info("rdb_last_bgsave_time_sec:-1")
+		info("rdb_current_bgsave_time_sec:-1")
+		info("rdb_last_cow_size:0")
+		info("aof_enabled:0")
+		info("aof_rewrite_in_progress:0")
+		info("aof_rewrite_scheduled:0")
+		info("aof_last_rewrite_time_sec:-1")
+		info("aof_current_rewrite_time_sec:-1")
+		info("aof_last_bgrewrite_status:ok")
+		info("aof_last_write_status:ok")
+		info("aof_last_cow_size:0")
+	}
+
+	if newSection("Stats") {
+		info("total_connections_received:%d", atomic.LoadInt64(&s.stat.numconnections))
+		info("total_commands_processed:%d", s.stat.numcommands)
+		info("instantaneous_ops_per_sec:%d", s.getInstantaneousMetric(STATS_METRIC_COMMAND))
+		info("total_net_input_bytes:%d", atomic.LoadInt64(&s.stat.netInputBytes))
+		info("total_net_output_bytes:%d", atomic.LoadInt64(&s.stat.netOutputBytes))
+		info("instantaneous_input_kbps:%.2f", float64(s.getInstantaneousMetric(STATS_METRIC_NET_INPUT))/1024)
+		info("instantaneous_output_kbps:%.2f", float64(s.getInstantaneousMetric(STATS_METRIC_NET_OUTPUT))/1024)
+		info("rejected_connections:0")
+		info("sync_full:0")
+		info("sync_partial_ok:0")
+		info("sync_partial_err:0")
+		info("expired_keys:%d", s.stat.expiredKeys)
+		info("expired_stale_perc:%.2f", s.stat.expiredStalePerc)
+		info("expired_time_cap_reached_count:%d", s.stat.expiredTimeCapReachedCount)
+		info("evicted_keys:%d", s.stat.evictedKeys)
+		info("keyspace_hits:%d", s.stat.keyspaceHits)
+		info("keyspace_misses:%d", s.stat.keyspaceMisses)
+		info("pubsub_channels:0")
+		info("pubsub_patterns:0")
+		info("latest_fork_usec:0")
+		info("migrate_cached_sockets:0")
+	}
+
+	if newSection("Replication") {
+		info("role:master")
+		info("connected_slaves:0")
+		info("master_replid:%s", s.replid)
+		info("master_replid2:%s", strings.Repeat("0", CONFIG_RUN_ID_SIZE))
+		info("master_repl_offset:0")
+		info("second_repl_offset:-1")
+		info("repl_backlog_active:0")
+		info("repl_backlog_size:1048576")
+		info("repl_backlog_first_byte_offset:0")
+		info("repl_backlog_histlen:0")
+	}
+
+	if newSection("CPU") {
+		sys, user, sysChildren, userChildren := cpuUsage()
+		info("used_cpu_sys:%.6f", sys)
+		info("used_cpu_user:%.6f", user)
+		info("used_cpu_sys_children:%.6f", sysChildren)
+		info("used_cpu_user_children:%.6f", userChildren)
+	}
+
+	if newSection("Commandstats") {
+		for _, cmd := range s.commandTable {
+			stat := s.stat.commands[cmd.Name]
+			if stat.calls == 0 {
+				continue
+			}
+			info("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f",
+				cmd.Name, stat.calls, stat.microseconds, float64(stat.microseconds)/float64(stat.calls))
+		}
+	}
+
+	if newSection("Cluster") {
+		info("cluster_enabled:0")
+	}
+
+	if newSection("Keyspace") {
+		for _, db := range s.db {
+			keys, vkeys := dbSize(db), DictSize(db.expires)
+			if keys == 0 && vkeys == 0 {
+				continue
+			}
+			info("db%d:keys=%d,expires=%d,avg_ttl=%d", db.ID, keys, vkeys, db.avgTTL)
+		}
+	}
+	return b.String()
+}
+
+// infoCommand INFO [section]
+func infoCommand(c *Client) {
+	section := "default"
+	if len(c.Argv) == 2 {
+		section = strings.ToLower(string(c.Argv[1]))
+	} else if len(c.Argv) > 2 {
+		c.addReplyError("syntax error")
+		return
+	}
+	c.reply.WriteBulkString(c.server.genRedisInfoString(section))
+}
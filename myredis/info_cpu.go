@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package myredis
+
+import (
+	"syscall"
+	"time"
+)
+
+// cpuUsage 进程以及子进程使用的用户态和内核态cpu时间 秒
+func cpuUsage() (sys, user, sysChildren, userChildren float64) {
+	var self, children syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &self)
+	syscall.Getrusage(syscall.RUSAGE_CHILDREN, &children)
+	return timevalSeconds(self.Stime), timevalSeconds(self.Utime),
+		timevalSeconds(children.Stime), timevalSeconds(children.Utime)
+}
+
+func timevalSeconds(tv syscall.Timeval) float64 {
+	return float64(time.Duration(tv.Nano())) / float64(time.Second)
+}
@@ -0,0 +1,6 @@
+package myredis
+
+// cpuUsage windows 没有getrusage，cpu时间返回0
+func cpuUsage() (sys, user, sysChildren, userChildren float64) {
+	return 0, 0, 0, 0
+}
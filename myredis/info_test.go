@@ -0,0 +1,110 @@
+package myredis
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// parseInfo 解析INFO的输出为 field -> value
+func parseInfo(t *testing.T, info string) map[string]string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(info, "\r\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			t.Fatalf("invalid info line %q", line)
+		}
+		fields[line[:i]] = line[i+1:]
+	}
+	return fields
+}
+
+func TestInfoSections(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	info := client.Info().Val()
+	for _, name := range []string{"Server", "Clients", "Memory", "Persistence", "Stats", "Replication", "CPU", "Keyspace"} {
+		if !strings.Contains(info, "# "+name+"\r\n") {
+			t.Fatalf("default info missing section %s", name)
+		}
+	}
+	if strings.Contains(info, "# Commandstats") {
+		t.Fatal("commandstats should not be in default info")
+	}
+	if !strings.Contains(client.Info("all").Val(), "# Commandstats") {
+		t.Fatal("all info missing commandstats")
+	}
+	if server := client.Info("SERVER").Val(); strings.Contains(server, "# Memory") || !strings.HasPrefix(server, "# Server\r\n") {
+		t.Fatalf("info server %q", server)
+	}
+	if client.Info("unknown").Val() != "" {
+		t.Fatal("unknown section should be empty")
+	}
+
+	fields := parseInfo(t, info)
+	_, port, _ := net.SplitHostPort(addr)
+	if fields["redis_mode"] != "standalone" || fields["role"] != "master" || fields["tcp_port"] != port {
+		t.Fatalf("info %v", fields)
+	}
+	if len(fields["run_id"]) != CONFIG_RUN_ID_SIZE || fields["connected_clients"] != "1" {
+		t.Fatalf("info %v", fields)
+	}
+	for _, name := range []string{"mem_fragmentation_ratio", "master_repl_offset", "expired_stale_perc", "expired_time_cap_reached_count", "used_cpu_user", "rdb_changes_since_last_save"} {
+		if _, ok := fields[name]; !ok {
+			t.Fatalf("info missing %s", name)
+		}
+	}
+}
+
+func TestInfoStats(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	client.Set("k1", "v", 0)
+	client.Set("k2", "v", time.Minute)
+	db1 := redis.NewClient(&redis.Options{Addr: addr, DB: 1})
+	defer db1.Close()
+	db1.Set("k3", "v", 0)
+	db1.Get("k3")
+	db1.Get("missing")
+
+	stats := parseInfo(t, client.Info("stats").Val())
+	if stats["keyspace_hits"] != "1" || stats["keyspace_misses"] != "1" {
+		t.Fatalf("keyspace hits %s misses %s", stats["keyspace_hits"], stats["keyspace_misses"])
+	}
+	if n, _ := strconv.Atoi(stats["total_commands_processed"]); n < 6 {
+		t.Fatalf("total_commands_processed %d", n)
+	}
+	if stats["total_connections_received"] != "2" {
+		t.Fatalf("total_connections_received %s", stats["total_connections_received"])
+	}
+	if n, _ := strconv.Atoi(stats["total_net_input_bytes"]); n == 0 {
+		t.Fatal("total_net_input_bytes")
+	}
+
+	keyspace := client.Info("keyspace").Val()
+	if !strings.Contains(keyspace, "db0:keys=2,expires=1,avg_ttl=") || !strings.Contains(keyspace, "db1:keys=1,expires=0,avg_ttl=0") {
+		t.Fatalf("keyspace %q", keyspace)
+	}
+	if strings.Contains(keyspace, "db2:") {
+		t.Fatal("empty db in keyspace")
+	}
+
+	cmdstats := parseInfo(t, client.Info("commandstats").Val())
+	if !strings.HasPrefix(cmdstats["cmdstat_set"], "calls=3,usec=") || !strings.Contains(cmdstats["cmdstat_get"], "usec_per_call=") {
+		t.Fatalf("commandstats %v", cmdstats)
+	}
+	if _, ok := cmdstats["cmdstat_del"]; ok {
+		t.Fatal("commands never called should not be listed")
+	}
+}
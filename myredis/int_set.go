@@ -1,6 +1,7 @@
 package myredis
 
 import (
+	"math"
 	"math/rand"
 	"unsafe"
 )
@@ -37,114 +38,101 @@ type Intset struct {
 	// cintents 只升级不降级，比如从INTSET_ENC_INT32变为INTSET_ENC_INT64之后，不会变回去
 	contents    []unsafe.Pointer // 保存元素的数组
 	contentsLen int
+	alloc       *allocator // 整数集合使用的分配器
 }
 
-func (intset *Intset) saveNumWithEncoding(encoding uint, numptr unsafe.Pointer) error {
-	if intset.Encoding < encoding {
-		// 内部的类型值 需要扩增 O(n)
-		for i := 0; i < intset.Length; i++ {
-			newPtr := intset.convertTypeWithEnconding(encoding, intset.contents[i])
-			intset.contents[i] = newPtr
-		}
+// intsetValueEncoding 保存num需要的最小编码
+func intsetValueEncoding(num int64) uint {
+	if num < math.MinInt32 || num > math.MaxInt32 {
+		return INTSET_ENC_INT64
 	}
-	if encoding < intset.Encoding {
-		encoding = intset.Encoding
+	if num < math.MinInt16 || num > math.MaxInt16 {
+		return INTSET_ENC_INT32
 	}
-	// 保存值时需要对值进行排序
-	ptr := intset.convertTypeWithEnconding(encoding, numptr)
-	if ptr == nil {
-		return &Error{Code: NOT_ALLOW_TYPE, MSG: "encoding must in [int8, int16, int32, int64]"}
+	if num < math.MinInt8 || num > math.MaxInt8 {
+		return INTSET_ENC_INT16
 	}
+	return INTSET_ENC_INT8
+}
 
-	if intset.contentsLen > intset.Length {
-		// 如果有多个未用空间需要直接存入对应的下标即可
-		intset.contents[intset.Length-1] = ptr
-	} else {
-		intset.contents = append(intset.contents, ptr)
-		intset.contentsLen++
+// readWithEncoding 按照编码读取指针指向的整数
+func readWithEncoding(encoding uint, numptr unsafe.Pointer) (int64, bool) {
+	switch encoding {
+	case INTSET_ENC_INT8:
+		return int64(*(*int8)(numptr)), true
+	case INTSET_ENC_INT16:
+		return int64(*(*int16)(numptr)), true
+	case INTSET_ENC_INT32:
+		return int64(*(*int32)(numptr)), true
+	case INTSET_ENC_INT64:
+		return *(*int64)(numptr), true
 	}
-	intset.Length++ // 长度增加
-	intset.Encoding = encoding
-	return nil
+	return 0, false
 }
 
-func (intset *Intset) convertTypeWithEnconding(encoding uint, numptr unsafe.Pointer) unsafe.Pointer {
+// convertTypeWithEnconding 按照编码保存整数，返回指向新值的指针
+func (intset *Intset) convertTypeWithEnconding(encoding uint, num int64) unsafe.Pointer {
 	var ptr unsafe.Pointer
 	switch encoding {
 	case INTSET_ENC_INT8:
-		var numInt8 int8
-		numInt8 = *(*int8)(numptr)
+		numInt8 := int8(num)
 		ptr = unsafe.Pointer(&numInt8)
 	case INTSET_ENC_INT16:
-		var numInt16 int16
-		numInt16 = *(*int16)(numptr)
+		numInt16 := int16(num)
 		ptr = unsafe.Pointer(&numInt16)
 	case INTSET_ENC_INT32:
-		var numInt32 int32
-		numInt32 = *(*int32)(numptr)
+		numInt32 := int32(num)
 		ptr = unsafe.Pointer(&numInt32)
 	case INTSET_ENC_INT64:
-		var numInt64 int64
-		numInt64 = *(*int64)(numptr)
+		numInt64 := num
 		ptr = unsafe.Pointer(&numInt64)
 	}
 	return ptr
 }
 
-// findValue 根据给定的值查找value
-func (intset *Intset) findValue(numptr unsafe.Pointer) (bool, int) {
+// get 读取index位置的元素
+func (intset *Intset) get(index int) int64 {
+	num, _ := readWithEncoding(intset.Encoding, intset.contents[index])
+	return num
+}
+
+// upgrade 把所有的元素升级为encoding编码 O(n)
+func (intset *Intset) upgrade(encoding uint) {
+	intset.alloc.zrealloc(intset.Length*int(intset.Encoding)/8, intset.Length*int(encoding)/8)
+	for i := 0; i < intset.Length; i++ {
+		intset.contents[i] = intset.convertTypeWithEnconding(encoding, intset.get(i))
+	}
+	intset.Encoding = encoding
+}
+
+// findValue 二分查找 O(logN)
+// 返回值是否存在，存在时返回所在的位置，不存在时返回应该插入的位置
+func (intset *Intset) findValue(num int64) (bool, int) {
 	start := 0
 	end := intset.Length - 1
-	// 先从中间开始比较
-	// 如果num1==num2直接返回
-	// 如果num1>num2, 并且前一个依然num3>num2，则在数组的左端查找
-	// 如果num1<num2, 并且后一个num3>num2, 直接返回，否则在数组后半段查找
-	for start < end {
+	for start <= end {
 		mid := (end + start) / 2
-		cutnNumPtr := intset.contents[mid]
-		curNum := *(*int64)(cutnNumPtr)
-		targetNum := *(*int64)(numptr)
-		if curNum == targetNum {
+		curNum := intset.get(mid)
+		if curNum == num {
 			return true, mid
 		}
-
-		if curNum < targetNum {
-			if mid == intset.Length-1 {
-				// 已经查找到最后一个元素，没有发现值
-				return false, mid
-			}
-			nextNum := *(*int64)(intset.contents[mid+1])
-			if nextNum > targetNum {
-				// 查找到插入的位置
-				return false, mid
-			}
-			end = mid + 1
-		}
-		if curNum > targetNum {
-			if mid == 0 {
-				// 已经查找到第一个元素，没有发现值
-				return false, mid
-			}
-			preNum := *(*int64)(intset.contents[mid-1])
-			if preNum < targetNum {
-				// 查找到插入的位置
-				return false, mid
-			}
-			start = mid - 1
+		if curNum < num {
+			start = mid + 1
+		} else {
+			end = mid - 1
 		}
 	}
-	return false, -1
+	return false, start
 }
 
-func (intset *Intset) moveWithIndex(index int) {
-	if intset.Length < 2 && index == intset.Length-1 {
-		return
-	}
-	for i := intset.Length - 1; i > index; i-- {
-		temp := intset.contents[i]
-		intset.contents[i] = intset.contents[i-1]
-		intset.contents[i-1] = temp
-	}
+// insertWithIndex 把后边的元素向后移动，在index位置插入 O(n)
+func (intset *Intset) insertWithIndex(index int, ptr unsafe.Pointer) {
+	intset.contents = append(intset.contents, nil)
+	copy(intset.contents[index+1:], intset.contents[index:intset.Length])
+	intset.contents[index] = ptr
+	intset.alloc.zmalloc(int(intset.Encoding) / 8)
+	intset.Length++
+	intset.contentsLen = len(intset.contents)
 }
 
 // 删除不支持降级操作
@@ -152,25 +140,28 @@ func (intset *Intset) deleteWithIndex(index int) error {
 	// 原版redis中已经升级的数值不进行降级
 	// 把后边的元素向前移动覆盖需要删除的元素
 	// 然后释放最后一个空间
-
-	// 在go中由于自身管理底层的连续空间，这里只把最后一个元素设置为nil
 	if index < 0 || index > intset.Length-1 {
 		return &Error{Code: OUT_RANGE, MSG: "index out arr len"}
 	}
-	for i := index; i < intset.Length-1; i++ {
-		intset.contents[i] = intset.contents[i+1]
-	}
+	copy(intset.contents[index:], intset.contents[index+1:intset.Length])
+	intset.alloc.zfree(int(intset.Encoding) / 8)
 	intset.Length--
-	if intset.contentsLen-intset.Length >= SET_MAX_VALUE {
+	intset.contents[intset.Length] = nil
+	intset.contents = intset.contents[:intset.Length]
+	if cap(intset.contents)-intset.Length >= SET_MAX_VALUE {
 		// 没有使用的空间过大时, 生成新的切片
-		intset.contents = intset.contents[: intset.Length-1 : intset.Length-1]
+		contents := make([]unsafe.Pointer, intset.Length)
+		copy(contents, intset.contents)
+		intset.contents = contents
 	}
 	intset.contentsLen = intset.Length
 	return nil
 }
 
-func IntSetNew() *Intset {
+func IntSetNew(alloc *allocator) *Intset {
+	alloc.zmalloc(INTSET_OVERHEAD)
 	return &Intset{
+		alloc:    alloc,
 		Encoding: INTSET_ENC_INT8,
 		Length:   0,
 		contents: []unsafe.Pointer{},
@@ -189,25 +180,28 @@ func IntSetAdd(intset *Intset, value unsafe.Pointer, enconding uint) error {
 		return &Error{Code: OBJ_PTR_NIL, MSG: "num ptr nil"}
 	}
 
+	num, ok := readWithEncoding(enconding, value)
+	if !ok {
+		return &Error{Code: NOT_ALLOW_TYPE, MSG: "encoding must in [int8, int16, int32, int64]"}
+	}
+
 	// 二分法查找类似O(logN)
-	isExist, index := intset.findValue(value)
+	isExist, index := intset.findValue(num)
 	if isExist {
 		return &Error{Code: VALUE_EXIST, MSG: "value exist"}
 	}
-	// 直接存储O(1)
-	if err := intset.saveNumWithEncoding(enconding, value); err != nil {
-		return err
-	}
-	if index > -1 {
-		// 需要根据index，把值交换到对应的位置，确保contens内部的值是排序的O(n)
-		intset.moveWithIndex(index)
+	// 新值需要的编码大于当前编码时升级 O(n)
+	if encoding := intsetValueEncoding(num); encoding > intset.Encoding {
+		intset.upgrade(encoding)
 	}
+	// 插入到index的位置，确保contens内部的值是排序的O(n)
+	intset.insertWithIndex(index, intset.convertTypeWithEnconding(intset.Encoding, num))
 	return nil
 }
 
 // IntsetRemove 在整数集合中删除
 // 如果value不存在，返回错误
-// 如果存在直接删除对应的value
+// 如果存在直接删除对应的value，value指向int64
 // T=O(logn+n)
 func IntsetRemove(intset *Intset, value unsafe.Pointer) error {
 	if intset == nil {
@@ -217,8 +211,8 @@ func IntsetRemove(intset *Intset, value unsafe.Pointer) error {
 	if value == nil {
 		return &Error{Code: OBJ_PTR_NIL, MSG: "num ptr nil"}
 	}
-	// 采用二分法变种查找 O(logn)
-	isExist, index := intset.findValue(value)
+	// 采用二分法查找 O(logn)
+	isExist, index := intset.findValue(*(*int64)(value))
 	if !isExist {
 		return &Error{Code: VALUE_NOT_FOUND, MSG: "value not exist"}
 	}
@@ -237,13 +231,18 @@ func IntsetFind(intset *Intset, value unsafe.Pointer, enconding uint) (bool, err
 		return false, &Error{Code: OBJ_PTR_NIL, MSG: "num ptr nil"}
 	}
 
-	// 如果编码大于当前的编码则一定不再整数集合中
-	if enconding > intset.Encoding {
+	num, ok := readWithEncoding(enconding, value)
+	if !ok {
+		return false, &Error{Code: NOT_ALLOW_TYPE, MSG: "encoding must in [int8, int16, int32, int64]"}
+	}
+
+	// 如果需要的编码大于当前的编码则一定不再整数集合中
+	if intsetValueEncoding(num) > intset.Encoding {
 		return false, nil
 	}
 
-	// 采用二分法的变种进行查找 T=O(logn)
-	isExist, _ := intset.findValue(value)
+	// 采用二分法进行查找 T=O(logn)
+	isExist, _ := intset.findValue(num)
 	return isExist, nil
 
 }
@@ -258,7 +257,7 @@ func IntsetRandom(intset *Intset) (num int64, err error) {
 		return
 	}
 	index := rand.Intn(intset.Length)
-	num = *(*int64)(intset.contents[index])
+	num = intset.get(index)
 	return
 }
 
@@ -275,7 +274,7 @@ func IntsetGet(intset *Intset, index int) (num int64, err error) {
 
 	}
 
-	num = *(*int64)(intset.contents[index])
+	num = intset.get(index)
 	return
 }
 
@@ -289,13 +288,21 @@ func IntsetLen(intset *Intset) (length int, err error) {
 	return
 }
 
-// IntsetBlobLen 返回集合占用的总字节数
+// IntsetBlobLen 返回集合占用的总字节数，encoding为每个元素的位数
 func IntsetBlobLen(intset *Intset) int {
 	if intset == nil {
 		return 0
 	}
-	setLen := int(unsafe.Sizeof(unsafe.Pointer(intset)))
-	contenLen := cap(intset.contents) * int(intset.Encoding)
-	size := setLen + contenLen
-	return size
+	return INTSET_OVERHEAD + intset.Length*int(intset.Encoding)/8
+}
+
+// IntsetFree 释放整数集合
+func IntsetFree(intset *Intset) {
+	if intset == nil {
+		return
+	}
+	intset.alloc.zfree(IntsetBlobLen(intset))
+	intset.contents = nil
+	intset.Length = 0
+	intset.contentsLen = 0
 }
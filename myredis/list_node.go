@@ -7,7 +7,8 @@ type ListNode struct {
 	value *SDS      // 存储的值
 }
 
-func NewNode(value *SDS) *ListNode {
+func NewNode(alloc *allocator, value *SDS) *ListNode {
+	alloc.zmalloc(LIST_NODE_OVERHEAD)
 	return &ListNode{value: value}
 }
 
@@ -32,6 +33,7 @@ type List struct {
 	dup   func(*ListNode) *ListNode         // 节点复制函数
 	free  func(*ListNode)                   // 节点释放函数
 	match func(node1, node2 *ListNode) bool // 节点比较函数
+	alloc *allocator                        // 链表和节点使用的分配器
 }
 
 // ListSetDupMethod 将给定的函数设置为链表的复制函数
@@ -104,13 +106,25 @@ func (l *List) ListNodeValue(node *ListNode) *SDS {
 }
 
 // ListCreate 创建一个不包含任何节点的新的链表
-func (l *List) ListCreate() *List {
-	return &List{}
+func (l *List) ListCreate(alloc *allocator) *List {
+	alloc.zmalloc(LIST_OVERHEAD)
+	return &List{alloc: alloc}
+}
+
+// freeNode 使用释放函数释放节点的值，然后释放节点
+func (l *List) freeNode(node *ListNode) {
+	if l.free != nil {
+		l.free(node)
+	}
+	node.prev = nil
+	node.next = nil
+	node.value = nil
+	l.alloc.zfree(LIST_NODE_OVERHEAD)
 }
 
 // 将一个包含给定值的新节点添加到表头
 func (l *List) ListAddNodeHead(value *SDS) {
-	node := NewNode(value)
+	node := NewNode(l.alloc, value)
 	l.listAddNodeHead(node)
 }
 
@@ -129,13 +143,14 @@ func (l *List) listAddNodeHead(node *ListNode) {
 
 // 将一个包含给定值的新节点添加到表尾
 func (l *List) ListAddNodeTail(value *SDS) {
-	node := NewNode(value)
+	node := NewNode(l.alloc, value)
 	if l.tail == nil {
 		l.head = node
 		l.tail = node
 	} else {
 		l.tail.next = node
 		node.prev = l.tail
+		l.tail = node
 	}
 	l.len++
 }
@@ -151,7 +166,7 @@ func (l *List) ListInsertNode(value *SDS, insertPosition *ListNode) {
 		return
 	}
 
-	node := NewNode(value)
+	node := NewNode(l.alloc, value)
 	insertPosition.prev.next = node
 	node.prev = insertPosition.prev
 	node.next = insertPosition
@@ -159,6 +174,24 @@ func (l *List) ListInsertNode(value *SDS, insertPosition *ListNode) {
 	l.len++
 }
 
+// ListInsertNodeAfter 将一个包含给定值的新节点添加到指定的节点后
+func (l *List) ListInsertNodeAfter(value *SDS, insertPosition *ListNode) {
+	if insertPosition == nil {
+		return
+	}
+
+	node := NewNode(l.alloc, value)
+	node.prev = insertPosition
+	node.next = insertPosition.next
+	if insertPosition == l.tail {
+		l.tail = node
+	} else {
+		insertPosition.next.prev = node
+	}
+	insertPosition.next = node
+	l.len++
+}
+
 // 查找并返回链表中包含给定值的节点
 func (l *List) ListSearchKey(key *SDS) *ListNode {
 	node := l.head
@@ -171,17 +204,23 @@ func (l *List) ListSearchKey(key *SDS) *ListNode {
 	return nil
 }
 
-// 返回链表在给定索引上的节点
-func (l *List) ListIndex(index uint) *ListNode {
-	node := l.head
-	var i uint
-	for i < l.len {
-		if i == index-1 {
-			return node
+// ListIndex 返回链表在给定索引上的节点
+// 正数索引从表头开始，0为头节点，负数索引从表尾开始，-1为尾节点，超出范围返回nil
+func (l *List) ListIndex(index int) *ListNode {
+	var node *ListNode
+	if index < 0 {
+		index = -index - 1
+		node = l.tail
+		for ; index > 0 && node != nil; index-- {
+			node = node.prev
+		}
+	} else {
+		node = l.head
+		for ; index > 0 && node != nil; index-- {
+			node = node.next
 		}
-		node = node.next
 	}
-	return nil
+	return node
 }
 
 // 从链表中删除给定节点
@@ -199,16 +238,25 @@ func (l *List) ListDelNode(node *ListNode) {
 	}
 
 	// 删除普通节点
-	node.prev = node.next
+	node.prev.next = node.next
 	node.next.prev = node.prev
 	l.len--
+	l.freeNode(node)
 }
 
 // ListDelHead 删除头节点
 func (l *List) ListDelHead() {
+	if node := l.unlinkHead(); node != nil {
+		l.freeNode(node)
+	}
+}
+
+// unlinkHead 从链表中摘除头节点，不释放节点
+func (l *List) unlinkHead() *ListNode {
 	if l.len <= 0 {
-		return
+		return nil
 	}
+	node := l.head
 	next := l.head.next
 	l.head = next
 	if next != nil {
@@ -217,27 +265,41 @@ func (l *List) ListDelHead() {
 		l.tail = nil
 	}
 	l.len--
+	return node
 }
 
 // ListDelTail 删除尾节点
 func (l *List) ListDelTail() {
+	if node := l.unlinkTail(); node != nil {
+		l.freeNode(node)
+	}
+}
+
+// unlinkTail 从链表中摘除尾节点，不释放节点
+func (l *List) unlinkTail() *ListNode {
 	if l.len <= 0 {
-		return
+		return nil
 	}
+	node := l.tail
 	prev := l.tail.prev
+	l.tail = prev
 	if prev != nil {
 		prev.next = nil
 	} else {
 		l.head = nil
 	}
 	l.len--
+	return node
 }
 
 // 将链表的尾节点弹出，然后将被弹出的节点放入链表的表头，成为新的头结点
 func (l *List) ListRotate() {
 	// 弹出尾节点
-	tail := l.tail
-	l.ListDelTail()
+	if l.len <= 1 {
+		return
+	}
+	tail := l.unlinkTail()
+	tail.prev = nil
 	// 把尾节点添加到头成为新的头节点
 	l.listAddNodeHead(tail)
 }
@@ -251,6 +313,7 @@ func (l *List) ListDup() *List {
 		dup:   l.dup,
 		free:  l.free,
 		match: l.match,
+		alloc: l.alloc,
 	}
 }
 
@@ -258,10 +321,11 @@ func (l *List) ListDup() *List {
 func (l *List) ListRelease() {
 	node := l.head
 	for node != nil {
-		node.prev = nil
-		node = node.next
+		next := node.next
+		l.freeNode(node)
+		node = next
 	}
-	node = nil
+	l.alloc.zfree(LIST_OVERHEAD)
 	l.head = nil
 	l.tail = nil
 	l.dup = nil
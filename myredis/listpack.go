@@ -0,0 +1,545 @@
+package myredis
+
+import (
+	"encoding/binary"
+)
+
+// 紧凑列表 listpack，用于替代压缩表
+// 压缩表的节点保存前一个节点的长度，前一个节点变长时prevlen可能从1字节扩展为5字节，导致连锁更新
+// 紧凑列表的节点只保存自身的长度(backlen)，修改一个节点不会影响其他的节点
+
+/*
+area        |<-- header -->|<---------------- entries ---------------->|<-end->|
+
+size          4 bytes 2 bytes    ?        ?        ?        ?          1 byte
+            +-------+-------+--------+--------+--------+--------+-----------+
+component   | total | num   | entry1 | entry2 |  ...   | entryN | 1111 1111 |
+            +-------+-------+--------+--------+--------+--------+-----------+
+
+total 整个紧凑列表的字节数 小端序
+num   节点的个数，等于LP_HDR_NUMELE_UNKNOWN时需要遍历计算
+
+节点的结构 <encoding-type><element-data><element-tot-len>
+
+encoding
+  |0xxxxxxx|                                        7位无符号整数 0-127
+  |10xxxxxx|                                        长度小于64的字符串
+  |110xxxxx|yyyyyyyy|                               13位有符号整数
+  |1110xxxx|yyyyyyyy|                               长度小于4096的字符串
+  |11110000|<4 bytes len>|                          32位长度的字符串
+  |11110001|                                        int16 之后2字节
+  |11110010|                                        24位有符号整数 之后3字节
+  |11110011|                                        int32 之后4字节
+  |11110100|                                        int64 之后8字节
+  |11111111|                                        结束标记
+
+element-tot-len 即backlen，encoding和element-data的总长度，占用1到5字节
+  每个字节的低7位保存长度，最高位为1表示左边还有字节，从右向左读取，用于从后向前遍历
+*/
+
+const (
+	LP_HDR_SIZE           = 6         // total num
+	LP_HDR_NUMELE_UNKNOWN = 1<<16 - 1 // 节点数超过uint16时需要遍历
+	LP_EOF                = 0xff      // 结束标记
+
+	LP_ENCODING_7BIT_UINT      = 0
+	LP_ENCODING_7BIT_UINT_MASK = 0x80
+	LP_ENCODING_6BIT_STR       = 0x80
+	LP_ENCODING_6BIT_STR_MASK  = 0xc0
+	LP_ENCODING_13BIT_INT      = 0xc0
+	LP_ENCODING_13BIT_INT_MASK = 0xe0
+	LP_ENCODING_12BIT_STR      = 0xe0
+	LP_ENCODING_12BIT_STR_MASK = 0xf0
+	LP_ENCODING_16BIT_INT      = 0xf1
+	LP_ENCODING_24BIT_INT      = 0xf2
+	LP_ENCODING_32BIT_INT      = 0xf3
+	LP_ENCODING_64BIT_INT      = 0xf4
+	LP_ENCODING_32BIT_STR      = 0xf0
+
+	LP_BEFORE  = 0 // 插入到p之前
+	LP_AFTER   = 1 // 插入到p之后
+	LP_REPLACE = 2 // 替换p
+)
+
+// Listpack 紧凑列表，所有的节点保存在一块连续的内存中
+// 节点的位置使用在buf中的偏移量表示，-1表示不存在
+type Listpack struct {
+	buf   []byte
+	alloc *allocator // 统计内存使用的分配器
+}
+
+func lpGetTotalBytes(buf []byte) int {
+	return int(binary.LittleEndian.Uint32(buf))
+}
+
+func lpSetTotalBytes(buf []byte, n int) {
+	binary.LittleEndian.PutUint32(buf, uint32(n))
+}
+
+func lpGetNumElements(buf []byte) int {
+	return int(binary.LittleEndian.Uint16(buf[4:]))
+}
+
+func lpSetNumElements(buf []byte, n int) {
+	if n > LP_HDR_NUMELE_UNKNOWN {
+		n = LP_HDR_NUMELE_UNKNOWN
+	}
+	binary.LittleEndian.PutUint16(buf[4:], uint16(n))
+}
+
+// lpEncodeInt 把整数编码到buf，返回编码之后的字节数，buf为nil时只计算字节数
+func lpEncodeInt(buf []byte, v int64) int {
+	switch {
+	case v >= 0 && v <= 127:
+		if buf != nil {
+			buf[0] = byte(v)
+		}
+		return 1
+	case v >= -4096 && v <= 4095:
+		if buf != nil {
+			u := uint16(v) & 0x1fff
+			buf[0] = byte(u>>8) | LP_ENCODING_13BIT_INT
+			buf[1] = byte(u)
+		}
+		return 2
+	case v >= -32768 && v <= 32767:
+		if buf != nil {
+			buf[0] = LP_ENCODING_16BIT_INT
+			binary.LittleEndian.PutUint16(buf[1:], uint16(v))
+		}
+		return 3
+	case v >= INT24_MIN && v <= INT24_MAX:
+		if buf != nil {
+			buf[0] = LP_ENCODING_24BIT_INT
+			buf[1], buf[2], buf[3] = byte(v), byte(v>>8), byte(v>>16)
+		}
+		return 4
+	case v >= -2147483648 && v <= 2147483647:
+		if buf != nil {
+			buf[0] = LP_ENCODING_32BIT_INT
+			binary.LittleEndian.PutUint32(buf[1:], uint32(v))
+		}
+		return 5
+	}
+	if buf != nil {
+		buf[0] = LP_ENCODING_64BIT_INT
+		binary.LittleEndian.PutUint64(buf[1:], uint64(v))
+	}
+	return 9
+}
+
+// lpEncodeString 把字符串编码到buf，返回编码之后的字节数，buf为nil时只计算字节数
+func lpEncodeString(buf []byte, s []byte) int {
+	n := len(s)
+	switch {
+	case n < 64:
+		if buf != nil {
+			buf[0] = LP_ENCODING_6BIT_STR | byte(n)
+			copy(buf[1:], s)
+		}
+		return 1 + n
+	case n < 4096:
+		if buf != nil {
+			buf[0] = LP_ENCODING_12BIT_STR | byte(n>>8)
+			buf[1] = byte(n)
+			copy(buf[2:], s)
+		}
+		return 2 + n
+	}
+	if buf != nil {
+		buf[0] = LP_ENCODING_32BIT_STR
+		binary.LittleEndian.PutUint32(buf[1:], uint32(n))
+		copy(buf[5:], s)
+	}
+	return 5 + n
+}
+
+// lpEncode 把元素编码到buf，可以表示为整数的元素使用整数编码
+func lpEncode(buf []byte, ele []byte) int {
+	if v, ok := stringObjectInt64(ele); ok {
+		return lpEncodeInt(buf, v)
+	}
+	return lpEncodeString(buf, ele)
+}
+
+// lpEncodeBacklen 把节点的长度l编码为backlen写入buf，返回占用的字节数，buf为nil时只计算字节数
+func lpEncodeBacklen(buf []byte, l int) int {
+	size := 5
+	switch {
+	case l <= 127:
+		size = 1
+	case l < 16383:
+		size = 2
+	case l < 2097151:
+		size = 3
+	case l < 268435455:
+		size = 4
+	}
+	if buf != nil {
+		// 最左边的字节保存最高位，右边的字节最高位为1表示左边还有字节
+		for i := 0; i < size; i++ {
+			b := byte(l >> (7 * uint(size-1-i)) & 127)
+			if i > 0 {
+				b |= 128
+			}
+			buf[i] = b
+		}
+	}
+	return size
+}
+
+// lpDecodeBacklen 从p向左读取backlen，p为backlen的最后一个字节
+// backlen 超过5字节或者越过了头部时返回-1
+func lpDecodeBacklen(buf []byte, p int) int {
+	val, shift := 0, uint(0)
+	for {
+		if p < LP_HDR_SIZE || shift > 28 {
+			return -1
+		}
+		val |= int(buf[p]&127) << shift
+		if buf[p]&128 == 0 {
+			return val
+		}
+		shift += 7
+		p--
+	}
+}
+
+// lpCurrentEncodedSizeBytes 读取节点长度需要的字节数，无效的编码返回0
+func lpCurrentEncodedSizeBytes(encoding byte) int {
+	switch {
+	case encoding&LP_ENCODING_7BIT_UINT_MASK == LP_ENCODING_7BIT_UINT,
+		encoding&LP_ENCODING_6BIT_STR_MASK == LP_ENCODING_6BIT_STR,
+		encoding&LP_ENCODING_13BIT_INT_MASK == LP_ENCODING_13BIT_INT:
+		return 1
+	case encoding&LP_ENCODING_12BIT_STR_MASK == LP_ENCODING_12BIT_STR:
+		return 2
+	case encoding == LP_ENCODING_32BIT_STR:
+		return 5
+	case encoding >= LP_ENCODING_16BIT_INT && encoding <= LP_ENCODING_64BIT_INT, encoding == LP_EOF:
+		return 1
+	}
+	return 0
+}
+
+// lpCurrentEncodedSize p处节点encoding和element-data的长度，不包括backlen
+// 调用方保证编码有效
+func lpCurrentEncodedSize(p []byte) int {
+	switch {
+	case p[0]&LP_ENCODING_7BIT_UINT_MASK == LP_ENCODING_7BIT_UINT:
+		return 1
+	case p[0]&LP_ENCODING_6BIT_STR_MASK == LP_ENCODING_6BIT_STR:
+		return 1 + int(p[0]&0x3f)
+	case p[0]&LP_ENCODING_13BIT_INT_MASK == LP_ENCODING_13BIT_INT:
+		return 2
+	case p[0]&LP_ENCODING_12BIT_STR_MASK == LP_ENCODING_12BIT_STR:
+		return 2 + (int(p[0]&0x0f)<<8 | int(p[1]))
+	}
+	switch p[0] {
+	case LP_ENCODING_16BIT_INT:
+		return 3
+	case LP_ENCODING_24BIT_INT:
+		return 4
+	case LP_ENCODING_32BIT_INT:
+		return 5
+	case LP_ENCODING_64BIT_INT:
+		return 9
+	case LP_ENCODING_32BIT_STR:
+		return 5 + int(binary.LittleEndian.Uint32(p[1:]))
+	case LP_EOF:
+		return 1
+	}
+	panic("myredis: invalid listpack encoding")
+}
+
+// lpSkip 跳过p处的节点，返回下一个节点或者结束标记的偏移量
+func lpSkip(buf []byte, p int) int {
+	entrylen := lpCurrentEncodedSize(buf[p:])
+	return p + entrylen + lpEncodeBacklen(nil, entrylen)
+}
+
+// signExtend 把bits位的无符号数转换为有符号数
+func signExtend(u uint64, bits uint) int64 {
+	return int64(u<<(64-bits)) >> (64 - bits)
+}
+
+// LpNew 创建一个空的紧凑列表
+func LpNew(alloc *allocator) *Listpack {
+	buf := make([]byte, LP_HDR_SIZE+1)
+	lpSetTotalBytes(buf, LP_HDR_SIZE+1)
+	lpSetNumElements(buf, 0)
+	buf[LP_HDR_SIZE] = LP_EOF
+	alloc.zmalloc(len(buf))
+	return &Listpack{buf: buf, alloc: alloc}
+}
+
+// LpFree 释放紧凑列表占用的内存
+func LpFree(lp *Listpack) {
+	lp.alloc.zfree(len(lp.buf))
+	lp.buf = nil
+}
+
+// lpResize 修改紧凑列表的大小，同realloc
+func (lp *Listpack) resize(length int) {
+	lp.alloc.zrealloc(len(lp.buf), length)
+	if length <= cap(lp.buf) {
+		lp.buf = lp.buf[:length]
+	} else {
+		lp.buf = append(lp.buf, make([]byte, length-len(lp.buf))...)
+	}
+	lpSetTotalBytes(lp.buf, length)
+}
+
+// LpInsert 在p处插入、替换或者删除元素，返回新元素的位置
+// where为LP_BEFORE、LP_AFTER插入到p之前或者之后，LP_REPLACE替换p
+// ele为nil时删除p，返回被删除元素的下一个元素，没有下一个元素时返回-1
+// 只修改p处的节点，不会像压缩表一样连锁更新
+func LpInsert(lp *Listpack, ele []byte, p int, where int) int {
+	if ele == nil {
+		where = LP_REPLACE
+	}
+	if where == LP_AFTER {
+		p = lpSkip(lp.buf, p)
+		where = LP_BEFORE
+	}
+
+	enclen, backlenSize := 0, 0
+	if ele != nil {
+		enclen = lpEncode(nil, ele)
+		backlenSize = lpEncodeBacklen(nil, enclen)
+	}
+	oldBytes := lpGetTotalBytes(lp.buf)
+	replacedLen := 0
+	if where == LP_REPLACE {
+		replacedLen = lpCurrentEncodedSize(lp.buf[p:])
+		replacedLen += lpEncodeBacklen(nil, replacedLen)
+	}
+	newBytes := oldBytes + enclen + backlenSize - replacedLen
+
+	// 扩大时先分配空间再移动之后的节点，缩小时先移动再释放空间
+	if newBytes > oldBytes {
+		lp.resize(newBytes)
+	}
+	if where == LP_BEFORE {
+		copy(lp.buf[p+enclen+backlenSize:], lp.buf[p:oldBytes])
+	} else {
+		copy(lp.buf[p+enclen+backlenSize:], lp.buf[p+replacedLen:oldBytes])
+	}
+	if newBytes < oldBytes {
+		lp.resize(newBytes)
+	}
+
+	if ele != nil {
+		lpEncode(lp.buf[p:], ele)
+		lpEncodeBacklen(lp.buf[p+enclen:], enclen)
+	}
+
+	if num := lpGetNumElements(lp.buf); num != LP_HDR_NUMELE_UNKNOWN {
+		if where == LP_BEFORE {
+			lpSetNumElements(lp.buf, num+1)
+		} else if ele == nil {
+			lpSetNumElements(lp.buf, num-1)
+		}
+	}
+
+	if ele == nil && lp.buf[p] == LP_EOF {
+		return -1
+	}
+	return p
+}
+
+// LpAppend 插入到表尾，返回新元素的位置
+func LpAppend(lp *Listpack, ele []byte) int {
+	return LpInsert(lp, ele, lpGetTotalBytes(lp.buf)-1, LP_BEFORE)
+}
+
+// LpPrepend 插入到表头，返回新元素的位置
+func LpPrepend(lp *Listpack, ele []byte) int {
+	return LpInsert(lp, ele, LP_HDR_SIZE, LP_BEFORE)
+}
+
+// LpReplace 把p处的元素替换为ele，返回新元素的位置
+func LpReplace(lp *Listpack, p int, ele []byte) int {
+	return LpInsert(lp, ele, p, LP_REPLACE)
+}
+
+// LpDelete 删除p处的元素，返回下一个元素的位置，没有时返回-1
+// 下一个元素移动到了p，可以在迭代的过程中删除
+func LpDelete(lp *Listpack, p int) int {
+	return LpInsert(lp, nil, p, LP_REPLACE)
+}
+
+// LpDeleteRange 从index开始连续删除num个元素，返回删除的个数
+func LpDeleteRange(lp *Listpack, index, num int) int {
+	p := LpSeek(lp, index)
+	if p < 0 || num <= 0 {
+		return 0
+	}
+	start, deleted := p, 0
+	for ; deleted < num && lp.buf[p] != LP_EOF; deleted++ {
+		p = lpSkip(lp.buf, p)
+	}
+	oldBytes := lpGetTotalBytes(lp.buf)
+	copy(lp.buf[start:], lp.buf[p:oldBytes])
+	lp.resize(oldBytes - (p - start))
+	if n := lpGetNumElements(lp.buf); n != LP_HDR_NUMELE_UNKNOWN {
+		lpSetNumElements(lp.buf, n-deleted)
+	}
+	return deleted
+}
+
+// LpFirst 第一个元素的位置，空列表返回-1
+func LpFirst(lp *Listpack) int {
+	if lp.buf[LP_HDR_SIZE] == LP_EOF {
+		return -1
+	}
+	return LP_HDR_SIZE
+}
+
+// LpLast 最后一个元素的位置，空列表返回-1
+func LpLast(lp *Listpack) int {
+	return LpPrev(lp, lpGetTotalBytes(lp.buf)-1)
+}
+
+// LpNext p的下一个元素，p为最后一个元素时返回-1
+func LpNext(lp *Listpack, p int) int {
+	p = lpSkip(lp.buf, p)
+	if lp.buf[p] == LP_EOF {
+		return -1
+	}
+	return p
+}
+
+// LpPrev p的前一个元素，通过p之前的backlen计算前一个元素的长度
+// p为结束标记时返回最后一个元素，p为第一个元素时返回-1
+func LpPrev(lp *Listpack, p int) int {
+	if p == LP_HDR_SIZE {
+		return -1
+	}
+	p--
+	prevlen := lpDecodeBacklen(lp.buf, p)
+	prevlen += lpEncodeBacklen(nil, prevlen)
+	return p - prevlen + 1
+}
+
+// LpGet 取出p处的元素
+// 字符串返回sval，和紧凑列表共享内存，修改之后失效；整数sval为nil，返回lval
+func LpGet(lp *Listpack, p int) (sval []byte, lval int64, ok bool) {
+	if p < 0 || lp.buf[p] == LP_EOF {
+		return nil, 0, false
+	}
+	b := lp.buf[p:]
+	switch {
+	case b[0]&LP_ENCODING_7BIT_UINT_MASK == LP_ENCODING_7BIT_UINT:
+		return nil, int64(b[0] & 0x7f), true
+	case b[0]&LP_ENCODING_6BIT_STR_MASK == LP_ENCODING_6BIT_STR:
+		n := int(b[0] & 0x3f)
+		return b[1 : 1+n : 1+n], 0, true
+	case b[0]&LP_ENCODING_13BIT_INT_MASK == LP_ENCODING_13BIT_INT:
+		return nil, signExtend(uint64(b[0]&0x1f)<<8|uint64(b[1]), 13), true
+	case b[0]&LP_ENCODING_12BIT_STR_MASK == LP_ENCODING_12BIT_STR:
+		n := int(b[0]&0x0f)<<8 | int(b[1])
+		return b[2 : 2+n : 2+n], 0, true
+	}
+	switch b[0] {
+	case LP_ENCODING_16BIT_INT:
+		return nil, int64(int16(binary.LittleEndian.Uint16(b[1:]))), true
+	case LP_ENCODING_24BIT_INT:
+		return nil, signExtend(uint64(b[1])|uint64(b[2])<<8|uint64(b[3])<<16, 24), true
+	case LP_ENCODING_32BIT_INT:
+		return nil, int64(int32(binary.LittleEndian.Uint32(b[1:]))), true
+	case LP_ENCODING_64BIT_INT:
+		return nil, int64(binary.LittleEndian.Uint64(b[1:])), true
+	case LP_ENCODING_32BIT_STR:
+		n := int(binary.LittleEndian.Uint32(b[1:]))
+		return b[5 : 5+n : 5+n], 0, true
+	}
+	panic("myredis: invalid listpack encoding")
+}
+
+// LpLength 元素的个数，头部的个数未知时遍历计算
+func LpLength(lp *Listpack) int {
+	if n := lpGetNumElements(lp.buf); n != LP_HDR_NUMELE_UNKNOWN {
+		return n
+	}
+	n := 0
+	for p := LpFirst(lp); p != -1; p = LpNext(lp, p) {
+		n++
+	}
+	if n < LP_HDR_NUMELE_UNKNOWN {
+		lpSetNumElements(lp.buf, n)
+	}
+	return n
+}
+
+// LpBytes 紧凑列表占用的字节数
+func LpBytes(lp *Listpack) int {
+	return lpGetTotalBytes(lp.buf)
+}
+
+// LpSeek 返回索引为index的元素，负数从表尾开始，超出范围返回-1
+// 元素个数已知时从较近的一端开始遍历
+func LpSeek(lp *Listpack, index int) int {
+	forward := index >= 0
+	if numele := lpGetNumElements(lp.buf); numele != LP_HDR_NUMELE_UNKNOWN {
+		if index < 0 {
+			index += numele
+		}
+		if index < 0 || index >= numele {
+			return -1
+		}
+		forward = index <= numele/2
+		if !forward {
+			index -= numele
+		}
+	}
+	if forward {
+		p := LpFirst(lp)
+		for ; index > 0 && p != -1; index-- {
+			p = LpNext(lp, p)
+		}
+		return p
+	}
+	p := LpLast(lp)
+	for ; index < -1 && p != -1; index++ {
+		p = LpPrev(lp, p)
+	}
+	return p
+}
+
+// LpValidateIntegrity 检查buf是否为有效的紧凑列表，用于校验从外部读取的数据
+// deep为false时只检查头部和结束标记，为true时检查每个节点的编码和backlen
+func LpValidateIntegrity(buf []byte, deep bool) bool {
+	if len(buf) < LP_HDR_SIZE+1 {
+		return false
+	}
+	if lpGetTotalBytes(buf) != len(buf) || buf[len(buf)-1] != LP_EOF {
+		return false
+	}
+	if !deep {
+		return true
+	}
+
+	count, p, end := 0, LP_HDR_SIZE, len(buf)-1
+	for p < end {
+		lenbytes := lpCurrentEncodedSizeBytes(buf[p])
+		if lenbytes == 0 || buf[p] == LP_EOF || p+lenbytes > end {
+			return false
+		}
+		entrylen := lpCurrentEncodedSize(buf[p:])
+		backlenSize := lpEncodeBacklen(nil, entrylen)
+		if entrylen < lenbytes || p+entrylen+backlenSize > end {
+			return false
+		}
+		if lpDecodeBacklen(buf, p+entrylen+backlenSize-1) != entrylen {
+			return false
+		}
+		p += entrylen + backlenSize
+		count++
+	}
+	if p != end {
+		return false
+	}
+	numele := lpGetNumElements(buf)
+	return numele == LP_HDR_NUMELE_UNKNOWN || numele == count
+}
@@ -0,0 +1,290 @@
+package myredis
+
+import (
+	"bytes"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lpValues 校验紧凑列表并返回所有的元素，同时检查从后向前遍历的结果一致
+func lpValues(t *testing.T, lp *Listpack) []string {
+	t.Helper()
+	if !LpValidateIntegrity(lp.buf, true) {
+		t.Fatalf("invalid listpack % x", lp.buf)
+	}
+	get := func(p int) string {
+		sval, lval, _ := LpGet(lp, p)
+		if sval == nil {
+			return strconv.FormatInt(lval, 10)
+		}
+		return string(sval)
+	}
+	var values []string
+	for p := LpFirst(lp); p != -1; p = LpNext(lp, p) {
+		values = append(values, get(p))
+	}
+	i := len(values) - 1
+	for p := LpLast(lp); p != -1; p = LpPrev(lp, p) {
+		if i < 0 || get(p) != values[i] {
+			t.Fatalf("backward iteration mismatch at %d", i)
+		}
+		i--
+	}
+	if i != -1 || LpLength(lp) != len(values) {
+		t.Fatalf("length %d, iterated %d", LpLength(lp), len(values))
+	}
+	return values
+}
+
+func TestListpackNew(t *testing.T) {
+	lp := LpNew(nil)
+	if !bytes.Equal(lp.buf, []byte{7, 0, 0, 0, 0, 0, 0xff}) {
+		t.Fatalf("empty listpack % x", lp.buf)
+	}
+	if LpFirst(lp) != -1 || LpLast(lp) != -1 || LpSeek(lp, 0) != -1 || LpSeek(lp, -1) != -1 {
+		t.Fatal("empty listpack has no entries")
+	}
+	LpFree(lp)
+}
+
+func TestListpackEncoding(t *testing.T) {
+	long := strings.Repeat("a", 200)
+	cases := []struct {
+		value string
+		entry []byte // encoding element-data backlen
+	}{
+		{"0", []byte{0x00, 0x01}},
+		{"127", []byte{0x7f, 0x01}},
+		{"128", []byte{0xc0, 0x80, 0x02}},
+		{"-1", []byte{0xdf, 0xff, 0x02}},
+		{"-4096", []byte{0xd0, 0x00, 0x02}},
+		{"4096", []byte{LP_ENCODING_16BIT_INT, 0x00, 0x10, 0x03}},
+		{"-32769", []byte{LP_ENCODING_24BIT_INT, 0xff, 0x7f, 0xff, 0x04}},
+		{"8388608", []byte{LP_ENCODING_32BIT_INT, 0x00, 0x00, 0x80, 0x00, 0x05}},
+		{"-9223372036854775808", []byte{LP_ENCODING_64BIT_INT, 0, 0, 0, 0, 0, 0, 0, 0x80, 0x09}},
+		{"", []byte{0x80, 0x01}},
+		{"007", []byte{0x83, '0', '0', '7', 0x04}},
+		{long, append(append([]byte{0xe0, 200}, long...), 0x01, 0xca)},
+	}
+	for _, c := range cases {
+		lp := LpNew(nil)
+		LpAppend(lp, []byte(c.value))
+		if entry := lp.buf[LP_HDR_SIZE : len(lp.buf)-1]; !bytes.Equal(entry, c.entry) {
+			t.Fatalf("%.20q entry % x", c.value, entry)
+		}
+		if values := lpValues(t, lp); values[0] != c.value {
+			t.Fatalf("%.20q got %.20q", c.value, values[0])
+		}
+		LpFree(lp)
+	}
+
+	// 32位长度的字符串以及多字节的backlen
+	lp := LpNew(nil)
+	huge := strings.Repeat("h", 20000)
+	LpAppend(lp, []byte(huge))
+	LpAppend(lp, []byte("tail"))
+	if lp.buf[LP_HDR_SIZE] != LP_ENCODING_32BIT_STR || lpEncodeBacklen(nil, 20005) != 3 {
+		t.Fatalf("encoding %x", lp.buf[LP_HDR_SIZE])
+	}
+	if values := lpValues(t, lp); values[0] != huge || values[1] != "tail" {
+		t.Fatal("huge string")
+	}
+	LpFree(lp)
+}
+
+func TestListpackInsertDeleteSeek(t *testing.T) {
+	lp := LpNew(nil)
+	for _, v := range []string{"b", "d", "100000"} {
+		LpAppend(lp, []byte(v))
+	}
+	LpPrepend(lp, []byte("a"))
+	p := LpInsert(lp, []byte("c"), LpSeek(lp, 1), LP_AFTER)
+	if sval, _, _ := LpGet(lp, p); string(sval) != "c" {
+		t.Fatalf("inserted %q", sval)
+	}
+	if got := strings.Join(lpValues(t, lp), ","); got != "a,b,c,d,100000" {
+		t.Fatalf("values %s", got)
+	}
+
+	for i, want := range []string{"a", "b", "c", "d", "100000"} {
+		if LpSeek(lp, i) != LpSeek(lp, i-5) {
+			t.Fatalf("seek %d", i)
+		}
+		if sval, lval, _ := LpGet(lp, LpSeek(lp, i)); string(sval) != want && strconv.FormatInt(lval, 10) != want {
+			t.Fatalf("seek %d got %q %d", i, sval, lval)
+		}
+	}
+	if LpSeek(lp, 5) != -1 || LpSeek(lp, -6) != -1 {
+		t.Fatal("seek out of range")
+	}
+
+	LpReplace(lp, LpSeek(lp, 0), []byte(strings.Repeat("x", 100)))
+	LpReplace(lp, LpSeek(lp, -1), []byte("7"))
+	if got := strings.Join(lpValues(t, lp), ","); got != strings.Repeat("x", 100)+",b,c,d,7" {
+		t.Fatalf("values after replace %.30s", got)
+	}
+
+	// 迭代过程中删除，删除之后返回下一个元素
+	for p := LpFirst(lp); p != -1; {
+		if sval, _, _ := LpGet(lp, p); string(sval) == "b" || string(sval) == "d" {
+			p = LpDelete(lp, p)
+		} else {
+			p = LpNext(lp, p)
+		}
+	}
+	if got := strings.Join(lpValues(t, lp), ","); got != strings.Repeat("x", 100)+",c,7" {
+		t.Fatalf("values after delete %.30s", got)
+	}
+	if LpDelete(lp, LpLast(lp)) != -1 {
+		t.Fatal("delete last should return -1")
+	}
+	if n := LpDeleteRange(lp, -2, 10); n != 2 || LpLength(lp) != 0 || LpBytes(lp) != LP_HDR_SIZE+1 {
+		t.Fatalf("delete range %d", n)
+	}
+	LpFree(lp)
+}
+
+func TestListpackRandom(t *testing.T) {
+	base := usedMemory()
+	rnd := rand.New(rand.NewSource(1))
+	randValue := func() string {
+		switch rnd.Intn(4) {
+		case 0:
+			return strconv.FormatInt(rnd.Int63n(1<<40)-1<<39, 10)
+		case 1:
+			return strconv.Itoa(rnd.Intn(10000) - 5000)
+		case 2:
+			return strings.Repeat("s", rnd.Intn(70))
+		}
+		return strings.Repeat("l", 100+rnd.Intn(5000))
+	}
+
+	lp := LpNew(nil)
+	var want []string
+	for i := 0; i < 2000; i++ {
+		switch op := rnd.Intn(5); {
+		case op <= 1 || len(want) == 0:
+			v, index := randValue(), rnd.Intn(len(want)+1)
+			if index == len(want) {
+				LpAppend(lp, []byte(v))
+			} else {
+				LpInsert(lp, []byte(v), LpSeek(lp, index), LP_BEFORE)
+			}
+			want = append(want[:index], append([]string{v}, want[index:]...)...)
+		case op == 2:
+			index := rnd.Intn(len(want))
+			LpDelete(lp, LpSeek(lp, index))
+			want = append(want[:index], want[index+1:]...)
+		case op == 3:
+			index, num := rnd.Intn(len(want)), 1+rnd.Intn(3)
+			LpDeleteRange(lp, index, num)
+			if index+num > len(want) {
+				num = len(want) - index
+			}
+			want = append(want[:index], want[index+num:]...)
+		default:
+			v, index := randValue(), rnd.Intn(len(want))
+			LpReplace(lp, LpSeek(lp, index), []byte(v))
+			want[index] = v
+		}
+		if got := lpValues(t, lp); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("op %d: got %d values, want %d", i, len(got), len(want))
+		}
+	}
+	if used := usedMemory() - base; used != LpBytes(lp) {
+		t.Fatalf("used %d, listpack %d bytes", used, LpBytes(lp))
+	}
+	LpFree(lp)
+	if used := usedMemory(); used != base {
+		t.Fatalf("leaked %d bytes", used-base)
+	}
+}
+
+func TestListpackUnknownLength(t *testing.T) {
+	lp := LpNew(nil)
+	for i := 0; i < 10; i++ {
+		LpAppend(lp, []byte(strconv.Itoa(i)))
+	}
+	lpSetNumElements(lp.buf, LP_HDR_NUMELE_UNKNOWN)
+	if LpSeek(lp, -1) != LpLast(lp) || LpSeek(lp, 3) != LpNext(lp, LpNext(lp, LpNext(lp, LpFirst(lp)))) {
+		t.Fatal("seek with unknown length")
+	}
+	if LpLength(lp) != 10 || lpGetNumElements(lp.buf) != 10 {
+		t.Fatalf("length %d", LpLength(lp))
+	}
+	LpFree(lp)
+}
+
+func TestListpackValidateIntegrity(t *testing.T) {
+	lp := LpNew(nil)
+	for _, v := range []string{"1", "hello", strings.Repeat("x", 300), "-70000"} {
+		LpAppend(lp, []byte(v))
+	}
+	valid := append([]byte(nil), lp.buf...)
+	LpFree(lp)
+	if !LpValidateIntegrity(valid, true) {
+		t.Fatal("valid listpack")
+	}
+
+	corrupt := func(name string, f func(b []byte) []byte) {
+		b := f(append([]byte(nil), valid...))
+		if LpValidateIntegrity(b, true) {
+			t.Fatalf("%s should be invalid", name)
+		}
+	}
+	corrupt("too short", func(b []byte) []byte { return b[:LP_HDR_SIZE] })
+	corrupt("truncated", func(b []byte) []byte { return b[:len(b)-3] })
+	corrupt("total bytes", func(b []byte) []byte { lpSetTotalBytes(b, len(b)+1); return b })
+	corrupt("missing eof", func(b []byte) []byte { b[len(b)-1] = 0; return b })
+	corrupt("element count", func(b []byte) []byte { lpSetNumElements(b, 3); return b })
+	corrupt("invalid encoding", func(b []byte) []byte { b[LP_HDR_SIZE] = 0xf5; return b })
+	corrupt("backlen", func(b []byte) []byte { b[LP_HDR_SIZE+1] = 3; return b })
+	corrupt("string length", func(b []byte) []byte { b[LP_HDR_SIZE+2] = 0xbf; return b })
+	corrupt("eof inside entries", func(b []byte) []byte { b[LP_HDR_SIZE] = LP_EOF; return b })
+
+	// 不检查节点时只检查头部
+	b := append([]byte(nil), valid...)
+	b[LP_HDR_SIZE] = 0xf5
+	if !LpValidateIntegrity(b, false) {
+		t.Fatal("shallow validation only checks the header")
+	}
+}
+
+// BenchmarkCascadeInsert 所有节点的长度都在250到253字节之间时在表头插入大节点
+// 压缩表需要扩展之后所有节点的prevlen，紧凑列表只移动内存
+func BenchmarkCascadeInsert(b *testing.B) {
+	const entries = 500
+	value := []byte(strings.Repeat("v", 250))
+	big := []byte(strings.Repeat("b", 300))
+
+	b.Run("ziplist", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			zl := ZiplistNew(nil)
+			for j := 0; j < entries; j++ {
+				zl = ZiplistPush(nil, zl, value, ZIPLIST_TAIL)
+			}
+			b.StartTimer()
+			zl = ZiplistPush(nil, zl, big, ZIPLIST_HEAD)
+			b.StopTimer()
+			ZiplistFree(nil, zl)
+			b.StartTimer()
+		}
+	})
+	b.Run("listpack", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			lp := LpNew(nil)
+			for j := 0; j < entries; j++ {
+				LpAppend(lp, value)
+			}
+			b.StartTimer()
+			LpPrepend(lp, big)
+			b.StopTimer()
+			LpFree(lp)
+			b.StartTimer()
+		}
+	})
+}
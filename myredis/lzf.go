@@ -0,0 +1,181 @@
+package myredis
+
+// LZF 压缩，同redis中使用的liblzf，快速列表使用它压缩中间的节点
+// 压缩之后的数据由若干段组成，每段以控制字节开头
+// 000lllll                          之后是l+1个字面字节
+// LLLooooo [LLLLLLLL] oooooooo     引用之前输出的数据，长度为L+2，L为7时使用下一个字节扩展长度
+//                                   偏移量为o+1，最大为8192
+
+const (
+	LZF_HLOG    = 16
+	LZF_HSIZE   = 1 << LZF_HLOG
+	LZF_MAX_LIT = 1 << 5
+	LZF_MAX_OFF = 1 << 13
+	LZF_MAX_REF = 1<<8 + 1<<3
+)
+
+// lzfIdx 三个字节的哈希值在哈希表中的位置
+func lzfIdx(hval uint32) uint32 {
+	return ((hval >> (3*8 - LZF_HLOG)) - hval*5) & (LZF_HSIZE - 1)
+}
+
+// lzfCompress 压缩in到out，返回压缩之后的长度
+// out 的空间不够或者in为空时返回0
+func lzfCompress(in, out []byte) int {
+	inEnd, outEnd := len(in), len(out)
+	if inEnd == 0 || outEnd == 0 {
+		return 0
+	}
+	// 保存每个哈希值最近一次出现的位置，0表示没有出现过
+	htab := make([]int, LZF_HSIZE)
+	ip, op, lit := 0, 0, 0
+	op++ // 字面段的控制字节
+
+	var hval uint32
+	if inEnd >= 2 {
+		hval = uint32(in[0])<<8 | uint32(in[1])
+	}
+	for ip < inEnd-2 {
+		hval = hval<<8 | uint32(in[ip+2])
+		slot := lzfIdx(hval)
+		ref := htab[slot]
+		htab[slot] = ip
+
+		if off := ip - ref - 1; ref > 0 && ref < ip && off < LZF_MAX_OFF &&
+			in[ref] == in[ip] && in[ref+1] == in[ip+1] && in[ref+2] == in[ip+2] {
+			length := 2
+			maxlen := inEnd - ip - length
+			if maxlen > LZF_MAX_REF {
+				maxlen = LZF_MAX_REF
+			}
+			if op-boolToInt(lit == 0)+3+1 >= outEnd {
+				return 0
+			}
+
+			out[op-lit-1] = byte(lit - 1) // 结束字面段
+			op -= boolToInt(lit == 0)     // 字面段为空时去掉控制字节
+
+			for {
+				length++
+				if length >= maxlen || in[ref+length] != in[ip+length] {
+					break
+				}
+			}
+
+			length -= 2 // 长度减2保存
+			ip++
+			if length < 7 {
+				out[op] = byte(off>>8 + length<<5)
+				op++
+			} else {
+				out[op] = byte(off>>8 + 7<<5)
+				out[op+1] = byte(length - 7)
+				op += 2
+			}
+			out[op] = byte(off)
+			op++
+
+			lit = 0
+			op++ // 新的字面段
+
+			ip += length + 1
+			if ip >= inEnd-2 {
+				break
+			}
+			// 匹配部分的每个位置都加入哈希表
+			ip -= length + 1
+			for ; length >= 0; length-- {
+				hval = hval<<8 | uint32(in[ip+2])
+				htab[lzfIdx(hval)] = ip
+				ip++
+			}
+			continue
+		}
+
+		if op >= outEnd {
+			return 0
+		}
+		lit++
+		out[op] = in[ip]
+		op++
+		ip++
+		if lit == LZF_MAX_LIT {
+			out[op-lit-1] = byte(lit - 1)
+			lit = 0
+			op++
+		}
+	}
+
+	// 最后的几个字节作为字面量
+	if op+3 > outEnd {
+		return 0
+	}
+	for ip < inEnd {
+		lit++
+		out[op] = in[ip]
+		op++
+		ip++
+		if lit == LZF_MAX_LIT {
+			out[op-lit-1] = byte(lit - 1)
+			lit = 0
+			op++
+		}
+	}
+	out[op-lit-1] = byte(lit - 1)
+	op -= boolToInt(lit == 0)
+	return op
+}
+
+// lzfDecompress 解压in到out，返回解压之后的长度，out的空间不够或者数据无效时返回0
+func lzfDecompress(in, out []byte) int {
+	ip, op := 0, 0
+	inEnd, outEnd := len(in), len(out)
+	for ip < inEnd {
+		ctrl := int(in[ip])
+		ip++
+		if ctrl < 1<<5 {
+			// 字面段
+			ctrl++
+			if op+ctrl > outEnd || ip+ctrl > inEnd {
+				return 0
+			}
+			copy(out[op:], in[ip:ip+ctrl])
+			op += ctrl
+			ip += ctrl
+			continue
+		}
+
+		// 引用之前的数据
+		length := ctrl >> 5
+		ref := op - (ctrl&0x1f)<<8 - 1
+		if ip >= inEnd {
+			return 0
+		}
+		if length == 7 {
+			length += int(in[ip])
+			ip++
+			if ip >= inEnd {
+				return 0
+			}
+		}
+		ref -= int(in[ip])
+		ip++
+		length += 2
+		if op+length > outEnd || ref < 0 {
+			return 0
+		}
+		// 引用的数据可能和输出重叠，逐字节复制
+		for i := 0; i < length; i++ {
+			out[op+i] = out[ref+i]
+		}
+		op += length
+	}
+	return op
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
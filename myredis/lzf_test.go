@@ -0,0 +1,56 @@
+package myredis
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestLzfRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 5000)
+	rnd.Read(random)
+	inputs := [][]byte{
+		[]byte("a"),
+		[]byte("abc"),
+		[]byte(strings.Repeat("a", 1000)),
+		[]byte(strings.Repeat("hello world ", 500)),
+		[]byte(strings.Repeat("0123456789", 3000)), // 引用的偏移量超过8192
+		random,
+		append([]byte(strings.Repeat("x", 100)), random[:300]...),
+	}
+	for _, in := range inputs {
+		out := make([]byte, len(in)+len(in)/16+64)
+		n := lzfCompress(in, out)
+		if n == 0 {
+			t.Fatalf("compress %d bytes failed", len(in))
+		}
+		decompressed := make([]byte, len(in))
+		if m := lzfDecompress(out[:n], decompressed); m != len(in) || !bytes.Equal(decompressed, in) {
+			t.Fatalf("round trip %d bytes, decompressed %d", len(in), m)
+		}
+	}
+
+	// 重复的数据压缩率很高
+	in := []byte(strings.Repeat("hello world ", 500))
+	out := make([]byte, len(in))
+	if n := lzfCompress(in, out); n == 0 || n > len(in)/10 {
+		t.Fatalf("compressed to %d bytes", n)
+	}
+	// 随机数据不能压缩到更小的空间
+	if n := lzfCompress(random, make([]byte, len(random))); n != 0 {
+		t.Fatalf("random data compressed to %d bytes", n)
+	}
+	// 输出的空间不够以及无效的数据
+	n := lzfCompress(in, out)
+	if lzfDecompress(out[:n], make([]byte, len(in)-1)) != 0 {
+		t.Fatal("decompress into small buffer")
+	}
+	if lzfDecompress([]byte{0x20, 0x00}, make([]byte, 10)) != 0 {
+		t.Fatal("back reference before the start")
+	}
+	if lzfDecompress([]byte{0x05, 'a'}, make([]byte, 10)) != 0 {
+		t.Fatal("truncated literal run")
+	}
+}
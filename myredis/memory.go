@@ -1,6 +1,12 @@
 package myredis
 
-import "errors"
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
 
 type MemoryPolicy int
 
@@ -19,36 +25,326 @@ const (
 	VolatileTTL // 删除最近过期的键、没有退化为noeviction
 )
 
-// 已经使用的内存
+var memoryPolicyNames = map[MemoryPolicy]string{
+	Noeviction:     "noeviction",
+	VolatileLRU:    "volatile-lru",
+	AllKeysLRU:     "allkeys-lru",
+	VolatileRandom: "volatile-random",
+	ALLKeysRandom:  "allkeys-random",
+	VolatileLFU:    "volatile-lfu",
+	AllKeysLFU:     "allkeys-lfu",
+	VolatileTTL:    "volatile-ttl",
+}
+
+// String maxmemory-policy 配置中的名称
+func (p MemoryPolicy) String() string {
+	return memoryPolicyNames[p]
+}
+
+// ParseMemoryPolicy 根据配置中的名称返回内存策略
+func ParseMemoryPolicy(name string) (MemoryPolicy, bool) {
+	name = strings.ToLower(name)
+	for policy, policyName := range memoryPolicyNames {
+		if policyName == name {
+			return policy, true
+		}
+	}
+	return 0, false
+}
+
+// isLFU 是否使用lfu算法淘汰，对象的lru字段记录访问频率
+func (p MemoryPolicy) isLFU() bool {
+	return p == VolatileLFU || p == AllKeysLFU
+}
+
+// isVolatile 是否只淘汰设置了过期时间的键
+func (p MemoryPolicy) isVolatile() bool {
+	return p == VolatileLRU || p == VolatileRandom || p == VolatileLFU || p == VolatileTTL
+}
+
+const (
+	MAXMEMORY_SAMPLES = 5  // maxmemory-samples 默认每次抽样的键数
+	EVPOOL_SIZE       = 16 // 淘汰池的大小
+
+	LFU_INIT_VAL     = 5  // 新对象的访问频率，避免刚写入的键被立即淘汰
+	LFU_LOG_FACTOR   = 10 // lfu-log-factor 默认值
+	LFU_DECAY_TIME   = 1  // lfu-decay-time 默认值 分钟
+	LFU_COUNTER_MAX  = 255
+	LFU_TIME_MAX     = 1<<16 - 1
+	LFU_COUNTER_BITS = 8
+	LFU_COUNTER_MASK = 1<<LFU_COUNTER_BITS - 1
+)
+
+var errOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'")
+
+// evictionPoolEntry 淘汰池中的候选键
+// idle 越大越优先淘汰，lru时为空闲时间，lfu时为255减去访问频率，ttl时越早过期越大
+type evictionPoolEntry struct {
+	idle uint64
+	key  string
+	dbid int
+}
+
+// usedMemory 默认的分配器已经使用的内存，不属于任何Server的数据结构使用
 func usedMemory() int {
-	return 0
+	return defaultAllocator.usedMemory()
+}
+
+// usedMemory Server的数据结构以及客户端使用的内存
+func (s *Server) usedMemory() int {
+	return s.mem.usedMemory()
 }
 
 // 主从的时，从的节点缓冲区
+// 还没有实现主从复制，从节点没有输出缓冲区
 func salvesOutputBufferSize(slaves int) int {
-	return 256
+	return 0
 }
 
 // aof重写的时候缓冲区
+// 还没有实现aof，没有重写缓冲区
 func aofRewriteBufferSize() int {
-	return 100
+	return 0
+}
+
+// memoryOverhead 不属于数据集的内存
+type memoryOverhead struct {
+	startup       int // 初始化完成时使用的内存
+	clientsNormal int // 普通客户端的缓冲区
+	clientsSlaves int // 从节点的输出缓冲区
+	aofBuffer     int // aof缓冲区
+	dbs           int // 键空间和过期字典本身，以及每个键的redisObject
+	total         int
+}
+
+// dictOverhead 字典本身占用的内存，不包括键和值
+func dictOverhead(d *Dict) int {
+	slots := int(d.ht[0].size + d.ht[1].size)
+	return DICT_OVERHEAD + slots*PTR_SIZE + DictSize(d)*DICT_ENTRY_OVERHEAD
+}
+
+// getMemoryOverheadData 统计额外开销，used_memory减去额外开销为数据集的大小
+func (s *Server) getMemoryOverheadData() memoryOverhead {
+	mh := memoryOverhead{startup: s.initialMemoryUsage}
+	s.mu.Lock()
+	for _, c := range s.clients {
+		mh.clientsNormal += c.memory()
+	}
+	s.mu.Unlock()
+	mh.clientsSlaves = salvesOutputBufferSize(s.SlaveNum)
+	mh.aofBuffer = aofRewriteBufferSize()
+	for _, db := range s.db {
+		mh.dbs += dictOverhead(db.dict) + DictSize(db.dict)*OBJ_OVERHEAD
+		mh.dbs += dictOverhead(db.expires)
+	}
+	mh.total = mh.startup + mh.clientsNormal + mh.clientsSlaves + mh.aofBuffer + mh.dbs
+	return mh
+}
+
+// estimateObjectIdleTime 对象的空闲时间 毫秒
+// lru时钟只有24位，当前时钟小于对象的时钟时说明时钟已经回绕
+func estimateObjectIdleTime(o *RedisObject) uint64 {
+	lruclock := LRUClock()
+	if lruclock >= o.lru {
+		return uint64(lruclock-o.lru) * LRU_CLOCK_RESOLUTION
+	}
+	return uint64(lruclock+(LRU_CLOCK_MAX-o.lru)) * LRU_CLOCK_RESOLUTION
+}
+
+// LFU 对象的lru字段拆分为两部分
+//      16 bits      8 bits
+// +----------------+--------+
+// + Last decr time | LOG_C  |
+// +----------------+--------+
+// Last decr time 最后一次衰减的时间，unix时间的分钟数截断为16位
+// LOG_C 对数计数器，访问次数越多增加的概率越小，255大约对应百万次访问(lfu-log-factor为10)
+// 计数器每经过lfu-decay-time分钟减1，长时间不访问的热点键会逐渐变冷
+
+// LFUGetTimeInMinutes 当前的分钟数，截断为16位
+func LFUGetTimeInMinutes() uint32 {
+	return uint32(time.Now().Unix()/60) & LFU_TIME_MAX
+}
+
+// LFUTimeElapsed 距离ldt经过的分钟数，时间回绕时加上一个周期
+func LFUTimeElapsed(ldt uint32) uint32 {
+	now := LFUGetTimeInMinutes()
+	if now >= ldt {
+		return now - ldt
+	}
+	return LFU_TIME_MAX - ldt + now
+}
+
+// LFULogIncr 按照对数的概率增加计数器
+// 计数器越大增加的概率越小: p = 1/((counter-LFU_INIT_VAL)*lfu_log_factor+1)
+func LFULogIncr(counter uint8, logFactor int) uint8 {
+	if counter == LFU_COUNTER_MAX {
+		return counter
+	}
+	baseval := float64(counter) - LFU_INIT_VAL
+	if baseval < 0 {
+		baseval = 0
+	}
+	p := 1.0 / (baseval*float64(logFactor) + 1)
+	if rand.Float64() < p {
+		counter++
+	}
+	return counter
+}
+
+// LFUDecrAndReturn 根据经过的时间衰减计数器并返回，不修改对象
+func (s *Server) LFUDecrAndReturn(o *RedisObject) uint8 {
+	ldt := o.lru >> LFU_COUNTER_BITS
+	counter := o.lru & LFU_COUNTER_MASK
+	var periods uint32
+	if s.LfuDecayTime > 0 {
+		periods = LFUTimeElapsed(ldt) / uint32(s.LfuDecayTime)
+	}
+	if periods >= counter {
+		return 0
+	}
+	return uint8(counter - periods)
+}
+
+// updateLFU 访问对象时先衰减再增加计数器，同时更新衰减的时间
+func (s *Server) updateLFU(o *RedisObject) {
+	counter := s.LFUDecrAndReturn(o)
+	counter = LFULogIncr(counter, s.LfuLogFactor)
+	o.lru = LFUGetTimeInMinutes()<<LFU_COUNTER_BITS | uint32(counter)
+}
+
+// evictionScore 候选键在淘汰池中的分数，分数越大越优先淘汰
+func (s *Server) evictionScore(o *RedisObject, expire int64) uint64 {
+	switch s.MaxMemoryPolicy {
+	case VolatileTTL:
+		// 越早过期分数越大
+		return math.MaxUint64 - uint64(expire)
+	case VolatileLFU, AllKeysLFU:
+		// 访问频率越低分数越大
+		return LFU_COUNTER_MAX - uint64(s.LFUDecrAndReturn(o))
+	}
+	return estimateObjectIdleTime(o)
+}
+
+// evictionPoolPopulate 从sampledict中抽样maxmemory-samples个键放入淘汰池
+// 淘汰池按照分数从小到大排序，池满时只有比池中最小分数大的键才能放入
+func (s *Server) evictionPoolPopulate(db *RedisDb, sampledict *Dict) {
+	for i := 0; i < s.MaxMemorySamples; i++ {
+		entry := DictGetRandomKey(sampledict)
+		if entry == nil {
+			return
+		}
+		key := entry.key.(string)
+		var o *RedisObject
+		var expire int64
+		if sampledict == db.expires {
+			expire = entry.val.(int64)
+			if s.MaxMemoryPolicy != VolatileTTL {
+				// 过期字典中的键在键空间中不存在时跳过
+				valEntry := DictFetchValue(db.dict, key)
+				if valEntry == nil {
+					continue
+				}
+				o = valEntry.val.(*RedisObject)
+			}
+		} else {
+			o = entry.val.(*RedisObject)
+		}
+		s.evictionPoolInsert(db.ID, key, s.evictionScore(o, expire))
+	}
+}
+
+// evictionPoolInsert 按照分数插入淘汰池，同一个键只保留最新的分数
+func (s *Server) evictionPoolInsert(dbid int, key string, idle uint64) {
+	pool := s.evictionPool
+	for k := range pool {
+		if pool[k].dbid == dbid && pool[k].key == key {
+			pool = append(pool[:k], pool[k+1:]...)
+			break
+		}
+	}
+
+	k := 0
+	for k < len(pool) && pool[k].idle < idle {
+		k++
+	}
+	entry := evictionPoolEntry{idle: idle, key: key, dbid: dbid}
+	if len(pool) < EVPOOL_SIZE {
+		pool = append(pool, evictionPoolEntry{})
+		copy(pool[k+1:], pool[k:])
+		pool[k] = entry
+	} else if k > 0 {
+		// 池已经满了，丢弃分数最小的键
+		copy(pool, pool[1:k])
+		pool[k-1] = entry
+	}
+	s.evictionPool = pool
+}
+
+// evictionDict 根据策略返回抽样的字典
+func (s *Server) evictionDict(db *RedisDb) *Dict {
+	if s.MaxMemoryPolicy.isVolatile() {
+		return db.expires
+	}
+	return db.dict
+}
+
+// findBestKey 根据淘汰策略选择需要淘汰的键，没有可以淘汰的键时返回false
+func (s *Server) findBestKey() (*RedisDb, string, bool) {
+	switch s.MaxMemoryPolicy {
+	case VolatileRandom, ALLKeysRandom:
+		// 轮流从每个数据库中随机选择
+		for i := 0; i < len(s.db); i++ {
+			db := s.db[s.evictionNextDb%len(s.db)]
+			s.evictionNextDb++
+			if entry := DictGetRandomKey(s.evictionDict(db)); entry != nil {
+				return db, entry.key.(string), true
+			}
+		}
+		return nil, "", false
+	}
+
+	for {
+		keys := 0
+		for _, db := range s.db {
+			dict := s.evictionDict(db)
+			if size := DictSize(dict); size > 0 {
+				s.evictionPoolPopulate(db, dict)
+				keys += size
+			}
+		}
+		if keys == 0 {
+			return nil, "", false
+		}
+		// 从分数最大的键开始，池中的键可能已经被删除
+		for k := len(s.evictionPool) - 1; k >= 0; k-- {
+			entry := s.evictionPool[k]
+			s.evictionPool = s.evictionPool[:k]
+			db := s.db[entry.dbid]
+			if DictFetchValue(s.evictionDict(db), entry.key) != nil {
+				return db, entry.key, true
+			}
+		}
+	}
 }
 
-// redis 对应8中内存回收策略，这里内存回收伪代码如下
+// redis 对应8中内存回收策略
+// 使用的内存超过maxmemory时按照策略淘汰键，直到内存低于maxmemory
+// 没有可以淘汰的键时返回OOM错误
 func freeMemoryIfNeed(server *Server) (isNeed bool, err error) {
 	var memUsed, memToFree, memFreed int
 	// 计算当前内存总量、排除从节点缓冲区和AOF缓冲区的作用
 	slaves := server.SlaveNum
-	memUsed = usedMemory() - salvesOutputBufferSize(slaves) - aofRewriteBufferSize()
+	memUsed = server.usedMemory() - salvesOutputBufferSize(slaves) - aofRewriteBufferSize()
 
 	// 当前使用内存未达到使用边界
 	if memUsed <= server.MaxMemory {
 		return
 	}
+	isNeed = true
 
 	if server.MaxMemoryPolicy == Noeviction {
 		// 不进行淘汰
-		err = errors.New("OOM command not allowed when used memory > 'maxmemory'")
+		err = errOOM
 		return
 	}
 
@@ -56,7 +352,16 @@ func freeMemoryIfNeed(server *Server) (isNeed bool, err error) {
 	memToFree = memUsed - server.MaxMemory
 	// 根据maxmemory-policy策略循环删除 释放内存
 	for memFreed < memToFree {
-		// 迭代redis所有db空间
+		db, key, ok := server.findBestKey()
+		if !ok {
+			err = errOOM
+			return
+		}
+		delta := server.usedMemory()
+		dbDelete(db, key)
+		delta -= server.usedMemory()
+		memFreed += delta
+		server.stat.evictedKeys++
 	}
 
 	return
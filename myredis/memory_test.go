@@ -0,0 +1,349 @@
+package myredis
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestEvictionPoolInsert(t *testing.T) {
+	s := &Server{}
+	for i := 0; i < EVPOOL_SIZE+10; i++ {
+		s.evictionPoolInsert(0, "key:"+strconv.Itoa(i), uint64(i))
+	}
+	if len(s.evictionPool) != EVPOOL_SIZE {
+		t.Fatalf("pool size %d", len(s.evictionPool))
+	}
+	// 池满之后只保留分数最大的键
+	if s.evictionPool[0].idle != 10 || s.evictionPool[EVPOOL_SIZE-1].idle != EVPOOL_SIZE+9 {
+		t.Fatalf("pool %v", s.evictionPool)
+	}
+	s.evictionPoolInsert(0, "small", 1)
+	if s.evictionPool[0].key == "small" {
+		t.Fatal("worse than all keys should be ignored")
+	}
+	// 同一个键只保留一个
+	s.evictionPoolInsert(0, "key:10", 100)
+	if len(s.evictionPool) != EVPOOL_SIZE || s.evictionPool[EVPOOL_SIZE-1].key != "key:10" {
+		t.Fatalf("pool %v", s.evictionPool)
+	}
+}
+
+// TestEvictionPoolPopulateStaleExpire 过期字典中的键在键空间中不存在时跳过
+func TestEvictionPoolPopulateStaleExpire(t *testing.T) {
+	s := NewServer()
+	s.MaxMemoryPolicy = VolatileLRU
+	db := newRedisDb(s, 0)
+	setKey(db, "k", CreateStringObject(db.alloc, []byte("v")))
+	setExpire(db, "k", mstime()+60000)
+	DictAdd(db.expires, "stale", mstime()+60000)
+	for i := 0; i < 10; i++ {
+		s.evictionPoolPopulate(db, db.expires)
+	}
+	if len(s.evictionPool) != 1 || s.evictionPool[0].key != "k" {
+		t.Fatalf("pool %v", s.evictionPool)
+	}
+}
+
+// withMaxMemory 预留空数据库的字典和一个客户端的缓冲区，数据集最多使用dataset字节
+func withMaxMemory(policy MemoryPolicy, dataset int) func(s *Server) {
+	return func(s *Server) {
+		s.MaxMemoryPolicy = policy
+		s.MaxMemory = s.DBNum*2*DICT_OVERHEAD + CLIENT_OVERHEAD + PROTO_IOBUF_LEN + PROTO_REPLY_CHUNK_BYTES + dataset
+	}
+}
+
+// TestUsedMemoryPerServer 每个Server只统计自己的数据结构和客户端
+func TestUsedMemoryPerServer(t *testing.T) {
+	_, addrA := startServer(t)
+	_, addrB := startServer(t)
+	a, b := newTestClient(addrA), newTestClient(addrB)
+	defer a.Close()
+	defer b.Close()
+	usedMemory := func(client *redis.Client) int {
+		fields := parseInfo(t, client.Info("memory").Val())
+		used, _ := strconv.Atoi(fields["used_memory"])
+		return used
+	}
+
+	empty := 16*2*DICT_OVERHEAD + CLIENT_OVERHEAD + PROTO_IOBUF_LEN + PROTO_REPLY_CHUNK_BYTES
+	if used := usedMemory(b); used != empty {
+		t.Fatalf("empty server used %d, want %d", used, empty)
+	}
+	// executor之外创建的数据结构不计入任何Server
+	done := make(chan []*RedisObject)
+	go func() {
+		objs := make([]*RedisObject, 0, 1000)
+		for i := 0; i < 1000; i++ {
+			objs = append(objs, CreateStringObject(nil, []byte("outside")))
+		}
+		done <- objs
+	}()
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 1000; i++ {
+		a.Set("key:"+strconv.Itoa(i), value, 0)
+	}
+	objs := <-done
+	defer func() {
+		for _, o := range objs {
+			DecrRefCount(o)
+		}
+	}()
+	if used := usedMemory(a); used < empty+1000*len(value) {
+		t.Fatalf("used %d after 1000 keys", used)
+	}
+	if used := usedMemory(b); used != empty {
+		t.Fatalf("other server used %d, want %d", used, empty)
+	}
+	a.FlushAll()
+	if used := usedMemory(a); used != empty {
+		t.Fatalf("used %d after flushall, want %d", used, empty)
+	}
+}
+
+func TestMaxMemoryEviction(t *testing.T) {
+	value := strings.Repeat("v", 100)
+	for _, policy := range []MemoryPolicy{AllKeysLRU, ALLKeysRandom, AllKeysLFU} {
+		t.Run(policy.String(), func(t *testing.T) {
+			_, addr := startServer(t, withMaxMemory(policy, 8000))
+			client := newTestClient(addr)
+			defer client.Close()
+			for i := 0; i < 500; i++ {
+				if err := client.Set("key:"+strconv.Itoa(i), value, 0).Err(); err != nil {
+					t.Fatalf("set %v", err)
+				}
+			}
+			if n := client.DBSize().Val(); n == 0 || n >= 100 {
+				t.Fatalf("dbsize %d", n)
+			}
+			// 最后写入的键一定没有被淘汰
+			if client.Get("key:499").Val() != value {
+				t.Fatal("last key evicted")
+			}
+		})
+	}
+}
+
+func TestMaxMemoryVolatile(t *testing.T) {
+	value := strings.Repeat("v", 100)
+	for _, policy := range []MemoryPolicy{VolatileLRU, VolatileRandom, VolatileLFU, VolatileTTL} {
+		t.Run(policy.String(), func(t *testing.T) {
+			_, addr := startServer(t, withMaxMemory(policy, 8000))
+			client := newTestClient(addr)
+			defer client.Close()
+			for i := 0; i < 20; i++ {
+				client.Set("persistent:"+strconv.Itoa(i), value, 0)
+			}
+			for i := 0; i < 500; i++ {
+				if err := client.Set("volatile:"+strconv.Itoa(i), value, time.Duration(i+1)*time.Minute).Err(); err != nil {
+					t.Fatalf("set %v", err)
+				}
+			}
+			// 只淘汰设置了过期时间的键
+			for i := 0; i < 20; i++ {
+				if client.Exists("persistent:"+strconv.Itoa(i)).Val() != 1 {
+					t.Fatal("persistent key evicted")
+				}
+			}
+			if policy == VolatileTTL && client.Exists("volatile:0").Val() != 0 {
+				t.Fatal("volatile-ttl should evict the key closest to expire")
+			}
+			// 没有可以淘汰的键时返回OOM
+			for i := 0; i < 500; i++ {
+				client.Del("volatile:" + strconv.Itoa(i))
+			}
+			for i := 20; i < 100; i++ {
+				client.Set("persistent:"+strconv.Itoa(i), value, 0)
+			}
+			if err := client.Set("persistent:100", value, 0).Err(); err == nil || !strings.HasPrefix(err.Error(), "OOM") {
+				t.Fatalf("no volatile keys %v", err)
+			}
+		})
+	}
+}
+
+func TestMaxMemoryNoeviction(t *testing.T) {
+	_, addr := startServer(t, withMaxMemory(Noeviction, 8000))
+	client := newTestClient(addr)
+	defer client.Close()
+
+	value := strings.Repeat("v", 100)
+	var err error
+	for i := 0; i < 500 && err == nil; i++ {
+		err = client.Set("key:"+strconv.Itoa(i), value, 0).Err()
+	}
+	if err == nil || !strings.HasPrefix(err.Error(), "OOM") {
+		t.Fatalf("noeviction %v", err)
+	}
+	// 读命令以及释放内存的命令不受影响
+	if client.Get("key:0").Val() != value {
+		t.Fatal("get after oom")
+	}
+	client.FlushDB()
+	if err := client.Set("key:0", value, 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLFUCounter(t *testing.T) {
+	s := NewServer()
+	var counter uint8 = LFU_INIT_VAL
+	for i := 0; i < 1000; i++ {
+		counter = LFULogIncr(counter, s.LfuLogFactor)
+	}
+	// 对数计数器增长缓慢，1000次访问远远没有达到上限
+	if counter <= LFU_INIT_VAL || counter >= 50 {
+		t.Fatalf("counter after 1000 incr %d", counter)
+	}
+	if LFULogIncr(LFU_COUNTER_MAX, 0) != LFU_COUNTER_MAX {
+		t.Fatal("counter should saturate")
+	}
+
+	// 每经过lfu-decay-time分钟计数器减1
+	o := CreateStringObject(nil, []byte("v"))
+	o.lru = (LFUGetTimeInMinutes()-10)&LFU_TIME_MAX<<LFU_COUNTER_BITS | 20
+	if freq := s.LFUDecrAndReturn(o); freq != 10 {
+		t.Fatalf("decayed freq %d", freq)
+	}
+	s.LfuDecayTime = 0
+	if freq := s.LFUDecrAndReturn(o); freq != 20 {
+		t.Fatalf("freq without decay %d", freq)
+	}
+	s.LfuDecayTime = 1
+	o.lru = (LFUGetTimeInMinutes()-100)&LFU_TIME_MAX<<LFU_COUNTER_BITS | 20
+	if freq := s.LFUDecrAndReturn(o); freq != 0 {
+		t.Fatalf("freq should decay to 0, got %d", freq)
+	}
+}
+
+// TestLFUHotKeysSurvive 经常访问的键在淘汰时保留，只访问过一次的键被淘汰
+// 淘汰是抽样的，抽样的键全部是热点键时热点键也会被淘汰，热点键只占少数并增加抽样数
+func TestLFUHotKeysSurvive(t *testing.T) {
+	_, addr := startServer(t, withMaxMemory(AllKeysLFU, 12000), func(s *Server) {
+		s.MaxMemorySamples = 10
+	})
+	client := newTestClient(addr)
+	defer client.Close()
+
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 10; i++ {
+		client.Set("hot:"+strconv.Itoa(i), value, 0)
+	}
+	pipe := client.Pipeline()
+	for n := 0; n < 100; n++ {
+		for i := 0; i < 10; i++ {
+			pipe.Get("hot:" + strconv.Itoa(i))
+		}
+	}
+	if _, err := pipe.Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		if err := client.Set("cold:"+strconv.Itoa(i), value, 0).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if client.Exists("hot:"+strconv.Itoa(i)).Val() != 1 {
+			t.Fatalf("hot key %d evicted", i)
+		}
+	}
+	if n := client.DBSize().Val(); n >= 100 {
+		t.Fatalf("dbsize %d", n)
+	}
+}
+
+// TestZmallocBalance 数据结构释放之后内存回到分配之前
+func TestZmallocBalance(t *testing.T) {
+	base := usedMemory()
+
+	db := newRedisDb(nil, 0)
+	for i := 0; i < 1000; i++ {
+		val := CreateStringObject(nil, []byte(strings.Repeat("v", i%100)))
+		setKey(db, "key:"+strconv.Itoa(i), val)
+		DecrRefCount(val)
+		setExpire(db, "key:"+strconv.Itoa(i), mstime()+60000)
+	}
+	// intset 转换为字典
+	set := SetTypeCreate(nil, []byte("1"))
+	for i := 0; i < SET_MAX_INTSET_ENTRIES+10; i++ {
+		SetTypeAdd(set, []byte(strconv.Itoa(i)))
+	}
+	setKey(db, "set", set)
+	DecrRefCount(set)
+	if used := usedMemory() - base; used < 1000*(OBJ_OVERHEAD+DICT_ENTRY_OVERHEAD) {
+		t.Fatalf("used %d", used)
+	}
+	for i := 0; i < 500; i++ {
+		dbDelete(db, "key:"+strconv.Itoa(i))
+	}
+	emptyDb(db)
+	DictRelease(db.dict)
+	DictRelease(db.expires)
+	if used := usedMemory(); used != base {
+		t.Fatalf("leaked %d bytes", used-base)
+	}
+
+	list := CreateListObject(nil)
+	l := list.ptr.(*List)
+	for i := 0; i < 10; i++ {
+		l.ListAddNodeTail(SDSNewLen(nil, []byte("value")))
+	}
+	l.ListRotate()
+	l.ListDelHead()
+	DecrRefCount(list)
+	if used := usedMemory(); used != base {
+		t.Fatalf("list leaked %d bytes", used-base)
+	}
+}
+
+func TestMemoryUsageAndInfo(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	client.Set("small", "v", 0)
+	client.Set("big", strings.Repeat("v", 1000), 0)
+	small, _ := client.Do("memory", "usage", "small").Int64()
+	big, _ := client.Do("memory", "usage", "big", "samples", "0").Int64()
+	if small <= 0 || big-small < 990 {
+		t.Fatalf("memory usage small %d big %d", small, big)
+	}
+	if err := client.Do("memory", "usage", "missing").Err(); err != redis.Nil {
+		t.Fatalf("memory usage missing %v", err)
+	}
+
+	infoField := func(name string) int {
+		for _, line := range strings.Split(client.Info("memory").Val(), "\r\n") {
+			if strings.HasPrefix(line, name+":") {
+				n, _ := strconv.Atoi(line[len(name)+1:])
+				return n
+			}
+		}
+		t.Fatalf("info missing %s", name)
+		return 0
+	}
+	before := infoField("used_memory")
+	pipe := client.Pipeline()
+	for i := 0; i < 1000; i++ {
+		pipe.Set("key:"+strconv.Itoa(i), strings.Repeat("v", 100), 0)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	after := infoField("used_memory")
+	if after-before < 1000*100 {
+		t.Fatalf("used_memory %d -> %d", before, after)
+	}
+	if dataset, overhead := infoField("used_memory_dataset"), infoField("used_memory_overhead"); dataset+overhead != after || dataset < 1000*100 {
+		t.Fatalf("dataset %d overhead %d used %d", dataset, overhead, after)
+	}
+	client.FlushAll()
+	if used := infoField("used_memory"); used >= after-1000*100 {
+		t.Fatalf("used_memory after flushall %d", used)
+	}
+}
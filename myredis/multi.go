@@ -0,0 +1,80 @@
+package myredis
+
+// 事务 MULTI EXEC DISCARD，没有实现WATCH
+// MULTI之后的命令加入队列，EXEC时依次执行，执行过程中不会执行其他客户端的命令
+// 命令加入队列时出现错误(命令不存在、参数个数错误、OOM)，EXEC时放弃整个事务
+// 被阻塞的客户端在EXEC执行完毕之后才会被唤醒，事务中的阻塞命令不会阻塞
+
+// multiCmd 事务队列中的命令
+type multiCmd struct {
+	argv [][]byte
+	cmd  *RedisCommand
+}
+
+// isTransactionCommand 在MULTI状态中直接执行，不加入队列的命令
+func isTransactionCommand(cmd *RedisCommand) bool {
+	switch cmd.Name {
+	case "multi", "exec", "discard", "quit":
+		return true
+	}
+	return false
+}
+
+// queueMultiCommand 把当前的命令加入事务队列
+func queueMultiCommand(c *Client, cmd *RedisCommand) {
+	c.mstate = append(c.mstate, multiCmd{argv: c.Argv, cmd: cmd})
+}
+
+// discardTransaction 清空事务队列，退出MULTI状态
+func discardTransaction(c *Client) {
+	c.mstate = nil
+	c.Flags &^= CLIENT_MULTI | CLIENT_DIRTY_EXEC
+}
+
+// flagTransaction 事务中的命令出现错误，EXEC时放弃事务
+func flagTransaction(c *Client) {
+	if c.Flags&CLIENT_MULTI != 0 {
+		c.Flags |= CLIENT_DIRTY_EXEC
+	}
+}
+
+func multiCommand(c *Client) {
+	if c.Flags&CLIENT_MULTI != 0 {
+		c.addReplyError("MULTI calls can not be nested")
+		return
+	}
+	c.Flags |= CLIENT_MULTI
+	c.addReplyOK()
+}
+
+func discardCommand(c *Client) {
+	if c.Flags&CLIENT_MULTI == 0 {
+		c.addReplyError("DISCARD without MULTI")
+		return
+	}
+	discardTransaction(c)
+	c.addReplyOK()
+}
+
+// execCommand 依次执行事务中的命令，返回每个命令的回复组成的数组
+func execCommand(c *Client) {
+	if c.Flags&CLIENT_MULTI == 0 {
+		c.addReplyError("EXEC without MULTI")
+		return
+	}
+	if c.Flags&CLIENT_DIRTY_EXEC != 0 {
+		c.reply.WriteError("EXECABORT Transaction discarded because of previous errors.")
+		discardTransaction(c)
+		return
+	}
+
+	argv := c.Argv
+	c.reply.WriteArray(len(c.mstate))
+	// 执行过程中保持CLIENT_MULTI，阻塞命令根据这个标记不阻塞
+	for _, mc := range c.mstate {
+		c.Argv = mc.argv
+		c.server.call(c, mc.cmd)
+	}
+	c.Argv = argv
+	discardTransaction(c)
+}
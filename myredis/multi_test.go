@@ -0,0 +1,61 @@
+package myredis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+func TestMultiExec(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	cmds, err := client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set("k", "v", 0)
+		pipe.RPush("l", "a", "b")
+		pipe.Get("k")
+		return nil
+	})
+	if err != nil || len(cmds) != 3 {
+		t.Fatalf("exec %v %v", cmds, err)
+	}
+	if cmds[1].(*redis.IntCmd).Val() != 2 || cmds[2].(*redis.StringCmd).Val() != "v" {
+		t.Fatalf("exec replies %v", cmds)
+	}
+
+	// 只有一个连接，手动执行的事务命令在同一个连接上
+	conn := redis.NewClient(&redis.Options{Addr: addr, PoolSize: 1})
+	defer conn.Close()
+	if err := conn.Do("exec").Err(); err == nil || err.Error() != "ERR EXEC without MULTI" {
+		t.Fatalf("exec without multi %v", err)
+	}
+	if err := conn.Do("discard").Err(); err == nil || err.Error() != "ERR DISCARD without MULTI" {
+		t.Fatalf("discard without multi %v", err)
+	}
+	conn.Do("multi")
+	if err := conn.Do("multi").Err(); err == nil || err.Error() != "ERR MULTI calls can not be nested" {
+		t.Fatalf("nested multi %v", err)
+	}
+	if v := conn.Do("set", "k", "discarded").Val(); v != "QUEUED" {
+		t.Fatalf("queued %v", v)
+	}
+	conn.Do("discard")
+	if v := conn.Get("k").Val(); v != "v" {
+		t.Fatalf("discarded command executed %s", v)
+	}
+
+	// 命令加入队列时出错，放弃整个事务
+	conn.Do("multi")
+	conn.Do("set", "k", "aborted")
+	if err := conn.Do("get").Err(); err == nil {
+		t.Fatal("wrong number of arguments in multi")
+	}
+	if err := conn.Do("exec").Err(); err == nil || !strings.HasPrefix(err.Error(), "EXECABORT") {
+		t.Fatalf("exec after error %v", err)
+	}
+	if v := conn.Get("k").Val(); v != "v" {
+		t.Fatalf("aborted command executed %s", v)
+	}
+}
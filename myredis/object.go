@@ -0,0 +1,722 @@
+package myredis
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// redis中的每个值都是一个redisObject
+// type 记录值的类型，encoding 记录底层使用的数据结构，同一种类型根据元素的数量和大小使用不同的编码
+// lru 24位，使用LRU淘汰时记录最后一次访问的时间(秒)，使用LFU淘汰时高16位记录时间(分钟)，低8位记录访问频率
+// refcount 引用计数，为0时释放底层的数据结构，共享对象的引用计数为OBJ_SHARED_REFCOUNT不会被释放
+
+const (
+	OBJ_STRING = 0 // 字符串
+	OBJ_LIST   = 1 // 列表
+	OBJ_SET    = 2 // 集合
+	OBJ_ZSET   = 3 // 有序集合
+	OBJ_HASH   = 4 // 哈希
+)
+
+const (
+	OBJ_ENCODING_RAW        = 0 // 字符串 []byte
+	OBJ_ENCODING_INT        = 1 // 字符串 int64
+	OBJ_ENCODING_HT         = 2 // 集合和哈希 *Dict
+	OBJ_ENCODING_LINKEDLIST = 4 // 列表 *List
+	OBJ_ENCODING_ZIPLIST    = 5 // 列表、有序集合和哈希 []byte
+	OBJ_ENCODING_INTSET     = 6 // 集合 *Intset
+	OBJ_ENCODING_SKIPLIST   = 7 // 有序集合 *Zset
+	OBJ_ENCODING_EMBSTR     = 8 // 短字符串 []byte
+	OBJ_ENCODING_QUICKLIST  = 9 // 列表
+)
+
+const (
+	LRU_BITS             = 24
+	LRU_CLOCK_MAX        = 1<<LRU_BITS - 1 // lru字段的最大值
+	LRU_CLOCK_RESOLUTION = 1000            // LRU时钟的精度 毫秒
+
+	OBJ_SHARED_REFCOUNT            = math.MaxInt32 // 共享对象的引用计数
+	OBJ_SHARED_INTEGERS            = 10000         // [0, 10000) 的整数使用共享对象
+	OBJ_ENCODING_EMBSTR_SIZE_LIMIT = 44            // 不超过此长度的字符串使用embstr编码
+
+	SET_MAX_INTSET_ENTRIES = 512 // set-max-intset-entries 整数集合最多的元素个数
+
+	OBJ_COMPUTE_SIZE_DEF_SAMPLES = 5 // MEMORY USAGE 默认的抽样元素个数
+)
+
+type RedisObject struct {
+	Type     uint8
+	Encoding uint8
+	lru      uint32 // 只使用低24位
+	refcount int32
+	ptr      interface{}
+	alloc    *allocator // 创建对象的分配器，释放时使用
+}
+
+// Zset 跳表编码的有序集合，字典用于O(1)查找成员的分值，跳表用于范围操作
+type Zset struct {
+	dict *Dict
+	zsl  *SkipList
+}
+
+// sharedIntegers 共享的整数对象
+var sharedIntegers = func() []*RedisObject {
+	objs := make([]*RedisObject, OBJ_SHARED_INTEGERS)
+	for i := range objs {
+		objs[i] = &RedisObject{
+			Type:     OBJ_STRING,
+			Encoding: OBJ_ENCODING_INT,
+			refcount: OBJ_SHARED_REFCOUNT,
+			ptr:      int64(i),
+		}
+	}
+	return objs
+}()
+
+func dictStringHash(key interface{}) uint64 {
+	return murmur3.Sum64([]byte(key.(string)))
+}
+
+func dictStringCompare(key1, key2 interface{}) bool {
+	return key1.(string) == key2.(string)
+}
+
+// dictSdsDestructor 释放字典中string键占用的内存
+func dictSdsDestructor(d *Dict, key interface{}) {
+	d.alloc.zfree(sdsAllocSize(len(key.(string))))
+}
+
+// setDictType 集合的字典，键为string，值为nil
+var setDictType = &DictType{
+	hashFuction:  dictStringHash,
+	keyCampare:   dictStringCompare,
+	keyDestrutor: dictSdsDestructor,
+}
+
+// hashDictType 字典编码的哈希，域和值都是string，删除时释放
+var hashDictType = &DictType{
+	hashFuction:  dictStringHash,
+	keyCampare:   dictStringCompare,
+	keyDestrutor: dictSdsDestructor,
+	valDestrutor: dictSdsDestructor,
+}
+
+// zsetDictType 有序集合的字典，值为分值，成员和跳表共享，由跳表释放
+var zsetDictType = &DictType{
+	hashFuction: dictStringHash,
+	keyCampare:  dictStringCompare,
+}
+
+// LRUClock 当前的LRU时钟，按照LRU_CLOCK_RESOLUTION取整并截断为24位
+func LRUClock() uint32 {
+	return uint32(time.Now().UnixNano()/int64(time.Millisecond)/LRU_CLOCK_RESOLUTION) & LRU_CLOCK_MAX
+}
+
+// createObject 创建对象，引用计数为1
+// 字符串的内容和对象一起统计内存，其他编码的数据结构自己统计
+func createObject(alloc *allocator, typ, encoding uint8, ptr interface{}) *RedisObject {
+	o := &RedisObject{
+		Type:     typ,
+		Encoding: encoding,
+		lru:      LRUClock(),
+		refcount: 1,
+		ptr:      ptr,
+		alloc:    alloc,
+	}
+	alloc.zmalloc(OBJ_OVERHEAD + stringObjectAllocSize(o))
+	return o
+}
+
+// stringObjectAllocSize raw和embstr编码的字符串内容占用的内存
+func stringObjectAllocSize(o *RedisObject) int {
+	if o.Type != OBJ_STRING || (o.Encoding != OBJ_ENCODING_RAW && o.Encoding != OBJ_ENCODING_EMBSTR) {
+		return 0
+	}
+	return sdsAllocSize(len(o.ptr.([]byte)))
+}
+
+// CreateRawStringObject raw编码的字符串，修改时直接修改底层的字节
+func CreateRawStringObject(alloc *allocator, b []byte) *RedisObject {
+	return createObject(alloc, OBJ_STRING, OBJ_ENCODING_RAW, b)
+}
+
+// CreateEmbeddedStringObject embstr编码的字符串，只读，修改之前需要转换为raw
+// redis中对象和sds在一次分配的连续内存中，这里复制一份和参数不共享底层数组
+func CreateEmbeddedStringObject(alloc *allocator, b []byte) *RedisObject {
+	buf := make([]byte, len(b))
+	copy(buf, b)
+	return createObject(alloc, OBJ_STRING, OBJ_ENCODING_EMBSTR, buf)
+}
+
+// CreateStringObject 根据长度选择embstr或者raw编码
+func CreateStringObject(alloc *allocator, b []byte) *RedisObject {
+	if len(b) <= OBJ_ENCODING_EMBSTR_SIZE_LIMIT {
+		return CreateEmbeddedStringObject(alloc, b)
+	}
+	return CreateRawStringObject(alloc, b)
+}
+
+// CreateStringObjectFromInt64 int编码的字符串，[0, OBJ_SHARED_INTEGERS) 返回共享对象
+func CreateStringObjectFromInt64(alloc *allocator, v int64) *RedisObject {
+	if v >= 0 && v < OBJ_SHARED_INTEGERS {
+		return sharedIntegers[v]
+	}
+	return createObject(alloc, OBJ_STRING, OBJ_ENCODING_INT, v)
+}
+
+// createSdsList 保存SDS的双端链表，释放节点时释放SDS
+func createSdsList(alloc *allocator) *List {
+	l := (&List{}).ListCreate(alloc)
+	l.ListSetFreeMethod(func(node *ListNode) {
+		SDSFree(node.value)
+	})
+	return l
+}
+
+// CreateListObject 双端链表编码的列表
+func CreateListObject(alloc *allocator) *RedisObject {
+	return createObject(alloc, OBJ_LIST, OBJ_ENCODING_LINKEDLIST, createSdsList(alloc))
+}
+
+// CreateZiplistObject 压缩表编码的列表
+func CreateZiplistObject(alloc *allocator) *RedisObject {
+	return createObject(alloc, OBJ_LIST, OBJ_ENCODING_ZIPLIST, ZiplistNew(alloc))
+}
+
+// CreateQuicklistObject 快速表编码的列表，使用默认的fill和compress
+func CreateQuicklistObject(alloc *allocator) *RedisObject {
+	return createObject(alloc, OBJ_LIST, OBJ_ENCODING_QUICKLIST, QuicklistCreate(alloc))
+}
+
+// CreateSetObject 字典编码的集合，值为nil
+func CreateSetObject(alloc *allocator) *RedisObject {
+	return createObject(alloc, OBJ_SET, OBJ_ENCODING_HT, DictCreate(alloc, setDictType))
+}
+
+// CreateIntsetObject 整数集合编码的集合
+func CreateIntsetObject(alloc *allocator) *RedisObject {
+	return createObject(alloc, OBJ_SET, OBJ_ENCODING_INTSET, IntSetNew(alloc))
+}
+
+// CreateHashObject 哈希对象默认使用压缩表编码
+func CreateHashObject(alloc *allocator) *RedisObject {
+	return createObject(alloc, OBJ_HASH, OBJ_ENCODING_ZIPLIST, ZiplistNew(alloc))
+}
+
+// CreateZsetObject 跳表编码的有序集合
+func CreateZsetObject(alloc *allocator) *RedisObject {
+	zs := &Zset{dict: DictCreate(alloc, zsetDictType), zsl: SLCreate(alloc)}
+	alloc.zmalloc(ZSET_OVERHEAD)
+	return createObject(alloc, OBJ_ZSET, OBJ_ENCODING_SKIPLIST, zs)
+}
+
+// CreateZsetZiplistObject 压缩表编码的有序集合
+func CreateZsetZiplistObject(alloc *allocator) *RedisObject {
+	return createObject(alloc, OBJ_ZSET, OBJ_ENCODING_ZIPLIST, ZiplistNew(alloc))
+}
+
+// IncrRefCount 增加引用计数
+func IncrRefCount(o *RedisObject) {
+	if o.refcount != OBJ_SHARED_REFCOUNT {
+		o.refcount++
+	}
+}
+
+// DecrRefCount 减少引用计数，为0时释放底层的数据结构
+func DecrRefCount(o *RedisObject) {
+	if o.refcount == OBJ_SHARED_REFCOUNT {
+		return
+	}
+	if o.refcount <= 0 {
+		panic("myredis: decrRefCount against refcount <= 0")
+	}
+	o.refcount--
+	if o.refcount > 0 {
+		return
+	}
+	o.alloc.zfree(OBJ_OVERHEAD + stringObjectAllocSize(o))
+	switch ptr := o.ptr.(type) {
+	case []byte:
+		if o.Encoding == OBJ_ENCODING_ZIPLIST {
+			ZiplistFree(o.alloc, ptr)
+		}
+	case *List:
+		ptr.ListRelease()
+	case *Quicklist:
+		QuicklistRelease(ptr)
+	case *Dict:
+		DictRelease(ptr)
+	case *Intset:
+		IntsetFree(ptr)
+	case *Zset:
+		DictRelease(ptr.dict)
+		SLFree(ptr.zsl)
+		o.alloc.zfree(ZSET_OVERHEAD)
+	}
+	o.ptr = nil
+}
+
+// RefCount 返回引用计数，OBJECT REFCOUNT 使用
+func (o *RedisObject) RefCount() int32 {
+	return o.refcount
+}
+
+// isShared 共享对象不能被修改
+func (o *RedisObject) isShared() bool {
+	return o.refcount > 1
+}
+
+// ObjectTypeName TYPE 命令返回的类型名称
+func ObjectTypeName(o *RedisObject) string {
+	switch o.Type {
+	case OBJ_STRING:
+		return "string"
+	case OBJ_LIST:
+		return "list"
+	case OBJ_SET:
+		return "set"
+	case OBJ_ZSET:
+		return "zset"
+	case OBJ_HASH:
+		return "hash"
+	}
+	return "unknown"
+}
+
+// ObjectEncodingName OBJECT ENCODING 命令返回的编码名称
+func ObjectEncodingName(o *RedisObject) string {
+	switch o.Encoding {
+	case OBJ_ENCODING_RAW:
+		return "raw"
+	case OBJ_ENCODING_INT:
+		return "int"
+	case OBJ_ENCODING_HT:
+		return "hashtable"
+	case OBJ_ENCODING_LINKEDLIST:
+		return "linkedlist"
+	case OBJ_ENCODING_ZIPLIST:
+		return "ziplist"
+	case OBJ_ENCODING_INTSET:
+		return "intset"
+	case OBJ_ENCODING_SKIPLIST:
+		return "skiplist"
+	case OBJ_ENCODING_EMBSTR:
+		return "embstr"
+	case OBJ_ENCODING_QUICKLIST:
+		return "quicklist"
+	}
+	return "unknown"
+}
+
+// objectCommandLookup OBJECT 命令查找键，不更新访问时间和频率
+func objectCommandLookup(c *Client, key string) *RedisObject {
+	if expireIfNeeded(c.db, key) {
+		return nil
+	}
+	entry := DictFetchValue(c.db.dict, key)
+	if entry == nil {
+		return nil
+	}
+	return entry.val.(*RedisObject)
+}
+
+// objectCommand OBJECT ENCODING|REFCOUNT|IDLETIME|FREQ key
+// IDLETIME 只有使用lru淘汰时有意义，FREQ 只有使用lfu淘汰时有意义
+func objectCommand(c *Client) {
+	subcommand := strings.ToLower(string(c.Argv[1]))
+	if subcommand == "help" && len(c.Argv) == 2 {
+		c.reply.WriteArray(4)
+		c.reply.WriteSimpleString("ENCODING <key> -- Return the kind of internal representation used in order to store the value associated with a key.")
+		c.reply.WriteSimpleString("FREQ <key> -- Return the access frequency index of the key. The returned integer is proportional to the logarithm of the recent access frequency of the key.")
+		c.reply.WriteSimpleString("IDLETIME <key> -- Return the idle time of the key, that is the approximated number of seconds elapsed since the last access to the key.")
+		c.reply.WriteSimpleString("REFCOUNT <key> -- Return the number of references of the value associated with the specified key.")
+		return
+	}
+	if len(c.Argv) != 3 {
+		c.addReplyError("Unknown subcommand or wrong number of arguments for '" + string(c.Argv[1]) + "'. Try OBJECT HELP.")
+		return
+	}
+
+	o := objectCommandLookup(c, string(c.Argv[2]))
+	switch subcommand {
+	case "encoding", "refcount", "idletime", "freq":
+	default:
+		c.addReplyError("Unknown subcommand or wrong number of arguments for '" + string(c.Argv[1]) + "'. Try OBJECT HELP.")
+		return
+	}
+	if o == nil {
+		c.reply.WriteNil()
+		return
+	}
+	lfu := c.server.MaxMemoryPolicy.isLFU()
+	switch subcommand {
+	case "encoding":
+		c.reply.WriteBulkString(ObjectEncodingName(o))
+	case "refcount":
+		c.reply.WriteInt(int64(o.RefCount()))
+	case "idletime":
+		if lfu {
+			c.addReplyError("An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+			return
+		}
+		c.reply.WriteInt(int64(estimateObjectIdleTime(o) / 1000))
+	case "freq":
+		if !lfu {
+			c.addReplyError("An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+			return
+		}
+		c.reply.WriteInt(int64(c.server.LFUDecrAndReturn(o)))
+	}
+}
+
+// dictComputeSize 估算字典占用的内存，抽样samples个节点计算平均大小
+func dictComputeSize(d *Dict, samples int, entrySize func(entry *DictEntry) int) int {
+	size := DICT_OVERHEAD + int(d.ht[0].size+d.ht[1].size)*PTR_SIZE
+	elesize, n := 0, 0
+	DictForEach(d, func(entry *DictEntry) bool {
+		elesize += DICT_ENTRY_OVERHEAD + entrySize(entry)
+		n++
+		return n < samples
+	})
+	if n > 0 {
+		size += elesize / n * DictSize(d)
+	}
+	return size
+}
+
+// stringEntrySize 字典节点中string键和值占用的内存
+func stringEntrySize(entry *DictEntry) int {
+	size := 0
+	if key, ok := entry.key.(string); ok {
+		size += sdsAllocSize(len(key))
+	}
+	if val, ok := entry.val.(string); ok {
+		size += sdsAllocSize(len(val))
+	}
+	return size
+}
+
+// objectComputeSize 估算对象占用的内存，MEMORY USAGE 使用
+// 聚合类型抽样samples个元素计算平均大小，乘以元素个数
+func objectComputeSize(o *RedisObject, samples int) int {
+	size := OBJ_OVERHEAD
+	switch ptr := o.ptr.(type) {
+	case []byte:
+		// 字符串以及压缩表编码的聚合类型
+		if o.Type == OBJ_STRING {
+			return size + stringObjectAllocSize(o)
+		}
+		return size + len(ptr)
+	case *List:
+		size += LIST_OVERHEAD
+		elesize, n := 0, 0
+		for node := ptr.head; node != nil && n < samples; node = node.next {
+			elesize += LIST_NODE_OVERHEAD + SDS_HDR_SIZE + node.value.Len
+			n++
+		}
+		if n > 0 {
+			size += elesize / n * int(ptr.len)
+		}
+	case *Quicklist:
+		size += QUICKLIST_OVERHEAD
+		elesize, n := 0, 0
+		for node := ptr.head; node != nil && n < samples; node = node.next {
+			elesize += QUICKLIST_NODE_OVERHEAD + node.sz
+			n++
+		}
+		if n > 0 {
+			size += elesize / n * ptr.len
+		}
+	case *Dict:
+		size += dictComputeSize(ptr, samples, stringEntrySize)
+	case *Intset:
+		size += IntsetBlobLen(ptr)
+	case *Zset:
+		size += ZSET_OVERHEAD + dictComputeSize(ptr.dict, samples, func(entry *DictEntry) int { return 0 })
+		size += SKIPLIST_OVERHEAD + SKIPLIST_NODE_OVERHEAD + SKIPLIST_MAXVALUE*SKIPLIST_LEVEL_OVERHEAD
+		elesize, n := 0, 0
+		for node := ptr.zsl.Header.Level[0].Forward; node != nil && n < samples; node = node.Level[0].Forward {
+			elesize += SKIPLIST_NODE_OVERHEAD + len(node.Level)*SKIPLIST_LEVEL_OVERHEAD
+			if member, ok := node.Obj.(string); ok {
+				elesize += sdsAllocSize(len(member))
+			}
+			n++
+		}
+		if n > 0 {
+			size += elesize / n * ptr.zsl.Length
+		}
+	}
+	return size
+}
+
+// memoryCommand MEMORY USAGE key [SAMPLES count]
+// 聚合类型默认抽样5个元素，SAMPLES 0 计算所有的元素
+func memoryCommand(c *Client) {
+	subcommand := strings.ToLower(string(c.Argv[1]))
+	if subcommand == "help" && len(c.Argv) == 2 {
+		c.reply.WriteArray(2)
+		c.reply.WriteSimpleString("MEMORY USAGE <key> [SAMPLES <count>] - Estimate memory usage of key")
+		c.reply.WriteSimpleString("MEMORY HELP - Show this help")
+		return
+	}
+	if subcommand != "usage" || len(c.Argv) < 3 {
+		c.addReplyError("Unknown subcommand or wrong number of arguments for '" + string(c.Argv[1]) + "'. Try MEMORY HELP.")
+		return
+	}
+
+	samples := OBJ_COMPUTE_SIZE_DEF_SAMPLES
+	for j := 3; j < len(c.Argv); j++ {
+		if strings.ToLower(string(c.Argv[j])) != "samples" || j+1 >= len(c.Argv) {
+			c.addReplyError("syntax error")
+			return
+		}
+		j++
+		n, err := strconv.Atoi(string(c.Argv[j]))
+		if err != nil {
+			c.addReplyError("value is not an integer or out of range")
+			return
+		}
+		if n < 0 {
+			c.addReplyError("syntax error")
+			return
+		}
+		samples = n
+		if samples == 0 {
+			samples = math.MaxInt32
+		}
+	}
+
+	key := string(c.Argv[2])
+	o := objectCommandLookup(c, key)
+	if o == nil {
+		c.reply.WriteNil()
+		return
+	}
+	usage := objectComputeSize(o, samples) + sdsAllocSize(len(key)) + DICT_ENTRY_OVERHEAD
+	c.reply.WriteInt(int64(usage))
+}
+
+// stringObjectBytes 返回字符串对象的内容，int编码转换为十进制字符串
+func stringObjectBytes(o *RedisObject) []byte {
+	if o.Encoding == OBJ_ENCODING_INT {
+		return strconv.AppendInt(nil, o.ptr.(int64), 10)
+	}
+	return o.ptr.([]byte)
+}
+
+// stringObjectLen 字符串对象的长度
+func stringObjectLen(o *RedisObject) int {
+	if o.Encoding == OBJ_ENCODING_INT {
+		return len(strconv.FormatInt(o.ptr.(int64), 10))
+	}
+	return len(o.ptr.([]byte))
+}
+
+// stringObjectInt64 字符串对象是否可以表示为int64
+// 只接受redis的整数格式，不能有前导0、正号以及空白
+func stringObjectInt64(b []byte) (int64, bool) {
+	if len(b) == 0 || len(b) > 20 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != string(b) {
+		return 0, false
+	}
+	return v, true
+}
+
+// TryObjectEncoding 尝试使用更节约内存的编码保存字符串
+// 1 可以表示为整数的使用int编码，小整数使用共享对象
+// 2 短字符串使用embstr编码
+// 3 raw编码的字符串释放多余的空间
+// 被共享的对象不会转换
+func TryObjectEncoding(o *RedisObject) *RedisObject {
+	if o.Type != OBJ_STRING {
+		return o
+	}
+	if o.Encoding != OBJ_ENCODING_RAW && o.Encoding != OBJ_ENCODING_EMBSTR {
+		return o
+	}
+	if o.isShared() {
+		return o
+	}
+
+	b := o.ptr.([]byte)
+	if v, ok := stringObjectInt64(b); ok {
+		if v >= 0 && v < OBJ_SHARED_INTEGERS {
+			DecrRefCount(o)
+			return sharedIntegers[v]
+		}
+		o.alloc.zfree(stringObjectAllocSize(o))
+		o.Encoding = OBJ_ENCODING_INT
+		o.ptr = v
+		return o
+	}
+
+	if len(b) <= OBJ_ENCODING_EMBSTR_SIZE_LIMIT {
+		if o.Encoding == OBJ_ENCODING_EMBSTR {
+			return o
+		}
+		emb := CreateEmbeddedStringObject(o.alloc, b)
+		emb.lru = o.lru
+		DecrRefCount(o)
+		return emb
+	}
+
+	// 剩余空间超过10%时释放
+	if o.Encoding == OBJ_ENCODING_RAW && cap(b)-len(b) > len(b)/10 {
+		trimmed := make([]byte, len(b))
+		copy(trimmed, b)
+		o.ptr = trimmed
+	}
+	return o
+}
+
+// GetDecodedObject 返回raw或者embstr编码的字符串对象
+// 已经是字节编码时增加引用计数返回自身，int编码时创建新的对象
+func GetDecodedObject(o *RedisObject) *RedisObject {
+	if o.Encoding != OBJ_ENCODING_INT {
+		IncrRefCount(o)
+		return o
+	}
+	return CreateStringObject(o.alloc, stringObjectBytes(o))
+}
+
+// DupStringObject 复制字符串对象，修改embstr或者共享对象之前使用
+func DupStringObject(o *RedisObject) *RedisObject {
+	switch o.Encoding {
+	case OBJ_ENCODING_INT:
+		return createObject(o.alloc, OBJ_STRING, OBJ_ENCODING_INT, o.ptr)
+	case OBJ_ENCODING_EMBSTR:
+		return CreateEmbeddedStringObject(o.alloc, o.ptr.([]byte))
+	}
+	b := o.ptr.([]byte)
+	buf := make([]byte, len(b))
+	copy(buf, b)
+	return CreateRawStringObject(o.alloc, buf)
+}
+
+// SetTypeCreate 根据第一个元素选择集合的编码，整数使用intset
+func SetTypeCreate(alloc *allocator, value []byte) *RedisObject {
+	if _, ok := stringObjectInt64(value); ok {
+		return CreateIntsetObject(alloc)
+	}
+	return CreateSetObject(alloc)
+}
+
+// SetTypeAdd 向集合中添加元素，添加成功返回true
+// 整数集合中添加非整数或者元素个数超过SET_MAX_INTSET_ENTRIES时转换为字典
+func SetTypeAdd(o *RedisObject, value []byte) bool {
+	if o.Encoding == OBJ_ENCODING_INTSET {
+		if v, ok := stringObjectInt64(value); ok {
+			is := o.ptr.(*Intset)
+			if IntSetAdd(is, unsafe.Pointer(&v), INTSET_ENC_INT64) != nil {
+				return false
+			}
+			if is.Length > SET_MAX_INTSET_ENTRIES {
+				SetTypeConvert(o, OBJ_ENCODING_HT)
+			}
+			return true
+		}
+		SetTypeConvert(o, OBJ_ENCODING_HT)
+	}
+	return setTypeDictAdd(o.ptr.(*Dict), string(value))
+}
+
+// setTypeDictAdd 向字典编码的集合中添加元素
+func setTypeDictAdd(d *Dict, member string) bool {
+	if DictAdd(d, member, nil) != nil {
+		return false
+	}
+	d.alloc.zmalloc(sdsAllocSize(len(member)))
+	return true
+}
+
+// SetTypeIsMember 元素是否在集合中
+func SetTypeIsMember(o *RedisObject, value []byte) bool {
+	if o.Encoding == OBJ_ENCODING_INTSET {
+		v, ok := stringObjectInt64(value)
+		if !ok {
+			return false
+		}
+		found, _ := IntsetFind(o.ptr.(*Intset), unsafe.Pointer(&v), INTSET_ENC_INT64)
+		return found
+	}
+	return DictFetchValue(o.ptr.(*Dict), string(value)) != nil
+}
+
+// SetTypeSize 集合的元素个数
+func SetTypeSize(o *RedisObject) int {
+	if o.Encoding == OBJ_ENCODING_INTSET {
+		return o.ptr.(*Intset).Length
+	}
+	return DictSize(o.ptr.(*Dict))
+}
+
+// SetTypeConvert 把整数集合编码的集合转换为字典编码，集合只会从intset转换为hashtable
+func SetTypeConvert(o *RedisObject, encoding uint8) {
+	if o.Type != OBJ_SET || o.Encoding != OBJ_ENCODING_INTSET || encoding != OBJ_ENCODING_HT {
+		return
+	}
+	is := o.ptr.(*Intset)
+	d := DictCreate(o.alloc, setDictType)
+	for i := 0; i < is.Length; i++ {
+		setTypeDictAdd(d, strconv.FormatInt(is.get(i), 10))
+	}
+	IntsetFree(is)
+	o.Encoding = OBJ_ENCODING_HT
+	o.ptr = d
+}
+
+// HashTypeConvert 把压缩表编码的哈希转换为字典编码，哈希只会从ziplist转换为hashtable
+// 压缩表中域和值相邻保存
+func HashTypeConvert(o *RedisObject, encoding uint8) {
+	if o.Type != OBJ_HASH || o.Encoding != OBJ_ENCODING_ZIPLIST || encoding != OBJ_ENCODING_HT {
+		return
+	}
+	zl := o.ptr.([]byte)
+	d := DictCreate(o.alloc, hashDictType)
+	for p := ZiplistIndex(zl, 0); p != -1; p = ZiplistNext(zl, p) {
+		field := string(ziplistEntryBytes(zl, p))
+		p = ZiplistNext(zl, p)
+		value := string(ziplistEntryBytes(zl, p))
+		if DictAdd(d, field, value) != nil {
+			panic("myredis: ziplist corruption detected")
+		}
+		o.alloc.zmalloc(sdsAllocSize(len(field)) + sdsAllocSize(len(value)))
+	}
+	ZiplistFree(o.alloc, zl)
+	o.Encoding = OBJ_ENCODING_HT
+	o.ptr = d
+}
+
+// ZsetConvert 把压缩表编码的有序集合转换为跳表编码，有序集合只会从ziplist转换为skiplist
+// 压缩表中成员和分值相邻保存，按照分值从小到大排列
+func ZsetConvert(o *RedisObject, encoding uint8) {
+	if o.Type != OBJ_ZSET || o.Encoding != OBJ_ENCODING_ZIPLIST || encoding != OBJ_ENCODING_SKIPLIST {
+		return
+	}
+	zl := o.ptr.([]byte)
+	zs := &Zset{dict: DictCreate(o.alloc, zsetDictType), zsl: SLCreate(o.alloc)}
+	o.alloc.zmalloc(ZSET_OVERHEAD)
+	for p := ZiplistIndex(zl, 0); p != -1; p = ZiplistNext(zl, p) {
+		member := string(ziplistEntryBytes(zl, p))
+		p = ZiplistNext(zl, p)
+		score, err := strconv.ParseFloat(string(ziplistEntryBytes(zl, p)), 32)
+		if err != nil {
+			panic("myredis: ziplist corruption detected")
+		}
+		node, err := SLInsert(zs.zsl, member, float32(score))
+		if err != nil || DictAdd(zs.dict, member, node.Score) != nil {
+			panic("myredis: ziplist corruption detected")
+		}
+	}
+	ZiplistFree(o.alloc, zl)
+	o.Encoding = OBJ_ENCODING_SKIPLIST
+	o.ptr = zs
+}
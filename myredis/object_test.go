@@ -0,0 +1,201 @@
+package myredis
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+func TestTryObjectEncoding(t *testing.T) {
+	cases := []struct {
+		value    string
+		encoding uint8
+	}{
+		{"123", OBJ_ENCODING_INT},
+		{"-9223372036854775808", OBJ_ENCODING_INT},
+		{"0123", OBJ_ENCODING_EMBSTR},
+		{"hello", OBJ_ENCODING_EMBSTR},
+		{string(make([]byte, OBJ_ENCODING_EMBSTR_SIZE_LIMIT+1)), OBJ_ENCODING_RAW},
+	}
+	for _, c := range cases {
+		o := TryObjectEncoding(CreateRawStringObject(nil, []byte(c.value)))
+		if o.Encoding != c.encoding || string(stringObjectBytes(o)) != c.value {
+			t.Fatalf("%q encoding %s", c.value, ObjectEncodingName(o))
+		}
+	}
+	if o := TryObjectEncoding(CreateRawStringObject(nil, []byte("42"))); o != sharedIntegers[42] || o.RefCount() != OBJ_SHARED_REFCOUNT {
+		t.Fatal("small integer should use shared object")
+	}
+	decoded := GetDecodedObject(CreateStringObjectFromInt64(nil, -7))
+	if decoded.Encoding != OBJ_ENCODING_EMBSTR || string(stringObjectBytes(decoded)) != "-7" {
+		t.Fatalf("decoded %s", ObjectEncodingName(decoded))
+	}
+}
+
+func TestSetTypeConvert(t *testing.T) {
+	o := SetTypeCreate(nil, []byte("1"))
+	for _, v := range []string{"5", "-200", "70000", "3", "5"} {
+		SetTypeAdd(o, []byte(v))
+	}
+	is := o.ptr.(*Intset)
+	if o.Encoding != OBJ_ENCODING_INTSET || is.Length != 4 || is.Encoding != INTSET_ENC_INT32 {
+		t.Fatalf("intset %s length %d", ObjectEncodingName(o), is.Length)
+	}
+	for i, expected := range []int64{-200, 3, 5, 70000} {
+		if is.get(i) != expected {
+			t.Fatalf("intset index %d %d", i, is.get(i))
+		}
+	}
+
+	SetTypeAdd(o, []byte("abc"))
+	if o.Encoding != OBJ_ENCODING_HT || SetTypeSize(o) != 5 || !SetTypeIsMember(o, []byte("70000")) {
+		t.Fatalf("converted %s size %d", ObjectEncodingName(o), SetTypeSize(o))
+	}
+
+	big := SetTypeCreate(nil, []byte("0"))
+	for i := 0; i <= SET_MAX_INTSET_ENTRIES; i++ {
+		SetTypeAdd(big, []byte(strconv.Itoa(i)))
+	}
+	if big.Encoding != OBJ_ENCODING_HT || SetTypeSize(big) != SET_MAX_INTSET_ENTRIES+1 {
+		t.Fatalf("big set %s size %d", ObjectEncodingName(big), SetTypeSize(big))
+	}
+}
+
+func TestHashTypeConvert(t *testing.T) {
+	base := usedMemory()
+	o := CreateHashObject(nil)
+	fields := map[string]string{"name": "redis", "version": "6", "big": strings.Repeat("x", 100)}
+	for field, value := range fields {
+		zl := ZiplistPush(nil, o.ptr.([]byte), []byte(field), ZIPLIST_TAIL)
+		o.ptr = ZiplistPush(nil, zl, []byte(value), ZIPLIST_TAIL)
+	}
+	HashTypeConvert(o, OBJ_ENCODING_HT)
+	d := o.ptr.(*Dict)
+	if o.Encoding != OBJ_ENCODING_HT || DictSize(d) != len(fields) {
+		t.Fatalf("converted %s size %d", ObjectEncodingName(o), DictSize(d))
+	}
+	for field, value := range fields {
+		if entry := DictFetchValue(d, field); entry == nil || entry.Val() != value {
+			t.Fatalf("field %s = %v", field, entry)
+		}
+	}
+	// 已经是字典编码时不转换
+	HashTypeConvert(o, OBJ_ENCODING_HT)
+	if o.ptr.(*Dict) != d {
+		t.Fatal("converted twice")
+	}
+	DecrRefCount(o)
+	if used := usedMemory(); used != base {
+		t.Fatalf("leaked %d bytes", used-base)
+	}
+}
+
+func TestZsetConvert(t *testing.T) {
+	base := usedMemory()
+	o := CreateZsetZiplistObject(nil)
+	members := []struct {
+		member string
+		score  string
+	}{{"a", "1"}, {"c", "2.5"}, {"b", "2.5"}, {"d", "-3"}, {"e", "100"}}
+	for _, m := range members {
+		zl := ZiplistPush(nil, o.ptr.([]byte), []byte(m.member), ZIPLIST_TAIL)
+		o.ptr = ZiplistPush(nil, zl, []byte(m.score), ZIPLIST_TAIL)
+	}
+	ZsetConvert(o, OBJ_ENCODING_SKIPLIST)
+	zs := o.ptr.(*Zset)
+	if o.Encoding != OBJ_ENCODING_SKIPLIST || zs.zsl.Length != len(members) || DictSize(zs.dict) != len(members) {
+		t.Fatalf("converted %s length %d", ObjectEncodingName(o), zs.zsl.Length)
+	}
+	// 按照分值排序，分值相同时按照成员排序
+	var got []string
+	for node := zs.zsl.Header.Level[0].Forward; node != nil; node = node.Level[0].Forward {
+		got = append(got, node.Obj.(string))
+	}
+	if strings.Join(got, ",") != "d,a,b,c,e" || zs.zsl.Tail.Obj != "e" || zs.zsl.Tail.Backward.Obj != "c" {
+		t.Fatalf("skiplist order %v", got)
+	}
+	if entry := DictFetchValue(zs.dict, "c"); entry == nil || entry.Val() != float32(2.5) {
+		t.Fatalf("score of c %v", entry)
+	}
+	// 每一层的跨度之和都是节点的个数
+	for i := 0; i < zs.zsl.Level; i++ {
+		span := 0
+		for node := zs.zsl.Header; node.Level[i].Forward != nil; node = node.Level[i].Forward {
+			span += node.Level[i].Span
+		}
+		if span > zs.zsl.Length {
+			t.Fatalf("level %d span %d", i, span)
+		}
+	}
+	if _, err := SLInsert(zs.zsl, "a", 1); err == nil {
+		t.Fatal("insert existing member")
+	}
+	DecrRefCount(o)
+	if used := usedMemory(); used != base {
+		t.Fatalf("leaked %d bytes", used-base)
+	}
+}
+
+func TestQuicklistObject(t *testing.T) {
+	base := usedMemory()
+	o := CreateQuicklistObject(nil)
+	if ObjectTypeName(o) != "list" || ObjectEncodingName(o) != "quicklist" {
+		t.Fatalf("type %s encoding %s", ObjectTypeName(o), ObjectEncodingName(o))
+	}
+	ql := o.ptr.(*Quicklist)
+	for i := 0; i < 1000; i++ {
+		QuicklistPushTail(ql, []byte("value:"+strconv.Itoa(i)))
+	}
+	if size := objectComputeSize(o, OBJ_COMPUTE_SIZE_DEF_SAMPLES); size < OBJ_OVERHEAD+QUICKLIST_OVERHEAD+ql.len*QUICKLIST_NODE_OVERHEAD+1000*len("value:0") {
+		t.Fatalf("size %d of %d nodes", size, ql.len)
+	}
+	DecrRefCount(o)
+	if used := usedMemory(); used != base {
+		t.Fatalf("leaked %d bytes", used-base)
+	}
+}
+
+func TestObjectCommand(t *testing.T) {
+	_, addr := startServer(t, func(s *Server) {
+		s.MaxMemoryPolicy = AllKeysLFU
+	})
+	client := newTestClient(addr)
+	defer client.Close()
+
+	client.Set("k", "hello", 0)
+	if enc := client.ObjectEncoding("k").Val(); enc != "embstr" {
+		t.Fatalf("encoding %s", enc)
+	}
+	if n := client.ObjectRefCount("k").Val(); n != 1 {
+		t.Fatalf("refcount %d", n)
+	}
+	// OBJECT 不更新访问频率
+	if freq, _ := client.Do("object", "freq", "k").Int64(); freq != LFU_INIT_VAL {
+		t.Fatalf("freq %d", freq)
+	}
+	for i := 0; i < 100; i++ {
+		client.Get("k")
+	}
+	if freq, _ := client.Do("object", "freq", "k").Int64(); freq <= LFU_INIT_VAL {
+		t.Fatalf("freq after access %d", freq)
+	}
+	if err := client.ObjectIdleTime("k").Err(); err == nil {
+		t.Fatal("idletime with lfu policy")
+	}
+	if err := client.Do("object", "freq", "missing").Err(); err != redis.Nil {
+		t.Fatalf("freq missing %v", err)
+	}
+
+	_, addr = startServer(t)
+	lru := newTestClient(addr)
+	defer lru.Close()
+	lru.Set("k", "hello", 0)
+	if idle := lru.ObjectIdleTime("k").Val(); idle != 0 {
+		t.Fatalf("idletime %v", idle)
+	}
+	if err := lru.Do("object", "freq", "k").Err(); err == nil {
+		t.Fatal("freq without lfu policy")
+	}
+}
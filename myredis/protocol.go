@@ -0,0 +1,380 @@
+package myredis
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// RESP2 协议
+// 请求有两种格式
+// 1 multibulk: *<参数个数>\r\n$<参数长度>\r\n<参数>\r\n ... 客户端库使用的格式
+// 2 inline: 空格分隔的一行命令，以\n或者\r\n结尾 telnet等工具使用的格式
+// 回复的类型
+// +简单字符串 -错误 :整数 $批量字符串 *数组，$-1表示nil，*-1表示nil数组
+
+const (
+	PROTO_INLINE_MAX_SIZE = 64 * 1024         // inline请求以及长度行的最大字节数
+	PROTO_MAX_BULK_LEN    = 512 * 1024 * 1024 // 单个参数的最大长度 proto-max-bulk-len
+	PROTO_MAX_MULTIBULK   = 1024 * 1024       // 单个请求最多的参数个数
+
+	// 长度由客户端声明，数据到达之前只预分配有限的空间，同redis
+	PROTO_MBULK_PREALLOC = 1024      // 参数数组最多预分配的个数
+	PROTO_MBULK_BIG_ARG  = 32 * 1024 // 参数超过此长度时随着数据到达分块读取
+)
+
+func protocolError(msg string) error {
+	return &Error{Code: PROTOCOL_ERR, MSG: "ERR Protocol error: " + msg}
+}
+
+// IsProtocolError 协议错误之后连接中的数据已经无法解析，回复错误之后需要关闭连接
+func IsProtocolError(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.Code == PROTOCOL_ERR
+}
+
+// RequestReader 从连接中流式解析请求
+type RequestReader struct {
+	r            *bufio.Reader
+	MaxBulkLen   int64 // 单个参数的最大长度
+	MaxMultibulk int64 // 单个请求最多的参数个数
+}
+
+func NewRequestReader(r *bufio.Reader) *RequestReader {
+	return &RequestReader{
+		r:            r,
+		MaxBulkLen:   PROTO_MAX_BULK_LEN,
+		MaxMultibulk: PROTO_MAX_MULTIBULK,
+	}
+}
+
+// ReadRequest 读取一个完整的请求，返回命令和参数
+// 空行或者 *0 返回长度为0的参数，调用方直接忽略
+// 连接关闭返回io.EOF，协议错误返回PROTOCOL_ERR
+func (rr *RequestReader) ReadRequest() ([][]byte, error) {
+	first, err := rr.r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] == '*' {
+		return rr.readMultibulk()
+	}
+	return rr.readInline()
+}
+
+// readLine 读取一行，去掉结尾的\r\n，超过PROTO_INLINE_MAX_SIZE返回tooBig错误
+func (rr *RequestReader) readLine(tooBig string) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := rr.r.ReadSlice('\n')
+		if len(line)+len(chunk) > PROTO_INLINE_MAX_SIZE {
+			return nil, protocolError(tooBig)
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		break
+	}
+	line = line[:len(line)-1]
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, nil
+}
+
+func (rr *RequestReader) readInline() ([][]byte, error) {
+	line, err := rr.readLine("too big inline request")
+	if err != nil {
+		return nil, err
+	}
+	args, ok := splitArgs(line)
+	if !ok {
+		return nil, protocolError("unbalanced quotes in request")
+	}
+	return args, nil
+}
+
+func (rr *RequestReader) readMultibulk() ([][]byte, error) {
+	line, err := rr.readLine("too big mbulk count string")
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.ParseInt(string(line[1:]), 10, 64)
+	if err != nil || count > rr.MaxMultibulk {
+		return nil, protocolError("invalid multibulk length")
+	}
+	if count <= 0 {
+		return [][]byte{}, nil
+	}
+
+	prealloc := count
+	if prealloc > PROTO_MBULK_PREALLOC {
+		prealloc = PROTO_MBULK_PREALLOC
+	}
+	args := make([][]byte, 0, prealloc)
+	for i := int64(0); i < count; i++ {
+		line, err := rr.readLine("too big bulk count string")
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			got := byte(' ')
+			if len(line) > 0 {
+				got = line[0]
+			}
+			return nil, protocolError("expected '$', got '" + string(got) + "'")
+		}
+		size, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil || size < 0 || size > rr.MaxBulkLen {
+			return nil, protocolError("invalid bulk length")
+		}
+		arg, err := rr.readBulk(size)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readBulk 读取size字节的参数以及结尾的\r\n
+// 大参数每次最多读取PROTO_MBULK_BIG_ARG字节，缓冲区随着数据到达成倍增长
+// 客户端声明很大的长度却不发送数据时不会占用声明长度的内存
+func (rr *RequestReader) readBulk(size int64) ([]byte, error) {
+	total := int(size + 2)
+	alloc := total
+	if alloc > PROTO_MBULK_BIG_ARG {
+		alloc = PROTO_MBULK_BIG_ARG
+	}
+	arg := make([]byte, 0, alloc)
+	for len(arg) < total {
+		n := total - len(arg)
+		if n > PROTO_MBULK_BIG_ARG {
+			n = PROTO_MBULK_BIG_ARG
+		}
+		if cap(arg)-len(arg) < n {
+			grow := 2 * cap(arg)
+			if grow > total {
+				grow = total
+			}
+			buf := make([]byte, len(arg), grow)
+			copy(buf, arg)
+			arg = buf
+		}
+		start := len(arg)
+		arg = arg[:start+n]
+		if _, err := io.ReadFull(rr.r, arg[start:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	if arg[size] != '\r' || arg[size+1] != '\n' {
+		return nil, protocolError("invalid bulk format")
+	}
+	return arg[:size], nil
+}
+
+// splitArgs 按照空白分隔inline请求，支持双引号(含转义)和单引号，同redis的sdssplitargs
+func splitArgs(line []byte) ([][]byte, bool) {
+	args := [][]byte{}
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, true
+		}
+		var arg []byte
+		inDouble, inSingle := false, false
+		for ; i < len(line); i++ {
+			c := line[i]
+			if inDouble {
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]) {
+					v, _ := strconv.ParseUint(string(line[i+2:i+4]), 16, 8)
+					arg = append(arg, byte(v))
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, line[i])
+					}
+				} else if c == '"' {
+					// 闭合的引号之后必须是空白或者结尾
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, false
+					}
+					inDouble = false
+					i++
+					break
+				} else {
+					arg = append(arg, c)
+				}
+			} else if inSingle {
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					arg = append(arg, '\'')
+					i++
+				} else if c == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, false
+					}
+					inSingle = false
+					i++
+					break
+				} else {
+					arg = append(arg, c)
+				}
+			} else {
+				if isSpace(c) {
+					break
+				}
+				if c == '"' {
+					inDouble = true
+				} else if c == '\'' {
+					inSingle = true
+				} else {
+					arg = append(arg, c)
+				}
+			}
+		}
+		if inDouble || inSingle {
+			return nil, false
+		}
+		if arg == nil {
+			arg = []byte{}
+		}
+		args = append(args, arg)
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// ReplyWriter 写回复，回复先写入缓冲区，调用Flush之后发送给客户端
+type ReplyWriter struct {
+	w *bufio.Writer
+}
+
+func NewReplyWriter(w *bufio.Writer) *ReplyWriter {
+	return &ReplyWriter{w: w}
+}
+
+// WriteSimpleString +OK\r\n 内容中不能包含\r\n
+func (rw *ReplyWriter) WriteSimpleString(s string) error {
+	return rw.writeLine('+', stripCRLF(s))
+}
+
+// WriteError -ERR message\r\n msg需要包含错误前缀，如ERR、WRONGTYPE
+func (rw *ReplyWriter) WriteError(msg string) error {
+	return rw.writeLine('-', stripCRLF(msg))
+}
+
+// WriteInt :1\r\n
+func (rw *ReplyWriter) WriteInt(n int64) error {
+	return rw.writeLine(':', strconv.FormatInt(n, 10))
+}
+
+// WriteBulk $3\r\nfoo\r\n
+func (rw *ReplyWriter) WriteBulk(b []byte) error {
+	if err := rw.writeLine('$', strconv.Itoa(len(b))); err != nil {
+		return err
+	}
+	if _, err := rw.w.Write(b); err != nil {
+		return err
+	}
+	_, err := rw.w.WriteString("\r\n")
+	return err
+}
+
+func (rw *ReplyWriter) WriteBulkString(s string) error {
+	return rw.WriteBulk([]byte(s))
+}
+
+// WriteNil $-1\r\n 键不存在
+func (rw *ReplyWriter) WriteNil() error {
+	_, err := rw.w.WriteString("$-1\r\n")
+	return err
+}
+
+// WriteArray *n\r\n 之后需要再写入n个元素
+func (rw *ReplyWriter) WriteArray(n int) error {
+	return rw.writeLine('*', strconv.Itoa(n))
+}
+
+// WriteNilArray *-1\r\n 如BLPOP超时
+func (rw *ReplyWriter) WriteNilArray() error {
+	_, err := rw.w.WriteString("*-1\r\n")
+	return err
+}
+
+// WriteBulkArray 写入批量字符串组成的数组，nil元素写为$-1
+func (rw *ReplyWriter) WriteBulkArray(items [][]byte) error {
+	if err := rw.WriteArray(len(items)); err != nil {
+		return err
+	}
+	for _, item := range items {
+		var err error
+		if item == nil {
+			err = rw.WriteNil()
+		} else {
+			err = rw.WriteBulk(item)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rw *ReplyWriter) Flush() error {
+	return rw.w.Flush()
+}
+
+func (rw *ReplyWriter) writeLine(prefix byte, s string) error {
+	if err := rw.w.WriteByte(prefix); err != nil {
+		return err
+	}
+	if _, err := rw.w.WriteString(s); err != nil {
+		return err
+	}
+	_, err := rw.w.WriteString("\r\n")
+	return err
+}
+
+func stripCRLF(s string) string {
+	if !bytes.ContainsAny([]byte(s), "\r\n") {
+		return s
+	}
+	return string(bytes.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, []byte(s)))
+}
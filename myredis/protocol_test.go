@@ -0,0 +1,112 @@
+package myredis
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func readRequests(t *testing.T, input string) ([][][]byte, error) {
+	rr := NewRequestReader(bufio.NewReader(strings.NewReader(input)))
+	var requests [][][]byte
+	for {
+		args, err := rr.ReadRequest()
+		if err == io.EOF {
+			return requests, nil
+		}
+		if err != nil {
+			return requests, err
+		}
+		requests = append(requests, args)
+	}
+}
+
+func TestReadRequest(t *testing.T) {
+	requests, err := readRequests(t, "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\na\r\nb \r\nPING\r\n\r\nset \"a b\" 'c d' \"\\x41\\n\"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{{"SET", "k", "a\r\nb "}, {"PING"}, {}, {"set", "a b", "c d", "A\n"}}
+	if len(requests) != len(expected) {
+		t.Fatalf("requests %q", requests)
+	}
+	for i, args := range requests {
+		if len(args) != len(expected[i]) {
+			t.Fatalf("request %d args %q", i, args)
+		}
+		for j, arg := range args {
+			if string(arg) != expected[i][j] {
+				t.Fatalf("request %d arg %d %q != %q", i, j, arg, expected[i][j])
+			}
+		}
+	}
+}
+
+func TestReadRequestProtocolError(t *testing.T) {
+	cases := map[string]string{
+		"*1\r\n+PING\r\n":     "ERR Protocol error: expected '$', got '+'",
+		"*x\r\n":              "ERR Protocol error: invalid multibulk length",
+		"*1\r\n$-3\r\n":       "ERR Protocol error: invalid bulk length",
+		"*1\r\n$2\r\nabc\r\n": "ERR Protocol error: invalid bulk format",
+		"set \"a\r\n":         "ERR Protocol error: unbalanced quotes in request",
+		"*2000000\r\n":        "ERR Protocol error: invalid multibulk length",
+		strings.Repeat("a", PROTO_INLINE_MAX_SIZE+1): "ERR Protocol error: too big inline request",
+	}
+	for input, msg := range cases {
+		_, err := readRequests(t, input)
+		if err == nil || !IsProtocolError(err) || err.Error() != msg {
+			t.Fatalf("input %q err %v", input, err)
+		}
+	}
+
+	rr := NewRequestReader(bufio.NewReader(strings.NewReader("*1\r\n$10\r\nabc\r\n")))
+	if _, err := rr.ReadRequest(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("truncated bulk err %v", err)
+	}
+}
+
+// TestReadRequestBigArg 大参数分块读取，声明的长度不会在数据到达之前分配
+func TestReadRequestBigArg(t *testing.T) {
+	big := strings.Repeat("x", 3*PROTO_MBULK_BIG_ARG+7)
+	requests, err := readRequests(t, "*2\r\n$3\r\nSET\r\n$"+strconv.Itoa(len(big))+"\r\n"+big+"\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || len(requests[0]) != 2 || string(requests[0][1]) != big {
+		t.Fatalf("big arg request %d", len(requests))
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	rr := NewRequestReader(bufio.NewReader(strings.NewReader("*1000000\r\n$536870912\r\nabc")))
+	if _, err := rr.ReadRequest(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("truncated request err %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Fatalf("allocated %d bytes for a truncated request", alloc)
+	}
+}
+
+func TestReplyWriter(t *testing.T) {
+	var buf bytes.Buffer
+	rw := NewReplyWriter(bufio.NewWriter(&buf))
+	rw.WriteSimpleString("OK")
+	rw.WriteError("ERR bad\r\nline")
+	rw.WriteInt(-7)
+	rw.WriteBulkString("foo")
+	rw.WriteNil()
+	rw.WriteNilArray()
+	rw.WriteBulkArray([][]byte{[]byte("a"), nil, {}})
+	if err := rw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "+OK\r\n-ERR bad  line\r\n:-7\r\n$3\r\nfoo\r\n$-1\r\n*-1\r\n*3\r\n$1\r\na\r\n$-1\r\n$0\r\n\r\n"
+	if buf.String() != expected {
+		t.Fatalf("reply %q", buf.String())
+	}
+}
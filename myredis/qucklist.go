@@ -1,3 +1,968 @@
 package myredis
 
+import (
+	"math"
+	"strconv"
+)
+
 // 快速表，由压缩表和双端表组成
+// 双端链表的每个节点保存一个压缩表(或者紧凑列表)，兼顾了双端链表两端操作O(1)和压缩表节约内存的优点
+// list-max-ziplist-size(fill) 限制每个节点的大小
+//   正数: 每个节点最多保存的元素个数
+//   负数: -1到-5 每个节点最多占用4k 8k 16k 32k 64k字节
+// list-compress-depth(compress) 两端各有compress个节点不压缩，中间的节点使用LZF压缩，0表示不压缩
+//   列表两端的访问最频繁，中间的节点很少访问
+
+const (
+	QUICKLIST_HEAD = 0
+	QUICKLIST_TAIL = -1
+
+	QUICKLIST_NODE_ENCODING_RAW = 1 // 没有压缩
+	QUICKLIST_NODE_ENCODING_LZF = 2 // LZF压缩
+
+	QUICKLIST_NODE_CONTAINER_ZIPLIST  = 2 // 节点使用压缩表
+	QUICKLIST_NODE_CONTAINER_LISTPACK = 3 // 节点使用紧凑列表
+
+	AL_START_HEAD = 0 // 从表头向表尾迭代
+	AL_START_TAIL = 1 // 从表尾向表头迭代
+
+	QUICKLIST_FILL_MAX     = 1 << 15
+	QUICKLIST_COMPRESS_MAX = 1<<16 - 1
+
+	SIZE_SAFETY_LIMIT    = 8192 // fill为正数时节点的最大字节数
+	MIN_COMPRESS_BYTES   = 48   // 小于这个大小的节点不压缩
+	MIN_COMPRESS_IMPROVE = 8    // 压缩之后至少节约的字节数
+)
+
+// optimizationLevel fill为-1到-5时节点的最大字节数
+var optimizationLevel = []int{4096, 8192, 16384, 32768, 65536}
+
+// QuicklistNode 快速表的节点
+type QuicklistNode struct {
+	prev       *QuicklistNode
+	next       *QuicklistNode
+	zl         []byte // 压缩表或者紧凑列表，节点被压缩时为nil
+	lzf        []byte // 压缩之后的数据
+	sz         int    // 压缩表的字节数，压缩之后也保存原始的大小
+	count      int    // 元素个数
+	encoding   int    // QUICKLIST_NODE_ENCODING_*
+	recompress bool   // 临时解压使用，使用之后需要重新压缩
+}
+
+// Quicklist 快速表
+type Quicklist struct {
+	head      *QuicklistNode
+	tail      *QuicklistNode
+	count     int // 所有节点的元素个数
+	len       int // 节点个数
+	fill      int // 节点的大小限制 list-max-ziplist-size
+	compress  int // 两端不压缩的节点数 list-compress-depth
+	container int // QUICKLIST_NODE_CONTAINER_*
+
+	alloc *allocator // 快速表以及节点使用的分配器
+}
+
+// QuicklistEntry 快速表中的一个元素
+// 字符串保存在value中，和节点共享内存，整数保存在longval中
+type QuicklistEntry struct {
+	quicklist *Quicklist
+	node      *QuicklistNode
+	zi        int    // 元素在节点中的偏移量，-1表示不存在
+	value     []byte // 字符串元素，整数元素为nil
+	longval   int64  // 整数元素
+	offset    int    // 元素在节点中的索引
+}
+
+// QuicklistIter 快速表的迭代器，迭代过程中可以使用QuicklistDelEntry删除元素
+type QuicklistIter struct {
+	quicklist *Quicklist
+	current   *QuicklistNode
+	zi        int // 当前元素在节点中的偏移量，-1表示需要根据offset重新查找
+	offset    int // 当前元素在节点中的索引，从表尾迭代时为负数
+	direction int
+}
+
+// QuicklistCreate 使用默认配置创建快速表 fill=-2 不压缩
+func QuicklistCreate(alloc *allocator) *Quicklist {
+	return QuicklistNew(alloc, -2, 0)
+}
+
+// QuicklistNew 创建节点使用压缩表的快速表
+func QuicklistNew(alloc *allocator, fill, compress int) *Quicklist {
+	return quicklistNew(alloc, fill, compress, QUICKLIST_NODE_CONTAINER_ZIPLIST)
+}
+
+// QuicklistNewListpack 创建节点使用紧凑列表的快速表
+func QuicklistNewListpack(alloc *allocator, fill, compress int) *Quicklist {
+	return quicklistNew(alloc, fill, compress, QUICKLIST_NODE_CONTAINER_LISTPACK)
+}
+
+func quicklistNew(alloc *allocator, fill, compress, container int) *Quicklist {
+	ql := &Quicklist{container: container, alloc: alloc}
+	QuicklistSetOptions(ql, fill, compress)
+	alloc.zmalloc(QUICKLIST_OVERHEAD)
+	return ql
+}
+
+// QuicklistSetFill 设置节点的大小限制
+func QuicklistSetFill(ql *Quicklist, fill int) {
+	if fill > QUICKLIST_FILL_MAX {
+		fill = QUICKLIST_FILL_MAX
+	} else if fill < -len(optimizationLevel) {
+		fill = -len(optimizationLevel)
+	}
+	ql.fill = fill
+}
+
+// QuicklistSetCompressDepth 设置两端不压缩的节点数
+func QuicklistSetCompressDepth(ql *Quicklist, compress int) {
+	if compress > QUICKLIST_COMPRESS_MAX {
+		compress = QUICKLIST_COMPRESS_MAX
+	} else if compress < 0 {
+		compress = 0
+	}
+	ql.compress = compress
+}
+
+// QuicklistSetOptions 设置fill和compress
+func QuicklistSetOptions(ql *Quicklist, fill, compress int) {
+	QuicklistSetFill(ql, fill)
+	QuicklistSetCompressDepth(ql, compress)
+}
+
+// QuicklistCount 元素的个数
+func QuicklistCount(ql *Quicklist) int {
+	return ql.count
+}
+
+// QuicklistRelease 释放快速表以及所有的节点
+func QuicklistRelease(ql *Quicklist) {
+	for node := ql.head; node != nil; {
+		next := node.next
+		ql.freeNode(node)
+		node = next
+	}
+	ql.head, ql.tail = nil, nil
+	ql.count, ql.len = 0, 0
+	ql.alloc.zfree(QUICKLIST_OVERHEAD)
+}
+
+// 节点的容器操作，根据快速表的container使用压缩表或者紧凑列表
+// 元素的位置为在容器中的偏移量，-1表示不存在
+
+func (ql *Quicklist) packNew() []byte {
+	if ql.container == QUICKLIST_NODE_CONTAINER_LISTPACK {
+		return LpNew(ql.alloc).buf
+	}
+	return ZiplistNew(ql.alloc)
+}
+
+// listpack 使用快速表的分配器操作节点中的紧凑列表
+func (ql *Quicklist) listpack(zl []byte) *Listpack {
+	return &Listpack{buf: zl, alloc: ql.alloc}
+}
+
+// packHeaderSize 空容器的字节数，合并两个节点时减去一个头部
+func (ql *Quicklist) packHeaderSize() int {
+	if ql.container == QUICKLIST_NODE_CONTAINER_LISTPACK {
+		return LP_HDR_SIZE + 1
+	}
+	return ZIPLIST_HEADER_SIZE + ZIPLIST_END_SIZE
+}
+
+func (ql *Quicklist) packPush(zl []byte, value []byte, where int) []byte {
+	if ql.container == QUICKLIST_NODE_CONTAINER_LISTPACK {
+		lp := ql.listpack(zl)
+		if where == QUICKLIST_HEAD {
+			LpPrepend(lp, value)
+		} else {
+			LpAppend(lp, value)
+		}
+		return lp.buf
+	}
+	if where == QUICKLIST_HEAD {
+		return ZiplistPush(ql.alloc, zl, value, ZIPLIST_HEAD)
+	}
+	return ZiplistPush(ql.alloc, zl, value, ZIPLIST_TAIL)
+}
+
+// packInsert 插入到p之前
+func (ql *Quicklist) packInsert(zl []byte, p int, value []byte) []byte {
+	if ql.container == QUICKLIST_NODE_CONTAINER_LISTPACK {
+		lp := ql.listpack(zl)
+		LpInsert(lp, value, p, LP_BEFORE)
+		return lp.buf
+	}
+	return ZiplistInsert(ql.alloc, zl, p, value)
+}
+
+// packReplace 替换p处的元素
+func (ql *Quicklist) packReplace(zl []byte, p int, value []byte) []byte {
+	if ql.container == QUICKLIST_NODE_CONTAINER_LISTPACK {
+		lp := ql.listpack(zl)
+		LpReplace(lp, p, value)
+		return lp.buf
+	}
+	return ZiplistReplace(ql.alloc, zl, p, value)
+}
+
+// packDelete 删除p处的元素，之后p指向下一个元素或者结束标记
+func (ql *Quicklist) packDelete(zl []byte, p int) []byte {
+	if ql.container == QUICKLIST_NODE_CONTAINER_LISTPACK {
+		lp := ql.listpack(zl)
+		LpDelete(lp, p)
+		return lp.buf
+	}
+	return ZiplistDelete(ql.alloc, zl, p)
+}
+
+// packDeleteRange 从index开始删除num个元素
+func (ql *Quicklist) packDeleteRange(zl []byte, index, num int) []byte {
+	if ql.container == QUICKLIST_NODE_CONTAINER_LISTPACK {
+		lp := ql.listpack(zl)
+		LpDeleteRange(lp, index, num)
+		return lp.buf
+	}
+	return ZiplistDeleteRange(ql.alloc, zl, index, num)
+}
+
+func (ql *Quicklist) packIndex(zl []byte, index int) int {
+	if ql.container == QUICKLIST_NODE_CONTAINER_LISTPACK {
+		return LpSeek(ql.listpack(zl), index)
+	}
+	return ZiplistIndex(zl, index)
+}
+
+func (ql *Quicklist) packNext(zl []byte, p int) int {
+	if ql.container == QUICKLIST_NODE_CONTAINER_LISTPACK {
+		return LpNext(ql.listpack(zl), p)
+	}
+	return ZiplistNext(zl, p)
+}
+
+func (ql *Quicklist) packPrev(zl []byte, p int) int {
+	if ql.container == QUICKLIST_NODE_CONTAINER_LISTPACK {
+		return LpPrev(ql.listpack(zl), p)
+	}
+	return ZiplistPrev(zl, p)
+}
+
+func (ql *Quicklist) packGet(zl []byte, p int) ([]byte, int64, bool) {
+	if ql.container == QUICKLIST_NODE_CONTAINER_LISTPACK {
+		return LpGet(ql.listpack(zl), p)
+	}
+	return ZiplistGet(zl, p)
+}
+
+func (ql *Quicklist) packLen(zl []byte) int {
+	if ql.container == QUICKLIST_NODE_CONTAINER_LISTPACK {
+		return LpLength(ql.listpack(zl))
+	}
+	return ZiplistLen(zl)
+}
+
+// quicklistCreateNode 创建空节点
+func (ql *Quicklist) createNode() *QuicklistNode {
+	ql.alloc.zmalloc(QUICKLIST_NODE_OVERHEAD)
+	return &QuicklistNode{encoding: QUICKLIST_NODE_ENCODING_RAW}
+}
+
+// freeNode 释放节点以及节点的数据
+func (ql *Quicklist) freeNode(node *QuicklistNode) {
+	if node.encoding == QUICKLIST_NODE_ENCODING_LZF {
+		ql.alloc.zfree(QUICKLIST_LZF_OVERHEAD + len(node.lzf))
+	} else {
+		ql.alloc.zfree(len(node.zl))
+	}
+	ql.alloc.zfree(QUICKLIST_NODE_OVERHEAD)
+	node.zl, node.lzf = nil, nil
+}
+
+func (node *QuicklistNode) updateSz() {
+	node.sz = len(node.zl)
+}
+
+// compressNode 使用LZF压缩节点，太小或者压缩之后没有节约足够的空间时不压缩
+func (node *QuicklistNode) compressNode(alloc *allocator) bool {
+	if node.sz < MIN_COMPRESS_BYTES {
+		return false
+	}
+	out := make([]byte, node.sz)
+	n := lzfCompress(node.zl, out)
+	if n == 0 || n+MIN_COMPRESS_IMPROVE >= node.sz {
+		return false
+	}
+	node.lzf = append([]byte(nil), out[:n]...)
+	alloc.zmalloc(QUICKLIST_LZF_OVERHEAD + n)
+	alloc.zfree(len(node.zl))
+	node.zl = nil
+	node.encoding = QUICKLIST_NODE_ENCODING_LZF
+	node.recompress = false
+	return true
+}
+
+// decompressNode 解压节点
+func (node *QuicklistNode) decompressNode(alloc *allocator) bool {
+	raw := make([]byte, node.sz)
+	if lzfDecompress(node.lzf, raw) != node.sz {
+		return false
+	}
+	alloc.zmalloc(node.sz)
+	alloc.zfree(QUICKLIST_LZF_OVERHEAD + len(node.lzf))
+	node.zl = raw
+	node.lzf = nil
+	node.encoding = QUICKLIST_NODE_ENCODING_RAW
+	return true
+}
+
+// quicklistCompressNode 压缩没有压缩的节点
+func quicklistCompressNode(alloc *allocator, node *QuicklistNode) {
+	if node != nil && node.encoding == QUICKLIST_NODE_ENCODING_RAW {
+		node.compressNode(alloc)
+	}
+}
+
+// quicklistDecompressNode 解压被压缩的节点
+func quicklistDecompressNode(alloc *allocator, node *QuicklistNode) {
+	if node != nil && node.encoding == QUICKLIST_NODE_ENCODING_LZF {
+		node.decompressNode(alloc)
+	}
+}
+
+// quicklistDecompressNodeForUse 临时解压节点，使用之后调用quicklistRecompressOnly重新压缩
+func quicklistDecompressNodeForUse(alloc *allocator, node *QuicklistNode) {
+	if node != nil && node.encoding == QUICKLIST_NODE_ENCODING_LZF {
+		node.decompressNode(alloc)
+		node.recompress = true
+	}
+}
+
+// quicklistRecompressOnly 重新压缩临时解压的节点
+func quicklistRecompressOnly(alloc *allocator, node *QuicklistNode) {
+	if node != nil && node.recompress {
+		quicklistCompressNode(alloc, node)
+	}
+}
+
+// allowsCompression 是否开启了压缩
+func (ql *Quicklist) allowsCompression() bool {
+	return ql.compress != 0
+}
+
+// __quicklistCompress 保证两端compress个节点没有压缩，node不在两端时压缩node
+// 节点的数量没有超过compress*2时不压缩
+func (ql *Quicklist) compressAround(node *QuicklistNode) {
+	if !ql.allowsCompression() || ql.len < ql.compress*2 {
+		return
+	}
+	forward, reverse := ql.head, ql.tail
+	inDepth := false
+	for depth := 0; depth < ql.compress; depth++ {
+		quicklistDecompressNode(ql.alloc, forward)
+		quicklistDecompressNode(ql.alloc, reverse)
+		if forward == node || reverse == node {
+			inDepth = true
+		}
+		// 所有的节点都在两端的范围内
+		if forward == reverse || forward.next == reverse {
+			return
+		}
+		forward = forward.next
+		reverse = reverse.prev
+	}
+	if !inDepth && node != nil {
+		quicklistCompressNode(ql.alloc, node)
+	}
+	// 刚刚超出两端范围的节点
+	quicklistCompressNode(ql.alloc, forward)
+	quicklistCompressNode(ql.alloc, reverse)
+}
+
+// quicklistCompress 压缩节点，临时解压的节点直接重新压缩
+func (ql *Quicklist) quicklistCompress(node *QuicklistNode) {
+	if node.recompress {
+		quicklistCompressNode(ql.alloc, node)
+	} else {
+		ql.compressAround(node)
+	}
+}
+
+// insertNode 把newNode插入到oldNode之后(after为true)或者之前
+func (ql *Quicklist) insertNode(oldNode, newNode *QuicklistNode, after bool) {
+	if after {
+		newNode.prev = oldNode
+		if oldNode != nil {
+			newNode.next = oldNode.next
+			if oldNode.next != nil {
+				oldNode.next.prev = newNode
+			}
+			oldNode.next = newNode
+		}
+		if ql.tail == oldNode {
+			ql.tail = newNode
+		}
+	} else {
+		newNode.next = oldNode
+		if oldNode != nil {
+			newNode.prev = oldNode.prev
+			if oldNode.prev != nil {
+				oldNode.prev.next = newNode
+			}
+			oldNode.prev = newNode
+		}
+		if ql.head == oldNode {
+			ql.head = newNode
+		}
+	}
+	if ql.len == 0 {
+		ql.head, ql.tail = newNode, newNode
+	}
+	// 先更新节点数，压缩时需要准确的节点数
+	ql.len++
+	if oldNode != nil {
+		ql.quicklistCompress(oldNode)
+	}
+}
+
+// delNode 删除节点，元素个数减去节点的元素个数
+func (ql *Quicklist) delNode(node *QuicklistNode) {
+	if node.next != nil {
+		node.next.prev = node.prev
+	}
+	if node.prev != nil {
+		node.prev.next = node.next
+	}
+	if node == ql.tail {
+		ql.tail = node.prev
+	}
+	if node == ql.head {
+		ql.head = node.next
+	}
+	ql.len--
+	ql.count -= node.count
+	// 删除之后重新调整两端不压缩的节点
+	ql.compressAround(nil)
+	ql.freeNode(node)
+}
+
+// sizeMeetsOptimizationRequirement fill为负数时sz是否满足大小限制
+func sizeMeetsOptimizationRequirement(sz, fill int) bool {
+	if fill >= 0 {
+		return false
+	}
+	offset := -fill - 1
+	return offset < len(optimizationLevel) && sz <= optimizationLevel[offset]
+}
+
+// nodeAllowInsert 节点插入长度为sz的元素之后是否满足fill的限制
+func (ql *Quicklist) nodeAllowInsert(node *QuicklistNode, sz int) bool {
+	if node == nil {
+		return false
+	}
+	// 估算元素头部的大小
+	overhead := 1
+	if sz >= 254 {
+		overhead = 5
+	}
+	if sz < 64 {
+		overhead++
+	} else if sz < 16384 {
+		overhead += 2
+	} else {
+		overhead += 5
+	}
+	newSz := node.sz + sz + overhead
+	if sizeMeetsOptimizationRequirement(newSz, ql.fill) {
+		return true
+	}
+	if newSz > SIZE_SAFETY_LIMIT {
+		return false
+	}
+	return node.count < ql.fill
+}
+
+// nodeAllowMerge 两个节点合并之后是否满足fill的限制
+func (ql *Quicklist) nodeAllowMerge(a, b *QuicklistNode) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	mergeSz := a.sz + b.sz - ql.packHeaderSize()
+	if sizeMeetsOptimizationRequirement(mergeSz, ql.fill) {
+		return true
+	}
+	if mergeSz > SIZE_SAFETY_LIMIT {
+		return false
+	}
+	return a.count+b.count <= ql.fill
+}
+
+// pushHead 插入到表头，表头节点已满时创建新的节点，返回是否创建了新的节点
+func (ql *Quicklist) pushHead(value []byte) bool {
+	origHead := ql.head
+	if ql.nodeAllowInsert(ql.head, len(value)) {
+		ql.head.zl = ql.packPush(ql.head.zl, value, QUICKLIST_HEAD)
+		ql.head.updateSz()
+	} else {
+		node := ql.createNode()
+		node.zl = ql.packPush(ql.packNew(), value, QUICKLIST_HEAD)
+		node.updateSz()
+		ql.insertNode(ql.head, node, false)
+	}
+	ql.count++
+	ql.head.count++
+	return origHead != ql.head
+}
+
+// pushTail 插入到表尾，表尾节点已满时创建新的节点，返回是否创建了新的节点
+func (ql *Quicklist) pushTail(value []byte) bool {
+	origTail := ql.tail
+	if ql.nodeAllowInsert(ql.tail, len(value)) {
+		ql.tail.zl = ql.packPush(ql.tail.zl, value, QUICKLIST_TAIL)
+		ql.tail.updateSz()
+	} else {
+		node := ql.createNode()
+		node.zl = ql.packPush(ql.packNew(), value, QUICKLIST_TAIL)
+		node.updateSz()
+		ql.insertNode(ql.tail, node, true)
+	}
+	ql.count++
+	ql.tail.count++
+	return origTail != ql.tail
+}
+
+// QuicklistPushHead 插入到表头，返回是否创建了新的节点
+func QuicklistPushHead(ql *Quicklist, value []byte) bool {
+	return ql.pushHead(value)
+}
+
+// QuicklistPushTail 插入到表尾，返回是否创建了新的节点
+func QuicklistPushTail(ql *Quicklist, value []byte) bool {
+	return ql.pushTail(value)
+}
+
+// QuicklistPush where为QUICKLIST_HEAD插入到表头，QUICKLIST_TAIL插入到表尾
+func QuicklistPush(ql *Quicklist, value []byte, where int) {
+	if where == QUICKLIST_HEAD {
+		ql.pushHead(value)
+	} else if where == QUICKLIST_TAIL {
+		ql.pushTail(value)
+	}
+}
+
+// delIndex 删除node中p处的元素，节点为空时删除节点，返回节点是否被删除
+func (ql *Quicklist) delIndex(node *QuicklistNode, p int) bool {
+	node.zl = ql.packDelete(node.zl, p)
+	node.count--
+	gone := false
+	if node.count == 0 {
+		gone = true
+		ql.delNode(node)
+	} else {
+		node.updateSz()
+	}
+	ql.count--
+	return gone
+}
+
+// QuicklistDelEntry 删除迭代器返回的元素，删除之后迭代器仍然有效
+func QuicklistDelEntry(iter *QuicklistIter, entry *QuicklistEntry) {
+	prev, next := entry.node.prev, entry.node.next
+	deletedNode := entry.quicklist.delIndex(entry.node, entry.zi)
+	// 删除之后偏移量失效，下一次迭代根据offset重新查找
+	// 没有删除节点时offset不需要改变，下一个元素移动到了当前的位置
+	iter.zi = -1
+	if deletedNode {
+		if iter.direction == AL_START_HEAD {
+			iter.current = next
+			iter.offset = 0
+		} else {
+			iter.current = prev
+			iter.offset = -1
+		}
+	}
+}
+
+// QuicklistReplaceAtIndex 替换索引为index的元素，索引不存在时返回false
+func QuicklistReplaceAtIndex(ql *Quicklist, index int, value []byte) bool {
+	var entry QuicklistEntry
+	if !QuicklistIndex(ql, index, &entry) {
+		return false
+	}
+	entry.node.zl = ql.packReplace(entry.node.zl, entry.zi, value)
+	entry.node.updateSz()
+	ql.quicklistCompress(entry.node)
+	return true
+}
+
+// splitNode 在offset处把节点分成两个节点，返回新的节点
+// after为true时node保留[0, offset]，新节点保存之后的元素，否则node保留[offset, end]，新节点保存之前的元素
+func (ql *Quicklist) splitNode(node *QuicklistNode, offset int, after bool) *QuicklistNode {
+	newNode := ql.createNode()
+	newNode.zl = append([]byte(nil), node.zl...)
+	ql.alloc.zmalloc(len(newNode.zl))
+
+	origStart, origExtent := 0, offset
+	newStart, newExtent := offset, math.MaxInt32
+	if after {
+		origStart, origExtent = offset+1, math.MaxInt32
+		newStart, newExtent = 0, offset+1
+	}
+
+	node.zl = ql.packDeleteRange(node.zl, origStart, origExtent)
+	node.count = ql.packLen(node.zl)
+	node.updateSz()
+
+	newNode.zl = ql.packDeleteRange(newNode.zl, newStart, newExtent)
+	newNode.count = ql.packLen(newNode.zl)
+	newNode.updateSz()
+	return newNode
+}
+
+// mergeInto 把b的元素追加到a，删除b，返回a
+func (ql *Quicklist) mergeInto(a, b *QuicklistNode) *QuicklistNode {
+	quicklistDecompressNode(ql.alloc, a)
+	quicklistDecompressNode(ql.alloc, b)
+	for p := ql.packIndex(b.zl, 0); p != -1; p = ql.packNext(b.zl, p) {
+		sval, lval, _ := ql.packGet(b.zl, p)
+		if sval == nil {
+			sval = strconv.AppendInt(nil, lval, 10)
+		}
+		a.zl = ql.packPush(a.zl, sval, QUICKLIST_TAIL)
+	}
+	a.count = ql.packLen(a.zl)
+	a.updateSz()
+	b.count = 0
+	ql.delNode(b)
+	ql.quicklistCompress(a)
+	return a
+}
+
+// mergeNodes 尝试合并center附近的节点
+// 依次尝试 (center.prev.prev, center.prev) (center.next, center.next.next) (center.prev, center) (center, center.next)
+func (ql *Quicklist) mergeNodes(center *QuicklistNode) {
+	var prev, prevPrev, next, nextNext *QuicklistNode
+	if center.prev != nil {
+		prev = center.prev
+		prevPrev = center.prev.prev
+	}
+	if center.next != nil {
+		next = center.next
+		nextNext = center.next.next
+	}
+	if ql.nodeAllowMerge(prev, prevPrev) {
+		ql.mergeInto(prevPrev, prev)
+	}
+	if ql.nodeAllowMerge(next, nextNext) {
+		ql.mergeInto(next, nextNext)
+	}
+	target := center
+	if ql.nodeAllowMerge(center, center.prev) {
+		target = ql.mergeInto(center.prev, center)
+	}
+	if ql.nodeAllowMerge(target, target.next) {
+		ql.mergeInto(target, target.next)
+	}
+}
+
+// insert 在entry之前或者之后插入value
+// 1 节点没有满时直接插入
+// 2 插入到节点的两端并且相邻的节点没有满时插入到相邻的节点
+// 3 插入到节点的两端并且相邻的节点也满了时创建新的节点
+// 4 插入到节点的中间时分裂节点，插入之后尝试合并附近的节点
+func (ql *Quicklist) insert(entry *QuicklistEntry, value []byte, after bool) {
+	node := entry.node
+	if node == nil {
+		newNode := ql.createNode()
+		newNode.zl = ql.packPush(ql.packNew(), value, QUICKLIST_HEAD)
+		newNode.updateSz()
+		newNode.count++
+		ql.insertNode(nil, newNode, after)
+		ql.count++
+		return
+	}
+
+	// 从表尾迭代或者查找时offset为负数
+	offset := entry.offset
+	if offset < 0 {
+		offset += node.count
+	}
+	full, atTail, atHead, fullNext, fullPrev := false, false, false, false, false
+	if !ql.nodeAllowInsert(node, len(value)) {
+		full = true
+	}
+	if after && offset == node.count-1 {
+		atTail = true
+		if !ql.nodeAllowInsert(node.next, len(value)) {
+			fullNext = true
+		}
+	}
+	if !after && offset == 0 {
+		atHead = true
+		if !ql.nodeAllowInsert(node.prev, len(value)) {
+			fullPrev = true
+		}
+	}
+
+	switch {
+	case !full && after:
+		quicklistDecompressNodeForUse(ql.alloc, node)
+		if next := ql.packNext(node.zl, entry.zi); next == -1 {
+			node.zl = ql.packPush(node.zl, value, QUICKLIST_TAIL)
+		} else {
+			node.zl = ql.packInsert(node.zl, next, value)
+		}
+		node.count++
+		node.updateSz()
+		quicklistRecompressOnly(ql.alloc, node)
+	case !full && !after:
+		quicklistDecompressNodeForUse(ql.alloc, node)
+		node.zl = ql.packInsert(node.zl, entry.zi, value)
+		node.count++
+		node.updateSz()
+		quicklistRecompressOnly(ql.alloc, node)
+	case full && atTail && node.next != nil && !fullNext && after:
+		newNode := node.next
+		quicklistDecompressNodeForUse(ql.alloc, newNode)
+		newNode.zl = ql.packPush(newNode.zl, value, QUICKLIST_HEAD)
+		newNode.count++
+		newNode.updateSz()
+		quicklistRecompressOnly(ql.alloc, newNode)
+	case full && atHead && node.prev != nil && !fullPrev && !after:
+		newNode := node.prev
+		quicklistDecompressNodeForUse(ql.alloc, newNode)
+		newNode.zl = ql.packPush(newNode.zl, value, QUICKLIST_TAIL)
+		newNode.count++
+		newNode.updateSz()
+		quicklistRecompressOnly(ql.alloc, newNode)
+	case full && ((atTail && node.next != nil && fullNext && after) || (atHead && node.prev != nil && fullPrev && !after)):
+		newNode := ql.createNode()
+		newNode.zl = ql.packPush(ql.packNew(), value, QUICKLIST_HEAD)
+		newNode.count++
+		newNode.updateSz()
+		ql.insertNode(node, newNode, after)
+	default:
+		// 分裂节点
+		quicklistDecompressNodeForUse(ql.alloc, node)
+		newNode := ql.splitNode(node, offset, after)
+		if after {
+			newNode.zl = ql.packPush(newNode.zl, value, QUICKLIST_HEAD)
+		} else {
+			newNode.zl = ql.packPush(newNode.zl, value, QUICKLIST_TAIL)
+		}
+		newNode.count++
+		newNode.updateSz()
+		ql.insertNode(node, newNode, after)
+		ql.mergeNodes(node)
+	}
+	ql.count++
+}
+
+// QuicklistInsertBefore 在entry之前插入value
+func QuicklistInsertBefore(ql *Quicklist, entry *QuicklistEntry, value []byte) {
+	ql.insert(entry, value, false)
+}
+
+// QuicklistInsertAfter 在entry之后插入value
+func QuicklistInsertAfter(ql *Quicklist, entry *QuicklistEntry, value []byte) {
+	ql.insert(entry, value, true)
+}
+
+// QuicklistDelRange 从start开始删除count个元素，start为负数时从表尾开始，返回删除的元素个数
+func QuicklistDelRange(ql *Quicklist, start, count int) int {
+	if count <= 0 {
+		return 0
+	}
+	extent := count
+	if start >= 0 && extent > ql.count-start {
+		extent = ql.count - start
+	} else if start < 0 && extent > -start {
+		extent = -start
+	}
+
+	var entry QuicklistEntry
+	if !QuicklistIndex(ql, start, &entry) {
+		return 0
+	}
+	deleted := extent
+	node := entry.node
+	for extent > 0 {
+		next := node.next
+		del := 0
+		deleteEntireNode := false
+		switch {
+		case entry.offset == 0 && extent >= node.count:
+			// 删除整个节点
+			deleteEntireNode = true
+			del = node.count
+		case entry.offset >= 0 && extent+entry.offset >= node.count:
+			// 删除offset之后的所有元素
+			del = node.count - entry.offset
+		case entry.offset < 0:
+			// 负数的offset为从节点的表尾开始，删除到表尾
+			del = -entry.offset
+			if del > extent {
+				del = extent
+			}
+		default:
+			// 删除节点中间的元素
+			del = extent
+		}
+
+		if deleteEntireNode {
+			ql.delNode(node)
+		} else {
+			quicklistDecompressNodeForUse(ql.alloc, node)
+			node.zl = ql.packDeleteRange(node.zl, entry.offset, del)
+			node.updateSz()
+			node.count -= del
+			ql.count -= del
+			if node.count == 0 {
+				ql.delNode(node)
+			} else {
+				quicklistRecompressOnly(ql.alloc, node)
+			}
+		}
+		extent -= del
+		node = next
+		entry.offset = 0
+	}
+	return deleted
+}
+
+// QuicklistIndex 查找索引为index的元素，负数从表尾开始，索引超出范围时返回false
+// 找到的节点如果被压缩会临时解压
+func QuicklistIndex(ql *Quicklist, index int, entry *QuicklistEntry) bool {
+	*entry = QuicklistEntry{quicklist: ql, zi: -1}
+	forward := index >= 0
+	idx := index
+	if !forward {
+		idx = -index - 1
+	}
+	if idx >= ql.count {
+		return false
+	}
+
+	n := ql.head
+	if !forward {
+		n = ql.tail
+	}
+	accum := 0
+	for n != nil {
+		if accum+n.count > idx {
+			break
+		}
+		accum += n.count
+		if forward {
+			n = n.next
+		} else {
+			n = n.prev
+		}
+	}
+	if n == nil {
+		return false
+	}
+
+	entry.node = n
+	if forward {
+		entry.offset = idx - accum
+	} else {
+		entry.offset = -idx - 1 + accum
+	}
+	quicklistDecompressNodeForUse(ql.alloc, n)
+	entry.zi = ql.packIndex(n.zl, entry.offset)
+	entry.value, entry.longval, _ = ql.packGet(n.zl, entry.zi)
+	return true
+}
+
+// QuicklistPop 从表头或者表尾弹出元素，返回的字符串是复制的，空列表返回false
+func QuicklistPop(ql *Quicklist, where int) (value []byte, longval int64, ok bool) {
+	if ql.count == 0 {
+		return nil, 0, false
+	}
+	node, pos := ql.head, 0
+	if where == QUICKLIST_TAIL {
+		node, pos = ql.tail, -1
+	}
+	p := ql.packIndex(node.zl, pos)
+	sval, lval, ok := ql.packGet(node.zl, p)
+	if !ok {
+		return nil, 0, false
+	}
+	if sval != nil {
+		sval = append([]byte{}, sval...)
+	}
+	ql.delIndex(node, p)
+	return sval, lval, true
+}
+
+// QuicklistGetIterator 创建迭代器，direction为AL_START_HEAD从表头开始，AL_START_TAIL从表尾开始
+func QuicklistGetIterator(ql *Quicklist, direction int) *QuicklistIter {
+	iter := &QuicklistIter{quicklist: ql, zi: -1, direction: direction}
+	if direction == AL_START_HEAD {
+		iter.current = ql.head
+		iter.offset = 0
+	} else {
+		iter.current = ql.tail
+		iter.offset = -1
+	}
+	return iter
+}
+
+// QuicklistGetIteratorAtIdx 创建从索引idx开始的迭代器，索引超出范围时返回nil
+func QuicklistGetIteratorAtIdx(ql *Quicklist, direction int, idx int) *QuicklistIter {
+	var entry QuicklistEntry
+	if !QuicklistIndex(ql, idx, &entry) {
+		return nil
+	}
+	iter := QuicklistGetIterator(ql, direction)
+	iter.current = entry.node
+	iter.offset = entry.offset
+	return iter
+}
+
+// QuicklistReleaseIterator 释放迭代器，重新压缩当前的节点
+func QuicklistReleaseIterator(iter *QuicklistIter) {
+	if iter != nil && iter.current != nil {
+		iter.quicklist.quicklistCompress(iter.current)
+	}
+}
+
+// QuicklistNext 返回下一个元素，没有更多的元素时返回false
+// 离开一个节点时重新压缩这个节点
+func QuicklistNext(iter *QuicklistIter, entry *QuicklistEntry) bool {
+	*entry = QuicklistEntry{quicklist: iter.quicklist, node: iter.current, zi: -1}
+	for iter.current != nil {
+		ql := iter.quicklist
+		if iter.zi == -1 {
+			// 根据offset查找
+			quicklistDecompressNodeForUse(ql.alloc, iter.current)
+			iter.zi = ql.packIndex(iter.current.zl, iter.offset)
+		} else if iter.direction == AL_START_HEAD {
+			iter.zi = ql.packNext(iter.current.zl, iter.zi)
+			iter.offset++
+		} else {
+			iter.zi = ql.packPrev(iter.current.zl, iter.zi)
+			iter.offset--
+		}
+
+		if iter.zi != -1 {
+			entry.node = iter.current
+			entry.zi = iter.zi
+			entry.offset = iter.offset
+			entry.value, entry.longval, _ = ql.packGet(iter.current.zl, iter.zi)
+			return true
+		}
+
+		// 当前节点已经迭代完成
+		ql.quicklistCompress(iter.current)
+		if iter.direction == AL_START_HEAD {
+			iter.current = iter.current.next
+			iter.offset = 0
+		} else {
+			iter.current = iter.current.prev
+			iter.offset = -1
+		}
+		entry.node = iter.current
+	}
+	return false
+}
@@ -0,0 +1,383 @@
+package myredis
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func qlEntryString(entry *QuicklistEntry) string {
+	if entry.value == nil {
+		return strconv.FormatInt(entry.longval, 10)
+	}
+	return string(entry.value)
+}
+
+// qlValues 检查快速表的节点并返回所有的元素，同时检查从表尾迭代的结果一致
+func qlValues(t *testing.T, ql *Quicklist) []string {
+	t.Helper()
+	count, nodes := 0, 0
+	for node := ql.head; node != nil; node = node.next {
+		if node.next != nil && node.next.prev != node || node.next == nil && ql.tail != node {
+			t.Fatal("broken links")
+		}
+		zl := node.zl
+		if node.encoding == QUICKLIST_NODE_ENCODING_LZF {
+			zl = make([]byte, node.sz)
+			if lzfDecompress(node.lzf, zl) != node.sz {
+				t.Fatal("invalid compressed node")
+			}
+		} else if node.sz != len(zl) {
+			t.Fatalf("node sz %d len %d", node.sz, len(zl))
+		}
+		if node.count == 0 || ql.packLen(zl) != node.count {
+			t.Fatalf("node count %d", node.count)
+		}
+		count += node.count
+		nodes++
+	}
+	if count != ql.count || nodes != ql.len {
+		t.Fatalf("count %d/%d len %d/%d", count, ql.count, nodes, ql.len)
+	}
+
+	var values []string
+	var entry QuicklistEntry
+	iter := QuicklistGetIterator(ql, AL_START_HEAD)
+	for QuicklistNext(iter, &entry) {
+		values = append(values, qlEntryString(&entry))
+	}
+	QuicklistReleaseIterator(iter)
+	i := len(values) - 1
+	iter = QuicklistGetIterator(ql, AL_START_TAIL)
+	for QuicklistNext(iter, &entry) {
+		if i < 0 || qlEntryString(&entry) != values[i] {
+			t.Fatalf("backward iteration mismatch at %d", i)
+		}
+		i--
+	}
+	QuicklistReleaseIterator(iter)
+	if i != -1 || len(values) != QuicklistCount(ql) {
+		t.Fatalf("iterated %d values, count %d", len(values), QuicklistCount(ql))
+	}
+	return values
+}
+
+// qlContainers 分别使用压缩表和紧凑列表测试
+func qlContainers(t *testing.T, f func(t *testing.T, create func(fill, compress int) *Quicklist)) {
+	t.Run("ziplist", func(t *testing.T) {
+		f(t, func(fill, compress int) *Quicklist { return QuicklistNew(nil, fill, compress) })
+	})
+	t.Run("listpack", func(t *testing.T) {
+		f(t, func(fill, compress int) *Quicklist { return QuicklistNewListpack(nil, fill, compress) })
+	})
+}
+
+func TestQuicklistPushPop(t *testing.T) {
+	qlContainers(t, func(t *testing.T, create func(fill, compress int) *Quicklist) {
+		ql := create(4, 0)
+		for i := 0; i < 10; i++ {
+			QuicklistPushTail(ql, []byte(strconv.Itoa(i)))
+		}
+		if !QuicklistPushHead(ql, []byte("head")) || QuicklistPushHead(ql, []byte("head2")) {
+			t.Fatal("push head should create a node only when the head is full")
+		}
+		if got := strings.Join(qlValues(t, ql), ","); got != "head2,head,0,1,2,3,4,5,6,7,8,9" {
+			t.Fatalf("values %s", got)
+		}
+		if ql.len != 4 {
+			t.Fatalf("nodes %d", ql.len)
+		}
+
+		if v, _, ok := QuicklistPop(ql, QUICKLIST_HEAD); !ok || string(v) != "head2" {
+			t.Fatalf("pop head %q", v)
+		}
+		if v, n, ok := QuicklistPop(ql, QUICKLIST_TAIL); !ok || v != nil || n != 9 {
+			t.Fatalf("pop tail %q %d", v, n)
+		}
+		for QuicklistCount(ql) > 0 {
+			QuicklistPop(ql, QUICKLIST_TAIL)
+		}
+		if _, _, ok := QuicklistPop(ql, QUICKLIST_HEAD); ok || ql.len != 0 || ql.head != nil || ql.tail != nil {
+			t.Fatal("pop empty quicklist")
+		}
+		QuicklistRelease(ql)
+	})
+}
+
+func TestQuicklistFill(t *testing.T) {
+	qlContainers(t, func(t *testing.T, create func(fill, compress int) *Quicklist) {
+		// 按照字节数限制节点的大小
+		ql := create(-1, 0)
+		value := []byte(strings.Repeat("v", 100))
+		for i := 0; i < 500; i++ {
+			QuicklistPushTail(ql, value)
+		}
+		for node := ql.head; node != nil; node = node.next {
+			if node.sz > optimizationLevel[0] {
+				t.Fatalf("node size %d", node.sz)
+			}
+		}
+		if ql.len < 500*100/4096 {
+			t.Fatalf("nodes %d", ql.len)
+		}
+		QuicklistRelease(ql)
+
+		// 按照元素个数限制，大元素超过安全限制时单独使用一个节点
+		ql = create(100, 0)
+		QuicklistPushTail(ql, []byte("small"))
+		QuicklistPushTail(ql, []byte(strings.Repeat("b", SIZE_SAFETY_LIMIT)))
+		QuicklistPushTail(ql, []byte("small"))
+		if ql.len != 3 {
+			t.Fatalf("nodes %d", ql.len)
+		}
+		QuicklistRelease(ql)
+	})
+}
+
+func TestQuicklistIndexInsertReplace(t *testing.T) {
+	qlContainers(t, func(t *testing.T, create func(fill, compress int) *Quicklist) {
+		ql := create(3, 0)
+		for i := 0; i < 9; i++ {
+			QuicklistPushTail(ql, []byte(strconv.Itoa(i)))
+		}
+		var entry QuicklistEntry
+		for i := 0; i < 9; i++ {
+			if !QuicklistIndex(ql, i, &entry) || entry.longval != int64(i) {
+				t.Fatalf("index %d got %d", i, entry.longval)
+			}
+			if !QuicklistIndex(ql, i-9, &entry) || entry.longval != int64(i) {
+				t.Fatalf("index %d got %d", i-9, entry.longval)
+			}
+		}
+		if QuicklistIndex(ql, 9, &entry) || QuicklistIndex(ql, -10, &entry) {
+			t.Fatal("index out of range")
+		}
+
+		// 节点已满时插入到相邻的节点、创建新的节点或者分裂节点
+		QuicklistIndex(ql, 2, &entry)
+		QuicklistInsertAfter(ql, &entry, []byte("a"))
+		QuicklistIndex(ql, 0, &entry)
+		QuicklistInsertBefore(ql, &entry, []byte("b"))
+		QuicklistIndex(ql, 5, &entry)
+		QuicklistInsertBefore(ql, &entry, []byte("c"))
+		QuicklistIndex(ql, -1, &entry)
+		QuicklistInsertAfter(ql, &entry, []byte("d"))
+		QuicklistIndex(ql, -2, &entry)
+		QuicklistInsertBefore(ql, &entry, []byte("e"))
+		if got := strings.Join(qlValues(t, ql), ","); got != "b,0,1,2,a,c,3,4,5,6,7,e,8,d" {
+			t.Fatalf("values after insert %s", got)
+		}
+
+		if !QuicklistReplaceAtIndex(ql, 1, []byte("zero")) || !QuicklistReplaceAtIndex(ql, -1, []byte("100")) {
+			t.Fatal("replace")
+		}
+		if QuicklistReplaceAtIndex(ql, 100, []byte("x")) {
+			t.Fatal("replace out of range")
+		}
+		if got := strings.Join(qlValues(t, ql), ","); got != "b,zero,1,2,a,c,3,4,5,6,7,e,8,100" {
+			t.Fatalf("values after replace %s", got)
+		}
+
+		// 空的快速表插入
+		empty := create(3, 0)
+		QuicklistIndex(empty, 0, &entry)
+		QuicklistInsertAfter(empty, &entry, []byte("only"))
+		if got := strings.Join(qlValues(t, empty), ","); got != "only" {
+			t.Fatalf("insert into empty %s", got)
+		}
+		QuicklistRelease(empty)
+		QuicklistRelease(ql)
+	})
+}
+
+func TestQuicklistDelRange(t *testing.T) {
+	qlContainers(t, func(t *testing.T, create func(fill, compress int) *Quicklist) {
+		ql := create(4, 0)
+		for i := 0; i < 20; i++ {
+			QuicklistPushTail(ql, []byte(strconv.Itoa(i)))
+		}
+		if n := QuicklistDelRange(ql, 2, 7); n != 7 {
+			t.Fatalf("deleted %d", n)
+		}
+		if n := QuicklistDelRange(ql, -3, 10); n != 3 {
+			t.Fatalf("deleted from tail %d", n)
+		}
+		if n := QuicklistDelRange(ql, 100, 1); n != 0 {
+			t.Fatalf("deleted out of range %d", n)
+		}
+		if got := strings.Join(qlValues(t, ql), ","); got != "0,1,9,10,11,12,13,14,15,16" {
+			t.Fatalf("values %s", got)
+		}
+		if n := QuicklistDelRange(ql, 0, 100); n != 10 || ql.len != 0 {
+			t.Fatalf("delete all %d", n)
+		}
+		QuicklistRelease(ql)
+	})
+}
+
+func TestQuicklistIteratorDelete(t *testing.T) {
+	qlContainers(t, func(t *testing.T, create func(fill, compress int) *Quicklist) {
+		for _, direction := range []int{AL_START_HEAD, AL_START_TAIL} {
+			ql := create(3, 1)
+			for i := 0; i < 20; i++ {
+				QuicklistPushTail(ql, []byte(strconv.Itoa(i)))
+			}
+			var entry QuicklistEntry
+			var visited []string
+			iter := QuicklistGetIterator(ql, direction)
+			for QuicklistNext(iter, &entry) {
+				visited = append(visited, qlEntryString(&entry))
+				// 删除偶数以及所有大于15的元素，会删除整个节点
+				if entry.longval%2 == 0 || entry.longval > 15 {
+					QuicklistDelEntry(iter, &entry)
+				}
+			}
+			QuicklistReleaseIterator(iter)
+			if len(visited) != 20 {
+				t.Fatalf("direction %d visited %v", direction, visited)
+			}
+			if got := strings.Join(qlValues(t, ql), ","); got != "1,3,5,7,9,11,13,15" {
+				t.Fatalf("direction %d values %s", direction, got)
+			}
+			QuicklistRelease(ql)
+		}
+
+		// 从索引开始迭代
+		ql := create(3, 0)
+		for i := 0; i < 10; i++ {
+			QuicklistPushTail(ql, []byte(strconv.Itoa(i)))
+		}
+		var got []string
+		var entry QuicklistEntry
+		iter := QuicklistGetIteratorAtIdx(ql, AL_START_TAIL, 4)
+		for QuicklistNext(iter, &entry) {
+			got = append(got, qlEntryString(&entry))
+		}
+		QuicklistReleaseIterator(iter)
+		if strings.Join(got, ",") != "4,3,2,1,0" || QuicklistGetIteratorAtIdx(ql, AL_START_HEAD, 10) != nil {
+			t.Fatalf("iterator at index %v", got)
+		}
+		QuicklistRelease(ql)
+	})
+}
+
+func TestQuicklistCompress(t *testing.T) {
+	qlContainers(t, func(t *testing.T, create func(fill, compress int) *Quicklist) {
+		base := usedMemory()
+		ql := create(-2, 1)
+		for i := 0; i < 2000; i++ {
+			QuicklistPushTail(ql, []byte("compressible value "+strconv.Itoa(i%10)))
+		}
+		// 两端的节点不压缩，中间的节点压缩
+		if ql.head.encoding != QUICKLIST_NODE_ENCODING_RAW || ql.tail.encoding != QUICKLIST_NODE_ENCODING_RAW {
+			t.Fatal("head and tail should not be compressed")
+		}
+		compressed := 0
+		for node := ql.head.next; node != ql.tail; node = node.next {
+			if node.encoding == QUICKLIST_NODE_ENCODING_LZF {
+				compressed++
+			}
+		}
+		if compressed != ql.len-2 {
+			t.Fatalf("compressed %d of %d nodes", compressed, ql.len)
+		}
+		raw := 0
+		for node := ql.head; node != nil; node = node.next {
+			raw += node.sz
+		}
+		if used := usedMemory() - base; used >= raw {
+			t.Fatalf("used %d, raw %d", used, raw)
+		}
+
+		// 访问中间的节点之后元素不变
+		var entry QuicklistEntry
+		if !QuicklistIndex(ql, 1000, &entry) || string(entry.value) != "compressible value 0" {
+			t.Fatalf("index compressed node %q", entry.value)
+		}
+		QuicklistInsertBefore(ql, &entry, []byte("inserted"))
+		QuicklistReplaceAtIndex(ql, 1500, []byte("replaced"))
+		values := qlValues(t, ql)
+		if values[1000] != "inserted" || values[1500] != "replaced" || len(values) != 2001 {
+			t.Fatal("values in compressed nodes")
+		}
+
+		// 删除节点之后两端的节点解压
+		for QuicklistCount(ql) > 100 {
+			QuicklistPop(ql, QUICKLIST_HEAD)
+		}
+		if ql.head.encoding != QUICKLIST_NODE_ENCODING_RAW || ql.tail.encoding != QUICKLIST_NODE_ENCODING_RAW {
+			t.Fatal("head and tail should be decompressed")
+		}
+		QuicklistRelease(ql)
+		if used := usedMemory(); used != base {
+			t.Fatalf("leaked %d bytes", used-base)
+		}
+	})
+}
+
+// TestQuicklistRandom 随机操作和切片的结果一致
+func TestQuicklistRandom(t *testing.T) {
+	qlContainers(t, func(t *testing.T, create func(fill, compress int) *Quicklist) {
+		for _, options := range [][2]int{{4, 0}, {-1, 1}, {16, 2}, {-2, 3}} {
+			base := usedMemory()
+			rnd := rand.New(rand.NewSource(int64(options[0]*10 + options[1])))
+			randValue := func() string {
+				if rnd.Intn(3) == 0 {
+					return strconv.Itoa(rnd.Intn(100000))
+				}
+				return strings.Repeat(string(rune('a'+rnd.Intn(26))), rnd.Intn(300))
+			}
+			ql := create(options[0], options[1])
+			var want []string
+			for i := 0; i < 1000; i++ {
+				var entry QuicklistEntry
+				switch op := rnd.Intn(8); {
+				case op == 0 || len(want) == 0:
+					v := randValue()
+					QuicklistPushHead(ql, []byte(v))
+					want = append([]string{v}, want...)
+				case op == 1:
+					v := randValue()
+					QuicklistPushTail(ql, []byte(v))
+					want = append(want, v)
+				case op == 2:
+					v, index := randValue(), rnd.Intn(len(want))
+					QuicklistIndex(ql, index, &entry)
+					QuicklistInsertBefore(ql, &entry, []byte(v))
+					want = append(want[:index], append([]string{v}, want[index:]...)...)
+				case op == 3:
+					v, index := randValue(), rnd.Intn(len(want))
+					QuicklistIndex(ql, index-len(want), &entry)
+					QuicklistInsertAfter(ql, &entry, []byte(v))
+					index++
+					want = append(want[:index], append([]string{v}, want[index:]...)...)
+				case op == 4:
+					index, num := rnd.Intn(len(want)), 1+rnd.Intn(10)
+					QuicklistDelRange(ql, index, num)
+					if index+num > len(want) {
+						num = len(want) - index
+					}
+					want = append(want[:index], want[index+num:]...)
+				case op == 5:
+					v, index := randValue(), rnd.Intn(len(want))
+					QuicklistReplaceAtIndex(ql, index, []byte(v))
+					want[index] = v
+				case op == 6:
+					QuicklistPop(ql, QUICKLIST_HEAD)
+					want = want[1:]
+				default:
+					QuicklistPop(ql, QUICKLIST_TAIL)
+					want = want[:len(want)-1]
+				}
+				if got := qlValues(t, ql); strings.Join(got, ",") != strings.Join(want, ",") {
+					t.Fatalf("options %v op %d: got %d values, want %d", options, i, len(got), len(want))
+				}
+			}
+			QuicklistRelease(ql)
+			if used := usedMemory(); used != base {
+				t.Fatalf("options %v leaked %d bytes", options, used-base)
+			}
+		}
+	})
+}
@@ -5,6 +5,8 @@ type SDS struct {
 	Len  int    // buf的空间长度 获取长度为O(1)
 	Free int    // buf中有多少未被使用 获取字符串长度Len-Free O(1)
 	Buf  []byte // 保存字符串对象
+
+	alloc *allocator // 统计内存使用的分配器
 }
 
 // 创建一个包含给定字符串的SDS
@@ -34,6 +36,33 @@ func (s *SDS) SDSNew(str string) {
 	}
 }
 
+// SDSNewLen 使用给定的字节创建SDS，buf的最后一个字节保存'\0'
+func SDSNewLen(alloc *allocator, b []byte) *SDS {
+	buf := make([]byte, len(b)+1)
+	copy(buf, b)
+	alloc.zmalloc(SDS_HDR_SIZE + len(buf))
+	return &SDS{Len: len(buf), Free: 0, Buf: buf, alloc: alloc}
+}
+
+// SDSFree 释放SDS
+func SDSFree(s *SDS) {
+	if s == nil || s.Buf == nil {
+		return
+	}
+	s.alloc.zfree(SDS_HDR_SIZE + s.Len)
+	s.Buf = nil
+	s.Len = 0
+	s.Free = 0
+}
+
+// Bytes 返回保存的字符串，不包含结尾的'\0'
+func (s *SDS) Bytes() []byte {
+	if s.Len-s.Free-1 <= 0 {
+		return []byte{}
+	}
+	return s.Buf[:s.Len-s.Free-1]
+}
+
 // 在一个给定字符串上拼接新的字符串
 func (s *SDS) SDSCat(str string) {
 	s.SDSNew(str)
@@ -43,6 +72,11 @@ func (s *SDS) SDSCat(str string) {
 func (s *SDS) appenBuf(bufLen int) {
 	// 由于goalng的slice分配有底层的规则，这里使用重新赋值来模拟这一情况
 	newBuf := make([]byte, bufLen)
+	if s.Buf == nil {
+		s.alloc.zmalloc(SDS_HDR_SIZE + bufLen)
+	} else {
+		s.alloc.zrealloc(SDS_HDR_SIZE+s.Len, SDS_HDR_SIZE+bufLen)
+	}
 	for i := 0; i < (s.Len - s.Free); i++ {
 		newBuf[i] = s.Buf[i]
 	}
@@ -1,10 +1,365 @@
 package myredis
 
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrServerClosed Shutdown之后ListenAndServe返回的错误
+var ErrServerClosed = errors.New("myredis: server closed")
+
 type Server struct {
-	Slaves          []*Server    // 自自身具有的从节点
-	ID              string       // 每次运行启动生成的id
-	SlaveNum        int          // 从客户端的个数
-	MaxMemory       int          // 允许设置的最大内存 byte
-	MaxMemoryPolicy MemoryPolicy // 允许的内存策略
-	DBNum           int          // 数据库的数量
+	Slaves             []*Server    // 自自身具有的从节点
+	ID                 string       // 每次运行启动生成的id
+	SlaveNum           int          // 从客户端的个数
+	MaxMemory          int          // 允许设置的最大内存 byte
+	MaxMemoryPolicy    MemoryPolicy // 允许的内存策略
+	MaxMemorySamples   int          // 淘汰时每次抽样的键数
+	LfuLogFactor       int          // lfu计数器增长的对数因子，越大计数器增长越慢
+	LfuDecayTime       int          // lfu计数器每经过多少分钟减1，0表示不衰减
+	ListMaxZiplistSize int          // 压缩表编码的列表的大小限制，正数为元素个数，-1到-5为4k到64k字节
+	ListQuicklist      bool         // 列表使用快速表编码，ListMaxZiplistSize限制每个节点的大小
+	ListCompressDepth  int          // 快速表两端不压缩的节点数，0表示不压缩
+	DBNum              int          // 数据库的数量
+	Hz                 int          // serverCron每秒执行的次数 [1, 500]
+
+	db           []*RedisDb
+	commandTable []*RedisCommand
+	commands     map[string]*RedisCommand
+	stat         serverStat
+	expire       expireState
+
+	mem                allocator // 数据结构和客户端使用的内存
+	initialMemoryUsage int       // 初始化完成时使用的内存
+	startTime          time.Time // 启动的时间
+	replid             string    // 复制id，没有实现复制，只在INFO中展示
+	dirty              int64     // 上一次保存之后执行的写命令数
+
+	evictionPool   []evictionPoolEntry // 按照分数从小到大排序的候选键
+	evictionNextDb int                 // 随机淘汰时下一个抽样的数据库
+
+	blockedClients int        // 阻塞的客户端数
+	readyKeys      []readyKey // 有客户端阻塞并且可能已经可以弹出元素的键
+
+	// 所有的命令都由executor协程串行执行，保证命令的原子性
+	requests     chan *Client
+	disconnected chan *Client // 阻塞期间断开连接的客户端
+	stopExec     chan struct{}
+	execDone     chan struct{}
+
+	mu           sync.Mutex
+	listener     net.Listener
+	clients      map[int64]*Client
+	clientsWg    sync.WaitGroup
+	nextClientID int64
+	closing      int32
+	initOnce     sync.Once
+}
+
+// serverStat INFO stats 中的统计
+type serverStat struct {
+	expiredKeys                int64   // 过期删除的键数
+	expiredStalePerc           float64 // 估算的过期键比例
+	expiredTimeCapReachedCount int64   // 定期删除因为超时退出的次数
+	evictedKeys                int64   // 内存淘汰删除的键数
+	peakMemory                 int     // 使用内存的峰值
+	numcommands                int64   // 执行的命令数
+	numconnections             int64   // 接受的连接数
+	netInputBytes              int64   // 从客户端读取的字节数 客户端协程中原子更新
+	netOutputBytes             int64   // 发送给客户端的字节数 客户端协程中原子更新
+	keyspaceHits               int64   // 查找键命中的次数
+	keyspaceMisses             int64   // 查找键没有命中的次数
+
+	commands   map[string]*commandStat                 // 每个命令的调用次数和耗时
+	instMetric [STATS_METRIC_COUNT]instantaneousMetric // 每秒的瞬时指标
+}
+
+// expireState 定期删除的状态
+type expireState struct {
+	currentDb     int       // 下一次处理的数据库
+	timelimitExit bool      // 上一次是否因为超时退出
+	lastFastCycle time.Time // 上一次执行快模式的时间
+}
+
+// NewServer 创建服务，字段可以在Serve之前修改
+func NewServer() *Server {
+	return &Server{
+		DBNum:              16,
+		MaxMemoryPolicy:    Noeviction,
+		MaxMemorySamples:   MAXMEMORY_SAMPLES,
+		LfuLogFactor:       LFU_LOG_FACTOR,
+		LfuDecayTime:       LFU_DECAY_TIME,
+		ListMaxZiplistSize: LIST_MAX_ZIPLIST_SIZE,
+		Hz:                 10,
+	}
+}
+
+// init 第一次Serve时根据配置创建数据库并启动executor
+func (s *Server) init() {
+	s.initOnce.Do(func() {
+		if s.DBNum <= 0 {
+			s.DBNum = 16
+		}
+		if s.MaxMemoryPolicy == 0 {
+			s.MaxMemoryPolicy = Noeviction
+		}
+		if s.MaxMemorySamples <= 0 {
+			s.MaxMemorySamples = MAXMEMORY_SAMPLES
+		}
+		if s.ListMaxZiplistSize == 0 {
+			s.ListMaxZiplistSize = LIST_MAX_ZIPLIST_SIZE
+		}
+		if s.ListMaxZiplistSize < -len(optimizationLevel) {
+			s.ListMaxZiplistSize = -len(optimizationLevel)
+		}
+		if s.Hz < 1 {
+			s.Hz = 10
+		}
+		if s.Hz > 500 {
+			s.Hz = 500
+		}
+		if s.ID == "" {
+			s.ID = getRandomHexChars(CONFIG_RUN_ID_SIZE)
+		}
+		s.replid = getRandomHexChars(CONFIG_RUN_ID_SIZE)
+		s.startTime = time.Now()
+		s.populateCommandTable()
+		s.db = make([]*RedisDb, s.DBNum)
+		for i := range s.db {
+			s.db[i] = newRedisDb(s, i)
+		}
+		s.requests = make(chan *Client)
+		s.disconnected = make(chan *Client)
+		s.stopExec = make(chan struct{})
+		s.execDone = make(chan struct{})
+		s.clients = map[int64]*Client{}
+		s.evictionPool = make([]evictionPoolEntry, 0, EVPOOL_SIZE)
+		s.initialMemoryUsage = s.usedMemory()
+		s.stat.peakMemory = s.initialMemoryUsage
+		go s.executor()
+	})
+}
+
+// ListenAndServe 监听addr并处理客户端的请求，Shutdown之后返回ErrServerClosed
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve 在给定的listener上处理请求
+func (s *Server) Serve(l net.Listener) error {
+	s.init()
+	s.mu.Lock()
+	if atomic.LoadInt32(&s.closing) == 1 {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+	log.Printf("myredis server listen %s\n", l.Addr().String())
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if atomic.LoadInt32(&s.closing) == 1 {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		s.mu.Lock()
+		if atomic.LoadInt32(&s.closing) == 1 {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		c := s.createClient(conn)
+		s.clientsWg.Add(1)
+		s.mu.Unlock()
+		go s.serveClient(c)
+	}
+}
+
+// Addr 监听的地址，没有监听时返回nil
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Shutdown 优雅关闭
+// 1 停止接受新的连接
+// 2 正在执行的命令执行完毕并把回复发送给客户端，之后关闭所有的连接
+// 3 停止executor
+func (s *Server) Shutdown() error {
+	s.init()
+	s.mu.Lock()
+	if !atomic.CompareAndSwapInt32(&s.closing, 0, 1) {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	// 唤醒阻塞在读取请求上的客户端，正在执行命令的客户端写完回复之后退出
+	for _, c := range s.clients {
+		c.conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	s.clientsWg.Wait()
+	close(s.stopExec)
+	<-s.execDone
+	log.Printf("myredis server shutdown\n")
+	return err
+}
+
+// executor 串行执行所有客户端的命令以及定时任务
+func (s *Server) executor() {
+	defer close(s.execDone)
+	cron := time.NewTicker(time.Second / time.Duration(s.Hz))
+	defer cron.Stop()
+	for {
+		select {
+		case c := <-s.requests:
+			s.processCommand(c)
+			c.done <- c.Flags&CLIENT_BLOCKED != 0
+			s.handleClientsBlockedOnKeys()
+			s.beforeSleep()
+		case c := <-s.disconnected:
+			// 阻塞的客户端断开连接
+			if c.Flags&CLIENT_BLOCKED != 0 {
+				s.unblockClient(c)
+			}
+		case <-cron.C:
+			s.serverCron()
+		case <-s.stopExec:
+			return
+		}
+	}
+}
+
+// getRandomHexChars 生成n个随机的十六进制字符，用于run_id
+func getRandomHexChars(n int) string {
+	buf := make([]byte, (n+1)/2)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("generate random id: %v\n", err)
+	}
+	return hex.EncodeToString(buf)[:n]
+}
+
+// serverCron 每秒执行hz次
+func (s *Server) serverCron() {
+	s.trackInstantaneousMetric(STATS_METRIC_COMMAND, s.stat.numcommands)
+	s.trackInstantaneousMetric(STATS_METRIC_NET_INPUT, atomic.LoadInt64(&s.stat.netInputBytes))
+	s.trackInstantaneousMetric(STATS_METRIC_NET_OUTPUT, atomic.LoadInt64(&s.stat.netOutputBytes))
+	s.updatePeakMemory()
+	s.clientsCron()
+	s.databasesCron()
+}
+
+// updatePeakMemory 记录使用内存的峰值
+func (s *Server) updatePeakMemory() {
+	if used := s.usedMemory(); used > s.stat.peakMemory {
+		s.stat.peakMemory = used
+	}
+}
+
+// databasesCron 数据库的后台任务
+// 定期删除过期键，之后缩小使用率过低的字典并推进rehash
+func (s *Server) databasesCron() {
+	s.activeExpireCycle(ACTIVE_EXPIRE_CYCLE_SLOW)
+	for _, db := range s.db {
+		tryResizeHashTables(db)
+		incrementallyRehash(db)
+	}
+}
+
+// beforeSleep 每次执行命令之后执行，过期键较多时执行快模式的定期删除
+func (s *Server) beforeSleep() {
+	s.activeExpireCycle(ACTIVE_EXPIRE_CYCLE_FAST)
+}
+
+func (s *Server) createClient(conn net.Conn) *Client {
+	now := time.Now()
+	s.nextClientID++
+	atomic.AddInt64(&s.stat.numconnections, 1)
+	c := &Client{
+		ID:              s.nextClientID,
+		Addr:            conn.RemoteAddr().String(),
+		CreateTime:      now,
+		LastInteraction: now,
+		server:          s,
+		conn:            conn,
+		db:              s.db[0],
+		done:            make(chan bool, 1),
+	}
+	c.querybuf = bufio.NewReaderSize(clientConn{c}, PROTO_IOBUF_LEN)
+	c.reply = NewReplyWriter(bufio.NewWriterSize(clientConn{c}, PROTO_REPLY_CHUNK_BYTES))
+	c.reader = NewRequestReader(c.querybuf)
+	s.clients[c.ID] = c
+	s.mem.zmalloc(c.memory())
+	return c
+}
+
+func (s *Server) freeClient(c *Client) {
+	c.conn.Close()
+	s.mu.Lock()
+	delete(s.clients, c.ID)
+	s.mem.zfree(c.memory())
+	s.mu.Unlock()
+	s.clientsWg.Done()
+}
+
+// serveClient 读取客户端的请求交给executor执行
+// 同一个客户端的命令按照顺序执行，pipeline中的命令全部执行之后再发送回复
+func (s *Server) serveClient(c *Client) {
+	defer s.freeClient(c)
+	for {
+		args, err := c.reader.ReadRequest()
+		if err != nil {
+			if IsProtocolError(err) {
+				c.reply.WriteError(err.Error())
+				c.reply.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		c.Argv = args
+		s.requests <- c
+		if blocked := <-c.done; blocked && !s.waitUnblocked(c) {
+			return
+		}
+		c.Argv = nil
+
+		quit := c.Flags&CLIENT_CLOSE_AFTER_REPLY != 0 || atomic.LoadInt32(&s.closing) == 1
+		if c.querybuf.Buffered() == 0 || quit {
+			if err := c.reply.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
 }
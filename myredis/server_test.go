@@ -0,0 +1,129 @@
+package myredis
+
+import (
+	"io/ioutil"
+	lredis "learn/l_redis"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+// startServer 在随机端口启动服务，测试结束之后关闭
+// configs 在Serve之前修改服务的配置
+func startServer(t *testing.T, configs ...func(s *Server)) (*Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	for _, config := range configs {
+		config(s)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(l)
+	}()
+	t.Cleanup(func() {
+		s.Shutdown()
+		if err := <-served; err != ErrServerClosed {
+			t.Errorf("serve err %v", err)
+		}
+	})
+	return s, l.Addr().String()
+}
+
+func newTestClient(addr string) *redis.Client {
+	return redis.NewClient(&redis.Options{Addr: addr})
+}
+
+// TestLredisOpen 使用lredis的配置文件连接myredis
+func TestLredisOpen(t *testing.T) {
+	_, addr := startServer(t)
+	dir, err := ioutil.TempDir("", "myredis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config.yaml")
+	config := "hosts: \"" + addr + "\"\ndb: 0\ntimeout: 3\npool_size: 4\ndb_mod: 1\n"
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := lredis.ReadConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+	clients, err := lredis.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := clients[0]
+	defer client.(*redis.Client).Close()
+
+	if pong, err := client.Ping().Result(); err != nil || pong != "PONG" {
+		t.Fatalf("ping %s %v", pong, err)
+	}
+	if err := client.Set("k", "v", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := client.Get("k").Result(); err != nil || val != "v" {
+		t.Fatalf("get %s %v", val, err)
+	}
+	if err := client.Get("missing").Err(); err != redis.Nil {
+		t.Fatalf("get missing %v", err)
+	}
+}
+
+func TestServerPipelineAndSelect(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	pipe := client.Pipeline()
+	pipe.Set("a", "1", 0)
+	get := pipe.Get("a")
+	echo := pipe.Echo("hello")
+	if _, err := pipe.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if get.Val() != "1" || echo.Val() != "hello" {
+		t.Fatalf("pipeline %q %q", get.Val(), echo.Val())
+	}
+
+	other := redis.NewClient(&redis.Options{Addr: addr, DB: 1})
+	defer other.Close()
+	if err := other.Get("a").Err(); err != redis.Nil {
+		t.Fatalf("db 1 get %v", err)
+	}
+	if err := client.Do("nosuchcommand", "a").Err(); err == nil || err.Error() != "ERR unknown command `nosuchcommand`, with args beginning with: `a`, " {
+		t.Fatalf("unknown command %v", err)
+	}
+}
+
+func TestCommandTable(t *testing.T) {
+	_, addr := startServer(t)
+	client := newTestClient(addr)
+	defer client.Close()
+
+	if err := client.Do("get").Err(); err == nil || err.Error() != "ERR wrong number of arguments for 'get' command" {
+		t.Fatalf("arity %v", err)
+	}
+	count, err := client.Do("command", "count").Int64()
+	if err != nil || count != int64(len(redisCommandTable)) {
+		t.Fatalf("command count %d %v", count, err)
+	}
+	info, err := client.Do("command", "info", "set", "nosuchcommand").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := info.([]interface{})
+	set := items[0].([]interface{})
+	if set[0] != "set" || set[1] != int64(-3) || len(set[2].([]interface{})) != 2 || set[3] != int64(1) {
+		t.Fatalf("command info set %v", set)
+	}
+	if items[1] != nil {
+		t.Fatalf("command info unknown %v", items[1])
+	}
+}
@@ -58,8 +58,9 @@ type SkipListLevel struct {
 }
 
 // slNodeCreate 创建跳表节点
-func slNodeCreate(level int, score float32, member interface{}) *SkipListNode {
+func slNodeCreate(alloc *allocator, level int, score float32, member interface{}) *SkipListNode {
 	levels := make([]SkipListLevel, level)
+	alloc.zmalloc(SKIPLIST_NODE_OVERHEAD + level*SKIPLIST_LEVEL_OVERHEAD)
 	return &SkipListNode{
 		Score: score,
 		Obj:   member,
@@ -68,7 +69,11 @@ func slNodeCreate(level int, score float32, member interface{}) *SkipListNode {
 }
 
 // Free 释放指定的节点
-func (slNode *SkipListNode) Free() {
+func (slNode *SkipListNode) Free(alloc *allocator) {
+	if slNode.Level == nil {
+		return
+	}
+	alloc.zfree(SKIPLIST_NODE_OVERHEAD + len(slNode.Level)*SKIPLIST_LEVEL_OVERHEAD)
 	slNode.Backward = nil
 	slNode.Score = 0
 	slNode.Obj = nil
@@ -90,6 +95,8 @@ type SkipList struct {
 	// 每个层带有 前进指针forward 后退指针bwward span 跨度
 	Level  int
 	Length int // 跳表目前包含的节点的数量
+
+	alloc *allocator // 跳表以及节点使用的分配器
 }
 
 func (sl *SkipList) Free() {
@@ -98,11 +105,15 @@ func (sl *SkipList) Free() {
 		backward := sl.Tail
 		for backward != nil {
 			node := backward.Backward
-			backward.Free()
+			backward.Free(sl.alloc)
 			backward = node
 			node = nil
 		}
 	}
+	if sl.Header != nil {
+		sl.Header.Free(sl.alloc)
+		sl.alloc.zfree(SKIPLIST_OVERHEAD)
+	}
 	sl.Header = nil
 	sl.Level = 0
 	sl.Tail = nil
@@ -135,12 +146,14 @@ func (sl *SkipList) slDeleteNode(skipNode *SkipListNode, updates []*SkipListNode
 }
 
 // SLCreate 创建一个新的跳跃表
-func SLCreate() *SkipList {
+func SLCreate(alloc *allocator) *SkipList {
+	alloc.zmalloc(SKIPLIST_OVERHEAD)
 	sl := &SkipList{
-		Level:  1,                                       // 设置起始层数
-		Length: 0,                                       // 设置高度
-		Header: slNodeCreate(SKIPLIST_MAXVALUE, 0, nil), // 初始化头节点
-		Tail:   nil,                                     // 设置表尾 在go中这里不赋值也可
+		Level:  1,                                              // 设置起始层数
+		Length: 0,                                              // 设置高度
+		Header: slNodeCreate(alloc, SKIPLIST_MAXVALUE, 0, nil), // 初始化头节点
+		Tail:   nil,                                            // 设置表尾 在go中这里不赋值也可
+		alloc:  alloc,
 	}
 	return sl
 }
@@ -177,28 +190,23 @@ func SLInsert(sl *SkipList, member interface{}, score float32) (*SkipListNode, e
 	// 在每个层级中查找节点的插入位置
 	slNode := sl.Header
 	for i := sl.Level - 1; i >= 0; i-- {
-		rankList[i] = 0 // 如果i为最后一层跨度为0
+		// 最高层从0开始，其他层的起始值为上一层的rank值，
+		// 每一层的rank值不断累加，最终rankList[0] +1 就是新创建节点的rank值
 		if i < sl.Level-1 {
-			// 如果不为第一层，则i层的起始值为i+1层的rank值，
-			// 每一层的rank值不断覆盖，最终rankList[0] +1 就是新创建节点的rank值
 			rankList[i] = rankList[i+1]
 		}
-		// 沿着前进指针遍历跳表
-		forward := slNode.Level[i].Forward
-		memberEqual := reflect.DeepEqual(forward.Obj, member)
-		scoreEqual := forward.Score == score
-		// 跳表节点已经存在
-		if scoreEqual && memberEqual {
-			return nil, &Error{Code: SKIPNODE_EXIST, MSG: "skip node exist"}
-		}
-		// 比对分值
-		// 如果分值相等，成员必须不相等
-		isNext := (forward != nil && forward.Score < score) || (scoreEqual && !memberEqual)
-		for isNext {
+		// 沿着前进指针遍历跳表，分值相同时按照成员排序
+		for forward := slNode.Level[i].Forward; forward != nil; forward = slNode.Level[i].Forward {
+			// 跳表节点已经存在
+			if forward.skipNodeExist(score, member) {
+				return nil, &Error{Code: SKIPNODE_EXIST, MSG: "skip node exist"}
+			}
+			if forward.Score > score || forward.Score == score && !slMemberLess(forward.Obj, member) {
+				break
+			}
 			// 记录沿途跨越了多少节点
-			rankList[i] = slNode.Level[i].Span
-			// 移动到下一个forward
-			slNode = slNode.Level[i].Forward
+			rankList[i] += slNode.Level[i].Span
+			slNode = forward
 		}
 		// 记录将要和新的节点相连接的节点
 		updateLevelList[i] = slNode
@@ -206,49 +214,55 @@ func SLInsert(sl *SkipList, member interface{}, score float32) (*SkipListNode, e
 
 	// 获取一个随机值作为新节点的层数
 	level := slRandomLevel()
-	// 新节点的层数没有被创建，需要初始化节点中没有使用的层,
-	// 然后记录到update数组中，将来也指向新节点
-	maxLevel := sl.Level
-	for ; maxLevel < level; maxLevel++ {
-		rankList[maxLevel] = 0
-		updateLevelList[maxLevel] = sl.Header.Copy()
-		updateLevelList[maxLevel].Level[maxLevel].Span = sl.Length
-	}
-	sl.Level = maxLevel
+	// 新节点的层数超过了跳表的层数，没有使用的层由表头指向新节点
+	for i := sl.Level; i < level; i++ {
+		rankList[i] = 0
+		updateLevelList[i] = sl.Header
+		updateLevelList[i].Level[i].Span = sl.Length
+	}
+	if level > sl.Level {
+		sl.Level = level
+	}
 	// 创建新的节点
-	slNodeCreate(level, score, member)
+	node := slNodeCreate(sl.alloc, level, score, member)
 	// 将前面记录的指针指向新的节点，并做相应的设置 O(1)
 	for i := 0; i < level; i++ {
 		// 设置新的forward指针
-		slNode.Level[i].Forward = updateLevelList[i].Level[i].Forward
+		node.Level[i].Forward = updateLevelList[i].Level[i].Forward
 		// 将沿途每个forward指针的指向新节点
-		updateLevelList[i].Level[i].Forward = slNode
+		updateLevelList[i].Level[i].Forward = node
 		// 计算新节点跨越的节点数量
-		slNode.Level[i].Span = slNode.Level[i].Span - (rankList[0] - rankList[i])
+		node.Level[i].Span = updateLevelList[i].Level[i].Span - (rankList[0] - rankList[i])
 		// 更新节点插入之后，沿途的span值。其中+1为添加的一个新节点
 		updateLevelList[i].Level[i].Span = rankList[0] - rankList[i] + 1
 	}
 
-	// 未删除的节点span+1, 并且直接从表头节点指向新节点 O(1)
+	// 未接触的层span+1，这些层从前面的节点直接越过新节点 O(1)
 	for i := level; i < sl.Level; i++ {
 		updateLevelList[i].Level[i].Span++
 	}
 
-	// 设置新节点的后退指针
-	slNode.Backward = nil
-	if updateLevelList[0] == sl.Header {
-		slNode.Backward = updateLevelList[0]
+	// 设置新节点的后退指针，第一个节点的后退指针为nil
+	if updateLevelList[0] != sl.Header {
+		node.Backward = updateLevelList[0]
 	}
 
-	if slNode.Level[0].Forward != nil {
-		slNode.Level[0].Forward.Backward = slNode
+	if node.Level[0].Forward != nil {
+		node.Level[0].Forward.Backward = node
 	} else {
 		// 尾节点
-		sl.Tail = slNode
+		sl.Tail = node
 	}
 	// 跳表节点数目++
 	sl.Length++
-	return slNode, nil
+	return node, nil
+}
+
+// slMemberLess 分值相同时成员的顺序，字符串成员按照字典序，其他成员插入到相同分值的节点之前
+func slMemberLess(member1, member2 interface{}) bool {
+	s1, ok1 := member1.(string)
+	s2, ok2 := member2.(string)
+	return ok1 && ok2 && s1 < s2
 }
 
 // SLDelete 删除跳表中给定member和score的节点
@@ -273,7 +287,7 @@ func SLDelete(sl *SkipList, member interface{}, score float32) error {
 	slNode = slNode.Level[0].Forward
 	if slNode.skipNodeExist(score, member) {
 		sl.slDeleteNode(slNode, updateLevelList)
-		slNode.Free()
+		slNode.Free(sl.alloc)
 		slNode = nil
 		return nil
 	}
@@ -422,7 +436,7 @@ func SLDeleteRangeByScore(sl *SkipList, rangeNum [2]float32, dict *Dict) (int, e
 		sl.slDeleteNode(slNode, updates)
 		// 在哈希表删除
 		DictDelete(dict, slNode.Obj)
-		slNode.Free()
+		slNode.Free(sl.alloc)
 		slNode = next
 		removed++
 	}
@@ -453,7 +467,7 @@ func SLDeleteRangeByRank(sl *SkipList, start, end int, dict *Dict) (int, error)
 		next := slNode.Level[0].Forward
 		sl.slDeleteNode(slNode, updates) // 跳表中删除
 		DictDelete(dict, slNode.Obj)     // 字典中删除
-		slNode.Free()                    // 释放节点
+		slNode.Free(sl.alloc)            // 释放节点
 		slNode = next
 		removed++   // 删除计数器增加
 		traversed++ // 排位计数器+1
@@ -0,0 +1,971 @@
+package myredis
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 列表命令
+// 列表创建时使用压缩表编码，超过list-max-ziplist-size的限制之后转换为双端链表，转换之后不再转换回压缩表
+//   正数: 压缩表最多保存的元素个数，单个元素超过SIZE_SAFETY_LIMIT字节时也转换
+//   负数: -1到-5 压缩表最多占用4k 8k 16k 32k 64k字节，同快速表的optimizationLevel
+// 开启ListQuicklist时同redis 3.2之后的版本，列表始终使用快速表编码，list-max-ziplist-size限制每个节点的大小
+// 索引从0开始，负数索引从表尾开始，-1为最后一个元素
+
+const (
+	LIST_HEAD = 0 // 表头 LPUSH LPOP
+	LIST_TAIL = 1 // 表尾 RPUSH RPOP
+
+	LIST_MAX_ZIPLIST_SIZE = -2 // list-max-ziplist-size 的默认值，压缩表最多8k字节
+
+	ZIPLIST_ENTRY_MAX_HEADER = 10 // 压缩表节点prevlen和encoding最多占用的字节数
+)
+
+// listTypeIterator 列表的迭代器
+// direction 为LIST_TAIL时从表头向表尾迭代，为LIST_HEAD时从表尾向表头迭代
+type listTypeIterator struct {
+	subject   *RedisObject
+	encoding  uint8
+	direction int
+	zi        int            // 压缩表中下一个元素的偏移量，-1表示迭代结束
+	ln        *ListNode      // 链表中下一个节点
+	qi        *QuicklistIter // 快速表的迭代器，索引超出范围时为nil
+}
+
+// listTypeEntry 迭代器返回的元素
+type listTypeEntry struct {
+	li *listTypeIterator
+	zi int
+	ln *ListNode
+	qe QuicklistEntry
+}
+
+// listTypeTryConversion 压缩表编码的列表加入value之后超过fill的限制时转换为双端链表
+func listTypeTryConversion(o *RedisObject, fill int, value []byte) {
+	if o.Encoding != OBJ_ENCODING_ZIPLIST {
+		return
+	}
+	zl := o.ptr.([]byte)
+	if fill < 0 {
+		if sizeMeetsOptimizationRequirement(len(zl)+len(value)+ZIPLIST_ENTRY_MAX_HEADER, fill) {
+			return
+		}
+	} else if len(value) <= SIZE_SAFETY_LIMIT && ZiplistLen(zl) < fill {
+		return
+	}
+	listTypeConvert(o, OBJ_ENCODING_LINKEDLIST)
+}
+
+// listTypeConvert 把压缩表编码的列表转换为双端链表
+func listTypeConvert(o *RedisObject, enc uint8) {
+	if o.Encoding != OBJ_ENCODING_ZIPLIST || enc != OBJ_ENCODING_LINKEDLIST {
+		panic("myredis: unsupported list conversion")
+	}
+	zl := o.ptr.([]byte)
+	l := createSdsList(o.alloc)
+	for p := ZiplistIndex(zl, 0); p != -1; p = ZiplistNext(zl, p) {
+		l.ListAddNodeTail(SDSNewLen(o.alloc, ziplistEntryBytes(zl, p)))
+	}
+	ZiplistFree(o.alloc, zl)
+	o.ptr = l
+	o.Encoding = OBJ_ENCODING_LINKEDLIST
+}
+
+// ziplistEntryBytes 压缩表节点的值，整数转换为十进制字符串，字符串和压缩表共享内存
+func ziplistEntryBytes(zl []byte, p int) []byte {
+	sval, lval, _ := ZiplistGet(zl, p)
+	if sval == nil {
+		return strconv.AppendInt(nil, lval, 10)
+	}
+	return sval
+}
+
+// listTypePush 把value加入到列表的表头或者表尾
+func listTypePush(o *RedisObject, fill int, value []byte, where int) {
+	listTypeTryConversion(o, fill, value)
+	if o.Encoding == OBJ_ENCODING_QUICKLIST {
+		pos := QUICKLIST_HEAD
+		if where == LIST_TAIL {
+			pos = QUICKLIST_TAIL
+		}
+		QuicklistPush(o.ptr.(*Quicklist), value, pos)
+		return
+	}
+	if o.Encoding == OBJ_ENCODING_ZIPLIST {
+		pos := ZIPLIST_HEAD
+		if where == LIST_TAIL {
+			pos = ZIPLIST_TAIL
+		}
+		o.ptr = ZiplistPush(o.alloc, o.ptr.([]byte), value, pos)
+		return
+	}
+	l := o.ptr.(*List)
+	if where == LIST_HEAD {
+		l.ListAddNodeHead(SDSNewLen(o.alloc, value))
+	} else {
+		l.ListAddNodeTail(SDSNewLen(o.alloc, value))
+	}
+}
+
+// listTypePop 弹出表头或者表尾的元素，列表为空时返回nil
+func listTypePop(o *RedisObject, where int) []byte {
+	if o.Encoding == OBJ_ENCODING_QUICKLIST {
+		pos := QUICKLIST_HEAD
+		if where == LIST_TAIL {
+			pos = QUICKLIST_TAIL
+		}
+		value, longval, ok := QuicklistPop(o.ptr.(*Quicklist), pos)
+		if !ok {
+			return nil
+		}
+		if value == nil {
+			return strconv.AppendInt(nil, longval, 10)
+		}
+		return value
+	}
+	index := 0
+	if where == LIST_TAIL {
+		index = -1
+	}
+	if o.Encoding == OBJ_ENCODING_ZIPLIST {
+		zl := o.ptr.([]byte)
+		p := ZiplistIndex(zl, index)
+		if p == -1 {
+			return nil
+		}
+		value := append([]byte{}, ziplistEntryBytes(zl, p)...)
+		o.ptr = ZiplistDelete(o.alloc, zl, p)
+		return value
+	}
+	l := o.ptr.(*List)
+	node := l.ListIndex(index)
+	if node == nil {
+		return nil
+	}
+	value := append([]byte{}, node.value.Bytes()...)
+	l.ListDelNode(node)
+	return value
+}
+
+// listTypeLength 列表的元素个数
+func listTypeLength(o *RedisObject) int {
+	switch o.Encoding {
+	case OBJ_ENCODING_ZIPLIST:
+		return ZiplistLen(o.ptr.([]byte))
+	case OBJ_ENCODING_QUICKLIST:
+		return QuicklistCount(o.ptr.(*Quicklist))
+	}
+	return int(o.ptr.(*List).ListLehgth())
+}
+
+// listTypeInitIterator 从index开始迭代列表，使用之后调用listTypeReleaseIterator
+func listTypeInitIterator(o *RedisObject, index int, direction int) *listTypeIterator {
+	li := &listTypeIterator{
+		subject:   o,
+		encoding:  o.Encoding,
+		direction: direction,
+	}
+	switch li.encoding {
+	case OBJ_ENCODING_ZIPLIST:
+		li.zi = ZiplistIndex(o.ptr.([]byte), index)
+	case OBJ_ENCODING_QUICKLIST:
+		qdirection := AL_START_HEAD
+		if direction == LIST_HEAD {
+			qdirection = AL_START_TAIL
+		}
+		li.qi = QuicklistGetIteratorAtIdx(o.ptr.(*Quicklist), qdirection, index)
+	default:
+		li.ln = o.ptr.(*List).ListIndex(index)
+	}
+	return li
+}
+
+// listTypeReleaseIterator 释放迭代器，快速表重新压缩迭代时解压的节点
+func listTypeReleaseIterator(li *listTypeIterator) {
+	if li.qi != nil {
+		QuicklistReleaseIterator(li.qi)
+	}
+}
+
+// listTypeNext 返回当前的元素并移动到下一个元素，迭代结束时返回false
+func listTypeNext(li *listTypeIterator, entry *listTypeEntry) bool {
+	if li.subject.Encoding != li.encoding {
+		panic("myredis: list encoding changed during iteration")
+	}
+	entry.li = li
+	if li.encoding == OBJ_ENCODING_QUICKLIST {
+		return li.qi != nil && QuicklistNext(li.qi, &entry.qe)
+	}
+	if li.encoding == OBJ_ENCODING_ZIPLIST {
+		entry.zi = li.zi
+		if entry.zi == -1 {
+			return false
+		}
+		zl := li.subject.ptr.([]byte)
+		if li.direction == LIST_TAIL {
+			li.zi = ZiplistNext(zl, li.zi)
+		} else {
+			li.zi = ZiplistPrev(zl, li.zi)
+		}
+		return true
+	}
+	entry.ln = li.ln
+	if entry.ln == nil {
+		return false
+	}
+	if li.direction == LIST_TAIL {
+		li.ln = li.ln.next
+	} else {
+		li.ln = li.ln.prev
+	}
+	return true
+}
+
+// listTypeGet 元素的值，和列表共享内存，修改列表之后失效
+func listTypeGet(entry *listTypeEntry) []byte {
+	switch entry.li.encoding {
+	case OBJ_ENCODING_ZIPLIST:
+		return ziplistEntryBytes(entry.li.subject.ptr.([]byte), entry.zi)
+	case OBJ_ENCODING_QUICKLIST:
+		if entry.qe.value == nil {
+			return strconv.AppendInt(nil, entry.qe.longval, 10)
+		}
+		return entry.qe.value
+	}
+	return entry.ln.value.Bytes()
+}
+
+// listTypeEqual 元素是否和value相等
+func listTypeEqual(entry *listTypeEntry, value []byte) bool {
+	switch entry.li.encoding {
+	case OBJ_ENCODING_ZIPLIST:
+		return ZiplistCompare(entry.li.subject.ptr.([]byte), entry.zi, value)
+	case OBJ_ENCODING_QUICKLIST:
+		// 整数保存为最短的十进制形式，转换为字符串之后比较
+		return string(listTypeGet(entry)) == string(value)
+	}
+	return string(entry.ln.value.Bytes()) == string(value)
+}
+
+// listTypeInsert 把value插入到元素的前面(LIST_HEAD)或者后面(LIST_TAIL)
+// 插入之后迭代器失效，调用方需要先使用listTypeTryConversion
+func listTypeInsert(entry *listTypeEntry, value []byte, where int) {
+	o := entry.li.subject
+	if o.Encoding == OBJ_ENCODING_QUICKLIST {
+		if where == LIST_TAIL {
+			QuicklistInsertAfter(o.ptr.(*Quicklist), &entry.qe, value)
+		} else {
+			QuicklistInsertBefore(o.ptr.(*Quicklist), &entry.qe, value)
+		}
+		return
+	}
+	if o.Encoding == OBJ_ENCODING_ZIPLIST {
+		zl := o.ptr.([]byte)
+		if where == LIST_TAIL {
+			if next := ZiplistNext(zl, entry.zi); next != -1 {
+				o.ptr = ZiplistInsert(o.alloc, zl, next, value)
+			} else {
+				o.ptr = ZiplistPush(o.alloc, zl, value, ZIPLIST_TAIL)
+			}
+		} else {
+			o.ptr = ZiplistInsert(o.alloc, zl, entry.zi, value)
+		}
+		return
+	}
+	l := o.ptr.(*List)
+	if where == LIST_TAIL {
+		l.ListInsertNodeAfter(SDSNewLen(o.alloc, value), entry.ln)
+	} else {
+		l.ListInsertNode(SDSNewLen(o.alloc, value), entry.ln)
+	}
+}
+
+// listTypeDelete 删除迭代器返回的元素，删除之后可以继续迭代
+func listTypeDelete(li *listTypeIterator, entry *listTypeEntry) {
+	o := li.subject
+	if li.encoding == OBJ_ENCODING_QUICKLIST {
+		QuicklistDelEntry(li.qi, &entry.qe)
+		return
+	}
+	if li.encoding == OBJ_ENCODING_ZIPLIST {
+		p := entry.zi
+		zl := ZiplistDelete(o.alloc, o.ptr.([]byte), p)
+		o.ptr = zl
+		// 删除之后p指向原来的后一个元素
+		if li.direction == LIST_TAIL {
+			if zl[p] == ZIP_END {
+				p = -1
+			}
+			li.zi = p
+		} else {
+			li.zi = ZiplistPrev(zl, p)
+		}
+		return
+	}
+	next := entry.ln.next
+	if li.direction == LIST_HEAD {
+		next = entry.ln.prev
+	}
+	o.ptr.(*List).ListDelNode(entry.ln)
+	li.ln = next
+}
+
+// listTypeIndex 返回索引上的元素，超出范围返回false
+func listTypeIndex(o *RedisObject, index int) ([]byte, bool) {
+	li := listTypeInitIterator(o, index, LIST_TAIL)
+	defer listTypeReleaseIterator(li)
+	var entry listTypeEntry
+	if !listTypeNext(li, &entry) {
+		return nil, false
+	}
+	return listTypeGet(&entry), true
+}
+
+// listTypeReplace 替换索引上的元素，超出范围返回false
+func listTypeReplace(o *RedisObject, fill int, index int, value []byte) bool {
+	if index >= listTypeLength(o) || index < -listTypeLength(o) {
+		return false
+	}
+	listTypeTryConversion(o, fill, value)
+	if o.Encoding == OBJ_ENCODING_QUICKLIST {
+		return QuicklistReplaceAtIndex(o.ptr.(*Quicklist), index, value)
+	}
+	if o.Encoding == OBJ_ENCODING_ZIPLIST {
+		zl := o.ptr.([]byte)
+		o.ptr = ZiplistReplace(o.alloc, zl, ZiplistIndex(zl, index), value)
+		return true
+	}
+	node := o.ptr.(*List).ListIndex(index)
+	SDSFree(node.value)
+	node.value = SDSNewLen(o.alloc, value)
+	return true
+}
+
+// listTypeDelRange 从start开始删除count个元素，start不能为负数
+func listTypeDelRange(o *RedisObject, start, count int) {
+	if count <= 0 {
+		return
+	}
+	if o.Encoding == OBJ_ENCODING_QUICKLIST {
+		QuicklistDelRange(o.ptr.(*Quicklist), start, count)
+		return
+	}
+	if o.Encoding == OBJ_ENCODING_ZIPLIST {
+		o.ptr = ZiplistDeleteRange(o.alloc, o.ptr.([]byte), start, count)
+		return
+	}
+	l := o.ptr.(*List)
+	node := l.ListIndex(start)
+	for ; count > 0 && node != nil; count-- {
+		next := node.next
+		l.ListDelNode(node)
+		node = next
+	}
+}
+
+// getLongLongFromObjectOrReply 解析整数参数，失败时回复错误
+func (c *Client) getLongLongFromObjectOrReply(arg []byte) (int64, bool) {
+	v, ok := stringObjectInt64(arg)
+	if !ok {
+		c.addReplyError("value is not an integer or out of range")
+	}
+	return v, ok
+}
+
+// getPositiveLongFromObjectOrReply 解析非负整数参数，为负数时回复msg
+func (c *Client) getPositiveLongFromObjectOrReply(arg []byte, msg string) (int64, bool) {
+	v, ok := c.getLongLongFromObjectOrReply(arg)
+	if ok && v < 0 {
+		c.addReplyError(msg)
+		return 0, false
+	}
+	return v, ok
+}
+
+// lookupListWrite 写命令查找列表，类型错误时回复错误并返回false
+func lookupListWrite(c *Client, key string) (*RedisObject, bool) {
+	o := lookupKeyWrite(c.db, key)
+	if o != nil && o.Type != OBJ_LIST {
+		c.addReplyWrongType()
+		return nil, false
+	}
+	return o, true
+}
+
+// lookupListRead 读命令查找列表，类型错误时回复错误并返回false
+func lookupListRead(c *Client, key string) (*RedisObject, bool) {
+	o := lookupKeyRead(c.db, key)
+	if o != nil && o.Type != OBJ_LIST {
+		c.addReplyWrongType()
+		return nil, false
+	}
+	return o, true
+}
+
+// createListKey 创建列表并加入键空间，开启ListQuicklist时使用快速表编码，否则使用压缩表编码
+func createListKey(db *RedisDb, key string) *RedisObject {
+	var o *RedisObject
+	if s := db.server; s != nil && s.ListQuicklist {
+		o = CreateQuicklistObject(db.alloc)
+		QuicklistSetOptions(o.ptr.(*Quicklist), s.ListMaxZiplistSize, s.ListCompressDepth)
+	} else {
+		o = CreateZiplistObject(db.alloc)
+	}
+	initObjectFreq(db, o, nil)
+	dbAdd(db, key, o)
+	return o
+}
+
+// deleteListIfEmpty 列表为空时删除键
+func deleteListIfEmpty(db *RedisDb, key string, o *RedisObject) {
+	if listTypeLength(o) == 0 {
+		dbDelete(db, key)
+	}
+}
+
+// pushGenericCommand LPUSH RPUSH LPUSHX RPUSHX key element [element ...]
+// xx 为true时只有列表存在才加入
+func pushGenericCommand(c *Client, where int, xx bool) {
+	key := string(c.Argv[1])
+	o, ok := lookupListWrite(c, key)
+	if !ok {
+		return
+	}
+	if o == nil {
+		if xx {
+			c.reply.WriteInt(0)
+			return
+		}
+		o = createListKey(c.db, key)
+	}
+	for _, value := range c.Argv[2:] {
+		listTypePush(o, c.server.ListMaxZiplistSize, value, where)
+	}
+	c.reply.WriteInt(int64(listTypeLength(o)))
+}
+
+func lpushCommand(c *Client) {
+	pushGenericCommand(c, LIST_HEAD, false)
+}
+
+func rpushCommand(c *Client) {
+	pushGenericCommand(c, LIST_TAIL, false)
+}
+
+func lpushxCommand(c *Client) {
+	pushGenericCommand(c, LIST_HEAD, true)
+}
+
+func rpushxCommand(c *Client) {
+	pushGenericCommand(c, LIST_TAIL, true)
+}
+
+// linsertCommand LINSERT key BEFORE|AFTER pivot element
+// 返回插入之后的长度，没有找到pivot返回-1，键不存在返回0
+func linsertCommand(c *Client) {
+	var where int
+	switch strings.ToLower(string(c.Argv[2])) {
+	case "after":
+		where = LIST_TAIL
+	case "before":
+		where = LIST_HEAD
+	default:
+		c.addReplyError("syntax error")
+		return
+	}
+	o, ok := lookupListWrite(c, string(c.Argv[1]))
+	if !ok {
+		return
+	}
+	if o == nil {
+		c.reply.WriteInt(0)
+		return
+	}
+	pivot, value := c.Argv[3], c.Argv[4]
+	// 插入之前转换编码，查找到的元素在转换之后失效
+	listTypeTryConversion(o, c.server.ListMaxZiplistSize, value)
+	li := listTypeInitIterator(o, 0, LIST_TAIL)
+	defer listTypeReleaseIterator(li)
+	var entry listTypeEntry
+	for listTypeNext(li, &entry) {
+		if listTypeEqual(&entry, pivot) {
+			listTypeInsert(&entry, value, where)
+			c.reply.WriteInt(int64(listTypeLength(o)))
+			return
+		}
+	}
+	c.reply.WriteInt(-1)
+}
+
+func llenCommand(c *Client) {
+	o, ok := lookupListRead(c, string(c.Argv[1]))
+	if !ok {
+		return
+	}
+	if o == nil {
+		c.reply.WriteInt(0)
+		return
+	}
+	c.reply.WriteInt(int64(listTypeLength(o)))
+}
+
+// lindexCommand LINDEX key index
+func lindexCommand(c *Client) {
+	o, ok := lookupListRead(c, string(c.Argv[1]))
+	if !ok {
+		return
+	}
+	if o == nil {
+		c.reply.WriteNil()
+		return
+	}
+	index, ok := c.getLongLongFromObjectOrReply(c.Argv[2])
+	if !ok {
+		return
+	}
+	value, ok := listTypeIndex(o, int(index))
+	if !ok {
+		c.reply.WriteNil()
+		return
+	}
+	c.reply.WriteBulk(value)
+}
+
+// lsetCommand LSET key index element
+func lsetCommand(c *Client) {
+	o, ok := lookupListWrite(c, string(c.Argv[1]))
+	if !ok {
+		return
+	}
+	if o == nil {
+		c.addReplyError("no such key")
+		return
+	}
+	index, ok := c.getLongLongFromObjectOrReply(c.Argv[2])
+	if !ok {
+		return
+	}
+	if !listTypeReplace(o, c.server.ListMaxZiplistSize, int(index), c.Argv[3]) {
+		c.addReplyError("index out of range")
+		return
+	}
+	c.addReplyOK()
+}
+
+// popGenericCommand LPOP RPOP key [count]
+// 没有count时返回一个元素，有count时返回数组，键不存在时返回nil数组
+func popGenericCommand(c *Client, where int) {
+	if len(c.Argv) > 3 {
+		c.addReplyError("wrong number of arguments for '" + strings.ToLower(string(c.Argv[0])) + "' command")
+		return
+	}
+	count := int64(-1)
+	if len(c.Argv) == 3 {
+		var ok bool
+		count, ok = c.getPositiveLongFromObjectOrReply(c.Argv[2], "value is out of range, must be positive")
+		if !ok {
+			return
+		}
+	}
+	key := string(c.Argv[1])
+	o, ok := lookupListWrite(c, key)
+	if !ok {
+		return
+	}
+	if o == nil || count == 0 {
+		if count == -1 {
+			c.reply.WriteNil()
+		} else {
+			c.reply.WriteNilArray()
+		}
+		return
+	}
+
+	if count == -1 {
+		c.reply.WriteBulk(listTypePop(o, where))
+	} else {
+		if n := int64(listTypeLength(o)); count > n {
+			count = n
+		}
+		c.reply.WriteArray(int(count))
+		for i := int64(0); i < count; i++ {
+			c.reply.WriteBulk(listTypePop(o, where))
+		}
+	}
+	deleteListIfEmpty(c.db, key, o)
+}
+
+func lpopCommand(c *Client) {
+	popGenericCommand(c, LIST_HEAD)
+}
+
+func rpopCommand(c *Client) {
+	popGenericCommand(c, LIST_TAIL)
+}
+
+// lrangeCommand LRANGE key start stop 包含stop
+func lrangeCommand(c *Client) {
+	start, ok := c.getLongLongFromObjectOrReply(c.Argv[2])
+	if !ok {
+		return
+	}
+	end, ok := c.getLongLongFromObjectOrReply(c.Argv[3])
+	if !ok {
+		return
+	}
+	o, ok := lookupListRead(c, string(c.Argv[1]))
+	if !ok {
+		return
+	}
+	if o == nil {
+		c.reply.WriteArray(0)
+		return
+	}
+
+	llen := int64(listTypeLength(o))
+	if start < 0 {
+		start += llen
+	}
+	if end < 0 {
+		end += llen
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > end || start >= llen {
+		c.reply.WriteArray(0)
+		return
+	}
+	if end >= llen {
+		end = llen - 1
+	}
+	rangelen := int(end - start + 1)
+	c.reply.WriteArray(rangelen)
+	li := listTypeInitIterator(o, int(start), LIST_TAIL)
+	var entry listTypeEntry
+	for i := 0; i < rangelen && listTypeNext(li, &entry); i++ {
+		c.reply.WriteBulk(listTypeGet(&entry))
+	}
+	listTypeReleaseIterator(li)
+}
+
+// ltrimCommand LTRIM key start stop 只保留[start, stop]之间的元素
+func ltrimCommand(c *Client) {
+	start, ok := c.getLongLongFromObjectOrReply(c.Argv[2])
+	if !ok {
+		return
+	}
+	end, ok := c.getLongLongFromObjectOrReply(c.Argv[3])
+	if !ok {
+		return
+	}
+	key := string(c.Argv[1])
+	o, ok := lookupListWrite(c, key)
+	if !ok {
+		return
+	}
+	if o == nil {
+		c.addReplyOK()
+		return
+	}
+
+	llen := int64(listTypeLength(o))
+	if start < 0 {
+		start += llen
+	}
+	if end < 0 {
+		end += llen
+	}
+	if start < 0 {
+		start = 0
+	}
+	var ltrim, rtrim int64
+	if start > end || start >= llen {
+		// 范围为空，删除所有的元素
+		ltrim, rtrim = llen, 0
+	} else {
+		if end >= llen {
+			end = llen - 1
+		}
+		ltrim, rtrim = start, llen-end-1
+	}
+	listTypeDelRange(o, int(llen-rtrim), int(rtrim))
+	listTypeDelRange(o, 0, int(ltrim))
+	deleteListIfEmpty(c.db, key, o)
+	c.addReplyOK()
+}
+
+// lremCommand LREM key count element
+// count大于0从表头开始删除count个，小于0从表尾开始删除-count个，等于0删除所有
+func lremCommand(c *Client) {
+	count, ok := c.getLongLongFromObjectOrReply(c.Argv[2])
+	if !ok {
+		return
+	}
+	key := string(c.Argv[1])
+	o, ok := lookupListWrite(c, key)
+	if !ok {
+		return
+	}
+	if o == nil {
+		c.reply.WriteInt(0)
+		return
+	}
+
+	var li *listTypeIterator
+	if count < 0 {
+		count = -count
+		li = listTypeInitIterator(o, -1, LIST_HEAD)
+	} else {
+		li = listTypeInitIterator(o, 0, LIST_TAIL)
+	}
+	var removed int64
+	var entry listTypeEntry
+	for listTypeNext(li, &entry) {
+		if listTypeEqual(&entry, c.Argv[3]) {
+			listTypeDelete(li, &entry)
+			removed++
+			if count > 0 && removed == count {
+				break
+			}
+		}
+	}
+	listTypeReleaseIterator(li)
+	deleteListIfEmpty(c.db, key, o)
+	c.reply.WriteInt(removed)
+}
+
+// lposCommand LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+// RANK 返回第rank个匹配，负数从表尾开始查找
+// COUNT 返回最多num个匹配的索引，0表示所有，没有COUNT时只返回一个索引
+// MAXLEN 最多比较len个元素，0表示不限制
+func lposCommand(c *Client) {
+	var rank, count, maxlen int64 = 1, -1, 0
+	for j := 3; j < len(c.Argv); j++ {
+		opt := strings.ToLower(string(c.Argv[j]))
+		moreargs := j+1 < len(c.Argv)
+		var ok bool
+		switch {
+		case opt == "rank" && moreargs:
+			j++
+			if rank, ok = c.getLongLongFromObjectOrReply(c.Argv[j]); !ok {
+				return
+			}
+			// -rank 溢出
+			if rank == math.MinInt64 {
+				c.addReplyError("value is out of range, value must between -9223372036854775807 and 9223372036854775807")
+				return
+			}
+			if rank == 0 {
+				c.addReplyError("RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+				return
+			}
+		case opt == "count" && moreargs:
+			j++
+			if count, ok = c.getPositiveLongFromObjectOrReply(c.Argv[j], "COUNT can't be negative"); !ok {
+				return
+			}
+		case opt == "maxlen" && moreargs:
+			j++
+			if maxlen, ok = c.getPositiveLongFromObjectOrReply(c.Argv[j], "MAXLEN can't be negative"); !ok {
+				return
+			}
+		default:
+			c.addReplyError("syntax error")
+			return
+		}
+	}
+
+	direction := LIST_TAIL
+	if rank < 0 {
+		rank = -rank
+		direction = LIST_HEAD
+	}
+
+	o, ok := lookupListRead(c, string(c.Argv[1]))
+	if !ok {
+		return
+	}
+	if o == nil {
+		if count != -1 {
+			c.reply.WriteArray(0)
+		} else {
+			c.reply.WriteNil()
+		}
+		return
+	}
+
+	start := 0
+	if direction == LIST_HEAD {
+		start = -1
+	}
+	li := listTypeInitIterator(o, start, direction)
+	defer listTypeReleaseIterator(li)
+	llen := int64(listTypeLength(o))
+	var index, matches int64
+	var indexes []int64
+	var entry listTypeEntry
+	for listTypeNext(li, &entry) && (maxlen == 0 || index < maxlen) {
+		if listTypeEqual(&entry, c.Argv[2]) {
+			matches++
+			if matches >= rank {
+				matchindex := index
+				if direction == LIST_HEAD {
+					matchindex = llen - index - 1
+				}
+				indexes = append(indexes, matchindex)
+				if count == -1 || (count > 0 && int64(len(indexes)) >= count) {
+					break
+				}
+			}
+		}
+		index++
+	}
+
+	if count != -1 {
+		c.reply.WriteArray(len(indexes))
+		for _, i := range indexes {
+			c.reply.WriteInt(i)
+		}
+		return
+	}
+	if len(indexes) == 0 {
+		c.reply.WriteNil()
+		return
+	}
+	c.reply.WriteInt(indexes[0])
+}
+
+// getListPosition LEFT|RIGHT
+func getListPosition(c *Client, arg []byte) (int, bool) {
+	switch strings.ToLower(string(arg)) {
+	case "left":
+		return LIST_HEAD, true
+	case "right":
+		return LIST_TAIL, true
+	}
+	c.addReplyError("syntax error")
+	return 0, false
+}
+
+// lmoveGenericCommand 从source的wherefrom弹出元素，加入到destination的whereto
+// 在弹出之前检查destination的类型，source和destination可以是同一个列表
+func lmoveGenericCommand(c *Client, wherefrom, whereto int) {
+	srckey, dstkey := string(c.Argv[1]), string(c.Argv[2])
+	sobj, ok := lookupListWrite(c, srckey)
+	if !ok {
+		return
+	}
+	if sobj == nil {
+		c.reply.WriteNil()
+		return
+	}
+	dobj, ok := lookupListWrite(c, dstkey)
+	if !ok {
+		return
+	}
+	value := listTypePop(sobj, wherefrom)
+	if dobj == nil {
+		dobj = createListKey(c.db, dstkey)
+	}
+	listTypePush(dobj, c.server.ListMaxZiplistSize, value, whereto)
+	// source和destination相同时加入之后不为空
+	deleteListIfEmpty(c.db, srckey, sobj)
+	c.reply.WriteBulk(value)
+}
+
+// rpoplpushCommand RPOPLPUSH source destination
+func rpoplpushCommand(c *Client) {
+	lmoveGenericCommand(c, LIST_TAIL, LIST_HEAD)
+}
+
+// lmoveCommand LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func lmoveCommand(c *Client) {
+	wherefrom, ok := getListPosition(c, c.Argv[3])
+	if !ok {
+		return
+	}
+	whereto, ok := getListPosition(c, c.Argv[4])
+	if !ok {
+		return
+	}
+	lmoveGenericCommand(c, wherefrom, whereto)
+}
+
+// blockingPopGenericCommand BLPOP BRPOP key [key ...] timeout
+// 按照参数的顺序弹出第一个不为空的列表，都为空时阻塞，事务中不阻塞直接返回nil
+func blockingPopGenericCommand(c *Client, where int) {
+	timeout, ok := c.getTimeoutFromObjectOrReply(c.Argv[len(c.Argv)-1])
+	if !ok {
+		return
+	}
+	keys := make([]string, 0, len(c.Argv)-2)
+	for _, arg := range c.Argv[1 : len(c.Argv)-1] {
+		key := string(arg)
+		o, ok := lookupListWrite(c, key)
+		if !ok {
+			return
+		}
+		if o != nil && listTypeLength(o) > 0 {
+			c.reply.WriteArray(2)
+			c.reply.WriteBulkString(key)
+			c.reply.WriteBulk(listTypePop(o, where))
+			deleteListIfEmpty(c.db, key, o)
+			return
+		}
+		keys = append(keys, key)
+	}
+	if c.Flags&CLIENT_MULTI != 0 {
+		c.reply.WriteNilArray()
+		return
+	}
+	blockForKeys(c, keys, timeout, where, "", false, 0)
+}
+
+func blpopCommand(c *Client) {
+	blockingPopGenericCommand(c, LIST_HEAD)
+}
+
+func brpopCommand(c *Client) {
+	blockingPopGenericCommand(c, LIST_TAIL)
+}
+
+// blmoveGenericCommand source为空时阻塞，否则同LMOVE
+func blmoveGenericCommand(c *Client, wherefrom, whereto int, timeoutArg []byte) {
+	timeout, ok := c.getTimeoutFromObjectOrReply(timeoutArg)
+	if !ok {
+		return
+	}
+	o, ok := lookupListWrite(c, string(c.Argv[1]))
+	if !ok {
+		return
+	}
+	if o != nil {
+		lmoveGenericCommand(c, wherefrom, whereto)
+		return
+	}
+	if c.Flags&CLIENT_MULTI != 0 {
+		c.reply.WriteNil()
+		return
+	}
+	blockForKeys(c, []string{string(c.Argv[1])}, timeout, wherefrom, string(c.Argv[2]), true, whereto)
+}
+
+// brpoplpushCommand BRPOPLPUSH source destination timeout
+func brpoplpushCommand(c *Client) {
+	blmoveGenericCommand(c, LIST_TAIL, LIST_HEAD, c.Argv[3])
+}
+
+// blmoveCommand BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func blmoveCommand(c *Client) {
+	wherefrom, ok := getListPosition(c, c.Argv[3])
+	if !ok {
+		return
+	}
+	whereto, ok := getListPosition(c, c.Argv[4])
+	if !ok {
+		return
+	}
+	blmoveGenericCommand(c, wherefrom, whereto, c.Argv[5])
+}
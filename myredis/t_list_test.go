@@ -0,0 +1,450 @@
+package myredis
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+// listEncodings 分别使用压缩表、双端链表和快速表编码测试，fill为1时第二个元素加入之前转换为双端链表
+// 快速表每个节点最多3个元素，使用多个节点
+func listEncodings(t *testing.T, f func(t *testing.T, client *redis.Client)) {
+	for _, enc := range []struct {
+		name      string
+		fill      int
+		quicklist bool
+	}{{"ziplist", 128, false}, {"linkedlist", 1, false}, {"quicklist", 3, true}} {
+		t.Run(enc.name, func(t *testing.T) {
+			fill, quicklist := enc.fill, enc.quicklist
+			_, addr := startServer(t, func(s *Server) {
+				s.ListMaxZiplistSize = fill
+				s.ListQuicklist = quicklist
+				s.ListCompressDepth = 1
+			})
+			client := newTestClient(addr)
+			defer client.Close()
+			f(t, client)
+		})
+	}
+}
+
+func assertList(t *testing.T, client *redis.Client, key string, want ...string) {
+	t.Helper()
+	got := client.LRange(key, 0, -1).Val()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("%s = %v, want %v", key, got, want)
+	}
+}
+
+func TestListPushPop(t *testing.T) {
+	listEncodings(t, func(t *testing.T, client *redis.Client) {
+		if n := client.RPush("l", "a", "b", "c").Val(); n != 3 {
+			t.Fatalf("rpush %d", n)
+		}
+		if n := client.LPush("l", "1", "2").Val(); n != 5 {
+			t.Fatalf("lpush %d", n)
+		}
+		assertList(t, client, "l", "2", "1", "a", "b", "c")
+		if client.LLen("l").Val() != 5 || client.LLen("missing").Val() != 0 {
+			t.Fatal("llen")
+		}
+
+		if n := client.LPushX("missing", "v").Val(); n != 0 || client.Exists("missing").Val() != 0 {
+			t.Fatal("lpushx on missing key")
+		}
+		if n := client.Do("rpushx", "l", "d", "e").Val(); n != int64(7) {
+			t.Fatalf("rpushx %v", n)
+		}
+
+		if v := client.LPop("l").Val(); v != "2" {
+			t.Fatalf("lpop %s", v)
+		}
+		if v := client.RPop("l").Val(); v != "e" {
+			t.Fatalf("rpop %s", v)
+		}
+		if v, _ := client.Do("lpop", "l", 2).Result(); strings.Join(toStrings(v), ",") != "1,a" {
+			t.Fatalf("lpop count %v", v)
+		}
+		if v, _ := client.Do("rpop", "l", 10).Result(); strings.Join(toStrings(v), ",") != "d,c,b" {
+			t.Fatalf("rpop count %v", v)
+		}
+		// 弹出所有元素之后删除键
+		if client.Exists("l").Val() != 0 {
+			t.Fatal("empty list should be deleted")
+		}
+		if err := client.LPop("l").Err(); err != redis.Nil {
+			t.Fatalf("lpop missing %v", err)
+		}
+		if err := client.Do("lpop", "l", 1).Err(); err != redis.Nil {
+			t.Fatalf("lpop count missing %v", err)
+		}
+		if err := client.Do("lpop", "l", -1).Err(); err == nil || !strings.Contains(err.Error(), "must be positive") {
+			t.Fatalf("lpop negative count %v", err)
+		}
+
+		client.Set("s", "v", 0)
+		if err := client.LPush("s", "v").Err(); err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+			t.Fatalf("lpush on string %v", err)
+		}
+		if err := client.LRange("s", 0, -1).Err(); err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+			t.Fatalf("lrange on string %v", err)
+		}
+	})
+}
+
+func toStrings(v interface{}) []string {
+	var s []string
+	for _, item := range v.([]interface{}) {
+		s = append(s, item.(string))
+	}
+	return s
+}
+
+func TestListIndexRange(t *testing.T) {
+	listEncodings(t, func(t *testing.T, client *redis.Client) {
+		client.RPush("l", "a", "b", "c", "d", "e", "100")
+		for index, want := range map[int64]string{0: "a", 2: "c", 5: "100", -1: "100", -6: "a", -3: "d"} {
+			if v := client.LIndex("l", index).Val(); v != want {
+				t.Fatalf("lindex %d = %s, want %s", index, v, want)
+			}
+		}
+		if err := client.LIndex("l", 6).Err(); err != redis.Nil {
+			t.Fatalf("lindex out of range %v", err)
+		}
+		if err := client.LIndex("l", -7).Err(); err != redis.Nil {
+			t.Fatalf("lindex out of range %v", err)
+		}
+		// 先查找键并检查类型，再解析索引
+		if err := client.Do("lindex", "missing", "x").Err(); err != redis.Nil {
+			t.Fatalf("lindex missing key with invalid index %v", err)
+		}
+		client.Set("s", "v", 0)
+		if err := client.Do("lindex", "s", "x").Err(); err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+			t.Fatalf("lindex wrong type with invalid index %v", err)
+		}
+		if err := client.Do("lindex", "l", "x").Err(); err == nil || err.Error() != "ERR value is not an integer or out of range" {
+			t.Fatalf("lindex invalid index %v", err)
+		}
+
+		for _, c := range []struct {
+			start, stop int64
+			want        string
+		}{
+			{0, -1, "a,b,c,d,e,100"},
+			{1, 2, "b,c"},
+			{-2, -1, "e,100"},
+			{-100, 1, "a,b"},
+			{4, 100, "e,100"},
+			{3, 1, ""},
+			{6, 10, ""},
+		} {
+			if got := strings.Join(client.LRange("l", c.start, c.stop).Val(), ","); got != c.want {
+				t.Fatalf("lrange %d %d = %s, want %s", c.start, c.stop, got, c.want)
+			}
+		}
+		if err := client.Do("lrange", "l", "a", 1).Err(); err == nil {
+			t.Fatal("lrange with invalid index")
+		}
+
+		if err := client.LSet("l", -1, "f").Err(); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.LSet("l", 0, strings.Repeat("x", 100)).Err(); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.LSet("l", 6, "x").Err(); err == nil || err.Error() != "ERR index out of range" {
+			t.Fatalf("lset out of range %v", err)
+		}
+		if err := client.LSet("missing", 0, "x").Err(); err == nil || err.Error() != "ERR no such key" {
+			t.Fatalf("lset missing %v", err)
+		}
+		assertList(t, client, "l", strings.Repeat("x", 100), "b", "c", "d", "e", "f")
+	})
+}
+
+func TestListInsertRemTrim(t *testing.T) {
+	listEncodings(t, func(t *testing.T, client *redis.Client) {
+		client.RPush("l", "a", "b", "c")
+		if n := client.LInsert("l", "before", "a", "0").Val(); n != 4 {
+			t.Fatalf("linsert %d", n)
+		}
+		client.LInsert("l", "after", "c", "d")
+		client.LInsert("l", "AFTER", "a", "a2")
+		if n := client.LInsert("l", "before", "missing", "x").Val(); n != -1 {
+			t.Fatalf("linsert missing pivot %d", n)
+		}
+		if n := client.LInsert("nokey", "before", "a", "x").Val(); n != 0 {
+			t.Fatalf("linsert missing key %d", n)
+		}
+		if err := client.Do("linsert", "l", "middle", "a", "x").Err(); err == nil {
+			t.Fatal("linsert syntax error")
+		}
+		assertList(t, client, "l", "0", "a", "a2", "b", "c", "d")
+
+		client.Del("l")
+		client.RPush("l", "x", "a", "x", "b", "x", "c", "x")
+		if n := client.LRem("l", 2, "x").Val(); n != 2 {
+			t.Fatalf("lrem %d", n)
+		}
+		assertList(t, client, "l", "a", "b", "x", "c", "x")
+		if n := client.LRem("l", -1, "x").Val(); n != 1 {
+			t.Fatalf("lrem from tail %d", n)
+		}
+		assertList(t, client, "l", "a", "b", "x", "c")
+		client.RPush("l", "x")
+		if n := client.LRem("l", 0, "x").Val(); n != 2 {
+			t.Fatalf("lrem all %d", n)
+		}
+		assertList(t, client, "l", "a", "b", "c")
+
+		client.RPush("l", "d", "e", "f")
+		client.LTrim("l", 1, -2)
+		assertList(t, client, "l", "b", "c", "d", "e")
+		client.LTrim("l", -3, 100)
+		assertList(t, client, "l", "c", "d", "e")
+		client.LTrim("l", 2, 1)
+		if client.Exists("l").Val() != 0 {
+			t.Fatal("ltrim to empty should delete the key")
+		}
+	})
+}
+
+func TestListPos(t *testing.T) {
+	listEncodings(t, func(t *testing.T, client *redis.Client) {
+		client.RPush("l", "a", "b", "c", "1", "2", "3", "c", "c")
+		lpos := func(args ...interface{}) interface{} {
+			v, err := client.Do(append([]interface{}{"lpos", "l"}, args...)...).Result()
+			if err == redis.Nil {
+				return nil
+			}
+			if err != nil {
+				return err.Error()
+			}
+			return v
+		}
+		for _, c := range []struct {
+			args []interface{}
+			want string
+		}{
+			{[]interface{}{"c"}, "2"},
+			{[]interface{}{"c", "rank", 2}, "6"},
+			{[]interface{}{"c", "rank", -1}, "7"},
+			{[]interface{}{"c", "rank", -3}, "2"},
+			{[]interface{}{"c", "count", 0}, "[2 6 7]"},
+			{[]interface{}{"c", "count", 2, "rank", -1}, "[7 6]"},
+			{[]interface{}{"c", "maxlen", 2}, "<nil>"},
+			{[]interface{}{"c", "count", 0, "maxlen", 7}, "[2 6]"},
+			{[]interface{}{"x", "count", 1}, "[]"},
+			{[]interface{}{"1"}, "3"},
+			{[]interface{}{"c", "rank", "-9223372036854775808"}, "ERR value is out of range, value must between -9223372036854775807 and 9223372036854775807"},
+			{[]interface{}{"c", "rank", "-9223372036854775807"}, "<nil>"},
+			{[]interface{}{"c", "rank", 0}, "ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list"},
+			{[]interface{}{"c", "count", -1}, "ERR COUNT can't be negative"},
+			{[]interface{}{"c", "maxlen"}, "ERR syntax error"},
+		} {
+			if got := fmtValue(lpos(c.args...)); got != c.want {
+				t.Fatalf("lpos %v = %s, want %s", c.args, got, c.want)
+			}
+		}
+		if v, err := client.Do("lpos", "missing", "a").Result(); err != redis.Nil {
+			t.Fatalf("lpos missing %v %v", v, err)
+		}
+	})
+}
+
+// fmtValue 把回复转换为字符串，数组为[a b]
+func fmtValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "<nil>"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case string:
+		return v
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmtValue(item)
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	}
+	return "?"
+}
+
+func TestListMove(t *testing.T) {
+	listEncodings(t, func(t *testing.T, client *redis.Client) {
+		client.RPush("src", "a", "b", "c")
+		if v := client.RPopLPush("src", "dst").Val(); v != "c" {
+			t.Fatalf("rpoplpush %s", v)
+		}
+		if v, _ := client.Do("lmove", "src", "dst", "left", "right").Result(); v != "a" {
+			t.Fatalf("lmove %v", v)
+		}
+		assertList(t, client, "src", "b")
+		assertList(t, client, "dst", "c", "a")
+
+		// 同一个列表，旋转
+		client.Do("lmove", "dst", "dst", "LEFT", "RIGHT")
+		assertList(t, client, "dst", "a", "c")
+		client.RPopLPush("src", "src")
+		assertList(t, client, "src", "b")
+
+		client.RPopLPush("src", "dst")
+		if client.Exists("src").Val() != 0 {
+			t.Fatal("source should be deleted when empty")
+		}
+		if err := client.RPopLPush("src", "dst").Err(); err != redis.Nil {
+			t.Fatalf("rpoplpush missing source %v", err)
+		}
+
+		// 目标类型错误时不弹出元素
+		client.Set("str", "v", 0)
+		if err := client.RPopLPush("dst", "str").Err(); err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+			t.Fatalf("rpoplpush to string %v", err)
+		}
+		assertList(t, client, "dst", "b", "a", "c")
+		if err := client.Do("lmove", "dst", "x", "up", "left").Err(); err == nil {
+			t.Fatal("lmove syntax error")
+		}
+	})
+}
+
+func TestListEncodingConversion(t *testing.T) {
+	_, addr := startServer(t, func(s *Server) { s.ListMaxZiplistSize = 4 })
+	client := newTestClient(addr)
+	defer client.Close()
+
+	client.RPush("l", "1", "2", "3", "4")
+	if enc := client.ObjectEncoding("l").Val(); enc != "ziplist" {
+		t.Fatalf("encoding %s", enc)
+	}
+	client.RPush("l", "5")
+	if enc := client.ObjectEncoding("l").Val(); enc != "linkedlist" {
+		t.Fatalf("encoding after exceeding entries %s", enc)
+	}
+	assertList(t, client, "l", "1", "2", "3", "4", "5")
+	// 删除元素之后不转换回压缩表
+	client.LTrim("l", 0, 0)
+	if enc := client.ObjectEncoding("l").Val(); enc != "linkedlist" {
+		t.Fatalf("encoding after trim %s", enc)
+	}
+
+	// 元素超过安全限制
+	client.RPush("big", "a")
+	client.LSet("big", 0, strings.Repeat("x", SIZE_SAFETY_LIMIT+1))
+	if enc := client.ObjectEncoding("big").Val(); enc != "linkedlist" {
+		t.Fatalf("encoding with big element %s", enc)
+	}
+
+	// 按照字节数限制
+	_, addr = startServer(t, func(s *Server) { s.ListMaxZiplistSize = -1 })
+	client2 := newTestClient(addr)
+	defer client2.Close()
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 30; i++ {
+		client2.RPush("l", value)
+	}
+	if enc := client2.ObjectEncoding("l").Val(); enc != "ziplist" {
+		t.Fatalf("encoding under 4k %s", enc)
+	}
+	for i := 0; i < 20; i++ {
+		client2.RPush("l", value)
+	}
+	if enc := client2.ObjectEncoding("l").Val(); enc != "linkedlist" {
+		t.Fatalf("encoding over 4k %s", enc)
+	}
+	if n := client2.LLen("l").Val(); n != 50 {
+		t.Fatalf("llen %d", n)
+	}
+
+	// 快速表编码不转换
+	_, addr = startServer(t, func(s *Server) {
+		s.ListQuicklist = true
+		s.ListMaxZiplistSize = 4
+	})
+	client3 := newTestClient(addr)
+	defer client3.Close()
+	client3.RPush("l", "1", "2", "3", "4", "5", strings.Repeat("x", SIZE_SAFETY_LIMIT+1))
+	if enc := client3.ObjectEncoding("l").Val(); enc != "quicklist" {
+		t.Fatalf("quicklist encoding %s", enc)
+	}
+	if n := client3.LLen("l").Val(); n != 6 {
+		t.Fatalf("quicklist llen %d", n)
+	}
+}
+
+// TestListTypeMemory 列表转换和释放之后内存回到分配之前
+func TestListTypeMemory(t *testing.T) {
+	base := usedMemory()
+	o := CreateZiplistObject(nil)
+	for i := 0; i < 100; i++ {
+		listTypePush(o, 16, []byte("value:"+strconv.Itoa(i)), LIST_TAIL)
+		listTypePush(o, 16, []byte(strconv.Itoa(i)), LIST_HEAD)
+	}
+	if o.Encoding != OBJ_ENCODING_LINKEDLIST || listTypeLength(o) != 200 {
+		t.Fatalf("encoding %d length %d", o.Encoding, listTypeLength(o))
+	}
+	if v := listTypePop(o, LIST_HEAD); string(v) != "99" {
+		t.Fatalf("pop %s", v)
+	}
+	listTypeReplace(o, 16, -1, []byte("replaced"))
+	listTypeDelRange(o, 10, 20)
+	DecrRefCount(o)
+	if used := usedMemory(); used != base {
+		t.Fatalf("leaked %d bytes", used-base)
+	}
+}
+
+// TestListTypeQuicklist 快速表编码的列表，中间的节点被压缩
+func TestListTypeQuicklist(t *testing.T) {
+	base := usedMemory()
+	o := CreateQuicklistObject(nil)
+	QuicklistSetOptions(o.ptr.(*Quicklist), 8, 1)
+	value := strings.Repeat("v", 64)
+	for i := 0; i < 100; i++ {
+		listTypePush(o, 8, []byte(value+strconv.Itoa(i)), LIST_TAIL)
+		listTypePush(o, 8, []byte(strconv.Itoa(i)), LIST_HEAD)
+	}
+	if o.Encoding != OBJ_ENCODING_QUICKLIST || listTypeLength(o) != 200 {
+		t.Fatalf("encoding %s length %d", ObjectEncodingName(o), listTypeLength(o))
+	}
+	if v := listTypePop(o, LIST_HEAD); string(v) != "99" {
+		t.Fatalf("pop %s", v)
+	}
+	if v, ok := listTypeIndex(o, 150); !ok || string(v) != value+"51" {
+		t.Fatalf("index %s", v)
+	}
+	if !listTypeReplace(o, 8, -1, []byte("replaced")) || listTypeReplace(o, 8, 199, []byte("x")) {
+		t.Fatal("replace")
+	}
+
+	// 从表尾迭代并删除所有的整数
+	li := listTypeInitIterator(o, -1, LIST_HEAD)
+	var entry listTypeEntry
+	for listTypeNext(li, &entry) {
+		if _, err := strconv.Atoi(string(listTypeGet(&entry))); err == nil {
+			listTypeDelete(li, &entry)
+		}
+	}
+	listTypeReleaseIterator(li)
+	if n := listTypeLength(o); n != 100 {
+		t.Fatalf("length after delete %d", n)
+	}
+	li = listTypeInitIterator(o, 0, LIST_TAIL)
+	listTypeNext(li, &entry)
+	listTypeInsert(&entry, []byte("first"), LIST_HEAD)
+	listTypeReleaseIterator(li)
+	listTypeDelRange(o, 10, 20)
+	if v, ok := listTypeIndex(o, 0); !ok || string(v) != "first" || listTypeLength(o) != 81 {
+		t.Fatalf("first %s length %d", v, listTypeLength(o))
+	}
+	if _, ok := listTypeIndex(o, 81); ok {
+		t.Fatal("index out of range")
+	}
+	DecrRefCount(o)
+	if used := usedMemory(); used != base {
+		t.Fatalf("leaked %d bytes", used-base)
+	}
+}
@@ -0,0 +1,77 @@
+package myredis
+
+import (
+	"strconv"
+	"strings"
+)
+
+// 字符串命令
+
+func getCommand(c *Client) {
+	val := lookupKeyRead(c.db, string(c.Argv[1]))
+	if val == nil {
+		c.reply.WriteNil()
+		return
+	}
+	if val.Type != OBJ_STRING {
+		c.addReplyWrongType()
+		return
+	}
+	c.reply.WriteBulk(stringObjectBytes(val))
+}
+
+// setCommand SET key value [EX seconds|PX milliseconds] [NX|XX]
+func setCommand(c *Client) {
+	nx, xx := false, false
+	var expire int64
+	var unit int64
+	for i := 3; i < len(c.Argv); i++ {
+		arg := strings.ToLower(string(c.Argv[i]))
+		switch {
+		case arg == "nx" && !xx:
+			nx = true
+		case arg == "xx" && !nx:
+			xx = true
+		case (arg == "ex" || arg == "px") && unit == 0 && i+1 < len(c.Argv):
+			unit = 1
+			if arg == "ex" {
+				unit = 1000
+			}
+			i++
+			v, err := strconv.ParseInt(string(c.Argv[i]), 10, 64)
+			if err != nil {
+				c.addReplyError("value is not an integer or out of range")
+				return
+			}
+			if v <= 0 {
+				c.addReplyError("invalid expire time in set")
+				return
+			}
+			expire = v
+		default:
+			c.addReplyError("syntax error")
+			return
+		}
+	}
+	var when int64
+	if unit != 0 {
+		var ok bool
+		if when, ok = expireTime(expire, unit, mstime()); !ok {
+			c.addReplyError("invalid expire time in set")
+			return
+		}
+	}
+	key := string(c.Argv[1])
+	exists := lookupKeyWrite(c.db, key) != nil
+	if (nx && exists) || (xx && !exists) {
+		c.reply.WriteNil()
+		return
+	}
+	val := TryObjectEncoding(CreateStringObject(c.db.alloc, c.Argv[2]))
+	setKey(c.db, key, val)
+	DecrRefCount(val)
+	if unit != 0 {
+		setExpire(c.db, key, when)
+	}
+	c.addReplyOK()
+}
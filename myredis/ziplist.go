@@ -1,6 +1,11 @@
 package myredis
 
-import "unsafe"
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"unsafe"
+)
 
 // 压缩表是尽可能节约内存的一种数据存储方式
 // 压缩表是列表键和哈希键的底层实现之一
@@ -87,116 +92,690 @@ address                                |                          |        |
                                                         ZIPLIST_ENTRY_TAIL
 */
 
-const (
-	ZL_BYTES = 1 << 32 // 4 字节
-	ZL_TAIL  = 1 << 32 // 4字节
-	ZL_LEN   = 1 << 16 // 2 字节
-	ZL_END   = 1 << 8  // 1字节
-)
+/*
+节点的结构 <prevlen> <encoding> <entry-data>
+
+prevlen 前一个节点的字节数，用于从后向前遍历
+  前一个节点小于254字节时使用1字节保存
+  否则使用5字节，第一个字节为254，之后的4字节以小端序保存长度
+
+encoding 内容的类型和长度
+  |00pppppp|                                       1字节 长度小于等于63的字符串
+  |01pppppp|qqqqqqqq|                              2字节 长度小于等于16383的字符串 大端序
+  |10000000|qqqqqqqq|rrrrrrrr|ssssssss|tttttttt|    5字节 长度小于等于2^32-1的字符串 大端序
+  |11000000|                                       int16 之后2字节
+  |11010000|                                       int32 之后4字节
+  |11100000|                                       int64 之后8字节
+  |11110000|                                       24位有符号整数 之后3字节
+  |11111110|                                       int8 之后1字节
+  |1111xxxx|                                       xxxx在0001和1101之间，保存0-12的整数，没有entry-data
+  |11111111|                                       zlend
+
+头部以及整数都以小端序保存
+*/
 
 const (
-	// 用于表示zlBytes所占的字节数
-	bytesLength = 4
-	// 字节和位的转换 1字节=8bit
-	bitCarry = 8
+	ZIPLIST_HEAD = 0 // 从表头插入
+	ZIPLIST_TAIL = 1 // 从表尾插入
+
+	ZIP_END         = 255 // zlend
+	ZIP_BIG_PREVLEN = 254 // prevlen使用5字节时第一个字节的值
+
+	ZIP_STR_MASK = 0xc0
+	ZIP_INT_MASK = 0x30
+	ZIP_STR_06B  = 0 << 6
+	ZIP_STR_14B  = 1 << 6
+	ZIP_STR_32B  = 2 << 6
+	ZIP_INT_16B  = 0xc0 | 0<<4
+	ZIP_INT_32B  = 0xc0 | 1<<4
+	ZIP_INT_64B  = 0xc0 | 2<<4
+	ZIP_INT_24B  = 0xc0 | 3<<4
+	ZIP_INT_8B   = 0xfe
+
+	ZIP_INT_IMM_MASK = 0x0f // 4位整数的掩码
+	ZIP_INT_IMM_MIN  = 0xf1 // 11110001 表示0
+	ZIP_INT_IMM_MAX  = 0xfd // 11111101 表示12
+
+	INT24_MAX = 0x7fffff
+	INT24_MIN = -INT24_MAX - 1
+
+	ZIPLIST_HEADER_SIZE = 4 + 4 + 2 // zlbytes zltail zllen
+	ZIPLIST_END_SIZE    = 1         // zlend
+	ZIPLIST_LEN_MAX     = 1<<16 - 1 // zllen 等于这个值时需要遍历压缩表计算节点数
 )
 
-func saveZltail(zl *[]byte, zlTail uint32) {
+// zlentry 解码之后的节点信息
+type zlentry struct {
+	prevrawlensize int  // prevlen 占用的字节数 1或者5
+	prevrawlen     int  // 前一个节点的字节数
+	lensize        int  // encoding 占用的字节数
+	len            int  // 内容的字节数，4位整数为0
+	headersize     int  // prevrawlensize + lensize
+	encoding       byte // ZIP_STR_* 或者 ZIP_INT_*
+	p              int  // 节点在压缩表中的偏移量
+}
+
+func ziplistBytes(zl []byte) int {
+	return int(binary.LittleEndian.Uint32(zl))
+}
+
+func saveZlBytes(zl []byte, zlBytes uint32) {
+	binary.LittleEndian.PutUint32(zl, zlBytes)
+}
+
+func ziplistTailOffset(zl []byte) int {
+	return int(binary.LittleEndian.Uint32(zl[4:]))
+}
+
+func saveZltail(zl []byte, zlTail uint32) {
+	binary.LittleEndian.PutUint32(zl[4:], zlTail)
+}
+
+func ziplistLength(zl []byte) int {
+	return int(binary.LittleEndian.Uint16(zl[8:]))
+}
+
+func saveZllen(zl []byte, zllen uint16) {
+	binary.LittleEndian.PutUint16(zl[8:], zllen)
+}
+
+// ziplistIncrLength 修改节点数，已经达到ZIPLIST_LEN_MAX时不再更新
+func ziplistIncrLength(zl []byte, incr int) {
+	if n := ziplistLength(zl); n < ZIPLIST_LEN_MAX {
+		saveZllen(zl, uint16(n+incr))
+	}
+}
+
+// ziplistEntryEnd zlend 的偏移量
+func ziplistEntryEnd(zl []byte) int {
+	return ziplistBytes(zl) - ZIPLIST_END_SIZE
+}
+
+// zipIsStr 编码是否为字符串
+func zipIsStr(encoding byte) bool {
+	return encoding&ZIP_STR_MASK < ZIP_STR_MASK
+}
+
+// zipIntSize 整数编码的内容占用的字节数
+func zipIntSize(encoding byte) int {
+	switch encoding {
+	case ZIP_INT_8B:
+		return 1
+	case ZIP_INT_16B:
+		return 2
+	case ZIP_INT_24B:
+		return 3
+	case ZIP_INT_32B:
+		return 4
+	case ZIP_INT_64B:
+		return 8
+	}
+	if encoding >= ZIP_INT_IMM_MIN && encoding <= ZIP_INT_IMM_MAX {
+		return 0
+	}
+	panic("myredis: invalid ziplist integer encoding")
+}
+
+// zipStoreEntryEncoding 把encoding和字符串的长度写入p，返回占用的字节数
+// p为nil时只计算字节数
+func zipStoreEntryEncoding(p []byte, encoding byte, rawlen int) int {
+	if !zipIsStr(encoding) {
+		if p != nil {
+			p[0] = encoding
+		}
+		return 1
+	}
+	switch {
+	case rawlen <= 0x3f:
+		if p != nil {
+			p[0] = ZIP_STR_06B | byte(rawlen)
+		}
+		return 1
+	case rawlen <= 0x3fff:
+		if p != nil {
+			p[0] = ZIP_STR_14B | byte(rawlen>>8&0x3f)
+			p[1] = byte(rawlen)
+		}
+		return 2
+	}
+	if p != nil {
+		p[0] = ZIP_STR_32B
+		binary.BigEndian.PutUint32(p[1:], uint32(rawlen))
+	}
+	return 5
+}
+
+// zipEntryEncoding 节点的编码，字符串编码去掉长度部分
+func zipEntryEncoding(p []byte) byte {
+	encoding := p[0]
+	if encoding < ZIP_STR_MASK {
+		encoding &= ZIP_STR_MASK
+	}
+	return encoding
+}
+
+// zipDecodeLength 解码encoding，返回编码、encoding占用的字节数以及内容的长度
+func zipDecodeLength(p []byte) (encoding byte, lensize, length int) {
+	encoding = zipEntryEncoding(p)
+	switch encoding {
+	case ZIP_STR_06B:
+		return encoding, 1, int(p[0] & 0x3f)
+	case ZIP_STR_14B:
+		return encoding, 2, int(p[0]&0x3f)<<8 | int(p[1])
+	case ZIP_STR_32B:
+		return encoding, 5, int(binary.BigEndian.Uint32(p[1:]))
+	}
+	return encoding, 1, zipIntSize(encoding)
+}
+
+// zipStorePrevEntryLength 把前一个节点的长度写入p，返回占用的字节数，p为nil时只计算字节数
+func zipStorePrevEntryLength(p []byte, length int) int {
+	if length < ZIP_BIG_PREVLEN {
+		if p != nil {
+			p[0] = byte(length)
+		}
+		return 1
+	}
+	return zipStorePrevEntryLengthLarge(p, length)
+}
+
+// zipStorePrevEntryLengthLarge 使用5字节保存前一个节点的长度
+// 连锁更新时为了避免缩小节点，较短的长度也可能使用5字节保存
+func zipStorePrevEntryLengthLarge(p []byte, length int) int {
+	if p != nil {
+		p[0] = ZIP_BIG_PREVLEN
+		binary.LittleEndian.PutUint32(p[1:], uint32(length))
+	}
+	return 5
+}
+
+// zipDecodePrevLen 解码prevlen，返回占用的字节数和前一个节点的长度
+func zipDecodePrevLen(p []byte) (prevlensize, prevlen int) {
+	if p[0] < ZIP_BIG_PREVLEN {
+		return 1, int(p[0])
+	}
+	return 5, int(binary.LittleEndian.Uint32(p[1:]))
+}
+
+// zipPrevLenByteDiff p保存长度为length的前一个节点时prevlen需要增加的字节数
+func zipPrevLenByteDiff(p []byte, length int) int {
+	prevlensize, _ := zipDecodePrevLen(p)
+	return zipStorePrevEntryLength(nil, length) - prevlensize
+}
+
+// zipRawEntryLength p处节点占用的字节数
+func zipRawEntryLength(p []byte) int {
+	prevlensize, _ := zipDecodePrevLen(p)
+	_, lensize, length := zipDecodeLength(p[prevlensize:])
+	return prevlensize + lensize + length
+}
+
+// zipTryEncoding 字符串是否可以保存为整数，返回整数值以及最小的整数编码
+func zipTryEncoding(entry []byte) (value int64, encoding byte, ok bool) {
+	if len(entry) == 0 || len(entry) >= 32 {
+		return 0, 0, false
+	}
+	value, ok = stringObjectInt64(entry)
+	if !ok {
+		return 0, 0, false
+	}
+	switch {
+	case value >= 0 && value <= 12:
+		encoding = ZIP_INT_IMM_MIN + byte(value)
+	case value >= math.MinInt8 && value <= math.MaxInt8:
+		encoding = ZIP_INT_8B
+	case value >= math.MinInt16 && value <= math.MaxInt16:
+		encoding = ZIP_INT_16B
+	case value >= INT24_MIN && value <= INT24_MAX:
+		encoding = ZIP_INT_24B
+	case value >= math.MinInt32 && value <= math.MaxInt32:
+		encoding = ZIP_INT_32B
+	default:
+		encoding = ZIP_INT_64B
+	}
+	return value, encoding, true
+}
 
+// zipSaveInteger 以encoding编码把整数写入p
+func zipSaveInteger(p []byte, value int64, encoding byte) {
+	switch encoding {
+	case ZIP_INT_8B:
+		p[0] = byte(int8(value))
+	case ZIP_INT_16B:
+		binary.LittleEndian.PutUint16(p, uint16(value))
+	case ZIP_INT_24B:
+		// 低3字节 和redis中int32左移8位之后保存高3字节相同
+		p[0], p[1], p[2] = byte(value), byte(value>>8), byte(value>>16)
+	case ZIP_INT_32B:
+		binary.LittleEndian.PutUint32(p, uint32(value))
+	case ZIP_INT_64B:
+		binary.LittleEndian.PutUint64(p, uint64(value))
+	}
+	// 4位整数保存在encoding中
 }
 
-func saveZllen(zl *[]byte, zllen uint16) {
+// zipLoadInteger 读取encoding编码的整数
+func zipLoadInteger(p []byte, encoding byte) int64 {
+	switch encoding {
+	case ZIP_INT_8B:
+		return int64(int8(p[0]))
+	case ZIP_INT_16B:
+		return int64(int16(binary.LittleEndian.Uint16(p)))
+	case ZIP_INT_24B:
+		return int64(int32(uint32(p[0])<<8|uint32(p[1])<<16|uint32(p[2])<<24) >> 8)
+	case ZIP_INT_32B:
+		return int64(int32(binary.LittleEndian.Uint32(p)))
+	case ZIP_INT_64B:
+		return int64(binary.LittleEndian.Uint64(p))
+	}
+	return int64(encoding&ZIP_INT_IMM_MASK) - 1
+}
 
+// zipEntry 解码p处的节点
+func zipEntry(zl []byte, p int) zlentry {
+	var e zlentry
+	e.prevrawlensize, e.prevrawlen = zipDecodePrevLen(zl[p:])
+	e.encoding, e.lensize, e.len = zipDecodeLength(zl[p+e.prevrawlensize:])
+	e.headersize = e.prevrawlensize + e.lensize
+	e.p = p
+	return e
 }
 
-// 上述是对压缩表结构的模型构建，使用[]byte存储压缩表即可
-// ZiplistNew 创建一个ziplist T=O(1)
-func ZiplistNew() []byte {
-	zlSize := (ZL_BYTES + ZL_TAIL + ZL_LEN + ZL_END) / ZL_END
-	zl := make([]byte, zlSize)
-	// ptr := *(*int64)(unsafe.Pointer(&zl))
-	saveZlBytes(zl)
-	zl[zlSize-1] = 1
+// ZiplistNew 创建一个空的ziplist T=O(1)
+func ZiplistNew(alloc *allocator) []byte {
+	size := ZIPLIST_HEADER_SIZE + ZIPLIST_END_SIZE
+	zl := make([]byte, size)
+	saveZlBytes(zl, uint32(size))
+	saveZltail(zl, ZIPLIST_HEADER_SIZE)
+	saveZllen(zl, 0)
+	zl[size-1] = ZIP_END
+	alloc.zmalloc(size)
 	return zl
 }
 
-func saveZlBytes([]byte) {
+// ziplistResize 把压缩表的大小修改为length，返回新的压缩表
+// 同realloc，扩大时原有的内容保持不变，缩小时截断末尾
+func ziplistResize(alloc *allocator, zl []byte, length int) []byte {
+	alloc.zrealloc(len(zl), length)
+	if length <= cap(zl) {
+		zl = zl[:length]
+	} else {
+		zl = append(zl, make([]byte, length-len(zl))...)
+	}
+	saveZlBytes(zl, uint32(length))
+	zl[length-1] = ZIP_END
+	return zl
+}
 
+// ZiplistFree 释放压缩表占用的内存
+func ZiplistFree(alloc *allocator, zl []byte) {
+	alloc.zfree(len(zl))
 }
 
-// ZiplistPush 将给定的值推入到ziplist
-// 创建一个新的节点根据where参数，如果where==ZIPLIST_HEAD表头，否则表尾巴
-// T=O(n^2)
-func ZiplistPush() {
+// ziplistCascadeUpdate 连锁更新
+// 插入或者删除节点之后p的长度发生变化，p的后一个节点的prevlen可能需要从1字节扩展为5字节，
+// 扩展之后后一个节点的长度也发生了变化，需要继续检查之后的节点
+// 为了避免反复扩展和缩小，prevlen只扩展不缩小，较短的长度使用5字节保存
+// T=O(N^2)
+func ziplistCascadeUpdate(alloc *allocator, zl []byte, p int) []byte {
+	curlen := ziplistBytes(zl)
+	for zl[p] != ZIP_END {
+		cur := zipEntry(zl, p)
+		rawlen := cur.headersize + cur.len
+		rawlensize := zipStorePrevEntryLength(nil, rawlen)
+
+		// 已经是最后一个节点
+		if zl[p+rawlen] == ZIP_END {
+			break
+		}
+		next := zipEntry(zl, p+rawlen)
+		if next.prevrawlen == rawlen {
+			break
+		}
+
+		if next.prevrawlensize < rawlensize {
+			// 扩展后一个节点的prevlen
+			extra := rawlensize - next.prevrawlensize
+			zl = ziplistResize(alloc, zl, curlen+extra)
+			np := p + rawlen
+			// 后一个节点不是表尾节点时表尾的偏移量增加
+			if ziplistTailOffset(zl) != np {
+				saveZltail(zl, uint32(ziplistTailOffset(zl)+extra))
+			}
+			copy(zl[np+rawlensize:], zl[np+next.prevrawlensize:curlen-1])
+			zipStorePrevEntryLength(zl[np:], rawlen)
+			p += rawlen
+			curlen += extra
+		} else {
+			if next.prevrawlensize > rawlensize {
+				zipStorePrevEntryLengthLarge(zl[p+rawlen:], rawlen)
+			} else {
+				zipStorePrevEntryLength(zl[p+rawlen:], rawlen)
+			}
+			break
+		}
+	}
+	return zl
+}
 
+// ziplistDelete 从p开始删除num个节点
+func ziplistDelete(alloc *allocator, zl []byte, p int, num int) []byte {
+	first := zipEntry(zl, p)
+	deleted := 0
+	for ; zl[p] != ZIP_END && deleted < num; deleted++ {
+		p += zipRawEntryLength(zl[p:])
+	}
+	totlen := p - first.p
+	if totlen == 0 {
+		return zl
+	}
+
+	nextdiff := 0
+	if zl[p] != ZIP_END {
+		// 删除之后的第一个节点的prevlen保存被删除的第一个节点的前一个节点的长度
+		nextdiff = zipPrevLenByteDiff(zl[p:], first.prevrawlen)
+		p -= nextdiff
+		zipStorePrevEntryLength(zl[p:], first.prevrawlen)
+		saveZltail(zl, uint32(ziplistTailOffset(zl)-totlen))
+		// 之后还有节点时prevlen长度的变化会影响表尾的偏移量
+		tail := zipEntry(zl, p)
+		if zl[p+tail.headersize+tail.len] != ZIP_END {
+			saveZltail(zl, uint32(ziplistTailOffset(zl)+nextdiff))
+		}
+		copy(zl[first.p:], zl[p:ziplistBytes(zl)-1])
+	} else {
+		// 删除了表尾的所有节点
+		saveZltail(zl, uint32(first.p-first.prevrawlen))
+	}
+	zl = ziplistResize(alloc, zl, ziplistBytes(zl)-totlen+nextdiff)
+	ziplistIncrLength(zl, -deleted)
+	if nextdiff != 0 {
+		zl = ziplistCascadeUpdate(alloc, zl, first.p)
+	}
+	return zl
 }
 
-// ZiplistInsert 将包含指定s值插入到指定的位置p中
-// 如果p指向一个节点，那么把新节点放到原有节点的前面
+// ziplistInsert 在p处插入s，p指向的节点以及之后的节点后移
+func ziplistInsert(alloc *allocator, zl []byte, p int, s []byte) []byte {
+	curlen := ziplistBytes(zl)
+
+	// 新节点的prevlen
+	prevlen := 0
+	if zl[p] != ZIP_END {
+		_, prevlen = zipDecodePrevLen(zl[p:])
+	} else if ptail := ziplistTailOffset(zl); zl[ptail] != ZIP_END {
+		prevlen = zipRawEntryLength(zl[ptail:])
+	}
+
+	value, encoding, isInt := zipTryEncoding(s)
+	reqlen := len(s)
+	if isInt {
+		reqlen = zipIntSize(encoding)
+	}
+	reqlen += zipStorePrevEntryLength(nil, prevlen)
+	reqlen += zipStoreEntryEncoding(nil, encoding, len(s))
+
+	// 后一个节点的prevlen需要变化的字节数
+	// 新节点小于4字节时后一个节点的prevlen不缩小，避免压缩表的长度小于插入之前
+	nextdiff, forcelarge := 0, false
+	if zl[p] != ZIP_END {
+		nextdiff = zipPrevLenByteDiff(zl[p:], reqlen)
+		if nextdiff == -4 && reqlen < 4 {
+			nextdiff = 0
+			forcelarge = true
+		}
+	}
+
+	zl = ziplistResize(alloc, zl, curlen+reqlen+nextdiff)
+	if zl[p] != ZIP_END {
+		copy(zl[p+reqlen:], zl[p-nextdiff:curlen-1])
+		if forcelarge {
+			zipStorePrevEntryLengthLarge(zl[p+reqlen:], reqlen)
+		} else {
+			zipStorePrevEntryLength(zl[p+reqlen:], reqlen)
+		}
+		saveZltail(zl, uint32(ziplistTailOffset(zl)+reqlen))
+		// 后一个节点不是表尾节点时，prevlen长度的变化会影响表尾的偏移量
+		tail := zipEntry(zl, p+reqlen)
+		if zl[p+reqlen+tail.headersize+tail.len] != ZIP_END {
+			saveZltail(zl, uint32(ziplistTailOffset(zl)+nextdiff))
+		}
+	} else {
+		// 新节点成为表尾节点
+		saveZltail(zl, uint32(p))
+	}
+	if nextdiff != 0 {
+		zl = ziplistCascadeUpdate(alloc, zl, p+reqlen)
+	}
+
+	q := p + zipStorePrevEntryLength(zl[p:], prevlen)
+	q += zipStoreEntryEncoding(zl[q:], encoding, len(s))
+	if isInt {
+		zipSaveInteger(zl[q:], value, encoding)
+	} else {
+		copy(zl[q:], s)
+	}
+	ziplistIncrLength(zl, 1)
+	return zl
+}
+
+// ZiplistPush 将给定的值推入到ziplist，返回新的ziplist
+// where==ZIPLIST_HEAD 插入到表头，否则插入到表尾
 // T=O(n^2)
-func ZiplistInsert() {
+func ZiplistPush(alloc *allocator, zl []byte, s []byte, where int) []byte {
+	p := ZIPLIST_HEADER_SIZE
+	if where != ZIPLIST_HEAD {
+		p = ziplistEntryEnd(zl)
+	}
+	return ziplistInsert(alloc, zl, p, s)
+}
 
+// ZiplistInsert 将s插入到位置p中，返回新的ziplist
+// 如果p指向一个节点，那么把新节点放到原有节点的前面
+// T=O(n^2)
+func ZiplistInsert(alloc *allocator, zl []byte, p int, s []byte) []byte {
+	return ziplistInsert(alloc, zl, p, s)
 }
 
-// 返回压缩列表给定索引上的节点 O(N)
+// ZiplistIndex 返回压缩列表给定索引上的节点的偏移量 O(N)
 // 如果索引为正从表头向表尾遍历，如果索引为负数从表尾巴向表头遍历
 // 正数索引从0开始，负数索引从-1开始
-// 如果索引超过列表的节点数量，或者列表为空，返回nil
-func ZiplistIndex() {
-
+// 如果索引超过列表的节点数量，或者列表为空，返回-1
+func ZiplistIndex(zl []byte, index int) int {
+	if index < 0 {
+		index = -index - 1
+		p := ziplistTailOffset(zl)
+		if zl[p] == ZIP_END {
+			return -1
+		}
+		_, prevlen := zipDecodePrevLen(zl[p:])
+		for prevlen > 0 && index > 0 {
+			p -= prevlen
+			_, prevlen = zipDecodePrevLen(zl[p:])
+			index--
+		}
+		if index > 0 {
+			return -1
+		}
+		return p
+	}
+	p := ZIPLIST_HEADER_SIZE
+	for zl[p] != ZIP_END && index > 0 {
+		p += zipRawEntryLength(zl[p:])
+		index--
+	}
+	if zl[p] == ZIP_END || index > 0 {
+		return -1
+	}
+	return p
 }
 
-// 寻找节点值和vstr相等的列表节点，并返回该节点的指针。
-// 每次比对之前都跳过skip节点
-// 查询不到响应的节点返回nil
+// ZiplistFind 从p开始寻找节点值和vstr相等的节点，并返回该节点的偏移量
+// 每次比对之后跳过skip个节点，如哈希中只比较键
+// 查询不到相应的节点返回-1
 // T=O(n^2)
-func ZiplistFind() {
-
+func ZiplistFind(zl []byte, p int, vstr []byte, skip int) int {
+	skipcnt := 0
+	var vencoding byte
+	var vll int64
+	vtried := false // 只在第一次比较整数节点时尝试把vstr转换为整数
+
+	for zl[p] != ZIP_END {
+		prevlensize, _ := zipDecodePrevLen(zl[p:])
+		encoding, lensize, length := zipDecodeLength(zl[p+prevlensize:])
+		q := p + prevlensize + lensize
+
+		if skipcnt == 0 {
+			if zipIsStr(encoding) {
+				if bytes.Equal(zl[q:q+length], vstr) {
+					return p
+				}
+			} else {
+				if !vtried {
+					var ok bool
+					if vll, vencoding, ok = zipTryEncoding(vstr); !ok {
+						// 不能转换为整数时和所有的整数节点都不相等
+						vencoding = math.MaxUint8
+					}
+					vtried = true
+				}
+				if vencoding != math.MaxUint8 && zipLoadInteger(zl[q:], encoding) == vll {
+					return p
+				}
+			}
+			skipcnt = skip
+		} else {
+			skipcnt--
+		}
+		p = q + length
+	}
+	return -1
 }
 
-// 返回P指向的后置节点
-// 如果p为表末端，或者p已经是表尾节点，返回nil
+// ZiplistNext 返回p的后一个节点的偏移量
+// 如果p为表末端，或者p已经是表尾节点，返回-1
 // T=O(1)
-func ZiplistNext() {
-
+func ZiplistNext(zl []byte, p int) int {
+	if zl[p] == ZIP_END {
+		return -1
+	}
+	p += zipRawEntryLength(zl[p:])
+	if zl[p] == ZIP_END {
+		return -1
+	}
+	return p
 }
 
-// 返回P指向的前置节点
-// 如果p指向空列表，或者p已经是表头，返回nil
+// ZiplistPrev 返回p的前一个节点的偏移量
+// p为表末端时返回表尾节点
+// 如果p指向空列表，或者p已经是表头，返回-1
 // T=O(1)
-func ZiplistPrev() {
+func ZiplistPrev(zl []byte, p int) int {
+	if zl[p] == ZIP_END {
+		p = ziplistTailOffset(zl)
+		if zl[p] == ZIP_END {
+			return -1
+		}
+		return p
+	}
+	if p == ZIPLIST_HEADER_SIZE {
+		return -1
+	}
+	_, prevlen := zipDecodePrevLen(zl[p:])
+	return p - prevlen
+}
 
+// ZiplistGet 取出p指向的节点
+// 如果节点保存的是字符串返回sval，sval和压缩表共享内存，修改压缩表之后失效
+// 如果节点保存的是整数，sval为nil，返回lval
+// 如果p指向列表末端，ok为false
+// T=O(1)
+func ZiplistGet(zl []byte, p int) (sval []byte, lval int64, ok bool) {
+	if p < 0 || zl[p] == ZIP_END {
+		return nil, 0, false
+	}
+	e := zipEntry(zl, p)
+	q := p + e.headersize
+	if zipIsStr(e.encoding) {
+		return zl[q : q+e.len : q+e.len], 0, true
+	}
+	return nil, zipLoadInteger(zl[q:], e.encoding), true
 }
 
-// 取出P指向的节点
-// 如果节点保存的是字符串，那么将值保存在*str中。
-// 如果节点保存的是整数，那么将节点保存在*num中。
-// 如果p为空列表，或者p指向列表末端，返回nil。
+// ZiplistCompare p指向的节点是否和s相等
 // T=O(1)
-func ZiplistGet() {
+func ZiplistCompare(zl []byte, p int, s []byte) bool {
+	if zl[p] == ZIP_END {
+		return false
+	}
+	e := zipEntry(zl, p)
+	q := p + e.headersize
+	if zipIsStr(e.encoding) {
+		return bytes.Equal(zl[q:q+e.len], s)
+	}
+	value, _, ok := zipTryEncoding(s)
+	return ok && value == zipLoadInteger(zl[q:], e.encoding)
+}
 
+// ZiplistReplace 把p指向的节点替换为s，返回新的ziplist
+// 长度相同时原地修改，否则删除之后重新插入
+func ZiplistReplace(alloc *allocator, zl []byte, p int, s []byte) []byte {
+	e := zipEntry(zl, p)
+	value, encoding, isInt := zipTryEncoding(s)
+	reqlen := len(s)
+	if isInt {
+		reqlen = zipIntSize(encoding)
+	}
+	reqlen += zipStoreEntryEncoding(nil, encoding, len(s))
+	if reqlen != e.lensize+e.len {
+		zl = ZiplistDelete(alloc, zl, p)
+		return ziplistInsert(alloc, zl, p, s)
+	}
+	q := p + e.prevrawlensize
+	q += zipStoreEntryEncoding(zl[q:], encoding, len(s))
+	if isInt {
+		zipSaveInteger(zl[q:], value, encoding)
+	} else {
+		copy(zl[q:], s)
+	}
+	return zl
 }
 
-// 从zl中删除p所指向的节点,并且从原地更新p所指向的位置，
-// 使得可以在迭代列表的过程中对节点进行删除
+// ZiplistDelete 从zl中删除p所指向的节点，返回新的ziplist
+// 删除之后p的偏移量指向原来的后一个节点，使得可以在迭代列表的过程中对节点进行删除
 // T=O(N^2)
-func ZiplistDelete() {
-
+func ZiplistDelete(alloc *allocator, zl []byte, p int) []byte {
+	return ziplistDelete(alloc, zl, p, 1)
 }
 
-// 从index指定的索引开始，连续的从zl中删除num个节点。
+// ZiplistDeleteRange 从index指定的索引开始，连续的从zl中删除num个节点。
 // T=O(n^2)
-func ZiplistDeleteRange() {
-
+func ZiplistDeleteRange(alloc *allocator, zl []byte, index, num int) []byte {
+	p := ZiplistIndex(zl, index)
+	if p < 0 {
+		return zl
+	}
+	return ziplistDelete(alloc, zl, p, num)
 }
 
-// 返回整个ziplist所占用的字节数
+// ZiplistBlobLen 返回整个ziplist所占用的字节数
 // T=O(1)
-func ZiplistBlobLen() {
-
+func ZiplistBlobLen(zl []byte) int {
+	return ziplistBytes(zl)
 }
 
-// zl中节点的个数 T=O(n)
-func ZiplistLen() {
-
+// ZiplistLen zl中节点的个数
+// 节点数小于ZIPLIST_LEN_MAX时直接读取zllen T=O(1)，否则需要遍历 T=O(n)
+func ZiplistLen(zl []byte) int {
+	if n := ziplistLength(zl); n < ZIPLIST_LEN_MAX {
+		return n
+	}
+	n := 0
+	for p := ZIPLIST_HEADER_SIZE; zl[p] != ZIP_END; p += zipRawEntryLength(zl[p:]) {
+		n++
+	}
+	if n < ZIPLIST_LEN_MAX {
+		saveZllen(zl, uint16(n))
+	}
+	return n
 }
@@ -0,0 +1,335 @@
+package myredis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// ziplistValidate 检查压缩表的头部以及每个节点的prevlen，返回所有节点的值
+func ziplistValidate(t *testing.T, zl []byte) []string {
+	t.Helper()
+	if ZiplistBlobLen(zl) != len(zl) || zl[len(zl)-1] != ZIP_END {
+		t.Fatalf("zlbytes %d len %d", ZiplistBlobLen(zl), len(zl))
+	}
+	var values []string
+	prevlen, tail := 0, ZIPLIST_HEADER_SIZE
+	for p := ZIPLIST_HEADER_SIZE; zl[p] != ZIP_END; p += zipRawEntryLength(zl[p:]) {
+		e := zipEntry(zl, p)
+		if e.prevrawlen != prevlen {
+			t.Fatalf("entry %d prevlen %d, want %d", len(values), e.prevrawlen, prevlen)
+		}
+		prevlen = e.headersize + e.len
+		tail = p
+		sval, lval, _ := ZiplistGet(zl, p)
+		if sval == nil {
+			values = append(values, strconv.FormatInt(lval, 10))
+		} else {
+			values = append(values, string(sval))
+		}
+	}
+	if ziplistTailOffset(zl) != tail {
+		t.Fatalf("zltail %d, want %d", ziplistTailOffset(zl), tail)
+	}
+	if ZiplistLen(zl) != len(values) {
+		t.Fatalf("zllen %d, want %d", ZiplistLen(zl), len(values))
+	}
+	return values
+}
+
+func TestZiplistNew(t *testing.T) {
+	zl := ZiplistNew(nil)
+	want := []byte{11, 0, 0, 0, 10, 0, 0, 0, 0, 0, 0xff}
+	if !bytes.Equal(zl, want) {
+		t.Fatalf("empty ziplist % x", zl)
+	}
+	if ZiplistIndex(zl, 0) != -1 || ZiplistIndex(zl, -1) != -1 || ZiplistPrev(zl, ziplistEntryEnd(zl)) != -1 {
+		t.Fatal("empty ziplist has no entries")
+	}
+	ZiplistFree(nil, zl)
+}
+
+func TestZiplistEncoding(t *testing.T) {
+	cases := []struct {
+		value    string
+		encoding []byte // encoding 的字节
+		length   int    // 内容的字节数
+	}{
+		{"0", []byte{0xf1}, 0},
+		{"12", []byte{0xfd}, 0},
+		{"13", []byte{ZIP_INT_8B}, 1},
+		{"-128", []byte{ZIP_INT_8B}, 1},
+		{"-129", []byte{ZIP_INT_16B}, 2},
+		{"32767", []byte{ZIP_INT_16B}, 2},
+		{"32768", []byte{ZIP_INT_24B}, 3},
+		{"-8388608", []byte{ZIP_INT_24B}, 3},
+		{"8388608", []byte{ZIP_INT_32B}, 4},
+		{"-2147483648", []byte{ZIP_INT_32B}, 4},
+		{"2147483648", []byte{ZIP_INT_64B}, 8},
+		{"-9223372036854775808", []byte{ZIP_INT_64B}, 8},
+		{"", []byte{0x00}, 0},
+		{"012", []byte{0x03}, 3},
+		{"hello", []byte{0x05}, 5},
+		{strings.Repeat("a", 63), []byte{0x3f}, 63},
+		{strings.Repeat("a", 64), []byte{0x40, 0x40}, 64},
+		{strings.Repeat("a", 16383), []byte{0x7f, 0xff}, 16383},
+		{strings.Repeat("a", 16384), []byte{0x80, 0x00, 0x00, 0x40, 0x00}, 16384},
+	}
+	for _, c := range cases {
+		zl := ZiplistNew(nil)
+		zl = ZiplistPush(nil, zl, []byte(c.value), ZIPLIST_TAIL)
+		p := ZIPLIST_HEADER_SIZE
+		if zl[p] != 0 || !bytes.Equal(zl[p+1:p+1+len(c.encoding)], c.encoding) {
+			t.Fatalf("%.20q encoding % x", c.value, zl[p:p+1+len(c.encoding)])
+		}
+		if size := zipRawEntryLength(zl[p:]); size != 1+len(c.encoding)+c.length {
+			t.Fatalf("%.20q entry size %d", c.value, size)
+		}
+		if values := ziplistValidate(t, zl); values[0] != c.value {
+			t.Fatalf("%.20q got %.20q", c.value, values[0])
+		}
+		ZiplistFree(nil, zl)
+	}
+
+	// 整数以小端序保存
+	zl := ZiplistPush(nil, ZiplistNew(nil), []byte("-2"), ZIPLIST_TAIL)
+	zl = ZiplistPush(nil, zl, []byte("1193046"), ZIPLIST_TAIL)
+	want := []byte{
+		19, 0, 0, 0, 13, 0, 0, 0, 2, 0,
+		0x00, ZIP_INT_8B, 0xfe,
+		0x03, ZIP_INT_24B, 0x56, 0x34, 0x12,
+		0xff,
+	}
+	if !bytes.Equal(zl, want) {
+		t.Fatalf("ziplist % x", zl)
+	}
+	ZiplistFree(nil, zl)
+}
+
+func TestZiplistIndexNextPrev(t *testing.T) {
+	zl := ZiplistNew(nil)
+	for _, v := range []string{"b", "c", "1024"} {
+		zl = ZiplistPush(nil, zl, []byte(v), ZIPLIST_TAIL)
+	}
+	zl = ZiplistPush(nil, zl, []byte("a"), ZIPLIST_HEAD)
+	if got := strings.Join(ziplistValidate(t, zl), ","); got != "a,b,c,1024" {
+		t.Fatalf("values %s", got)
+	}
+
+	for i, want := range []string{"a", "b", "c", "1024"} {
+		if !ZiplistCompare(zl, ZiplistIndex(zl, i), []byte(want)) || ZiplistIndex(zl, i-4) != ZiplistIndex(zl, i) {
+			t.Fatalf("index %d", i)
+		}
+	}
+	if ZiplistIndex(zl, 4) != -1 || ZiplistIndex(zl, -5) != -1 {
+		t.Fatal("index out of range")
+	}
+
+	var forward []string
+	for p := ZiplistIndex(zl, 0); p != -1; p = ZiplistNext(zl, p) {
+		sval, lval, _ := ZiplistGet(zl, p)
+		if sval == nil {
+			sval = []byte(strconv.FormatInt(lval, 10))
+		}
+		forward = append(forward, string(sval))
+	}
+	var backward []string
+	for p := ZiplistPrev(zl, ziplistEntryEnd(zl)); p != -1; p = ZiplistPrev(zl, p) {
+		sval, lval, _ := ZiplistGet(zl, p)
+		if sval == nil {
+			sval = []byte(strconv.FormatInt(lval, 10))
+		}
+		backward = append(backward, string(sval))
+	}
+	if strings.Join(forward, ",") != "a,b,c,1024" || strings.Join(backward, ",") != "1024,c,b,a" {
+		t.Fatalf("forward %v backward %v", forward, backward)
+	}
+	if _, _, ok := ZiplistGet(zl, ziplistEntryEnd(zl)); ok {
+		t.Fatal("get zlend")
+	}
+
+	// 在中间插入
+	zl = ZiplistInsert(nil, zl, ZiplistIndex(zl, 2), []byte("x"))
+	if got := strings.Join(ziplistValidate(t, zl), ","); got != "a,b,x,c,1024" {
+		t.Fatalf("values after insert %s", got)
+	}
+	ZiplistFree(nil, zl)
+}
+
+func TestZiplistDelete(t *testing.T) {
+	zl := ZiplistNew(nil)
+	for i := 0; i < 10; i++ {
+		zl = ZiplistPush(nil, zl, []byte(strconv.Itoa(i*100)), ZIPLIST_TAIL)
+	}
+	zl = ZiplistDeleteRange(nil, zl, 0, 2)
+	zl = ZiplistDeleteRange(nil, zl, -2, 5)
+	zl = ZiplistDeleteRange(nil, zl, 10, 1)
+	if got := strings.Join(ziplistValidate(t, zl), ","); got != "200,300,400,500,600,700" {
+		t.Fatalf("values after delete range %s", got)
+	}
+
+	// 迭代过程中删除，删除之后p指向下一个节点
+	for p := ZiplistIndex(zl, 0); zl[p] != ZIP_END; {
+		_, lval, _ := ZiplistGet(zl, p)
+		if lval%200 == 0 {
+			zl = ZiplistDelete(nil, zl, p)
+		} else {
+			p = p + zipRawEntryLength(zl[p:])
+		}
+	}
+	if got := strings.Join(ziplistValidate(t, zl), ","); got != "300,500,700" {
+		t.Fatalf("values after delete %s", got)
+	}
+	zl = ZiplistDeleteRange(nil, zl, 0, 3)
+	if ziplistValidate(t, zl) != nil || !bytes.Equal(zl, []byte{11, 0, 0, 0, 10, 0, 0, 0, 0, 0, 0xff}) {
+		t.Fatalf("empty after delete % x", zl)
+	}
+	ZiplistFree(nil, zl)
+}
+
+func TestZiplistFindAndReplace(t *testing.T) {
+	// 哈希的键值对交替保存，查找键时跳过值
+	zl := ZiplistNew(nil)
+	for _, v := range []string{"name", "value", "value", "100", "100", "name"} {
+		zl = ZiplistPush(nil, zl, []byte(v), ZIPLIST_TAIL)
+	}
+	head := ZiplistIndex(zl, 0)
+	if p := ZiplistFind(zl, head, []byte("value"), 1); p != ZiplistIndex(zl, 2) {
+		t.Fatalf("find value %d", p)
+	}
+	if p := ZiplistFind(zl, head, []byte("100"), 1); p != ZiplistIndex(zl, 4) {
+		t.Fatalf("find integer %d", p)
+	}
+	if ZiplistFind(zl, head, []byte("missing"), 1) != -1 || ZiplistFind(zl, head, []byte("0100"), 0) != -1 {
+		t.Fatal("find missing")
+	}
+
+	// 长度相同时原地替换，否则删除之后插入
+	size := len(zl)
+	zl = ZiplistReplace(nil, zl, ZiplistIndex(zl, 1), []byte("VALUE"))
+	if len(zl) != size {
+		t.Fatal("replace with same length should be in place")
+	}
+	zl = ZiplistReplace(nil, zl, ZiplistIndex(zl, 3), []byte(strings.Repeat("x", 300)))
+	zl = ZiplistReplace(nil, zl, ZiplistIndex(zl, 0), []byte("7"))
+	want := "7,VALUE,value," + strings.Repeat("x", 300) + ",100,name"
+	if got := strings.Join(ziplistValidate(t, zl), ","); got != want {
+		t.Fatalf("values after replace %.60s", got)
+	}
+	ZiplistFree(nil, zl)
+}
+
+// TestZiplistCascadeUpdate 节点长度都在250到253字节之间时，在表头插入大节点导致之后所有的prevlen扩展为5字节
+func TestZiplistCascadeUpdate(t *testing.T) {
+	value := strings.Repeat("v", 250) // 1 + 2 + 250 = 253 字节
+	zl := ZiplistNew(nil)
+	for i := 0; i < 10; i++ {
+		zl = ZiplistPush(nil, zl, []byte(value), ZIPLIST_TAIL)
+	}
+	if len(zl) != ZIPLIST_HEADER_SIZE+10*253+ZIPLIST_END_SIZE {
+		t.Fatalf("size %d", len(zl))
+	}
+	zl = ZiplistPush(nil, zl, []byte(strings.Repeat("b", 300)), ZIPLIST_HEAD)
+	ziplistValidate(t, zl)
+	for p := ZiplistIndex(zl, 1); p != -1; p = ZiplistNext(zl, p) {
+		if e := zipEntry(zl, p); e.prevrawlensize != 5 {
+			t.Fatalf("prevlen size %d at %d", e.prevrawlensize, p)
+		}
+	}
+	if len(zl) != ZIPLIST_HEADER_SIZE+303+10*257+ZIPLIST_END_SIZE {
+		t.Fatalf("size after cascade update %d", len(zl))
+	}
+
+	// 删除大节点之后不缩小prevlen
+	zl = ZiplistDelete(nil, zl, ZiplistIndex(zl, 0))
+	values := ziplistValidate(t, zl)
+	if len(values) != 10 || values[9] != value {
+		t.Fatalf("values %d", len(values))
+	}
+
+	// 在中间插入大节点同样会连锁更新
+	zl2 := ZiplistNew(nil)
+	for i := 0; i < 5; i++ {
+		zl2 = ZiplistPush(nil, zl2, []byte(value), ZIPLIST_TAIL)
+	}
+	zl2 = ZiplistInsert(nil, zl2, ZiplistIndex(zl2, 2), []byte(strings.Repeat("b", 300)))
+	ziplistValidate(t, zl2)
+	ZiplistFree(nil, zl)
+	ZiplistFree(nil, zl2)
+}
+
+// TestZiplistRandom 随机的插入删除和切片的结果一致
+func TestZiplistRandom(t *testing.T) {
+	base := usedMemory()
+	rnd := rand.New(rand.NewSource(1))
+	randValue := func() string {
+		switch rnd.Intn(4) {
+		case 0:
+			return strconv.FormatInt(rnd.Int63n(1<<40)-1<<39, 10)
+		case 1:
+			return strconv.Itoa(rnd.Intn(20))
+		case 2:
+			return strings.Repeat("s", rnd.Intn(10))
+		}
+		return strings.Repeat("l", 240+rnd.Intn(30))
+	}
+
+	zl := ZiplistNew(nil)
+	var want []string
+	for i := 0; i < 2000; i++ {
+		switch op := rnd.Intn(5); {
+		case op <= 1 || len(want) == 0:
+			v := randValue()
+			index := rnd.Intn(len(want) + 1)
+			if index == len(want) {
+				zl = ZiplistPush(nil, zl, []byte(v), ZIPLIST_TAIL)
+			} else {
+				zl = ZiplistInsert(nil, zl, ZiplistIndex(zl, index), []byte(v))
+			}
+			want = append(want[:index], append([]string{v}, want[index:]...)...)
+		case op == 2:
+			index := rnd.Intn(len(want))
+			zl = ZiplistDelete(nil, zl, ZiplistIndex(zl, index))
+			want = append(want[:index], want[index+1:]...)
+		case op == 3:
+			index, num := rnd.Intn(len(want)), 1+rnd.Intn(3)
+			zl = ZiplistDeleteRange(nil, zl, index, num)
+			if index+num > len(want) {
+				num = len(want) - index
+			}
+			want = append(want[:index], want[index+num:]...)
+		default:
+			v, index := randValue(), rnd.Intn(len(want))
+			zl = ZiplistReplace(nil, zl, ZiplistIndex(zl, index), []byte(v))
+			want[index] = v
+		}
+		if got := ziplistValidate(t, zl); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("op %d: got %d values, want %d", i, len(got), len(want))
+		}
+	}
+	if used := usedMemory() - base; used != len(zl) {
+		t.Fatalf("used %d, ziplist %d bytes", used, len(zl))
+	}
+	ZiplistFree(nil, zl)
+	if used := usedMemory(); used != base {
+		t.Fatalf("leaked %d bytes", used-base)
+	}
+}
+
+func TestZiplistLenOverflow(t *testing.T) {
+	zl := ZiplistNew(nil)
+	for i := 0; i < ZIPLIST_LEN_MAX+5; i++ {
+		zl = ZiplistPush(nil, zl, []byte("1"), ZIPLIST_TAIL)
+	}
+	if binary.LittleEndian.Uint16(zl[8:]) != ZIPLIST_LEN_MAX || ZiplistLen(zl) != ZIPLIST_LEN_MAX+5 {
+		t.Fatalf("len %d", ZiplistLen(zl))
+	}
+	zl = ZiplistDeleteRange(nil, zl, 0, 10)
+	if ZiplistLen(zl) != ZIPLIST_LEN_MAX-5 || ziplistLength(zl) != ZIPLIST_LEN_MAX-5 {
+		t.Fatalf("len after delete %d", ZiplistLen(zl))
+	}
+	ZiplistFree(nil, zl)
+}
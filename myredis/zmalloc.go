@@ -0,0 +1,69 @@
+package myredis
+
+import "sync/atomic"
+
+// 同redis的zmalloc，统计数据结构分配的内存
+// go 的内存由gc管理，这里在数据结构分配、扩展和释放空间时按照redis中对应结构的大小更新计数
+// 每个Server有自己的计数，数据库以及其中的数据结构使用Server的分配器
+// 不属于任何Server的数据结构计入默认的分配器
+
+// redis中各个结构的大小 64位
+const (
+	PTR_SIZE                = 8
+	OBJ_OVERHEAD            = 16 // redisObject
+	SDS_HDR_SIZE            = 3  // sdshdr8 len alloc flags
+	DICT_OVERHEAD           = 96 // dict
+	DICT_ENTRY_OVERHEAD     = 24 // dictEntry key val next
+	LIST_OVERHEAD           = 48 // list
+	LIST_NODE_OVERHEAD      = 24 // listNode prev next value
+	INTSET_OVERHEAD         = 8  // intset encoding length
+	SKIPLIST_OVERHEAD       = 32 // zskiplist
+	SKIPLIST_NODE_OVERHEAD  = 24 // zskiplistNode ele score backward
+	SKIPLIST_LEVEL_OVERHEAD = 16 // zskiplistLevel forward span
+	ZSET_OVERHEAD           = 16 // zset dict zsl
+	QUICKLIST_OVERHEAD      = 40 // quicklist
+	QUICKLIST_NODE_OVERHEAD = 32 // quicklistNode
+	QUICKLIST_LZF_OVERHEAD  = 4  // quicklistLZF sz
+	CLIENT_OVERHEAD         = 1024
+)
+
+// allocator 内存计数，used_memory 直接读取计数
+// 数据结构创建时记录所属的分配器，之后的分配和释放都计入这个分配器
+// nil 表示不属于任何Server，计入defaultAllocator
+type allocator struct {
+	used int64
+}
+
+var defaultAllocator allocator
+
+func (a *allocator) get() *allocator {
+	if a == nil {
+		return &defaultAllocator
+	}
+	return a
+}
+
+// zmalloc 分配size字节
+func (a *allocator) zmalloc(size int) {
+	atomic.AddInt64(&a.get().used, int64(size))
+}
+
+// zfree 释放size字节
+func (a *allocator) zfree(size int) {
+	atomic.AddInt64(&a.get().used, -int64(size))
+}
+
+// zrealloc 从oldSize扩展或者缩小为size
+func (a *allocator) zrealloc(oldSize, size int) {
+	a.zmalloc(size - oldSize)
+}
+
+// usedMemory 已经分配的内存
+func (a *allocator) usedMemory() int {
+	return int(atomic.LoadInt64(&a.get().used))
+}
+
+// sdsAllocSize 长度为n的sds占用的内存，包含头部以及结尾的'\0'
+func sdsAllocSize(n int) int {
+	return SDS_HDR_SIZE + n + 1
+}
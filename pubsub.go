@@ -0,0 +1,389 @@
+package lredis
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// 发布订阅的订阅者
+// 一个连接上管理所有的channel和pattern订阅，连接断开之后重新建立连接并重新订阅
+// 每个channel(pattern)拥有独立的有界缓冲区和处理协程
+// 不同channel之间并发处理，同一个channel内的消息保持顺序
+
+type DeliveryPolicy int
+
+const (
+	DeliveryBlock DeliveryPolicy = iota + 1 // 缓冲区满时阻塞接收协程，不丢消息但会拖慢其他channel
+	DeliveryDrop                            // 缓冲区满时丢弃新到的消息
+)
+
+// MessageHandler 处理订阅到的消息
+type MessageHandler func(msg *redis.Message)
+
+type SubscriberConfig struct {
+	BufferSize        int            // 每个channel的缓冲区大小
+	Policy            DeliveryPolicy // 缓冲区满时的处理策略
+	ReconnectInterval time.Duration  // 连接断开之后重新订阅的间隔
+}
+
+// pubSubClient 支持订阅的客户端, redis.Client和redis.ClusterClient均已实现
+type pubSubClient interface {
+	Subscribe(channels ...string) *redis.PubSub
+	PSubscribe(channels ...string) *redis.PubSub
+}
+
+type subscription struct {
+	name    string
+	handler MessageHandler
+	queue   chan *redis.Message
+	done    chan struct{} // 取消订阅时关闭
+}
+
+type Subscriber struct {
+	client pubSubClient
+	conf   SubscriberConfig
+
+	mu       sync.Mutex
+	pubsub   *redis.PubSub
+	channels map[string]*subscription
+	patterns map[string]*subscription
+
+	dropped int64
+
+	stopCh    chan struct{}
+	recvWg    sync.WaitGroup // 接收协程
+	consumeWg sync.WaitGroup // 各个channel的处理协程
+	running   int32
+}
+
+// NewSubscriber 创建订阅者，client需要支持Subscribe
+func NewSubscriber(client redis.Cmdable, conf SubscriberConfig) (*Subscriber, error) {
+	psClient, ok := client.(pubSubClient)
+	if !ok {
+		return nil, errors.New("client not support subscribe")
+	}
+
+	if conf.BufferSize <= 0 {
+		conf.BufferSize = 100
+	}
+
+	if conf.Policy == 0 {
+		conf.Policy = DeliveryBlock
+	}
+
+	if conf.ReconnectInterval <= 0 {
+		conf.ReconnectInterval = time.Second
+	}
+
+	return &Subscriber{
+		client:   psClient,
+		conf:     conf,
+		channels: map[string]*subscription{},
+		patterns: map[string]*subscription{},
+		stopCh:   make(chan struct{}),
+	}, nil
+}
+
+// Subscribe 订阅channel
+func (s *Subscriber) Subscribe(channel string, handler MessageHandler) error {
+	return s.add(s.channels, channel, handler, false)
+}
+
+// PSubscribe 订阅pattern
+func (s *Subscriber) PSubscribe(pattern string, handler MessageHandler) error {
+	return s.add(s.patterns, pattern, handler, true)
+}
+
+// Unsubscribe 取消订阅channel，缓冲区中未处理的消息会被丢弃
+func (s *Subscriber) Unsubscribe(channel string) error {
+	return s.remove(s.channels, channel, false)
+}
+
+// PUnsubscribe 取消订阅pattern
+func (s *Subscriber) PUnsubscribe(pattern string) error {
+	return s.remove(s.patterns, pattern, true)
+}
+
+// Dropped 因为缓冲区满被丢弃的消息数
+func (s *Subscriber) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Start 建立订阅连接并开始接收消息
+func (s *Subscriber) Start() error {
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		return errors.New("subscriber already started")
+	}
+	s.mu.Lock()
+	err := s.connect()
+	s.mu.Unlock()
+	if err != nil {
+		atomic.StoreInt32(&s.running, 0)
+		return err
+	}
+	s.recvWg.Add(1)
+	go s.receive()
+	return nil
+}
+
+// Close 关闭订阅连接，等待缓冲区中的消息处理完毕
+func (s *Subscriber) Close() error {
+	if atomic.SwapInt32(&s.running, 2) == 2 {
+		return nil
+	}
+	close(s.stopCh)
+
+	s.mu.Lock()
+	var err error
+	if s.pubsub != nil {
+		err = s.pubsub.Close()
+	}
+	s.mu.Unlock()
+	// 等待接收协程退出之后再关闭缓冲区，处理协程处理完剩余的消息之后退出
+	s.recvWg.Wait()
+
+	s.mu.Lock()
+	for name, sub := range s.channels {
+		close(sub.queue)
+		delete(s.channels, name)
+	}
+	for name, sub := range s.patterns {
+		close(sub.queue)
+		delete(s.patterns, name)
+	}
+	s.mu.Unlock()
+	s.consumeWg.Wait()
+	return err
+}
+
+func (s *Subscriber) add(subs map[string]*subscription, name string, handler MessageHandler, pattern bool) error {
+	if handler == nil {
+		return errors.New("subscribe handler nil")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if atomic.LoadInt32(&s.running) == 2 {
+		return errors.New("subscriber closed")
+	}
+	if _, ok := subs[name]; ok {
+		return fmt.Errorf("%s already subscribed", name)
+	}
+	// 先订阅再登记，订阅失败时不会留下没有订阅的channel和处理协程
+	// 持有锁期间dispatch无法投递，订阅成功之后立刻到达的消息不会丢失
+	if s.pubsub != nil {
+		var err error
+		if pattern {
+			err = s.pubsub.PSubscribe(name)
+		} else {
+			err = s.pubsub.Subscribe(name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// 未启动时只登记，Start时统一订阅
+	sub := &subscription{
+		name:    name,
+		handler: handler,
+		queue:   make(chan *redis.Message, s.conf.BufferSize),
+		done:    make(chan struct{}),
+	}
+	subs[name] = sub
+	s.consumeWg.Add(1)
+	go s.consume(sub)
+	return nil
+}
+
+func (s *Subscriber) remove(subs map[string]*subscription, name string, pattern bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := subs[name]
+	if !ok {
+		return nil
+	}
+	delete(subs, name)
+	close(sub.done)
+	if s.pubsub == nil {
+		return nil
+	}
+	if pattern {
+		return s.pubsub.PUnsubscribe(name)
+	}
+	return s.pubsub.Unsubscribe(name)
+}
+
+// connect 使用当前所有的channel和pattern建立新的订阅连接，调用方持有锁
+func (s *Subscriber) connect() error {
+	channels := make([]string, 0, len(s.channels))
+	for name := range s.channels {
+		channels = append(channels, name)
+	}
+	patterns := make([]string, 0, len(s.patterns))
+	for name := range s.patterns {
+		patterns = append(patterns, name)
+	}
+
+	pubsub := s.client.Subscribe(channels...)
+	if len(patterns) > 0 {
+		if err := pubsub.PSubscribe(patterns...); err != nil {
+			pubsub.Close()
+			return err
+		}
+	}
+	// 主动ping一次，确认连接可用
+	if err := pubsub.Ping(); err != nil {
+		pubsub.Close()
+		return err
+	}
+	s.pubsub = pubsub
+	return nil
+}
+
+// resubscribe 关闭旧连接，间隔ReconnectInterval之后重新订阅，直到成功或者关闭
+func (s *Subscriber) resubscribe() {
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-time.After(s.conf.ReconnectInterval):
+		}
+
+		s.mu.Lock()
+		if s.pubsub != nil {
+			s.pubsub.Close()
+			s.pubsub = nil
+		}
+		err := s.connect()
+		s.mu.Unlock()
+		if err == nil {
+			log.Println("subscriber resubscribe success")
+			return
+		}
+		log.Printf("subscriber resubscribe err: %s\n", err.Error())
+	}
+}
+
+func (s *Subscriber) receive() {
+	defer s.recvWg.Done()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		default:
+		}
+
+		s.mu.Lock()
+		pubsub := s.pubsub
+		s.mu.Unlock()
+		if pubsub == nil {
+			s.resubscribe()
+			continue
+		}
+
+		msg, err := pubsub.ReceiveTimeout(time.Second)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			select {
+			case <-s.stopCh:
+				return
+			default:
+			}
+			log.Printf("subscriber receive err: %s\n", err.Error())
+			s.resubscribe()
+			continue
+		}
+
+		if message, ok := msg.(*redis.Message); ok {
+			s.dispatch(message)
+		}
+	}
+}
+
+// dispatch 把消息投递到对应channel(pattern)的缓冲区
+func (s *Subscriber) dispatch(msg *redis.Message) {
+	s.mu.Lock()
+	var sub *subscription
+	if msg.Pattern != "" {
+		sub = s.patterns[msg.Pattern]
+	} else {
+		sub = s.channels[msg.Channel]
+	}
+	if sub == nil {
+		s.mu.Unlock()
+		return
+	}
+
+	if s.conf.Policy == DeliveryDrop {
+		select {
+		case sub.queue <- msg:
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+
+	// 阻塞投递时不能持有锁，否则handler中调用Subscribe会死锁
+	select {
+	case sub.queue <- msg:
+	case <-sub.done:
+	case <-s.stopCh:
+	}
+}
+
+func (s *Subscriber) consume(sub *subscription) {
+	defer s.consumeWg.Done()
+	for {
+		select {
+		case msg, ok := <-sub.queue:
+			if !ok {
+				return
+			}
+			sub.handler(msg)
+		case <-sub.done:
+			return
+		}
+	}
+}
+
+// 键空间通知
+// notify-keyspace-events 的配置参见redis.conf
+// K 键空间通知 __keyspace@<db>__:<key> 消息为事件名称
+// E 键事件通知 __keyevent@<db>__:<event> 消息为键名称
+// g 通用命令 $ 字符串 l 列表 s 集合 h 哈希 z 有序集合 x 过期 e 淘汰 A 所有类型
+
+// EnableKeyspaceNotifications 开启键空间通知，flags为空时使用 KEA
+func EnableKeyspaceNotifications(client redis.Cmdable, flags string) error {
+	if flags == "" {
+		flags = "KEA"
+	}
+	return client.ConfigSet("notify-keyspace-events", flags).Err()
+}
+
+// KeyspaceHandler 处理键空间通知, key为发生变化的键, event为命令或者事件名称如set、del、expired
+type KeyspaceHandler func(db int, key, event string)
+
+// SubscribeKeyspace 订阅db中匹配keyPattern的键空间通知
+func (s *Subscriber) SubscribeKeyspace(db int, keyPattern string, handler KeyspaceHandler) error {
+	if handler == nil {
+		return errors.New("keyspace handler nil")
+	}
+	if keyPattern == "" {
+		keyPattern = "*"
+	}
+	prefix := fmt.Sprintf("__keyspace@%d__:", db)
+	return s.PSubscribe(prefix+keyPattern, func(msg *redis.Message) {
+		handler(db, strings.TrimPrefix(msg.Channel, prefix), msg.Payload)
+	})
+}
@@ -0,0 +1,276 @@
+package lredis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// newTestSubscriber 未启动的订阅者，消息直接通过dispatch投递，不需要连接redis
+func newTestSubscriber(t *testing.T, conf SubscriberConfig) *Subscriber {
+	t.Helper()
+	s, err := NewSubscriber(redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"}), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSubscriberDispatch(t *testing.T) {
+	s := newTestSubscriber(t, SubscriberConfig{})
+	var mu sync.Mutex
+	got := map[string][]string{}
+	record := func(name string) MessageHandler {
+		return func(msg *redis.Message) {
+			mu.Lock()
+			got[name] = append(got[name], msg.Payload)
+			mu.Unlock()
+		}
+	}
+	if err := s.Subscribe("a", record("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Subscribe("a", record("a")); err == nil {
+		t.Fatal("duplicate subscribe should fail")
+	}
+	if err := s.PSubscribe("news.*", record("news.*")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SubscribeKeyspace(1, "user:*", func(db int, key, event string) {
+		record("keyspace")(&redis.Message{Payload: key + " " + event})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一个channel中的消息按照顺序处理
+	for _, payload := range []string{"1", "2", "3"} {
+		s.dispatch(&redis.Message{Channel: "a", Payload: payload})
+	}
+	s.dispatch(&redis.Message{Channel: "news.sport", Pattern: "news.*", Payload: "p"})
+	s.dispatch(&redis.Message{Channel: "__keyspace@1__:user:1", Pattern: "__keyspace@1__:user:*", Payload: "set"})
+	// 没有订阅的channel被忽略
+	s.dispatch(&redis.Message{Channel: "b", Payload: "x"})
+
+	// 等待缓冲区中的消息处理完毕，取消订阅时未处理的消息会被丢弃
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := len(got["a"])
+		mu.Unlock()
+		if n == 3 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := s.Unsubscribe("a"); err != nil {
+		t.Fatal(err)
+	}
+	s.dispatch(&redis.Message{Channel: "a", Payload: "4"})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Subscribe("c", record("c")); err == nil {
+		t.Fatal("subscribe after close should fail")
+	}
+
+	want := map[string][]string{
+		"a":        {"1", "2", "3"},
+		"news.*":   {"p"},
+		"keyspace": {"user:1 set"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// TestSubscriberDrop 缓冲区满时丢弃新到的消息
+func TestSubscriberDrop(t *testing.T) {
+	s := newTestSubscriber(t, SubscriberConfig{BufferSize: 1, Policy: DeliveryDrop})
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var handled []string
+	s.Subscribe("a", func(msg *redis.Message) {
+		if msg.Payload == "1" {
+			close(entered)
+			<-release
+		}
+		handled = append(handled, msg.Payload)
+	})
+
+	s.dispatch(&redis.Message{Channel: "a", Payload: "1"})
+	select {
+	case <-entered:
+	case <-time.After(time.Second):
+		t.Fatal("handler not called")
+	}
+	s.dispatch(&redis.Message{Channel: "a", Payload: "2"})
+	s.dispatch(&redis.Message{Channel: "a", Payload: "3"})
+	if s.Dropped() != 1 {
+		t.Fatalf("dropped %d", s.Dropped())
+	}
+	close(release)
+	s.Close()
+	if !reflect.DeepEqual(handled, []string{"1", "2"}) {
+		t.Fatalf("handled %v", handled)
+	}
+}
+
+// fakePubSubServer 只支持SUBSCRIBE和PING的发布订阅服务端，可以主动断开所有连接
+type fakePubSubServer struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	conns map[net.Conn]map[string]bool // 每个连接订阅的channel
+}
+
+func startFakePubSubServer(t *testing.T) *fakePubSubServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fakePubSubServer{ln: ln, conns: map[net.Conn]map[string]bool{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			srv.mu.Lock()
+			srv.conns[conn] = map[string]bool{}
+			srv.mu.Unlock()
+			go srv.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		srv.kill()
+	})
+	return srv
+}
+
+func (srv *fakePubSubServer) serve(conn net.Conn) {
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.conns, conn)
+		srv.mu.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readFakeCommand(r)
+		if err != nil {
+			return
+		}
+		srv.mu.Lock()
+		subscribed := srv.conns[conn]
+		switch args[0] {
+		case "subscribe":
+			for _, channel := range args[1:] {
+				subscribed[channel] = true
+				fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:%d\r\n", len(channel), channel, len(subscribed))
+			}
+		case "ping":
+			fmt.Fprint(conn, "*2\r\n$4\r\npong\r\n$0\r\n\r\n")
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		srv.mu.Unlock()
+	}
+}
+
+func readFakeCommand(r *bufio.Reader) ([]string, error) {
+	var n int
+	if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		var size int
+		if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	if n == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return args, nil
+}
+
+// publish 向订阅了channel的连接发送消息
+func (srv *fakePubSubServer) publish(channel, payload string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for conn, subscribed := range srv.conns {
+		if subscribed[channel] {
+			fmt.Fprintf(conn, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
+				len(channel), channel, len(payload), payload)
+		}
+	}
+}
+
+// kill 断开所有连接，模拟网络故障或者CLIENT KILL
+func (srv *fakePubSubServer) kill() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for conn := range srv.conns {
+		conn.Close()
+		delete(srv.conns, conn)
+	}
+}
+
+// TestSubscriberResubscribe 连接断开之后重新订阅，消息继续投递
+func TestSubscriberResubscribe(t *testing.T) {
+	srv := startFakePubSubServer(t)
+	client := redis.NewClient(&redis.Options{Addr: srv.ln.Addr().String()})
+	defer client.Close()
+	s, err := NewSubscriber(client, SubscriberConfig{ReconnectInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	got := make(chan string, 10)
+	if err := s.Subscribe("a", func(msg *redis.Message) { got <- msg.Payload }); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新订阅完成之前发布的消息会丢失，反复发布直到handler收到
+	publish := func(payload string) {
+		t.Helper()
+		deadline := time.After(2 * time.Second)
+		for {
+			srv.publish("a", payload)
+			select {
+			case p := <-got:
+				if p == payload {
+					return
+				}
+			case <-time.After(10 * time.Millisecond):
+			case <-deadline:
+				t.Fatalf("message %s not delivered", payload)
+			}
+		}
+	}
+
+	publish("1")
+	for i := 2; i <= 3; i++ {
+		srv.kill()
+		publish(strconv.Itoa(i))
+	}
+}
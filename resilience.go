@@ -0,0 +1,331 @@
+package lredis
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// 客户端的容错封装
+// 1 按照命令类别设置重试策略，幂等的读命令可以重试，非幂等的写命令不重试
+//   重试间隔为指数退避加随机抖动，避免大量客户端同时重试
+// 2 熔断器根据窗口内的错误率和慢请求比例在 关闭->打开->半开 之间切换
+//   打开时直接返回CircuitOpenError，不再访问redis，OpenTimeout之后进入半开状态放行少量探测请求
+//   探测全部成功之后关闭，任意一个失败重新打开
+// go-redis自身也会重试所有的命令，使用此封装时配置 max_retries: -1 关闭go-redis的重试
+
+const (
+	CommandRead  = "read"  // 幂等的读命令
+	CommandWrite = "write" // 非幂等的写命令
+)
+
+type RetryPolicy struct {
+	MaxRetries int           // 最大重试次数，0 不重试
+	MinBackoff time.Duration // 第一次重试的退避时间
+	MaxBackoff time.Duration // 退避时间的上限
+}
+
+// backoff 第attempt次重试的等待时间，在 [0, min(MaxBackoff, MinBackoff*2^attempt)] 之间随机
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	if p.MinBackoff <= 0 {
+		return 0
+	}
+	d := p.MinBackoff << uint(attempt)
+	if d <= 0 || (p.MaxBackoff > 0 && d > p.MaxBackoff) {
+		d = p.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// DefaultRetryPolicies 读命令重试3次，写命令不重试
+func DefaultRetryPolicies() map[string]RetryPolicy {
+	return map[string]RetryPolicy{
+		CommandRead:  {MaxRetries: 3, MinBackoff: 8 * time.Millisecond, MaxBackoff: 512 * time.Millisecond},
+		CommandWrite: {MaxRetries: 0},
+	}
+}
+
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota + 1 // 正常放行
+	BreakerOpen                             // 快速失败
+	BreakerHalfOpen                         // 放行少量探测请求
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type BreakerConfig struct {
+	Window           time.Duration // 统计错误率的窗口
+	MinRequests      int           // 窗口内请求数达到此值才会计算错误率
+	ErrorRate        float64       // 错误率达到此值时打开
+	SlowLatency      time.Duration // 耗时超过此值为慢请求，0 不统计慢请求
+	SlowRate         float64       // 慢请求比例达到此值时打开
+	OpenTimeout      time.Duration // 打开之后经过此时间进入半开
+	HalfOpenRequests int           // 半开状态放行的探测请求数
+}
+
+// CircuitOpenError 熔断器打开时返回的错误
+type CircuitOpenError struct {
+	RetryAfter time.Duration // 距离进入半开状态的时间
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("redis circuit breaker open, retry after %s", e.RetryAfter)
+}
+
+// IsCircuitOpen 判断是否为熔断器打开的错误
+func IsCircuitOpen(err error) bool {
+	_, ok := err.(*CircuitOpenError)
+	return ok
+}
+
+type CircuitBreaker struct {
+	conf BreakerConfig
+
+	mu          sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	slows       int
+	openedAt    time.Time
+	probes      int // 半开状态已经放行的请求数
+	probeOK     int // 半开状态成功的请求数
+	onChange    func(from, to BreakerState)
+}
+
+// NewCircuitBreaker 创建熔断器，未设置的配置使用默认值
+func NewCircuitBreaker(conf BreakerConfig) *CircuitBreaker {
+	if conf.Window <= 0 {
+		conf.Window = 10 * time.Second
+	}
+	if conf.MinRequests <= 0 {
+		conf.MinRequests = 20
+	}
+	if conf.ErrorRate <= 0 {
+		conf.ErrorRate = 0.5
+	}
+	if conf.SlowRate <= 0 {
+		conf.SlowRate = 0.5
+	}
+	if conf.OpenTimeout <= 0 {
+		conf.OpenTimeout = 5 * time.Second
+	}
+	if conf.HalfOpenRequests <= 0 {
+		conf.HalfOpenRequests = 3
+	}
+	return &CircuitBreaker{
+		conf:        conf,
+		state:       BreakerClosed,
+		windowStart: time.Now(),
+	}
+}
+
+// OnStateChange 设置状态变化的回调，回调在持有锁时调用，不能再调用熔断器的方法
+func (b *CircuitBreaker) OnStateChange(fn func(from, to BreakerState)) {
+	b.mu.Lock()
+	b.onChange = fn
+	b.mu.Unlock()
+}
+
+// State 当前状态
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.checkOpenTimeout(time.Now())
+	return b.state
+}
+
+// Allow 判断请求是否可以放行，放行之后需要调用Report上报结果
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.checkOpenTimeout(now)
+	switch b.state {
+	case BreakerOpen:
+		return &CircuitOpenError{RetryAfter: b.openedAt.Add(b.conf.OpenTimeout).Sub(now)}
+	case BreakerHalfOpen:
+		if b.probes >= b.conf.HalfOpenRequests {
+			return &CircuitOpenError{}
+		}
+		b.probes++
+	}
+	return nil
+}
+
+// Report 上报请求的结果
+func (b *CircuitBreaker) Report(err error, latency time.Duration) {
+	failed := isDegradedError(err)
+	slow := b.conf.SlowLatency > 0 && latency >= b.conf.SlowLatency
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed || slow {
+			b.setState(BreakerOpen, now)
+			return
+		}
+		b.probeOK++
+		if b.probeOK >= b.conf.HalfOpenRequests {
+			b.setState(BreakerClosed, now)
+		}
+	case BreakerClosed:
+		if now.Sub(b.windowStart) > b.conf.Window {
+			b.resetWindow(now)
+		}
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if slow {
+			b.slows++
+		}
+		if b.requests < b.conf.MinRequests {
+			return
+		}
+		if float64(b.failures)/float64(b.requests) >= b.conf.ErrorRate ||
+			(b.conf.SlowLatency > 0 && float64(b.slows)/float64(b.requests) >= b.conf.SlowRate) {
+			b.setState(BreakerOpen, now)
+		}
+	}
+}
+
+func (b *CircuitBreaker) checkOpenTimeout(now time.Time) {
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.conf.OpenTimeout {
+		b.setState(BreakerHalfOpen, now)
+	}
+}
+
+func (b *CircuitBreaker) resetWindow(now time.Time) {
+	b.windowStart = now
+	b.requests = 0
+	b.failures = 0
+	b.slows = 0
+}
+
+func (b *CircuitBreaker) setState(state BreakerState, now time.Time) {
+	from := b.state
+	b.state = state
+	b.probes = 0
+	b.probeOK = 0
+	switch state {
+	case BreakerOpen:
+		b.openedAt = now
+	case BreakerClosed:
+		b.resetWindow(now)
+	}
+	if b.onChange != nil && from != state {
+		b.onChange(from, state)
+	}
+}
+
+// isDegradedError 是否为redis不可用导致的错误
+// redis.Nil 以及 WRONGTYPE 等命令本身的错误不计入熔断
+func isDegradedError(err error) bool {
+	if err == nil || err == redis.Nil {
+		return false
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	msg := err.Error()
+	for _, prefix := range []string{"LOADING", "READONLY", "CLUSTERDOWN", "MASTERDOWN", "TRYAGAIN",
+		"ERR max number of clients reached", "redis: connection pool timeout", "redis: client is closed"} {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRetryableError 是否可以重试，超时也可以重试，命令本身的错误重试没有意义
+func isRetryableError(err error) bool {
+	if IsCircuitOpen(err) {
+		return false
+	}
+	return isDegradedError(err)
+}
+
+// Resilient 带有重试和熔断的客户端
+// go-redis v6 不能在WrapProcess中设置命令的错误，这里使用函数的方式执行命令
+//
+//	err := r.Read(func(c redis.Cmdable) error {
+//		val, err = c.Get(key).Result()
+//		return err
+//	})
+type Resilient struct {
+	client   redis.Cmdable
+	policies map[string]RetryPolicy
+	breaker  *CircuitBreaker
+}
+
+// NewResilient 创建容错客户端，policies为nil时使用DefaultRetryPolicies
+func NewResilient(client redis.Cmdable, policies map[string]RetryPolicy, conf BreakerConfig) *Resilient {
+	if policies == nil {
+		policies = DefaultRetryPolicies()
+	}
+	return &Resilient{
+		client:   client,
+		policies: policies,
+		breaker:  NewCircuitBreaker(conf),
+	}
+}
+
+// Breaker 返回熔断器，用于查看状态和设置回调
+func (r *Resilient) Breaker() *CircuitBreaker {
+	return r.breaker
+}
+
+// Read 执行幂等的读命令
+func (r *Resilient) Read(fn func(c redis.Cmdable) error) error {
+	return r.Execute(CommandRead, fn)
+}
+
+// Write 执行非幂等的写命令
+func (r *Resilient) Write(fn func(c redis.Cmdable) error) error {
+	return r.Execute(CommandWrite, fn)
+}
+
+// Execute 按照class对应的重试策略执行命令，每次尝试都需要经过熔断器
+func (r *Resilient) Execute(class string, fn func(c redis.Cmdable) error) error {
+	policy := r.policies[class]
+	var err error
+	for attempt := 0; attempt <= policy.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(policy.backoff(attempt - 1))
+		}
+		if err = r.breaker.Allow(); err != nil {
+			return err
+		}
+		start := time.Now()
+		err = fn(r.client)
+		r.breaker.Report(err, time.Since(start))
+		if !isRetryableError(err) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,169 @@
+package lredis
+
+import (
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+var errConnRefused = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for attempt, limit := range []time.Duration{10, 20, 40, 50, 50} {
+		limit *= time.Millisecond
+		for i := 0; i < 100; i++ {
+			if d := p.backoff(attempt); d < 0 || d > limit {
+				t.Fatalf("backoff(%d) = %s, limit %s", attempt, d, limit)
+			}
+		}
+	}
+	// 左移溢出时使用上限
+	if d := p.backoff(100); d < 0 || d > p.MaxBackoff {
+		t.Fatalf("backoff after overflow %s", d)
+	}
+	if d := (RetryPolicy{MinBackoff: time.Second}).backoff(100); d != 0 {
+		t.Fatalf("backoff after overflow without max %s", d)
+	}
+	if d := (RetryPolicy{}).backoff(3); d != 0 {
+		t.Fatalf("backoff without min %s", d)
+	}
+}
+
+func TestIsDegradedError(t *testing.T) {
+	for _, err := range []error{io.EOF, io.ErrUnexpectedEOF, errConnRefused,
+		errors.New("LOADING Redis is loading the dataset in memory"), errors.New("READONLY You can't write against a read only replica."),
+		errors.New("redis: connection pool timeout")} {
+		if !isDegradedError(err) || !isRetryableError(err) {
+			t.Fatalf("%v should be degraded", err)
+		}
+	}
+	for _, err := range []error{nil, redis.Nil, errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")} {
+		if isDegradedError(err) {
+			t.Fatalf("%v should not be degraded", err)
+		}
+	}
+	if isRetryableError(&CircuitOpenError{}) || !IsCircuitOpen(&CircuitOpenError{}) {
+		t.Fatal("circuit open error should not be retried")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker(BreakerConfig{MinRequests: 4, ErrorRate: 0.5, OpenTimeout: 20 * time.Millisecond, HalfOpenRequests: 2})
+	var changes []string
+	b.OnStateChange(func(from, to BreakerState) {
+		changes = append(changes, from.String()+"->"+to.String())
+	})
+
+	// 请求数不足MinRequests时不打开
+	b.Report(errConnRefused, 0)
+	b.Report(errConnRefused, 0)
+	b.Report(errConnRefused, 0)
+	if b.State() != BreakerClosed {
+		t.Fatal("breaker opened before min requests")
+	}
+	b.Report(errConnRefused, 0)
+	if b.State() != BreakerOpen {
+		t.Fatalf("state %s, want open", b.State())
+	}
+	if err, ok := b.Allow().(*CircuitOpenError); !ok || err.RetryAfter <= 0 {
+		t.Fatalf("allow when open %v", err)
+	}
+
+	// 超时之后半开，只放行HalfOpenRequests个探测请求，探测失败重新打开
+	time.Sleep(25 * time.Millisecond)
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("state %s, want half-open", b.State())
+	}
+	if b.Allow() != nil || b.Allow() != nil {
+		t.Fatal("probes should be allowed")
+	}
+	if !IsCircuitOpen(b.Allow()) {
+		t.Fatal("requests beyond probes should be rejected")
+	}
+	b.Report(nil, 0)
+	b.Report(errConnRefused, 0)
+	if b.State() != BreakerOpen {
+		t.Fatalf("state %s after failed probe", b.State())
+	}
+
+	// 探测全部成功之后关闭
+	time.Sleep(25 * time.Millisecond)
+	b.Allow()
+	b.Report(nil, 0)
+	b.Allow()
+	b.Report(nil, 0)
+	if b.State() != BreakerClosed || b.requests != 0 {
+		t.Fatalf("state %s requests %d after probes", b.State(), b.requests)
+	}
+
+	want := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("changes %v, want %v", changes, want)
+	}
+}
+
+// TestCircuitBreakerCommandError 命令本身的错误不计入错误率
+func TestCircuitBreakerCommandError(t *testing.T) {
+	b := NewCircuitBreaker(BreakerConfig{MinRequests: 4, ErrorRate: 0.5})
+	b.Report(errConnRefused, 0)
+	for i := 0; i < 10; i++ {
+		b.Report(redis.Nil, 0)
+		b.Report(errors.New("WRONGTYPE Operation against a key holding the wrong kind of value"), 0)
+	}
+	if b.State() != BreakerClosed || b.failures != 1 || b.requests != 21 {
+		t.Fatalf("state %s failures %d requests %d", b.State(), b.failures, b.requests)
+	}
+}
+
+// TestCircuitBreakerSlow 慢请求比例达到SlowRate时打开，窗口过期之后重新统计
+func TestCircuitBreakerSlow(t *testing.T) {
+	b := NewCircuitBreaker(BreakerConfig{Window: 30 * time.Millisecond, MinRequests: 2, SlowLatency: 10 * time.Millisecond, SlowRate: 1})
+	b.Report(nil, 20*time.Millisecond)
+	time.Sleep(40 * time.Millisecond)
+	b.Report(nil, 20*time.Millisecond)
+	if b.State() != BreakerClosed {
+		t.Fatal("requests of expired window should not count")
+	}
+	b.Report(nil, 20*time.Millisecond)
+	if b.State() != BreakerOpen {
+		t.Fatalf("state %s, want open", b.State())
+	}
+}
+
+func TestResilientRetry(t *testing.T) {
+	r := NewResilient(nil, map[string]RetryPolicy{
+		CommandRead:  {MaxRetries: 2, MinBackoff: time.Millisecond},
+		CommandWrite: {},
+	}, BreakerConfig{MinRequests: 100})
+
+	calls := 0
+	fail := func(err error) func(redis.Cmdable) error {
+		calls = 0
+		return func(redis.Cmdable) error {
+			calls++
+			return err
+		}
+	}
+	if err := r.Read(fail(errConnRefused)); err != errConnRefused || calls != 3 {
+		t.Fatalf("read %v calls %d", err, calls)
+	}
+	if err := r.Write(fail(errConnRefused)); err != errConnRefused || calls != 1 {
+		t.Fatalf("write %v calls %d", err, calls)
+	}
+	if err := r.Read(fail(redis.Nil)); err != redis.Nil || calls != 1 {
+		t.Fatalf("read nil %v calls %d", err, calls)
+	}
+
+	// 熔断器打开之后不再执行
+	r = NewResilient(nil, nil, BreakerConfig{MinRequests: 1})
+	r.Write(fail(errConnRefused))
+	if err := r.Read(fail(nil)); !IsCircuitOpen(err) || calls != 0 {
+		t.Fatalf("read with open breaker %v calls %d", err, calls)
+	}
+}
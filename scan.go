@@ -0,0 +1,237 @@
+package lredis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// 并行遍历所有节点的键
+// 集群模式遍历每个主节点，多个单实例(分片)遍历每个实例，每个节点一个协程
+// 集群迁移槽期间同一个键可能出现在两个节点上，SCAN本身也可能重复返回同一个键，这里统一去重
+// 去重需要在内存中保存已经返回的键，键的数量很大时注意内存占用
+
+type ScanOptions struct {
+	Match  string        // MATCH，为空匹配所有的键
+	Type   string        // TYPE，需要redis 6.0以上，为空不过滤
+	Count  int64         // 每次SCAN的COUNT
+	MinTTL time.Duration // 剩余过期时间不小于此值，0 不过滤，没有过期时间的键认为无限大
+	MaxTTL time.Duration // 剩余过期时间不大于此值，0 不过滤，设置之后没有过期时间的键会被过滤
+	Rate   int           // 每秒最多返回的键数，0 不限制
+	Buffer int           // 结果通道的缓冲区大小
+}
+
+// ScanResult 一个扫描到的键，Err不为nil时表示Node扫描失败
+type ScanResult struct {
+	Key  string
+	Node string
+	TTL  time.Duration // 设置了TTL过滤时有效，-1ms 没有过期时间，与go-redis的PTTL一致
+	Err  error
+}
+
+// ScanAll 遍历clients对应的所有节点，结果通过通道返回，遍历结束或者ctx取消之后通道关闭
+func ScanAll(ctx context.Context, clients []redis.Cmdable, opts ScanOptions) <-chan ScanResult {
+	if opts.Match == "" {
+		opts.Match = "*"
+	}
+	if opts.Count <= 0 {
+		opts.Count = 500
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = 1000
+	}
+	results := make(chan ScanResult, opts.Buffer)
+
+	go func() {
+		defer close(results)
+		nodes := []redis.Cmdable{}
+		for _, client := range clients {
+			masters, err := MasterNodes(client)
+			if err != nil {
+				sendScanResult(ctx, results, ScanResult{Node: NodeAddr(client), Err: err})
+				return
+			}
+			nodes = append(nodes, masters...)
+		}
+
+		s := &scanner{
+			opts:    opts,
+			results: results,
+			seen:    map[string]struct{}{},
+		}
+		if opts.Rate > 0 {
+			// 每秒超过1e9个时间隔为0，最小按照1ns
+			interval := time.Second / time.Duration(opts.Rate)
+			if interval <= 0 {
+				interval = time.Nanosecond
+			}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			s.tokens = ticker.C
+		}
+
+		var wg sync.WaitGroup
+		for _, node := range nodes {
+			wg.Add(1)
+			go func(node redis.Cmdable) {
+				defer wg.Done()
+				if err := s.scanNode(ctx, node); err != nil && ctx.Err() == nil {
+					sendScanResult(ctx, results, ScanResult{Node: NodeAddr(node), Err: err})
+				}
+			}(node)
+		}
+		wg.Wait()
+	}()
+	return results
+}
+
+type scanner struct {
+	opts    ScanOptions
+	results chan ScanResult
+	tokens  <-chan time.Time
+
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+func (s *scanner) scanNode(ctx context.Context, node redis.Cmdable) error {
+	addr := NodeAddr(node)
+	var cursor uint64
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		keys, next, err := s.scan(node, cursor)
+		if err != nil {
+			return err
+		}
+
+		var ttls []time.Duration
+		if s.opts.MinTTL > 0 || s.opts.MaxTTL > 0 {
+			if ttls, err = s.pttl(node, keys); err != nil {
+				return err
+			}
+		}
+		for i, key := range keys {
+			result := ScanResult{Key: key, Node: addr}
+			if ttls != nil {
+				result.TTL = ttls[i]
+				if !s.ttlMatch(ttls[i]) {
+					continue
+				}
+			}
+			if s.duplicate(key) {
+				continue
+			}
+			if s.tokens != nil {
+				select {
+				case <-s.tokens:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			if !sendScanResult(ctx, s.results, result) {
+				return ctx.Err()
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// scan 执行一次SCAN，设置了TYPE时go-redis没有封装，使用原始命令
+func (s *scanner) scan(node redis.Cmdable, cursor uint64) ([]string, uint64, error) {
+	if s.opts.Type == "" {
+		return node.Scan(cursor, s.opts.Match, s.opts.Count).Result()
+	}
+	reply, err := doCmd(node, "scan", cursor, "match", s.opts.Match, "count", s.opts.Count, "type", s.opts.Type)
+	if err != nil {
+		return nil, 0, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 2 {
+		return nil, 0, fmt.Errorf("scan unexpected reply %v", reply)
+	}
+	next, err := strconv.ParseUint(fmt.Sprint(items[0]), 10, 64)
+	if err != nil {
+		return nil, 0, err
+	}
+	values, _ := items[1].([]interface{})
+	keys := make([]string, 0, len(values))
+	for _, value := range values {
+		if key, ok := value.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, next, nil
+}
+
+// pttl 使用pipeline批量获取剩余过期时间
+func (s *scanner) pttl(node redis.Cmdable, keys []string) ([]time.Duration, error) {
+	if len(keys) == 0 {
+		return []time.Duration{}, nil
+	}
+	pipe := node.Pipeline()
+	defer pipe.Close()
+	cmds := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.PTTL(key)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return nil, err
+	}
+	ttls := make([]time.Duration, len(keys))
+	for i, cmd := range cmds {
+		ttls[i] = cmd.Val()
+	}
+	return ttls, nil
+}
+
+// go-redis把PTTL返回的-1和-2按照毫秒转换为 -1ms 和 -2ms
+const (
+	pttlNone    = -time.Millisecond     // 没有过期时间
+	pttlMissing = -2 * time.Millisecond // 键已经不存在
+)
+
+// ttlMatch ttl为PTTL的结果
+func (s *scanner) ttlMatch(ttl time.Duration) bool {
+	if ttl == pttlMissing {
+		return false
+	}
+	if ttl == pttlNone {
+		return s.opts.MaxTTL <= 0
+	}
+	if s.opts.MinTTL > 0 && ttl < s.opts.MinTTL {
+		return false
+	}
+	if s.opts.MaxTTL > 0 && ttl > s.opts.MaxTTL {
+		return false
+	}
+	return true
+}
+
+func (s *scanner) duplicate(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.seen[key]; ok {
+		return true
+	}
+	s.seen[key] = struct{}{}
+	return false
+}
+
+func sendScanResult(ctx context.Context, results chan<- ScanResult, result ScanResult) bool {
+	select {
+	case results <- result:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,119 @@
+package lredis
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// fakeScanClient 每次SCAN返回一批键，游标为批次的序号
+type fakeScanClient struct {
+	redis.Cmdable
+	batches [][]string
+	err     error
+}
+
+func (f *fakeScanClient) Scan(cursor uint64, match string, count int64) *redis.ScanCmd {
+	if f.err != nil {
+		return redis.NewScanCmdResult(nil, 0, f.err)
+	}
+	next := cursor + 1
+	if int(next) >= len(f.batches) {
+		next = 0
+	}
+	return redis.NewScanCmdResult(f.batches[cursor], next, nil)
+}
+
+func collectScan(t *testing.T, results <-chan ScanResult) (keys []string, errs []error) {
+	t.Helper()
+	for result := range results {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+			continue
+		}
+		keys = append(keys, result.Key)
+	}
+	sort.Strings(keys)
+	return keys, errs
+}
+
+// TestScanAll 多个节点并行遍历，重复的键只返回一次
+func TestScanAll(t *testing.T) {
+	a := &fakeScanClient{batches: [][]string{{"a", "b"}, {}, {"c", "a"}}}
+	b := &fakeScanClient{batches: [][]string{{"c", "d"}}}
+	// 超过每秒1e9个时不会因为间隔为0而panic
+	keys, errs := collectScan(t, ScanAll(context.Background(), []redis.Cmdable{a, b}, ScanOptions{Rate: 2e9}))
+	if strings.Join(keys, ",") != "a,b,c,d" || len(errs) != 0 {
+		t.Fatalf("keys %v errs %v", keys, errs)
+	}
+
+	failed := &fakeScanClient{err: errors.New("scan failed")}
+	keys, errs = collectScan(t, ScanAll(context.Background(), []redis.Cmdable{b, failed}, ScanOptions{}))
+	if strings.Join(keys, ",") != "c,d" || len(errs) != 1 {
+		t.Fatalf("keys %v errs %v", keys, errs)
+	}
+}
+
+func TestScanAllCancel(t *testing.T) {
+	batches := make([][]string, 100)
+	for i := range batches {
+		batches[i] = []string{strings.Repeat("k", i+1)}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	results := ScanAll(ctx, []redis.Cmdable{&fakeScanClient{batches: batches}}, ScanOptions{Rate: 100, Buffer: 1})
+	<-results
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		for range results {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("results not closed after cancel")
+	}
+}
+
+func TestScanTTLMatch(t *testing.T) {
+	// go-redis的PTTL返回 -1ms 没有过期时间 -2ms 键不存在
+	client := startMyRedis(t)
+	client.Set("k", "v", 0)
+	noTTL, missing := client.PTTL("k").Val(), client.PTTL("missing").Val()
+	if noTTL != pttlNone || missing != pttlMissing {
+		t.Fatalf("pttl sentinels %s %s", noTTL, missing)
+	}
+	for _, c := range []struct {
+		min, max time.Duration
+		ttl      time.Duration
+		want     bool
+	}{
+		{0, 0, noTTL, true},
+		{0, 0, missing, false},
+		{time.Minute, 0, noTTL, true},
+		{0, time.Minute, noTTL, false},
+		{time.Minute, 0, time.Second, false},
+		{time.Minute, 0, time.Hour, true},
+		{0, time.Minute, time.Hour, false},
+		{time.Second, time.Minute, 30 * time.Second, true},
+		{time.Second, time.Minute, time.Minute, true},
+	} {
+		s := &scanner{opts: ScanOptions{MinTTL: c.min, MaxTTL: c.max}}
+		if got := s.ttlMatch(c.ttl); got != c.want {
+			t.Fatalf("ttlMatch(%s) min %s max %s = %v", c.ttl, c.min, c.max, got)
+		}
+	}
+}
+
+func TestScanDuplicate(t *testing.T) {
+	s := &scanner{seen: map[string]struct{}{}}
+	if s.duplicate("a") || s.duplicate("b") || !s.duplicate("a") {
+		t.Fatal("duplicate")
+	}
+}
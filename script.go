@@ -0,0 +1,182 @@
+package lredis
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis"
+)
+
+// 服务端lua脚本注册表
+// 脚本在本地计算sha1，执行时使用EVALSHA只发送摘要
+// 服务端返回NOSCRIPT时(重启、SCRIPT FLUSH、故障转移到新节点)加载脚本之后重试
+// 集群模式下每个主节点的脚本缓存是独立的，需要在每个主节点上加载
+
+// 脚本文件开头使用 --! 声明元数据，目前支持 keys=N 声明键的个数
+// --! keys=1
+
+//go:embed scripts/*.lua
+var embedScripts embed.FS
+
+const scriptMetaPrefix = "--!"
+
+type Script struct {
+	Name    string // 脚本名称，文件名去掉.lua后缀
+	Src     string // 脚本内容
+	SHA     string // 脚本的sha1摘要
+	NumKeys int    // 脚本需要的键的个数，-1 不做检查
+}
+
+type ScriptRegistry struct {
+	mu      sync.RWMutex
+	scripts map[string]*Script
+}
+
+// NewScriptRegistry 创建脚本注册表，并加载内置的脚本
+func NewScriptRegistry() (*ScriptRegistry, error) {
+	r := &ScriptRegistry{scripts: map[string]*Script{}}
+	if err := r.LoadFS(embedScripts, "scripts"); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Register 注册一个脚本，同名的脚本会被覆盖
+func (r *ScriptRegistry) Register(name, src string, numKeys int) *Script {
+	sum := sha1.Sum([]byte(src))
+	script := &Script{
+		Name:    name,
+		Src:     src,
+		SHA:     hex.EncodeToString(sum[:]),
+		NumKeys: numKeys,
+	}
+	r.mu.Lock()
+	r.scripts[name] = script
+	r.mu.Unlock()
+	return script
+}
+
+// LoadFS 加载dir目录下所有的.lua文件，一般传入调用方的embed.FS
+func (r *ScriptRegistry) LoadFS(fsys fs.FS, dir string) error {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.lua"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		src := string(data)
+		numKeys, err := parseScriptKeys(src)
+		if err != nil {
+			return fmt.Errorf("script %s %s", file, err.Error())
+		}
+		r.Register(strings.TrimSuffix(path.Base(file), ".lua"), src, numKeys)
+	}
+	return nil
+}
+
+// Get 根据名称获取脚本
+func (r *ScriptRegistry) Get(name string) *Script {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.scripts[name]
+}
+
+// Run 使用EVALSHA执行脚本，遇到NOSCRIPT时加载脚本并重试一次
+func (r *ScriptRegistry) Run(client redis.Cmdable, name string, keys []string, args ...interface{}) (interface{}, error) {
+	script := r.Get(name)
+	if script == nil {
+		return nil, fmt.Errorf("script %s not registered", name)
+	}
+	if script.NumKeys >= 0 && script.NumKeys != len(keys) {
+		return nil, fmt.Errorf("script %s need %d keys, got %d", name, script.NumKeys, len(keys))
+	}
+
+	val, err := client.EvalSha(script.SHA, keys, args...).Result()
+	if err == nil || !isNoScript(err) {
+		return val, err
+	}
+	if err := loadScript(client, script); err != nil {
+		return nil, err
+	}
+	return client.EvalSha(script.SHA, keys, args...).Result()
+}
+
+// Preload 在所有的客户端上加载所有已经注册的脚本，一般在启动时调用
+// clients 为Open返回的客户端
+func (r *ScriptRegistry) Preload(clients []redis.Cmdable) error {
+	r.mu.RLock()
+	scripts := make([]*Script, 0, len(r.scripts))
+	for _, script := range r.scripts {
+		scripts = append(scripts, script)
+	}
+	r.mu.RUnlock()
+
+	for _, client := range clients {
+		for _, script := range scripts {
+			if err := loadScript(client, script); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadScript 在客户端对应的所有节点上加载脚本
+// 集群模式下SCRIPT LOAD只会发送到一个节点，这里在每个主节点上加载
+func loadScript(client redis.Cmdable, script *Script) error {
+	load := func(c redis.Cmdable) error {
+		sha, err := c.ScriptLoad(script.Src).Result()
+		if err != nil {
+			return err
+		}
+		if sha != script.SHA {
+			return fmt.Errorf("script %s sha mismatch local %s server %s", script.Name, script.SHA, sha)
+		}
+		return nil
+	}
+	if cluster, ok := client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(func(c *redis.Client) error {
+			return load(c)
+		})
+	}
+	return load(client)
+}
+
+func isNoScript(err error) bool {
+	return strings.HasPrefix(err.Error(), "NOSCRIPT")
+}
+
+// parseScriptKeys 解析脚本开头的 --! keys=N 元数据，没有声明时返回-1
+func parseScriptKeys(src string) (int, error) {
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, scriptMetaPrefix) {
+			break
+		}
+		for _, field := range strings.Fields(strings.TrimPrefix(line, scriptMetaPrefix)) {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 || kv[0] != "keys" {
+				continue
+			}
+			num, err := strconv.Atoi(kv[1])
+			if err != nil || num < 0 {
+				return 0, errors.New("invalid keys meta " + kv[1])
+			}
+			return num, nil
+		}
+	}
+	return -1, nil
+}
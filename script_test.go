@@ -0,0 +1,122 @@
+package lredis
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-redis/redis"
+)
+
+func TestParseScriptKeys(t *testing.T) {
+	for _, c := range []struct {
+		src  string
+		want int
+		err  bool
+	}{
+		{"--! keys=2\nreturn 1", 2, false},
+		{"  --! other=1 keys=0\n", 0, false},
+		{"--! other=1\n--! keys=3\nreturn 1", 3, false},
+		// 元数据只能出现在脚本开头
+		{"return 1\n--! keys=1", -1, false},
+		{"", -1, false},
+		{"--! keys=x", 0, true},
+		{"--! keys=-1", 0, true},
+	} {
+		got, err := parseScriptKeys(c.src)
+		if (err != nil) != c.err || got != c.want {
+			t.Fatalf("parseScriptKeys(%q) = %d %v, want %d", c.src, got, err, c.want)
+		}
+	}
+}
+
+func TestScriptRegistryLoad(t *testing.T) {
+	r, err := NewScriptRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"del_if_equal", "incr_limit"} {
+		if s := r.Get(name); s == nil || s.NumKeys != 1 {
+			t.Fatalf("embedded script %s = %+v", name, s)
+		}
+	}
+
+	// 脚本的sha1与 SCRIPT LOAD 返回的一致
+	if s := r.Register("one", "return 1", -1); s.SHA != "e0e1f9fabfc9d4800c877a703b823ac0578ff8db" {
+		t.Fatalf("sha %s", s.SHA)
+	}
+
+	fsys := fstest.MapFS{
+		"lua/a.lua":   {Data: []byte("--! keys=2\nreturn 1")},
+		"lua/b.txt":   {Data: []byte("ignored")},
+		"lua/bad.lua": {Data: []byte("--! keys=two\n")},
+	}
+	if err := r.LoadFS(fsys, "lua"); err == nil {
+		t.Fatal("invalid keys meta should fail")
+	}
+	delete(fsys, "lua/bad.lua")
+	if err := r.LoadFS(fsys, "lua"); err != nil {
+		t.Fatal(err)
+	}
+	if s := r.Get("a"); s == nil || s.NumKeys != 2 || r.Get("b") != nil {
+		t.Fatalf("LoadFS a=%+v b=%+v", s, r.Get("b"))
+	}
+}
+
+// fakeScriptClient 模拟服务端的脚本缓存
+type fakeScriptClient struct {
+	redis.Cmdable
+	cache  map[string]bool
+	loads  int
+	evals  int
+	sha    string // 不为空时SCRIPT LOAD返回此摘要
+	result interface{}
+}
+
+func (f *fakeScriptClient) EvalSha(sha string, keys []string, args ...interface{}) *redis.Cmd {
+	f.evals++
+	if !f.cache[sha] {
+		return redis.NewCmdResult(nil, errors.New("NOSCRIPT No matching script. Please use EVAL."))
+	}
+	return redis.NewCmdResult(f.result, nil)
+}
+
+func (f *fakeScriptClient) ScriptLoad(script string) *redis.StringCmd {
+	f.loads++
+	r := &ScriptRegistry{scripts: map[string]*Script{}}
+	sha := r.Register("", script, -1).SHA
+	f.cache[sha] = true
+	if f.sha != "" {
+		sha = f.sha
+	}
+	return redis.NewStringResult(sha, nil)
+}
+
+func TestScriptRegistryRun(t *testing.T) {
+	r := &ScriptRegistry{scripts: map[string]*Script{}}
+	r.Register("lock", "return 1", 1)
+	client := &fakeScriptClient{cache: map[string]bool{}, result: int64(1)}
+
+	if _, err := r.Run(client, "missing", nil); err == nil {
+		t.Fatal("unregistered script should fail")
+	}
+	if _, err := r.Run(client, "lock", []string{"a", "b"}); err == nil || client.evals != 0 {
+		t.Fatal("wrong number of keys should fail before EVALSHA")
+	}
+
+	// 第一次执行时服务端没有缓存脚本，加载之后重试
+	if v, err := r.Run(client, "lock", []string{"a"}); err != nil || v != int64(1) {
+		t.Fatalf("run %v %v", v, err)
+	}
+	if client.loads != 1 || client.evals != 2 {
+		t.Fatalf("loads %d evals %d", client.loads, client.evals)
+	}
+	if _, err := r.Run(client, "lock", []string{"a"}); err != nil || client.loads != 1 || client.evals != 3 {
+		t.Fatalf("cached script loads %d evals %d %v", client.loads, client.evals, err)
+	}
+
+	client = &fakeScriptClient{cache: map[string]bool{}, sha: "mismatch"}
+	if err := r.Preload([]redis.Cmdable{client}); err == nil {
+		t.Fatal("sha mismatch should fail")
+	}
+}
@@ -0,0 +1,7 @@
+--! keys=1
+-- 值等于ARGV[1]时删除键，用于释放分布式锁
+-- KEYS[1] 锁的键 ARGV[1] 加锁时写入的值
+if redis.call("get", KEYS[1]) == ARGV[1] then
+    return redis.call("del", KEYS[1])
+end
+return 0
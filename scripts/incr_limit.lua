@@ -0,0 +1,11 @@
+--! keys=1
+-- 在窗口内计数，超过上限返回-1，用于固定窗口限流
+-- KEYS[1] 计数的键 ARGV[1] 上限 ARGV[2] 窗口毫秒数
+local count = redis.call("incr", KEYS[1])
+if count == 1 then
+    redis.call("pexpire", KEYS[1], ARGV[2])
+end
+if count > tonumber(ARGV[1]) then
+    return -1
+end
+return count
@@ -0,0 +1,425 @@
+package lredis
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// 基于redis stream的消费组框架
+// 启动时创建消费组 -> N个worker使用XREADGROUP阻塞读取 -> handler成功之后XACK
+// 每个worker启动时先用id=0回放一遍自身pending列表中未ack的消息，之后只读取新消息
+// 处理失败的消息留在pending列表中，由XAUTOCLAIM重试，XPENDING中的投递次数达到MaxRetries之后转入死信stream
+// 定时XAUTOCLAIM其他消费者pending列表中超过空闲时间的消息，防止消费者宕机之后消息丢失
+// 定时按照MAXLEN或者MINID裁剪stream
+
+// StreamHandler 处理一条消息，返回nil时消息会被ack，否则留在pending列表中等待重试
+type StreamHandler func(msg redis.XMessage) error
+
+type StreamConsumerConfig struct {
+	Stream   string // stream的键名
+	Group    string // 消费组名称
+	Consumer string // 消费者名称前缀，worker的名称为 Consumer-序号
+	Workers  int    // 并发的worker数量
+
+	Count int64         // 每次XREADGROUP读取的消息条数
+	Block time.Duration // XREADGROUP阻塞的时间，也决定了关闭时的最大等待时间
+
+	ClaimInterval time.Duration // XAUTOCLAIM的执行间隔，0 不进行claim
+	ClaimMinIdle  time.Duration // 消息在pending列表中空闲超过此时间才会被claim
+	ClaimCount    int64         // 每次claim的消息条数
+
+	TrimInterval time.Duration // 裁剪stream的间隔，0 不进行裁剪
+	MaxLen       int64         // 按照MAXLEN裁剪，保留的最大长度
+	MinID        string        // 按照MINID裁剪，小于此id的消息会被删除
+	TrimApprox   bool          // 使用 ~ 近似裁剪，效率更高
+
+	MaxRetries int    // 消息的最大投递次数，处理失败并且达到之后ack并转入DeadLetter，0 不限制
+	DeadLetter string // 死信stream的键名，为空时直接丢弃超过重试次数的消息，集群模式下需要和Stream在同一个slot
+}
+
+// StreamStats 消费者运行的统计信息
+type StreamStats struct {
+	Processed int64            // 处理成功并ack的消息数
+	Failed    int64            // handler返回错误的消息数
+	Claimed   int64            // 通过XAUTOCLAIM接管的消息数
+	Dead      int64            // 超过重试次数被转入死信stream的消息数
+	Pending   int64            // 消费组pending列表中的消息总数
+	Consumers map[string]int64 // 每个消费者pending的消息数
+}
+
+type StreamConsumer struct {
+	client  redis.Cmdable
+	conf    StreamConsumerConfig
+	handler StreamHandler
+
+	processed int64
+	failed    int64
+	claimed   int64
+	dead      int64
+
+	// readGroup 执行XREADGROUP，测试时替换
+	readGroup func(consumer, id string, block time.Duration) ([]redis.XStream, error)
+	// deliveries 通过XPENDING查询消息的投递次数，测试时替换
+	deliveries func(id string) (int64, error)
+
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+	running int32
+}
+
+// NewStreamConsumer 创建一个消费者，未设置的配置使用默认值
+func NewStreamConsumer(client redis.Cmdable, conf StreamConsumerConfig, handler StreamHandler) (*StreamConsumer, error) {
+	if client == nil {
+		return nil, errors.New("stream consumer client nil")
+	}
+	if conf.Stream == "" || conf.Group == "" {
+		return nil, errors.New("stream consumer must have stream and group")
+	}
+	if handler == nil {
+		return nil, errors.New("stream consumer must have handler")
+	}
+
+	if conf.Consumer == "" {
+		conf.Consumer = conf.Group
+	}
+
+	if conf.Workers <= 0 {
+		conf.Workers = 1
+	}
+
+	if conf.Count <= 0 {
+		conf.Count = 10
+	}
+
+	if conf.Block <= 0 {
+		conf.Block = 2 * time.Second
+	}
+
+	if conf.ClaimMinIdle <= 0 {
+		conf.ClaimMinIdle = time.Minute
+	}
+
+	if conf.ClaimCount <= 0 {
+		conf.ClaimCount = 100
+	}
+
+	c := &StreamConsumer{
+		client:  client,
+		conf:    conf,
+		handler: handler,
+		stopCh:  make(chan struct{}),
+	}
+	c.readGroup = c.xreadGroup
+	c.deliveries = c.xpendingDeliveries
+	return c, nil
+}
+
+// Start 创建消费组并启动worker、claim和trim协程
+func (c *StreamConsumer) Start() error {
+	if !atomic.CompareAndSwapInt32(&c.running, 0, 1) {
+		return errors.New("stream consumer already started")
+	}
+
+	// 消费组已经存在时返回BUSYGROUP错误，忽略即可
+	err := c.client.XGroupCreateMkStream(c.conf.Stream, c.conf.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		atomic.StoreInt32(&c.running, 0)
+		return err
+	}
+
+	for i := 0; i < c.conf.Workers; i++ {
+		c.wg.Add(1)
+		go c.work(fmt.Sprintf("%s-%d", c.conf.Consumer, i))
+	}
+
+	if c.conf.ClaimInterval > 0 {
+		c.wg.Add(1)
+		go c.loop(c.conf.ClaimInterval, c.claim)
+	}
+
+	if c.conf.TrimInterval > 0 && (c.conf.MaxLen > 0 || c.conf.MinID != "") {
+		c.wg.Add(1)
+		go c.loop(c.conf.TrimInterval, c.trim)
+	}
+	return nil
+}
+
+// Stop 通知所有协程退出，并等待正在处理的消息处理完毕
+// 最长等待时间约为Block
+func (c *StreamConsumer) Stop() {
+	if !atomic.CompareAndSwapInt32(&c.running, 1, 2) {
+		return
+	}
+	close(c.stopCh)
+	c.wg.Wait()
+}
+
+// Publish 向stream中添加一条消息，配置了MaxLen时同时裁剪
+func (c *StreamConsumer) Publish(values map[string]interface{}) (string, error) {
+	args := &redis.XAddArgs{
+		Stream: c.conf.Stream,
+		Values: values,
+	}
+	if c.conf.MaxLen > 0 {
+		if c.conf.TrimApprox {
+			args.MaxLenApprox = c.conf.MaxLen
+		} else {
+			args.MaxLen = c.conf.MaxLen
+		}
+	}
+	return c.client.XAdd(args).Result()
+}
+
+// Stats 返回本地统计信息以及消费组中每个消费者的pending数量
+func (c *StreamConsumer) Stats() (*StreamStats, error) {
+	stats := &StreamStats{
+		Processed: atomic.LoadInt64(&c.processed),
+		Failed:    atomic.LoadInt64(&c.failed),
+		Claimed:   atomic.LoadInt64(&c.claimed),
+		Dead:      atomic.LoadInt64(&c.dead),
+		Consumers: map[string]int64{},
+	}
+	pending, err := c.client.XPending(c.conf.Stream, c.conf.Group).Result()
+	if err != nil {
+		return stats, err
+	}
+	stats.Pending = pending.Count
+	for consumer, count := range pending.Consumers {
+		stats.Consumers[consumer] = count
+	}
+	return stats, nil
+}
+
+func (c *StreamConsumer) stopped() bool {
+	select {
+	case <-c.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// work 单个worker的循环
+// 先从id=0开始回放一遍自身未ack的消息，每批之后从最后一条消息的id继续
+// 到达pending列表末尾之后使用 > 读取新消息，处理失败的消息不会在这里反复读取
+func (c *StreamConsumer) work(consumer string) {
+	defer c.wg.Done()
+	id := "0"
+	for !c.stopped() {
+		block := c.conf.Block
+		if id != ">" {
+			// 回放pending消息时不需要阻塞
+			block = -1
+		}
+		streams, err := c.readGroup(consumer, id, block)
+		if err == redis.Nil {
+			id = ">"
+			continue
+		}
+		if err != nil {
+			log.Printf("stream %s consumer %s read err: %s\n", c.conf.Stream, consumer, err.Error())
+			c.sleep(time.Second)
+			continue
+		}
+
+		last := ""
+		for _, stream := range streams {
+			if len(stream.Messages) > 0 {
+				last = stream.Messages[len(stream.Messages)-1].ID
+			}
+			c.handle(stream.Messages)
+		}
+		if id != ">" {
+			if last == "" {
+				// pending列表已经回放完毕
+				id = ">"
+			} else {
+				id = last
+			}
+		}
+	}
+}
+
+func (c *StreamConsumer) xreadGroup(consumer, id string, block time.Duration) ([]redis.XStream, error) {
+	return c.client.XReadGroup(&redis.XReadGroupArgs{
+		Group:    c.conf.Group,
+		Consumer: consumer,
+		Streams:  []string{c.conf.Stream, id},
+		Count:    c.conf.Count,
+		Block:    block,
+	}).Result()
+}
+
+// handle 依次处理消息，成功的消息以及超过重试次数直接丢弃的消息批量ack
+func (c *StreamConsumer) handle(msgs []redis.XMessage) {
+	ids := make([]string, 0, len(msgs))
+	dropped := 0
+	for _, msg := range msgs {
+		if err := c.handler(msg); err != nil {
+			atomic.AddInt64(&c.failed, 1)
+			log.Printf("stream %s handle msg %s err: %s\n", c.conf.Stream, msg.ID, err.Error())
+			if !c.retryExhausted(msg) {
+				continue
+			}
+			if c.conf.DeadLetter == "" {
+				ids = append(ids, msg.ID)
+				dropped++
+				continue
+			}
+			if err := c.deadLetter(msg); err != nil {
+				log.Printf("stream %s dead letter msg %s err: %s\n", c.conf.Stream, msg.ID, err.Error())
+				continue
+			}
+			atomic.AddInt64(&c.dead, 1)
+			continue
+		}
+		ids = append(ids, msg.ID)
+	}
+	if len(ids) == 0 {
+		return
+	}
+	if err := c.client.XAck(c.conf.Stream, c.conf.Group, ids...).Err(); err != nil {
+		log.Printf("stream %s ack err: %s\n", c.conf.Stream, err.Error())
+		return
+	}
+	atomic.AddInt64(&c.processed, int64(len(ids)-dropped))
+	atomic.AddInt64(&c.dead, int64(dropped))
+}
+
+// retryExhausted 返回消息的投递次数是否已经达到MaxRetries
+// 投递次数记录在消费组的pending列表中，XAUTOCLAIM接管时也会增加，进程重启之后依然有效
+// 查询失败或者消息已经不在pending列表中时返回false，消息留给下次投递处理
+func (c *StreamConsumer) retryExhausted(msg redis.XMessage) bool {
+	if c.conf.MaxRetries <= 0 {
+		return false
+	}
+	n, err := c.deliveries(msg.ID)
+	if err != nil {
+		log.Printf("stream %s pending msg %s err: %s\n", c.conf.Stream, msg.ID, err.Error())
+		return false
+	}
+	return n >= int64(c.conf.MaxRetries)
+}
+
+func (c *StreamConsumer) xpendingDeliveries(id string) (int64, error) {
+	pending, err := c.client.XPendingExt(&redis.XPendingExtArgs{
+		Stream: c.conf.Stream,
+		Group:  c.conf.Group,
+		Start:  id,
+		End:    id,
+		Count:  1,
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+	return pending[0].RetryCount, nil
+}
+
+// deadLetter 在MULTI/EXEC中写入死信stream并ack
+// 两条命令要么都执行要么都不执行，不会出现写入死信stream之后ack失败，消息再次投递时重复写入
+func (c *StreamConsumer) deadLetter(msg redis.XMessage) error {
+	_, err := c.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.XAdd(&redis.XAddArgs{Stream: c.conf.DeadLetter, Values: msg.Values})
+		pipe.XAck(c.conf.Stream, c.conf.Group, msg.ID)
+		return nil
+	})
+	return err
+}
+
+func (c *StreamConsumer) loop(interval time.Duration, fn func() error) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			if err := fn(); err != nil {
+				log.Printf("stream %s err: %s\n", c.conf.Stream, err.Error())
+			}
+		}
+	}
+}
+
+func (c *StreamConsumer) sleep(d time.Duration) {
+	select {
+	case <-c.stopCh:
+	case <-time.After(d):
+	}
+}
+
+// claim 使用XAUTOCLAIM接管其他消费者空闲过久的消息，并在当前协程中处理
+// XAUTOCLAIM 返回 [next-cursor, [[id, [field, value...]]...], [deleted-id...]]
+func (c *StreamConsumer) claim() error {
+	consumer := c.conf.Consumer + "-claim"
+	cursor := "0-0"
+	for !c.stopped() {
+		reply, err := doCmd(c.client, "xautoclaim", c.conf.Stream, c.conf.Group, consumer,
+			int64(c.conf.ClaimMinIdle/time.Millisecond), cursor, "count", c.conf.ClaimCount)
+		if err != nil {
+			return err
+		}
+		next, msgs, err := parseAutoClaim(reply)
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&c.claimed, int64(len(msgs)))
+		c.handle(msgs)
+		if next == "0-0" || next == cursor {
+			return nil
+		}
+		cursor = next
+	}
+	return nil
+}
+
+// trim 按照MAXLEN或者MINID裁剪stream
+func (c *StreamConsumer) trim() error {
+	if c.conf.MaxLen > 0 {
+		if c.conf.TrimApprox {
+			return c.client.XTrimApprox(c.conf.Stream, c.conf.MaxLen).Err()
+		}
+		return c.client.XTrim(c.conf.Stream, c.conf.MaxLen).Err()
+	}
+	args := []interface{}{"xtrim", c.conf.Stream, "minid"}
+	if c.conf.TrimApprox {
+		args = append(args, "~")
+	}
+	args = append(args, c.conf.MinID)
+	_, err := doCmd(c.client, args...)
+	return err
+}
+
+func parseAutoClaim(reply interface{}) (string, []redis.XMessage, error) {
+	items, ok := reply.([]interface{})
+	if !ok || len(items) < 2 {
+		return "", nil, fmt.Errorf("xautoclaim unexpected reply %v", reply)
+	}
+	next, _ := items[0].(string)
+	entries, _ := items[1].([]interface{})
+	msgs := make([]redis.XMessage, 0, len(entries))
+	for _, entry := range entries {
+		// 已经被删除的消息在6.2中返回nil
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) < 2 {
+			continue
+		}
+		id, _ := fields[0].(string)
+		kvs, _ := fields[1].([]interface{})
+		values := make(map[string]interface{}, len(kvs)/2)
+		for i := 0; i+1 < len(kvs); i += 2 {
+			key, _ := kvs[i].(string)
+			values[key] = kvs[i+1]
+		}
+		msgs = append(msgs, redis.XMessage{ID: id, Values: values})
+	}
+	return next, msgs, nil
+}
@@ -0,0 +1,234 @@
+package lredis
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// fakeStreamClient 记录ack和写入死信stream的消息，其他命令没有实现
+type fakeStreamClient struct {
+	redis.Cmdable
+	acked []string
+	added []*redis.XAddArgs
+	exec  error // 不为nil时事务整体失败，其中的命令都不执行
+}
+
+func (f *fakeStreamClient) XAck(stream, group string, ids ...string) *redis.IntCmd {
+	f.acked = append(f.acked, ids...)
+	return redis.NewIntResult(int64(len(ids)), nil)
+}
+
+func (f *fakeStreamClient) XAdd(a *redis.XAddArgs) *redis.StringCmd {
+	f.added = append(f.added, a)
+	return redis.NewStringResult("1-0", nil)
+}
+
+// fakeStreamPipe 在事务中执行XADD和XACK
+type fakeStreamPipe struct {
+	redis.Pipeliner
+	client *fakeStreamClient
+}
+
+func (p *fakeStreamPipe) XAck(stream, group string, ids ...string) *redis.IntCmd {
+	return p.client.XAck(stream, group, ids...)
+}
+
+func (p *fakeStreamPipe) XAdd(a *redis.XAddArgs) *redis.StringCmd {
+	return p.client.XAdd(a)
+}
+
+func (f *fakeStreamClient) TxPipelined(fn func(redis.Pipeliner) error) ([]redis.Cmder, error) {
+	if f.exec != nil {
+		return nil, f.exec
+	}
+	return nil, fn(&fakeStreamPipe{client: f})
+}
+
+func streamID(id string) int {
+	n, _ := strconv.Atoi(strings.TrimSuffix(id, "-0"))
+	return n
+}
+
+func xmsgs(ids ...int) []redis.XMessage {
+	msgs := make([]redis.XMessage, 0, len(ids))
+	for _, id := range ids {
+		msgs = append(msgs, redis.XMessage{ID: strconv.Itoa(id) + "-0", Values: map[string]interface{}{"n": id}})
+	}
+	return msgs
+}
+
+// TestStreamConsumerReplay 处理失败的pending消息只回放一次，之后读取新消息
+func TestStreamConsumerReplay(t *testing.T) {
+	client := &fakeStreamClient{}
+	var handled []string
+	c, err := NewStreamConsumer(client, StreamConsumerConfig{Stream: "s", Group: "g", Count: 2},
+		func(msg redis.XMessage) error {
+			handled = append(handled, msg.ID)
+			if msg.ID == "1-0" {
+				return errors.New("always fail")
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending := xmsgs(1, 2, 3)
+	fresh := xmsgs(4, 5)
+	var ids []string
+	c.readGroup = func(consumer, id string, block time.Duration) ([]redis.XStream, error) {
+		ids = append(ids, id)
+		if len(ids) > 10 {
+			t.Fatalf("worker loops on pending list: %v", ids)
+		}
+		if id == ">" {
+			if block < 0 {
+				t.Fatal("reading new messages should block")
+			}
+			if len(fresh) == 0 {
+				close(c.stopCh)
+				return nil, redis.Nil
+			}
+			msgs := fresh
+			fresh = nil
+			return []redis.XStream{{Stream: "s", Messages: msgs}}, nil
+		}
+		if block >= 0 {
+			t.Fatal("replaying pending messages should not block")
+		}
+		// 返回id之后尚未ack的消息
+		var msgs []redis.XMessage
+		for _, msg := range pending {
+			if streamID(msg.ID) > streamID(id) && int64(len(msgs)) < c.conf.Count {
+				msgs = append(msgs, msg)
+			}
+		}
+		return []redis.XStream{{Stream: "s", Messages: msgs}}, nil
+	}
+
+	c.wg.Add(1)
+	c.work("w-0")
+
+	if want := []string{"0", "2-0", "3-0", ">", ">"}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("read ids %v, want %v", ids, want)
+	}
+	if want := []string{"1-0", "2-0", "3-0", "4-0", "5-0"}; !reflect.DeepEqual(handled, want) {
+		t.Fatalf("handled %v, want %v", handled, want)
+	}
+	if want := []string{"2-0", "3-0", "4-0", "5-0"}; !reflect.DeepEqual(client.acked, want) {
+		t.Fatalf("acked %v, want %v", client.acked, want)
+	}
+	if c.processed != 4 || c.failed != 1 {
+		t.Fatalf("processed %d failed %d", c.processed, c.failed)
+	}
+}
+
+// TestStreamConsumerDeadLetter 投递次数达到MaxRetries的消息转入死信stream并ack
+func TestStreamConsumerDeadLetter(t *testing.T) {
+	client := &fakeStreamClient{}
+	c, _ := NewStreamConsumer(client, StreamConsumerConfig{Stream: "s", Group: "g", MaxRetries: 3, DeadLetter: "dlq"},
+		func(msg redis.XMessage) error {
+			if msg.ID == "1-0" {
+				return errors.New("always fail")
+			}
+			return nil
+		})
+	// 投递次数由XPENDING记录，这里每次处理时加一
+	delivered := map[string]int64{}
+	c.deliveries = func(id string) (int64, error) {
+		return delivered[id], nil
+	}
+	handle := func(ids ...int) {
+		for _, msg := range xmsgs(ids...) {
+			delivered[msg.ID]++
+		}
+		c.handle(xmsgs(ids...))
+	}
+
+	handle(1, 2)
+	handle(1)
+	if len(client.added) != 0 || !reflect.DeepEqual(client.acked, []string{"2-0"}) {
+		t.Fatalf("dead letter before max retries: added %v acked %v", client.added, client.acked)
+	}
+
+	// 事务失败时既不写入死信stream也不ack，下次投递时重试
+	client.exec = errors.New("exec failed")
+	handle(1)
+	if len(client.added) != 0 || len(client.acked) != 1 || c.dead != 0 {
+		t.Fatalf("added %v acked %v after dead letter failure", client.added, client.acked)
+	}
+
+	client.exec = nil
+	handle(1)
+	if len(client.added) != 1 || client.added[0].Stream != "dlq" || client.added[0].Values["n"] != 1 {
+		t.Fatalf("dead letter %+v", client.added)
+	}
+	if !reflect.DeepEqual(client.acked, []string{"2-0", "1-0"}) {
+		t.Fatalf("acked %v", client.acked)
+	}
+	if c.processed != 1 || c.failed != 4 || c.dead != 1 {
+		t.Fatalf("processed %d failed %d dead %d", c.processed, c.failed, c.dead)
+	}
+}
+
+// TestStreamConsumerDropExhausted 没有配置死信stream时超过重试次数的消息直接ack
+// 已经不在pending列表中的消息不会被ack
+func TestStreamConsumerDropExhausted(t *testing.T) {
+	client := &fakeStreamClient{}
+	c, _ := NewStreamConsumer(client, StreamConsumerConfig{Stream: "s", Group: "g", MaxRetries: 2},
+		func(msg redis.XMessage) error {
+			return errors.New("always fail")
+		})
+	delivered := map[string]int64{"1-0": 2, "2-0": 1}
+	c.deliveries = func(id string) (int64, error) {
+		return delivered[id], nil
+	}
+
+	c.handle(xmsgs(1, 2, 3))
+	if !reflect.DeepEqual(client.acked, []string{"1-0"}) || len(client.added) != 0 {
+		t.Fatalf("acked %v added %v", client.acked, client.added)
+	}
+	if c.processed != 0 || c.failed != 3 || c.dead != 1 {
+		t.Fatalf("processed %d failed %d dead %d", c.processed, c.failed, c.dead)
+	}
+}
+
+func TestParseAutoClaim(t *testing.T) {
+	reply := []interface{}{
+		"5-0",
+		[]interface{}{
+			[]interface{}{"1-0", []interface{}{"a", "1", "b", "2"}},
+			// 已经被删除的消息
+			nil,
+			[]interface{}{"3-0", []interface{}{}},
+		},
+		[]interface{}{"2-0"},
+	}
+	next, msgs, err := parseAutoClaim(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []redis.XMessage{
+		{ID: "1-0", Values: map[string]interface{}{"a": "1", "b": "2"}},
+		{ID: "3-0", Values: map[string]interface{}{}},
+	}
+	if next != "5-0" || !reflect.DeepEqual(msgs, want) {
+		t.Fatalf("parseAutoClaim = %s %v", next, msgs)
+	}
+
+	// 6.2之前的版本没有第三项
+	if next, msgs, err := parseAutoClaim([]interface{}{"0-0", []interface{}{}}); err != nil || next != "0-0" || len(msgs) != 0 {
+		t.Fatalf("parseAutoClaim without deleted ids = %s %v %v", next, msgs, err)
+	}
+	for _, reply := range []interface{}{nil, "OK", []interface{}{"0-0"}} {
+		if _, _, err := parseAutoClaim(reply); err == nil {
+			t.Fatalf("parseAutoClaim(%v) should fail", reply)
+		}
+	}
+}